@@ -0,0 +1,73 @@
+package opsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var cancelCmd = &cobra.Command{
+	Use:   "cancel <run-id>",
+	Short: "Cancel a run in progress on an opsql server",
+	Long: `Cancel asks an opsql server to stop a run before its next operation. The run's
+transaction is rolled back and it is recorded as cancelled. To cancel a local opsql run,
+send it SIGUSR1 instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCancel,
+}
+
+func init() {
+	cancelCmd.Flags().String("server", "", "URL of the opsql server (required, can use OPSQL_SERVER env)")
+	cancelCmd.Flags().String("token", "", "Bearer token of the server (optional, can use OPSQL_SERVER_TOKEN env)")
+}
+
+func runCancel(cmd *cobra.Command, args []string) error {
+	server, _ := cmd.Flags().GetString("server")
+	if server == "" {
+		server = os.Getenv("OPSQL_SERVER")
+	}
+	if server == "" {
+		return fmt.Errorf("--server or OPSQL_SERVER is required")
+	}
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("OPSQL_SERVER_TOKEN")
+	}
+
+	endpoint := strings.TrimSuffix(server, "/") + "/api/runs/" + url.PathEscape(args[0]) + "/cancel"
+	req, err := http.NewRequestWithContext(cmd.Context(), http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("X-Opsql-Actor", state.CurrentActor())
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to cancel run: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusAccepted {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error == "" {
+			body.Error = resp.Status
+		}
+		return fmt.Errorf("failed to cancel run: %s", body.Error)
+	}
+
+	fmt.Fprintf(os.Stderr, "Cancelling run %s; it stops before its next operation\n", args[0])
+	return nil
+}
@@ -0,0 +1,159 @@
+package opsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pyama86/opsql/internal/bluegreen"
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/spf13/cobra"
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Dry-run definitions against blue and green databases and report where their results differ",
+	Long: `Compare executes the definitions in dry-run mode against two databases at the same time,
+such as the blue and green instances of a migration cutover, and reports every field whose
+value differs: pass, failure code, affected rows, row counts and column values.
+
+The databases are named like those of cross_check operations: --blue old reads the DSN
+from DATABASE_DSN_OLD. The command exits with 0 when the results are identical, 1 when
+they differ and 2 when a side could not be run.`,
+	RunE: runCompare,
+}
+
+func init() {
+	compareCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	compareCmd.Flags().StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	compareCmd.Flags().String("blue", "", "Name of the blue database, whose DSN is read from DATABASE_DSN_<NAME> (required)")
+	compareCmd.Flags().String("green", "", "Name of the green database, whose DSN is read from DATABASE_DSN_<NAME> (required)")
+	compareCmd.Flags().String("format", "text", "Output format: text or json")
+	_ = compareCmd.MarkFlagRequired("config")
+	_ = compareCmd.MarkFlagRequired("blue")
+	_ = compareCmd.MarkFlagRequired("green")
+}
+
+// compareResult is the JSON output of compare
+type compareResult struct {
+	Identical   bool                   `json:"identical"`
+	Differences []bluegreen.Difference `json:"differences"`
+	Blue        []definition.Report    `json:"blue"`
+	Green       []definition.Report    `json:"green"`
+}
+
+func runCompare(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	configFiles, _ := cmd.Flags().GetStringSlice("config")
+	blue, _ := cmd.Flags().GetString("blue")
+	green, _ := cmd.Flags().GetString("green")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "text" && format != "json" {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("unsupported --format %q (allowed: text, json)", format)}
+	}
+	if blue == green {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("--blue and --green must name different databases")}
+	}
+	params, err := parseParamFlags(cmd)
+	if err != nil {
+		return &exitError{code: ExitAborted, err: err}
+	}
+
+	named := database.NewNamed()
+	defer func() {
+		if err := named.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close databases: %v\n", err)
+		}
+	}()
+
+	// 切り替え判断のため、両方を同時に実行してなるべく同じ時点の結果を比べる
+	names := []string{blue, green}
+	reports := make([][]definition.Report, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i], errs[i] = runCompareSide(ctx, configFiles, params, named, name)
+		}()
+	}
+	wg.Wait()
+	for i, name := range names {
+		if errs[i] != nil {
+			return &exitError{code: ExitAborted, err: fmt.Errorf("%s: %w", name, errs[i])}
+		}
+	}
+
+	diffs := bluegreen.Compare(reports[0], reports[1])
+	if format == "json" {
+		data, err := json.MarshalIndent(compareResult{
+			Identical:   len(diffs) == 0,
+			Differences: diffs,
+			Blue:        reports[0],
+			Green:       reports[1],
+		}, "", "  ")
+		if err != nil {
+			return &exitError{code: ExitAborted, err: err}
+		}
+		fmt.Println(string(data))
+	} else {
+		for i, side := range []string{"blue", "green"} {
+			fmt.Printf("%-5s %s (%s): %d operations, %d passed\n", side, names[i], database.DSNEnv(names[i]), len(reports[i]), passedCount(reports[i]))
+		}
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+	}
+
+	differing := make(map[string]bool)
+	for _, diff := range diffs {
+		differing[diff.Operation] = true
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(os.Stderr, "opsql compare: %d operations — IDENTICAL\n", len(reports[0]))
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "opsql compare: %d differences in %d operations — DIFFERENT\n", len(diffs), len(differing))
+	return &exitError{code: ExitFailed, err: fmt.Errorf("results of %s and %s differ", blue, green)}
+}
+
+// runCompareSide dry-runs the definitions against a named database. Failing assertions are part
+// of the result; only a run that executed nothing is an error.
+func runCompareSide(ctx context.Context, configFiles []string, params map[string]string, named *database.Named, name string) ([]definition.Report, error) {
+	def, err := definition.LoadDefinitionsWithParams(configFiles, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load definition: %w", err)
+	}
+
+	db, err := named.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if def.HasDerivedParams() {
+		if err := def.ResolveDerivedParams(ctx, db); err != nil {
+			return nil, fmt.Errorf("failed to resolve derived params: %w", err)
+		}
+	}
+
+	reports, err := executor.NewPlanExecutor(db, executor.WithDatabases(named.Open)).Execute(ctx, def)
+	if err != nil && len(reports) == 0 {
+		return nil, err
+	}
+	definition.LocalizeReports(reports)
+	return reports, nil
+}
+
+func passedCount(reports []definition.Report) int {
+	passed := 0
+	for _, report := range reports {
+		if report.Pass {
+			passed++
+		}
+	}
+	return passed
+}
@@ -0,0 +1,217 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/drift"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/github"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Periodically run the checks of definitions and open GitHub issues when they start failing",
+	Long: `Drift runs the checks of the definitions, the select and cross_check operations whose
+queries are SELECTs, once or every --interval, and compares the result with the previous
+check. Operations that write are never run, and each check runs alone in a read-only
+transaction that is rolled back. When checks that passed before start failing, it opens a
+GitHub issue with the diff, or comments on the open one.`,
+	RunE: runDrift,
+}
+
+func init() {
+	driftCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	driftCmd.Flags().StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	driftCmd.Flags().StringP("environment", "e", "", "Environment name (e.g., dev, staging, prod)")
+	driftCmd.Flags().Duration("interval", 0, "Check again at this interval until interrupted (e.g. 1h); 0 checks once")
+	driftCmd.Flags().String("github-repo", "", "GitHub repository the issues are opened in (owner/repo, can use GITHUB_REPOSITORY env)")
+	driftCmd.Flags().String("state-backend", "", "Where run history is kept; the previous check is read from it (optional, can use OPSQL_STATE_BACKEND env)")
+	driftCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+
+	_ = driftCmd.MarkFlagRequired("config")
+}
+
+func runDrift(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config, err := loadCommonRunConfig(cmd)
+	if err != nil {
+		return err
+	}
+	config.ConfigFiles, _ = cmd.Flags().GetStringSlice("config")
+	config.Params, err = parseParamFlags(cmd)
+	if err != nil {
+		return err
+	}
+	config.DryRun = true
+	config.Environment, _ = cmd.Flags().GetString("environment")
+	if config.Environment == "" {
+		config.Environment = os.Getenv("OPSQL_ENVIRONMENT")
+	}
+	config.DatabaseDSN = os.Getenv("DATABASE_DSN")
+	if config.DatabaseDSN == "" {
+		return fmt.Errorf("DATABASE_DSN environment variable is required")
+	}
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	checker := &driftChecker{config: config}
+	for {
+		err := checker.check(ctx)
+		if interval == 0 {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: drift check failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// driftChecker remembers the previous check between scheduled checks
+type driftChecker struct {
+	config   *RunConfig
+	previous []definition.Report
+	checked  bool
+}
+
+func (c *driftChecker) check(ctx context.Context) (checkErr error) {
+	startedAt := time.Now()
+	name := definition.Name(c.config.ConfigFiles)
+
+	var history *state.History
+	if c.config.StateBackend != "" {
+		store, err := state.Open(ctx, c.config.StateBackend, c.config.DatabaseDSN, c.config.OpsqlSchema)
+		if err != nil {
+			return fmt.Errorf("failed to open state backend: %w", err)
+		}
+		defer func() {
+			if err := store.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+			}
+		}()
+		history = state.NewHistory(store)
+
+		// 初回はプロセス外で実行された前回のチェック結果と比較する
+		if !c.checked {
+			last, err := history.LastCompletedRun(ctx, name, c.config.Environment)
+			if err != nil {
+				return fmt.Errorf("failed to read run history: %w", err)
+			}
+			if last != nil {
+				c.previous = last.Reports
+			}
+		}
+	}
+
+	reports, err := c.execute(ctx)
+	if history != nil {
+		record, recordErr := newRunRecord(c.config, startedAt)
+		if recordErr != nil {
+			return recordErr
+		}
+		finishRunRecord(record, reports, err)
+		if err := history.SaveRun(ctx, *record); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save run history: %v\n", err)
+		}
+	}
+	if err != nil && len(reports) == 0 {
+		return err
+	}
+
+	newly := drift.NewlyFailing(c.previous, reports)
+	c.previous = reports
+	c.checked = true
+
+	failing := 0
+	for _, report := range reports {
+		if !report.Pass {
+			failing++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "drift check %s: %d operations, %d failing, %d newly failing\n", name, len(reports), failing, len(newly))
+
+	if len(newly) == 0 {
+		return nil
+	}
+	return c.reportIssue(ctx, name, newly, startedAt)
+}
+
+func (c *driftChecker) execute(ctx context.Context) ([]definition.Report, error) {
+	def, err := definition.LoadDefinitionsWithParams(c.config.ConfigFiles, c.config.Params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load definition: %w", err)
+	}
+
+	checks := def.Checks()
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no checks to run: %d operations, none of them a select or cross_check that only reads", len(def.Operations))
+	}
+
+	db, err := database.NewDatabase(c.config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", err)
+		}
+	}()
+	named := database.NewNamed()
+	defer func() {
+		if err := named.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close databases: %v\n", err)
+		}
+	}()
+
+	if def.HasDerivedParams() {
+		if err := def.CheckDerivedParamsReadOnly(); err != nil {
+			return nil, err
+		}
+		if err := def.ResolveDerivedParams(ctx, db); err != nil {
+			return nil, fmt.Errorf("failed to resolve derived params: %w", err)
+		}
+	}
+
+	planExecutor := executor.NewPlanExecutor(db,
+		executor.WithReadOnly(),
+		executor.WithSampleRows(c.config.SampleRows),
+		executor.WithDatabases(named.Open),
+	)
+	return runChecks(ctx, planExecutor, def)
+}
+
+func (c *driftChecker) reportIssue(ctx context.Context, name string, newly []definition.Report, checkedAt time.Time) error {
+	client := github.NewClient(c.config.GitHubRepo, 0)
+	if client == nil {
+		return fmt.Errorf("%d assertions newly failing, but GitHub authentication is not configured to open an issue", len(newly))
+	}
+
+	title := drift.IssueTitle(name, c.config.Environment)
+	body := drift.FormatIssue(name, c.config.Environment, newly, checkedAt)
+	number, created, err := client.UpsertIssue(ctx, title, body, drift.IssueLabel)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		fmt.Fprintf(os.Stderr, "opened issue #%d: %s\n", number, title)
+	} else {
+		fmt.Fprintf(os.Stderr, "updated issue #%d: %s\n", number, title)
+	}
+	return nil
+}
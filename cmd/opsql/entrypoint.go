@@ -0,0 +1,142 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultReportDir is where the container image mounts its report volume
+const defaultReportDir = "/reports"
+
+var entrypointCmd = &cobra.Command{
+	Use:   "entrypoint",
+	Short: "Run a definition as a container job",
+	Long: `Entrypoint is the default command of the opsql container image, for Argo Workflows,
+ECS scheduled tasks and other job runners. It is run with the flags of run, which fall back
+to environment variables:
+
+  OPSQL_CONFIG       comma-separated definition files, e.g. mounted from a ConfigMap
+  OPSQL_DEFINITION   the definition itself, when no file is mounted
+  OPSQL_DRY_RUN      true to plan instead of applying
+  OPSQL_PARAM_<NAME> the value of the param <name> (lower-cased), ignored when empty
+  OPSQL_REPORT_FILE  the report file (default /reports/report.json when /reports exists)
+
+SIGTERM cancels the run before its next operation and rolls it back. The exit code is
+0 when the run passed, 1 when an operation failed, 2 when the run did not start and 3 when
+it was cancelled.`,
+	RunE: runEntrypoint,
+}
+
+func init() {
+	addRunFlags(entrypointCmd.Flags())
+}
+
+func runEntrypoint(cmd *cobra.Command, args []string) error {
+	startedAt := time.Now()
+	reportFile, _ := cmd.Flags().GetString("report-file")
+	if reportFile == "" {
+		reportFile = os.Getenv("OPSQL_REPORT_FILE")
+	}
+	if reportFile == "" {
+		if info, err := os.Stat(defaultReportDir); err == nil && info.IsDir() {
+			reportFile = filepath.Join(defaultReportDir, "report.json")
+		}
+	}
+	// 設定の誤りでもジョブの結果をレポートに残す
+	aborted := func(err error) error {
+		printRunSummary(os.Stderr, nil, nil, time.Since(startedAt), outcomeAborted)
+		writeReportFile(&RunConfig{ReportFile: reportFile}, runReportFile{Outcome: outcomeAborted, ExitCode: ExitAborted}, startedAt, nil, err)
+		return &exitError{code: ExitAborted, err: err}
+	}
+
+	cleanup, err := applyEntrypointEnv(cmd)
+	defer cleanup()
+	if err != nil {
+		return aborted(err)
+	}
+
+	config, err := loadRunConfig(cmd)
+	if err != nil {
+		return aborted(err)
+	}
+	config.ReportFile = reportFile
+
+	// ジョブランナーは停止時にSIGTERMを送るので、次の操作の前で止めてロールバックする
+	cancel, stop := cancelOnSignal(syscall.SIGTERM)
+	defer stop()
+	config.Cancel = cancel
+
+	return executeRun(context.Background(), config)
+}
+
+// applyEntrypointEnv sets the flags that were not given from the environment. The returned
+// function removes the file an inline OPSQL_DEFINITION was written to.
+func applyEntrypointEnv(cmd *cobra.Command) (func(), error) {
+	cleanup := func() {}
+	flags := cmd.Flags()
+
+	if !flags.Changed("config") {
+		switch {
+		case os.Getenv("OPSQL_CONFIG") != "":
+			if err := flags.Set("config", os.Getenv("OPSQL_CONFIG")); err != nil {
+				return cleanup, fmt.Errorf("invalid OPSQL_CONFIG: %w", err)
+			}
+		case os.Getenv("OPSQL_DEFINITION") != "":
+			f, err := os.CreateTemp("", "opsql-definition-*.yaml")
+			if err != nil {
+				return cleanup, err
+			}
+			cleanup = func() { _ = os.Remove(f.Name()) }
+			if _, err := f.WriteString(os.Getenv("OPSQL_DEFINITION")); err != nil {
+				_ = f.Close()
+				return cleanup, err
+			}
+			if err := f.Close(); err != nil {
+				return cleanup, err
+			}
+			if err := flags.Set("config", f.Name()); err != nil {
+				return cleanup, err
+			}
+		default:
+			return cleanup, fmt.Errorf("--config, OPSQL_CONFIG or OPSQL_DEFINITION is required")
+		}
+	}
+
+	if value := os.Getenv("OPSQL_DRY_RUN"); value != "" && !flags.Changed("dry-run") {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return cleanup, fmt.Errorf("invalid OPSQL_DRY_RUN: %s", value)
+		}
+		if err := flags.Set("dry-run", strconv.FormatBool(dryRun)); err != nil {
+			return cleanup, err
+		}
+	}
+
+	// --param takes precedence over the environment
+	given := make(map[string]bool)
+	values, _ := flags.GetStringArray("param")
+	for _, value := range values {
+		key, _, _ := strings.Cut(value, "=")
+		given[key] = true
+	}
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		name, ok := strings.CutPrefix(key, "OPSQL_PARAM_")
+		// 空の値はワークフローの既定値が空のパラメータなので、定義の値を使う
+		if !ok || name == "" || value == "" || given[strings.ToLower(name)] {
+			continue
+		}
+		if err := flags.Set("param", strings.ToLower(name)+"="+value); err != nil {
+			return cleanup, err
+		}
+	}
+	return cleanup, nil
+}
@@ -0,0 +1,122 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pyama86/opsql/internal/secret"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Encrypt and decrypt the .env.enc file loaded with .env",
+	Long: `opsql loads .env.enc after .env, decrypted with the key in OPSQL_ENV_KEY, so that the
+credentials of local runs need not be kept in plain text. OPSQL_ENV_KEY can itself be a
+secret reference such as keychain://<service>/<account>.`,
+}
+
+var envEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt an env file into .env.enc",
+	Long: `Encrypt encrypts an env file with the key in OPSQL_ENV_KEY. Without OPSQL_ENV_KEY, a new key
+is generated and printed to stderr. Delete the plain file once it is encrypted.`,
+	RunE: runEnvEncrypt,
+}
+
+var envDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Print the decrypted contents of .env.enc",
+	RunE:  runEnvDecrypt,
+}
+
+func init() {
+	envEncryptCmd.Flags().String("in", ".env", "Plain env file to encrypt")
+	envEncryptCmd.Flags().StringP("output", "o", secret.EncryptedEnvFile, "Encrypted file to write")
+	envDecryptCmd.Flags().String("in", secret.EncryptedEnvFile, "Encrypted env file to decrypt")
+
+	envCmd.AddCommand(envEncryptCmd)
+	envCmd.AddCommand(envDecryptCmd)
+}
+
+func runEnvEncrypt(cmd *cobra.Command, args []string) error {
+	in, _ := cmd.Flags().GetString("in")
+	output, _ := cmd.Flags().GetString("output")
+
+	plain, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+
+	var key string
+	if os.Getenv(secret.EnvKeyVar) != "" {
+		key, err = secret.EnvKey(context.Background())
+	} else {
+		key, err = secret.NewEnvKey()
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Generated a new key; keep it in the keychain and point %s to it:\n\n", secret.EnvKeyVar)
+			fmt.Fprintf(os.Stderr, "  security add-generic-password -s opsql -a env-key -w '%s'   # macOS\n", key)
+			fmt.Fprintf(os.Stderr, "  export %s=keychain://opsql/env-key\n\n", secret.EnvKeyVar)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := secret.EncryptEnv(plain, key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, encrypted, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s encrypted into %s\n", in, output)
+	return nil
+}
+
+func runEnvDecrypt(cmd *cobra.Command, args []string) error {
+	in, _ := cmd.Flags().GetString("in")
+
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	key, err := secret.EnvKey(context.Background())
+	if err != nil {
+		return err
+	}
+	plain, err := secret.DecryptEnv(data, key)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(plain)
+	return err
+}
+
+// loadSecrets loads .env.enc and resolves the secret references of the environment
+func loadSecrets(ctx context.Context) error {
+	if err := secret.LoadEncryptedEnv(ctx, secret.EncryptedEnvFile); err != nil {
+		return err
+	}
+	return secret.ResolveEnv(ctx)
+}
+
+// resolveFlags replaces the string flags given as secret references, such as --dsn vault://...,
+// with the secret they point to
+func resolveFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if err != nil || f.Value.Type() != "string" || !secret.IsReference(f.Value.String()) {
+			return
+		}
+		var value string
+		if value, err = secret.Resolve(cmd.Context(), f.Value.String()); err != nil {
+			err = fmt.Errorf("--%s: %w", f.Name, err)
+			return
+		}
+		err = f.Value.Set(value)
+	})
+	return err
+}
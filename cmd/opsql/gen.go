@@ -0,0 +1,247 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/scaffold"
+	"github.com/spf13/cobra"
+)
+
+var genCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "Generate runbooks for common operational patterns",
+}
+
+var genRetentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Generate a batched DELETE runbook removing rows older than a retention period",
+	Long: `Retention scaffolds a runbook deleting the rows of --table whose --column is older than
+--older-than, in batches of --batch rows. It checks the number of expired rows before the
+deletes and that none are left after them.
+
+The rows are counted against DATABASE_DSN unless --rows is given. The cutoff is fixed when
+the runbook is generated, so the counts stay valid until it is applied.`,
+	RunE: runGenRetention,
+}
+
+func init() {
+	genRetentionCmd.Flags().String("table", "", "Table to sweep (required)")
+	genRetentionCmd.Flags().String("column", "created_at", "Timestamp column compared with the cutoff")
+	genRetentionCmd.Flags().String("older-than", "", "Retention period, such as 180d or 720h (required)")
+	genRetentionCmd.Flags().Int("batch", 10000, "Rows deleted per statement")
+	genRetentionCmd.Flags().String("key", "id", "Column ordering the batches")
+	genRetentionCmd.Flags().Int64("rows", 0, "Number of expired rows (default: counted against DATABASE_DSN)")
+	genRetentionCmd.Flags().String("driver", "mysql", "SQL dialect when --rows is given without DATABASE_DSN: mysql or postgres")
+	genRetentionCmd.Flags().StringP("output", "o", "", "File to write the runbook to (default: stdout)")
+	_ = genRetentionCmd.MarkFlagRequired("table")
+	_ = genRetentionCmd.MarkFlagRequired("older-than")
+
+	genCmd.AddCommand(genRetentionCmd)
+
+	genWorkflowCmd.Flags().String("platform", "", "Workflow engine: argo or tekton (required)")
+	genWorkflowCmd.Flags().StringSliceP("config", "c", []string{}, "Definition files the workflow runs (required, can specify multiple)")
+	genWorkflowCmd.Flags().String("name", "", "Name of the workflow (default: derived from the first definition file)")
+	genWorkflowCmd.Flags().StringP("environment", "e", "", "Default environment of the runs")
+	genWorkflowCmd.Flags().String("image", "", "opsql container image (default: the release image of this version)")
+	genWorkflowCmd.Flags().String("configmap", "", "ConfigMap holding the definition files (default: <name>-definitions)")
+	genWorkflowCmd.Flags().String("secret", "", "Secret holding the database DSN (default: <name>-database)")
+	genWorkflowCmd.Flags().String("secret-key", "dsn", "Key of the DSN in the secret")
+	genWorkflowCmd.Flags().StringSlice("approvers", []string{}, "Users (or group:<name>) who may approve the Tekton approval task")
+	genWorkflowCmd.Flags().StringP("output", "o", "", "File to write the workflow to (default: stdout)")
+	_ = genWorkflowCmd.MarkFlagRequired("platform")
+	_ = genWorkflowCmd.MarkFlagRequired("config")
+
+	genCmd.AddCommand(genWorkflowCmd)
+}
+
+var genWorkflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Generate an Argo Workflows or Tekton pipeline that plans, approves and applies definitions",
+	Long: `Workflow scaffolds a workflow template running the opsql container image in three stages:
+a plan (dry run), an approval and an apply. The definition files are mounted from a ConfigMap
+and the DSN is read from a Secret. The params of the definitions become workflow parameters
+with the definitions' values as defaults, and each run's report.json is kept.
+
+Argo suspends between plan and apply until the workflow is resumed. Tekton waits for an
+ApprovalTask, which needs the manual approval gate controller.`,
+	RunE: runGenWorkflow,
+}
+
+var workflowNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+func runGenWorkflow(cmd *cobra.Command, args []string) error {
+	w := scaffold.Workflow{}
+	w.Platform, _ = cmd.Flags().GetString("platform")
+	w.Configs, _ = cmd.Flags().GetStringSlice("config")
+	w.Name, _ = cmd.Flags().GetString("name")
+	w.Environment, _ = cmd.Flags().GetString("environment")
+	w.Image, _ = cmd.Flags().GetString("image")
+	w.ConfigMap, _ = cmd.Flags().GetString("configmap")
+	w.Secret, _ = cmd.Flags().GetString("secret")
+	w.SecretKey, _ = cmd.Flags().GetString("secret-key")
+	w.Approvers, _ = cmd.Flags().GetStringSlice("approvers")
+
+	if w.Platform != scaffold.PlatformArgo && w.Platform != scaffold.PlatformTekton {
+		return fmt.Errorf("unsupported --platform: %s (expected argo or tekton)", w.Platform)
+	}
+	if w.Platform == scaffold.PlatformTekton && len(w.Approvers) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: no --approvers, add them to the approve task before applying the pipeline\n")
+	}
+
+	// ConfigMapにはファイル名だけで入るので、同名のファイルは区別できない
+	params := make(map[string]interface{})
+	schema := make(map[string]bool)
+	seen := make(map[string]string)
+	for _, config := range w.Configs {
+		base := filepath.Base(config)
+		if other, ok := seen[base]; ok {
+			return fmt.Errorf("%s and %s have the same file name, which a ConfigMap cannot hold both of", other, config)
+		}
+		seen[base] = config
+
+		def, err := definition.LoadDefinitionRaw(config)
+		if err != nil {
+			return err
+		}
+		for name, value := range def.Params {
+			params[name] = value
+		}
+		for name := range def.ParamSchema {
+			schema[name] = true
+		}
+		for _, derived := range def.DerivedParams {
+			delete(params, derived.Name)
+			delete(schema, derived.Name)
+		}
+	}
+	w.Params = scaffold.WorkflowParams(params, schema)
+
+	if w.Name == "" {
+		base := strings.TrimSuffix(filepath.Base(w.Configs[0]), filepath.Ext(w.Configs[0]))
+		w.Name = strings.Trim(workflowNameInvalid.ReplaceAllString(strings.ToLower(base), "-"), "-")
+		if w.Name == "" {
+			w.Name = "opsql"
+		}
+	}
+	if w.Image == "" {
+		w.Image = "ghcr.io/pyama86/opsql:latest"
+		if version != "dev" {
+			w.Image = "ghcr.io/pyama86/opsql:" + version
+		}
+	}
+	if w.ConfigMap == "" {
+		w.ConfigMap = w.Name + "-definitions"
+	}
+	if w.Secret == "" {
+		w.Secret = w.Name + "-database"
+	}
+
+	data, err := w.YAML()
+	if err != nil {
+		return fmt.Errorf("failed to render workflow: %w", err)
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write workflow: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s: %s workflow %s with %d params\n", output, w.Platform, w.Name, len(w.Params))
+	return nil
+}
+
+func runGenRetention(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	retention, err := parseRetentionDuration(olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid --older-than: %w", err)
+	}
+
+	sweep := scaffold.Retention{Cutoff: time.Now().Add(-retention).Truncate(time.Second)}
+	sweep.Table, _ = cmd.Flags().GetString("table")
+	sweep.Column, _ = cmd.Flags().GetString("column")
+	sweep.Key, _ = cmd.Flags().GetString("key")
+	sweep.Batch, _ = cmd.Flags().GetInt("batch")
+	sweep.Rows, _ = cmd.Flags().GetInt64("rows")
+	sweep.Driver, _ = cmd.Flags().GetString("driver")
+	if sweep.Batch <= 0 {
+		return fmt.Errorf("--batch must be positive")
+	}
+	if sweep.Driver != "mysql" && sweep.Driver != "postgres" {
+		return fmt.Errorf("unsupported --driver: %s (expected mysql or postgres)", sweep.Driver)
+	}
+
+	if !cmd.Flags().Changed("rows") {
+		if err := countExpiredRows(ctx, &sweep); err != nil {
+			return err
+		}
+	} else if sweep.Rows < 0 {
+		return fmt.Errorf("--rows must not be negative")
+	}
+	if sweep.Rows == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: no rows of %s are older than %s\n", sweep.Table, sweep.Cutoff.UTC().Format(scaffold.CutoffLayout))
+	}
+
+	data, err := sweep.YAML()
+	if err != nil {
+		return fmt.Errorf("failed to render runbook: %w", err)
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write runbook: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s: %d rows in batches of %d\n", output, sweep.Rows, sweep.Batch)
+	return nil
+}
+
+// countExpiredRows sets the rows and the dialect of the sweep from DATABASE_DSN
+func countExpiredRows(ctx context.Context, sweep *scaffold.Retention) error {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		return fmt.Errorf("DATABASE_DSN environment variable is required to count the expired rows (or pass --rows)")
+	}
+
+	db, err := database.NewDatabase(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", err)
+		}
+	}()
+
+	sweep.Driver = db.DriverName()
+	rows, err := db.QueryRowsContext(ctx, database.Rebind(sweep.Driver, sweep.CountQuery()), sweep.Cutoff.UTC().Format(scaffold.CutoffLayout))
+	if err != nil {
+		return fmt.Errorf("failed to count expired rows: %w", err)
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("failed to count expired rows: expected 1 row, got %d", len(rows))
+	}
+	count, err := strconv.ParseInt(fmt.Sprint(rows[0]["expired"]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to count expired rows: %w", err)
+	}
+	sweep.Rows = count
+	return nil
+}
@@ -0,0 +1,62 @@
+package opsql
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/graph"
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Render the execution flow of definitions as a Graphviz or Mermaid graph",
+	Long: `Graph draws the operations of the definitions in the order they run, the operations
+instantiated from each template use grouped together, and the tables each operation reads
+and writes. It does not connect to the database: derived params show as <name> unless
+they are given with --param.`,
+	RunE: runGraph,
+}
+
+func init() {
+	graphCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	graphCmd.Flags().StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	graphCmd.Flags().String("format", graph.FormatDOT, "Output format: dot or mermaid")
+	graphCmd.Flags().StringP("output", "o", "", "File to write the graph to (default: stdout)")
+	_ = graphCmd.MarkFlagRequired("config")
+}
+
+func runGraph(cmd *cobra.Command, args []string) error {
+	configFiles, _ := cmd.Flags().GetStringSlice("config")
+	format, _ := cmd.Flags().GetString("format")
+	params, err := parseParamFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	def, err := definition.LoadDefinitionsWithParams(configFiles, params)
+	if err != nil {
+		return fmt.Errorf("failed to load definition: %w", err)
+	}
+	if def.HasDerivedParams() {
+		if _, err := def.PlaceholderDerivedParams(); err != nil {
+			return fmt.Errorf("failed to process templates: %w", err)
+		}
+	}
+
+	rendered, err := graph.Build(def).Render(format)
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		fmt.Print(rendered)
+		return nil
+	}
+	if err := os.WriteFile(output, []byte(rendered), 0644); err != nil {
+		return fmt.Errorf("failed to write graph: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package opsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Inspect and maintain the run history of a state backend",
+}
+
+var historyPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old run records from the state backend",
+	Long: `Prune deletes run records outside the retention given by --older-than, --keep-last
+and --max-size. Locks are not affected. Use --dry-run to list the runs that would be deleted.`,
+	RunE: runHistoryPrune,
+}
+
+var historyStatsCmd = &cobra.Command{
+	Use:   "stats <operation-id>",
+	Short: "Show duration and row count statistics of an operation across runs",
+	Long: `Stats aggregates the duration and the affected (DML) or returned (SELECT) row count
+of an operation over the recorded runs, so that a recurring operation that keeps growing
+can be noticed before it becomes a problem.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistoryStats,
+}
+
+func init() {
+	historyCmd.PersistentFlags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (can use OPSQL_STATE_BACKEND env)")
+	historyCmd.PersistentFlags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+
+	historyPruneCmd.Flags().String("older-than", "", "Delete runs started longer ago than this (e.g. 90d, 36h)")
+	historyPruneCmd.Flags().Int("keep-last", 0, "Keep only this many of the newest runs")
+	historyPruneCmd.Flags().String("max-size", "", "Keep the newest runs whose records fit in this size (e.g. 500MB, 1GB)")
+	historyPruneCmd.Flags().BoolP("dry-run", "d", false, "List the runs that would be deleted without deleting them")
+
+	historyStatsCmd.Flags().String("definition", "", "Only runs of this definition (the config paths joined with +)")
+	historyStatsCmd.Flags().StringP("environment", "e", "", "Only runs in this environment")
+	historyStatsCmd.Flags().Bool("applied-only", false, "Leave dry runs out")
+	historyStatsCmd.Flags().Int("last", 10, "Number of most recent runs to list")
+	historyStatsCmd.Flags().String("format", "text", "Output format: text or json")
+
+	historyCmd.AddCommand(historyPruneCmd)
+	historyCmd.AddCommand(historyStatsCmd)
+}
+
+func runHistoryPrune(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	var retention state.Retention
+	if value, _ := cmd.Flags().GetString("older-than"); value != "" {
+		d, err := parseRetentionDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		retention.OlderThan = d
+	}
+	retention.KeepLast, _ = cmd.Flags().GetInt("keep-last")
+	if value, _ := cmd.Flags().GetString("max-size"); value != "" {
+		size, err := parseSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
+		retention.MaxBytes = size
+	}
+	if retention.OlderThan <= 0 && retention.KeepLast <= 0 && retention.MaxBytes <= 0 {
+		return fmt.Errorf("at least one of --older-than, --keep-last or --max-size is required")
+	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	store, err := openStateStore(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+
+	result, err := state.NewHistory(store).Prune(ctx, retention, time.Now(), dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune history: %w", err)
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	for _, run := range result.Runs {
+		fmt.Printf("%s %s %s@%s %s\n", verb, run.ID, run.Definition, run.Environment, run.Status)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d runs (%d bytes)\n", verb, len(result.Runs), result.Bytes)
+	return nil
+}
+
+func runHistoryStats(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	var filter state.StatsFilter
+	filter.Definition, _ = cmd.Flags().GetString("definition")
+	filter.Environment, _ = cmd.Flags().GetString("environment")
+	filter.Applied, _ = cmd.Flags().GetBool("applied-only")
+	last, _ := cmd.Flags().GetInt("last")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported --format: %s (expected text or json)", format)
+	}
+
+	store, err := openStateStore(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+
+	stats, err := state.NewHistory(store).OperationStats(ctx, args[0], filter)
+	if err != nil {
+		return fmt.Errorf("failed to read run history: %w", err)
+	}
+	if stats.Runs == 0 {
+		return fmt.Errorf("no recorded runs of operation %s", args[0])
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	printOperationStats(os.Stdout, stats, last)
+	return nil
+}
+
+func printOperationStats(out io.Writer, stats *state.OperationStats, last int) {
+	fmt.Fprintf(out, "operation %s: %d runs, %d failed\n\n", stats.OperationID, stats.Runs, stats.Failed)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tMIN\tMEDIAN\tP95\tMAX")
+	fmt.Fprintf(w, "duration (ms)\t%.0f\t%.0f\t%.0f\t%.0f\n", stats.DurationMS.Min, stats.DurationMS.Median, stats.DurationMS.P95, stats.DurationMS.Max)
+	if stats.Rows.Count > 0 {
+		fmt.Fprintf(w, "rows\t%.0f\t%.0f\t%.0f\t%.0f\n", stats.Rows.Min, stats.Rows.Median, stats.Rows.P95, stats.Rows.Max)
+	}
+	_ = w.Flush()
+
+	samples := stats.Samples
+	if last > 0 && len(samples) > last {
+		samples = samples[len(samples)-last:]
+	}
+	fmt.Fprintf(out, "\nlast %d runs:\n", len(samples))
+
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED AT\tRUN\tENVIRONMENT\tMODE\tDURATION (ms)\tROWS\tSTATUS")
+	for _, sample := range samples {
+		mode := "apply"
+		if sample.DryRun {
+			mode = "dry-run"
+		}
+		rows := "-"
+		if sample.Rows != nil {
+			rows = strconv.FormatInt(*sample.Rows, 10)
+		}
+		status := "pass"
+		if !sample.Pass {
+			status = "fail"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", definition.FormatReportTime(sample.StartedAt), sample.RunID, sample.Environment, mode, sample.DurationMS, rows, status)
+	}
+	_ = w.Flush()
+}
+
+// openStateStore opens the state backend given by --state-backend or OPSQL_STATE_BACKEND
+func openStateStore(ctx context.Context, cmd *cobra.Command) (state.StateStore, error) {
+	backend, _ := cmd.Flags().GetString("state-backend")
+	if backend == "" {
+		backend = os.Getenv("OPSQL_STATE_BACKEND")
+	}
+	if backend == "" {
+		backend = os.Getenv("OPSQL_STATE_DIR")
+	}
+	if backend == "" {
+		return nil, fmt.Errorf("a state backend is required (--state-backend or OPSQL_STATE_BACKEND)")
+	}
+
+	schema, _ := cmd.Flags().GetString("opsql-schema")
+	if schema == "" {
+		schema = os.Getenv("OPSQL_SCHEMA")
+	}
+	if schema != "" && !schemaNamePattern.MatchString(schema) {
+		return nil, fmt.Errorf("invalid --opsql-schema: %s", schema)
+	}
+
+	store, err := state.Open(ctx, backend, os.Getenv("DATABASE_DSN"), schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state backend: %w", err)
+	}
+	return store, nil
+}
+
+// parseRetentionDuration accepts time.ParseDuration units plus d for days
+func parseRetentionDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("expected a positive number of days, got %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expected a positive duration, got %q", value)
+	}
+	return d, nil
+}
+
+// parseSize parses a byte size such as 1024, 500KB, 500MB or 1GB (powers of 1024)
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			upper, multiplier = strings.TrimSpace(number), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a positive size such as 500MB, got %q", value)
+	}
+	return n * multiplier, nil
+}
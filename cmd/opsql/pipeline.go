@@ -0,0 +1,166 @@
+package opsql
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/pipeline"
+	"github.com/spf13/cobra"
+)
+
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "Run multi-stage pipelines of definitions",
+}
+
+var pipelineRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Execute the stages of a pipeline file in order",
+	Long: `Pipeline run executes the stages of a pipeline file one after another,
+for example staging apply -> verify -> prod apply.
+Each stage is an opsql run against its own environment and DSN, and the pipeline
+stops at the first failing stage. Stages with approval: true wait for confirmation
+on the terminal unless they are approved with --approve or --auto-approve.`,
+	RunE: runPipeline,
+}
+
+func init() {
+	pipelineRunCmd.Flags().StringP("file", "f", "pipeline.yaml", "Pipeline file path")
+	pipelineRunCmd.Flags().StringSlice("approve", []string{}, "Approve the gate of this stage in advance (can specify multiple)")
+	pipelineRunCmd.Flags().Bool("auto-approve", false, "Approve every gate without prompting")
+	pipelineRunCmd.Flags().String("github-repo", "", "GitHub repository (owner/repo)")
+	pipelineRunCmd.Flags().Int("github-pr", 0, "GitHub PR number")
+	pipelineRunCmd.Flags().String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
+	pipelineRunCmd.Flags().String("slack-channel", "", "Slack channel to post to with SLACK_BOT_TOKEN instead of the webhook, updating the message on retries (optional, can use SLACK_CHANNEL env)")
+	pipelineRunCmd.Flags().String("idempotency-key", "", "Key identifying the run across CI retries, whose notifications are updated instead of posted again (default: derived from the CI job; can use OPSQL_IDEMPOTENCY_KEY env)")
+	pipelineRunCmd.Flags().String("routing", "", "Routing file sending the failures of operations with an owner to that owner's Slack or PagerDuty (optional, can use OPSQL_ROUTING env)")
+	pipelineRunCmd.Flags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (optional, can use OPSQL_STATE_BACKEND env)")
+	pipelineRunCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+	pipelineRunCmd.Flags().String("emit", "", "Publish affected-entity events for capture_keys after each successful apply stage (optional, can use OPSQL_EMIT env)")
+	pipelineRunCmd.Flags().Float64("anomaly-factor", defaultAnomalyFactor, "Warn when a DML operation affects this many times more or fewer rows than its historical median; 0 disables (can use OPSQL_ANOMALY_FACTOR env)")
+	pipelineRunCmd.Flags().Int("sample-rows", 0, "Include up to this many of the rows each DML operation touches in its report")
+
+	pipelineCmd.AddCommand(pipelineRunCmd)
+}
+
+func runPipeline(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("file")
+	p, err := pipeline.Load(path)
+	if err != nil {
+		return err
+	}
+
+	base, err := loadCommonRunConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	approved, _ := cmd.Flags().GetStringSlice("approve")
+	autoApprove, _ := cmd.Flags().GetBool("auto-approve")
+	approve := pipelineApprover(os.Stdin, os.Stderr, approved, autoApprove)
+
+	return p.Run(context.Background(), func(ctx context.Context, stage pipeline.Stage) error {
+		config, err := stageRunConfig(base, stage)
+		if err != nil {
+			return err
+		}
+
+		mode := "apply"
+		if stage.DryRun {
+			mode = "dry-run"
+		}
+		fmt.Fprintf(os.Stderr, "==> stage %s (%s, environment %s)\n", stage.Name, mode, stage.Environment)
+		return executeRun(ctx, config)
+	}, approve)
+}
+
+// loadCommonRunConfig reads the notification, state and reporting settings of commands that start runs
+func loadCommonRunConfig(cmd *cobra.Command) (*RunConfig, error) {
+	config := &RunConfig{Repeat: 1}
+
+	config.GitHubRepo, _ = cmd.Flags().GetString("github-repo")
+	config.GitHubPR, _ = cmd.Flags().GetInt("github-pr")
+	config.SlackWebhook, _ = cmd.Flags().GetString("slack-webhook")
+	loadNotificationFlags(cmd, config)
+	config.Routing, _ = cmd.Flags().GetString("routing")
+	if config.Routing == "" {
+		config.Routing = os.Getenv("OPSQL_ROUTING")
+	}
+	config.SampleRows, _ = cmd.Flags().GetInt("sample-rows")
+	config.Emit, _ = cmd.Flags().GetString("emit")
+	if config.Emit == "" {
+		config.Emit = os.Getenv("OPSQL_EMIT")
+	}
+	var err error
+	config.AnomalyFactor, err = anomalyFactor(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTxMonitorFlags(cmd, config); err != nil {
+		return nil, err
+	}
+	config.StateBackend, _ = cmd.Flags().GetString("state-backend")
+	if config.StateBackend == "" {
+		config.StateBackend = os.Getenv("OPSQL_STATE_BACKEND")
+	}
+	config.OpsqlSchema, _ = cmd.Flags().GetString("opsql-schema")
+	if config.OpsqlSchema == "" {
+		config.OpsqlSchema = os.Getenv("OPSQL_SCHEMA")
+	}
+	if config.OpsqlSchema != "" && !schemaNamePattern.MatchString(config.OpsqlSchema) {
+		return nil, fmt.Errorf("invalid --opsql-schema: %s", config.OpsqlSchema)
+	}
+
+	return config, nil
+}
+
+func stageRunConfig(base *RunConfig, stage pipeline.Stage) (*RunConfig, error) {
+	dsn, err := stage.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	config := *base
+	config.ConfigFiles = stage.Configs
+	config.Params = stage.Params
+	config.Environment = stage.Environment
+	config.DryRun = stage.DryRun
+	config.DatabaseDSN = dsn
+	return &config, nil
+}
+
+// pipelineApprover approves gates given on the command line and asks on the terminal for the others
+func pipelineApprover(in io.Reader, out io.Writer, approved []string, autoApprove bool) pipeline.ApproveFunc {
+	reader := bufio.NewReader(in)
+	return func(stage pipeline.Stage) (bool, error) {
+		if autoApprove || contains(approved, stage.Name) {
+			fmt.Fprintf(out, "stage %s approved\n", stage.Name)
+			return true, nil
+		}
+
+		fmt.Fprintf(out, "Run stage %s against environment %s? [y/N]: ", stage.Name, stage.Environment)
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if err == io.EOF && answer == "" {
+			return false, fmt.Errorf("no input to approve stage %s (use --approve %s)", stage.Name, stage.Name)
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes", nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Apply definitions that were already applied successfully in another environment",
+	Long: `Promote applies the definitions to the --to environment only if the exact same
+configuration files (by checksum) were applied successfully to the --from environment,
+according to the run history. It enforces applying to staging before prod. The --to
+environment's database is read from DATABASE_DSN_<TO>.`,
+	RunE: runPromote,
+}
+
+func init() {
+	promoteCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	promoteCmd.Flags().StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	promoteCmd.Flags().String("from", "", "Environment the definitions must have been applied to (required)")
+	promoteCmd.Flags().String("to", "", "Environment to apply the definitions to (required)")
+	promoteCmd.Flags().BoolP("dry-run", "d", false, "Check the promotion and execute in dry-run mode against the --to environment")
+	promoteCmd.Flags().String("github-repo", "", "GitHub repository (owner/repo)")
+	promoteCmd.Flags().Int("github-pr", 0, "GitHub PR number")
+	promoteCmd.Flags().String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
+	promoteCmd.Flags().String("slack-channel", "", "Slack channel to post to with SLACK_BOT_TOKEN instead of the webhook, updating the message on retries (optional, can use SLACK_CHANNEL env)")
+	promoteCmd.Flags().String("idempotency-key", "", "Key identifying the run across CI retries, whose notifications are updated instead of posted again (default: derived from the CI job; can use OPSQL_IDEMPOTENCY_KEY env)")
+	promoteCmd.Flags().String("routing", "", "Routing file sending the failures of operations with an owner to that owner's Slack or PagerDuty (optional, can use OPSQL_ROUTING env)")
+	promoteCmd.Flags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (required, can use OPSQL_STATE_BACKEND env)")
+	promoteCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+	promoteCmd.Flags().String("emit", "", "Publish affected-entity events for capture_keys after a successful apply (optional, can use OPSQL_EMIT env)")
+	promoteCmd.Flags().Float64("anomaly-factor", defaultAnomalyFactor, "Warn when a DML operation affects this many times more or fewer rows than its historical median; 0 disables (can use OPSQL_ANOMALY_FACTOR env)")
+	promoteCmd.Flags().Int("sample-rows", 0, "Include up to this many of the rows each DML operation touches in its report")
+
+	_ = promoteCmd.MarkFlagRequired("config")
+	_ = promoteCmd.MarkFlagRequired("from")
+	_ = promoteCmd.MarkFlagRequired("to")
+}
+
+func runPromote(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	config, err := loadCommonRunConfig(cmd)
+	if err != nil {
+		return err
+	}
+	config.ConfigFiles, _ = cmd.Flags().GetStringSlice("config")
+	config.Params, err = parseParamFlags(cmd)
+	if err != nil {
+		return err
+	}
+	config.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	from, _ := cmd.Flags().GetString("from")
+	config.Environment, _ = cmd.Flags().GetString("to")
+
+	if from == config.Environment {
+		return fmt.Errorf("--from and --to must be different environments")
+	}
+	if config.StateBackend == "" {
+		return fmt.Errorf("promote requires a state backend (--state-backend or OPSQL_STATE_BACKEND)")
+	}
+
+	// 昇格先の記録と実際に書き込むデータベースを一致させるため、既定のDSNには頼らない
+	dsnEnv := database.DSNEnv(config.Environment)
+	config.DatabaseDSN = os.Getenv(dsnEnv)
+	if config.DatabaseDSN == "" {
+		return fmt.Errorf("%s environment variable is required to promote to %s", dsnEnv, config.Environment)
+	}
+
+	source, err := checkPromotion(ctx, config, from)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "promoting %s: applied to %s by %s in run %s at %s\n",
+		definition.Name(config.ConfigFiles), from, source.Actor, source.ID, definition.FormatReportTime(source.FinishedAt))
+
+	return executeRun(ctx, config)
+}
+
+// checkPromotion returns the run that applied the configuration files to the from environment
+func checkPromotion(ctx context.Context, config *RunConfig, from string) (*state.RunRecord, error) {
+	checksum, err := definition.Checksum(config.ConfigFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := state.Open(ctx, config.StateBackend, config.DatabaseDSN, config.OpsqlSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state backend: %w", err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+
+	source, err := state.NewHistory(store).LastPassedApply(ctx, checksum, from, config.Params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+	if source == nil {
+		return nil, fmt.Errorf("refusing to promote: %s (checksum %s) has not been applied successfully to %s with the same params", definition.Name(config.ConfigFiles), checksum[:12], from)
+	}
+	return source, nil
+}
@@ -0,0 +1,171 @@
+package opsql
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Revert an apply recorded with --record-undo",
+	Long: `Rollback loads the undo plan that an apply run with --record-undo stored in the state
+backend, shows it as a dry run, and applies it after confirmation. Each statement of the plan
+restores one row and must change exactly one row, so the rollback fails and changes nothing
+if the rows were modified again since the apply. It is recorded, notified and reported like
+any other run.`,
+	RunE: runRollback,
+}
+
+func init() {
+	rollbackCmd.Flags().String("run-id", "", "ID of the apply to revert (required)")
+	rollbackCmd.Flags().BoolP("dry-run", "d", false, "Only show the undo plan as a dry run")
+	rollbackCmd.Flags().BoolP("yes", "y", false, "Apply the undo plan without asking for confirmation")
+	rollbackCmd.Flags().Bool("allow-partial", false, "Revert the operations the undo plan covers even if it skipped others")
+	rollbackCmd.Flags().String("github-repo", "", "GitHub repository (owner/repo)")
+	rollbackCmd.Flags().Int("github-pr", 0, "GitHub PR number")
+	rollbackCmd.Flags().String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
+	rollbackCmd.Flags().String("slack-channel", "", "Slack channel to post to with SLACK_BOT_TOKEN instead of the webhook, updating the message on retries (optional, can use SLACK_CHANNEL env)")
+	rollbackCmd.Flags().String("idempotency-key", "", "Key identifying the run across CI retries, whose notifications are updated instead of posted again (default: derived from the CI job; can use OPSQL_IDEMPOTENCY_KEY env)")
+	rollbackCmd.Flags().String("routing", "", "Routing file sending the failures of operations with an owner to that owner's Slack or PagerDuty (optional, can use OPSQL_ROUTING env)")
+	rollbackCmd.Flags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (required, can use OPSQL_STATE_BACKEND env)")
+	rollbackCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+	rollbackCmd.Flags().Int("sample-rows", 0, "Include up to this many of the rows each DML operation touches in its report")
+
+	_ = rollbackCmd.MarkFlagRequired("run-id")
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	config, err := loadCommonRunConfig(cmd)
+	if err != nil {
+		return &exitError{code: ExitAborted, err: err}
+	}
+	if config.StateBackend == "" {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("rollback requires a state backend (--state-backend or OPSQL_STATE_BACKEND)")}
+	}
+	config.DatabaseDSN = os.Getenv("DATABASE_DSN")
+	if config.DatabaseDSN == "" {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("DATABASE_DSN environment variable is required")}
+	}
+
+	runID, _ := cmd.Flags().GetString("run-id")
+	allowPartial, _ := cmd.Flags().GetBool("allow-partial")
+	record, plan, err := loadUndoPlan(ctx, config, runID, allowPartial)
+	if err != nil {
+		return &exitError{code: ExitAborted, err: err}
+	}
+	if len(plan.Steps) == 0 {
+		fmt.Fprintf(os.Stderr, "run %s changed no rows that need restoring\n", runID)
+		return nil
+	}
+
+	config.ConfigFiles = record.Configs
+	config.Params = record.Params
+	config.Environment = record.Environment
+	config.RollbackOf = record.ID
+	// 取り消しの文には比べられる過去の実行がない
+	config.AnomalyFactor = 0
+	fmt.Fprintf(os.Stderr, "rolling back run %s of %s in %s (%d statements)\n", record.ID, record.Definition, record.Environment, len(plan.Steps))
+
+	// 実行する前に、同じ文をdry-runで確かめて見せる
+	dryRun := *config
+	dryRun.DryRun = true
+	dryRun.Definition = plan.Definition()
+	if err := executeRun(ctx, &dryRun); err != nil {
+		return err
+	}
+	if dry, _ := cmd.Flags().GetBool("dry-run"); dry {
+		return nil
+	}
+
+	yes, _ := cmd.Flags().GetBool("yes")
+	if !yes {
+		confirmed, err := confirmRollback(os.Stdin, os.Stderr, record)
+		if err != nil {
+			return &exitError{code: ExitAborted, err: err}
+		}
+		if !confirmed {
+			return &exitError{code: ExitAborted, err: fmt.Errorf("rollback of run %s was not confirmed", record.ID)}
+		}
+	}
+
+	config.Definition = plan.Definition()
+	return executeRun(ctx, config)
+}
+
+// loadUndoPlan returns the apply to revert and its undo plan
+func loadUndoPlan(ctx context.Context, config *RunConfig, runID string, allowPartial bool) (*state.RunRecord, *definition.UndoPlan, error) {
+	store, err := state.Open(ctx, config.StateBackend, config.DatabaseDSN, config.OpsqlSchema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open state backend: %w", err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+	history := state.NewHistory(store)
+
+	record, err := history.GetRun(ctx, runID)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, nil, fmt.Errorf("run %s not found", runID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read run %s: %w", runID, err)
+	}
+	if record.DryRun || record.Status != state.StatusPassed {
+		return nil, nil, fmt.Errorf("run %s is not a passed apply (dry run: %t, status: %s)", runID, record.DryRun, record.Status)
+	}
+
+	// 取り消し済みの実行をもう一度戻すと、削除した行を重ねて挿し直してしまう
+	rollback, err := history.RevertedBy(ctx, runID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+	if rollback != nil {
+		return nil, nil, fmt.Errorf("run %s was already rolled back by run %s", runID, rollback.ID)
+	}
+
+	plan, err := history.GetUndo(ctx, runID)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, nil, fmt.Errorf("run %s has no undo plan; only applies run with --record-undo can be rolled back", runID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the undo plan of run %s: %w", runID, err)
+	}
+
+	if len(plan.Skipped) > 0 {
+		for _, skip := range plan.Skipped {
+			fmt.Fprintf(os.Stderr, "Warning: operation %s is not reverted: %s\n", skip.OperationID, skip.Reason)
+		}
+		if !allowPartial {
+			return nil, nil, fmt.Errorf("the undo plan of run %s does not revert %d operations (use --allow-partial to revert the others)", runID, len(plan.Skipped))
+		}
+	}
+	return record, plan, nil
+}
+
+// confirmRollback asks on the terminal whether to apply the undo plan
+func confirmRollback(in io.Reader, out io.Writer, record *state.RunRecord) (bool, error) {
+	fmt.Fprintf(out, "Apply the rollback of run %s against environment %s? [y/N]: ", record.ID, record.Environment)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	if err == io.EOF && answer == "" {
+		return false, fmt.Errorf("no input to confirm the rollback (use --yes)")
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
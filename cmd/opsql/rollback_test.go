@@ -0,0 +1,71 @@
+package opsql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+)
+
+func TestLoadUndoPlan_RejectsRevertedRun(t *testing.T) {
+	ctx := context.Background()
+	config := &RunConfig{StateBackend: t.TempDir(), DatabaseDSN: "root@tcp(localhost:3306)/app"}
+
+	store, err := state.Open(ctx, config.StateBackend, config.DatabaseDSN, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	history := state.NewHistory(store)
+	apply := state.RunRecord{ID: "run-1", Definition: "cleanup.yaml", Environment: "prod", Status: state.StatusPassed}
+	if err := history.SaveRun(ctx, apply); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan := definition.UndoPlan{RunID: "run-1", Steps: []definition.UndoStep{{OperationID: "delete_old", Type: "insert", SQL: "INSERT INTO logs (id) VALUES (?)"}}}
+	if err := history.SaveUndo(ctx, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	record, _, err := loadUndoPlan(ctx, config, "run-1", false)
+	if err != nil {
+		t.Fatalf("first rollback should load the undo plan: %v", err)
+	}
+
+	// 一度目の取り消しが通ったあとは、同じ実行を戻せない
+	rollback := state.RunRecord{ID: "run-2", Definition: record.Definition, Environment: record.Environment, Status: state.StatusPassed, RollbackOf: record.ID}
+	if err := history.SaveRun(ctx, rollback); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _, err = loadUndoPlan(ctx, config, "run-1", false)
+	if err == nil || !strings.Contains(err.Error(), "already rolled back by run run-2") {
+		t.Errorf("expected the second rollback to be refused, got %v", err)
+	}
+}
+
+func TestLoadUndoPlan_AllowsRetryAfterFailedRollback(t *testing.T) {
+	ctx := context.Background()
+	config := &RunConfig{StateBackend: t.TempDir(), DatabaseDSN: "root@tcp(localhost:3306)/app"}
+
+	store, err := state.Open(ctx, config.StateBackend, config.DatabaseDSN, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	history := state.NewHistory(store)
+	for _, record := range []state.RunRecord{
+		{ID: "run-1", Definition: "cleanup.yaml", Environment: "prod", Status: state.StatusPassed},
+		{ID: "run-2", Definition: "cleanup.yaml", Environment: "prod", Status: state.StatusFailed, RollbackOf: "run-1"},
+		{ID: "run-3", Definition: "cleanup.yaml", Environment: "prod", Status: state.StatusPassed, DryRun: true, RollbackOf: "run-1"},
+	} {
+		if err := history.SaveRun(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := history.SaveUndo(ctx, definition.UndoPlan{RunID: "run-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := loadUndoPlan(ctx, config, "run-1", false); err != nil {
+		t.Errorf("failed and dry-run rollbacks should not block another attempt: %v", err)
+	}
+}
@@ -1,14 +1,17 @@
 package opsql
 
 import (
+	"errors"
 	"os"
 
 	"github.com/joho/godotenv"
+	"github.com/pyama86/opsql/internal/definition"
 	"github.com/spf13/cobra"
 )
 
 var rootCmd = &cobra.Command{
 	Use:          "opsql",
+	Version:      version,
 	SilenceUsage: true,
 	Short:        "A CLI tool for managing operational SQL with dry-run and automation features",
 	Long: `opsql is a CLI tool that helps manage operational SQL operations with YAML definitions.
@@ -25,6 +28,10 @@ Features:
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -33,5 +40,52 @@ func init() {
 	// .envファイルを読み込み（存在しない場合は無視）
 	_ = godotenv.Load()
 
+	rootCmd.PersistentFlags().String("report-timezone", "", "Time zone of the timestamps in reports and notifications, such as Asia/Tokyo (default UTC, can use OPSQL_REPORT_TIMEZONE env)")
+	rootCmd.PersistentPreRunE = preRun
+
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(pipelineCmd)
+	rootCmd.AddCommand(promoteCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(driftCmd)
+	rootCmd.AddCommand(compareCmd)
+	rootCmd.AddCommand(verifyAllCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(cancelCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(genCmd)
+	rootCmd.AddCommand(selfUpdateCmd)
+	rootCmd.AddCommand(entrypointCmd)
+	rootCmd.AddCommand(envCmd)
+}
+
+// setReportTimezone applies --report-timezone before any command runs
+func setReportTimezone(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("report-timezone")
+	if name == "" {
+		name = os.Getenv("OPSQL_REPORT_TIMEZONE")
+	}
+	loc, err := definition.LoadReportLocation(name)
+	if err != nil {
+		return err
+	}
+	definition.SetReportLocation(loc)
+	return nil
+}
+
+// preRun prepares the environment of every command: the env commands manage .env.enc and
+// must work without it
+func preRun(cmd *cobra.Command, args []string) error {
+	if cmd.Parent() != envCmd {
+		if err := loadSecrets(cmd.Context()); err != nil {
+			return err
+		}
+		if err := resolveFlags(cmd); err != nil {
+			return err
+		}
+	}
+	return setReportTimezone(cmd, args)
 }
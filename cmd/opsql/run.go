@@ -2,17 +2,36 @@ package opsql
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pyama86/opsql/internal/database"
 	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/emitter"
+	"github.com/pyama86/opsql/internal/ephemeral"
 	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/featureflag"
+	"github.com/pyama86/opsql/internal/fixture"
 	"github.com/pyama86/opsql/internal/github"
+	"github.com/pyama86/opsql/internal/kafka"
+	"github.com/pyama86/opsql/internal/routing"
+	"github.com/pyama86/opsql/internal/schema"
 	"github.com/pyama86/opsql/internal/slack"
+	"github.com/pyama86/opsql/internal/state"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var runCmd = &cobra.Command{
@@ -25,46 +44,274 @@ Use --dry-run to execute in dry-run mode without making permanent changes.`,
 }
 
 func init() {
-	runCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
-	runCmd.Flags().BoolP("dry-run", "d", false, "Execute in dry-run mode without making permanent changes")
-	runCmd.Flags().StringP("environment", "e", "", "Environment name (e.g., dev, staging, prod)")
-	runCmd.Flags().String("github-repo", "", "GitHub repository (owner/repo)")
-	runCmd.Flags().Int("github-pr", 0, "GitHub PR number")
-	runCmd.Flags().String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
-
+	addRunFlags(runCmd.Flags())
 	_ = runCmd.MarkFlagRequired("config")
 }
 
+// addRunFlags defines the flags of run, which entrypoint shares
+func addRunFlags(flags *pflag.FlagSet) {
+	flags.StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	flags.StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	flags.BoolP("dry-run", "d", false, "Execute in dry-run mode without making permanent changes")
+	flags.StringP("environment", "e", "", "Environment name (e.g., dev, staging, prod)")
+	flags.String("github-repo", "", "GitHub repository (owner/repo)")
+	flags.Int("github-pr", 0, "GitHub PR number")
+	flags.String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
+	flags.String("slack-channel", "", "Slack channel to post to with SLACK_BOT_TOKEN instead of the webhook, updating the message on retries (optional, can use SLACK_CHANNEL env)")
+	flags.String("idempotency-key", "", "Key identifying the run across CI retries, whose notifications are updated instead of posted again (default: derived from the CI job; can use OPSQL_IDEMPOTENCY_KEY env)")
+	flags.String("routing", "", "Routing file sending the failures of operations with an owner to that owner's Slack or PagerDuty (optional, can use OPSQL_ROUTING env)")
+	flags.String("ephemeral", "", "Run against a throwaway database container started from this image (e.g. mysql:8, postgres:16)")
+	flags.StringSlice("fixture", []string{}, "SQL or CSV files loaded into the --ephemeral database before executing operations")
+	flags.Bool("load-fixtures", false, "Load the definition's fixtures into the --ephemeral database before executing operations")
+	flags.String("state-dir", "", "Directory for run history and locks (optional, can use OPSQL_STATE_DIR env)")
+	flags.String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+	flags.String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (optional, can use OPSQL_STATE_BACKEND env)")
+	flags.Int("repeat", 1, "With --dry-run, run the definition this many times and fail if any result differs between runs")
+	flags.Float64("anomaly-factor", defaultAnomalyFactor, "Warn when a DML operation affects this many times more or fewer rows than its historical median; 0 disables (needs a state backend, can use OPSQL_ANOMALY_FACTOR env)")
+	flags.Bool("progress", false, "Print each operation to stderr as it starts and finishes")
+	flags.Int("sample-rows", 0, "Include up to this many of the rows each DML operation touches in its report (masked per the definition's mask)")
+	flags.String("emit", "", "Publish affected-entity events for capture_keys after a successful apply: https://... webhook or sqs://... queue (optional, can use OPSQL_EMIT env)")
+	flags.String("schema-baseline", "", "SQL file with the expected CREATE TABLE statements; the run aborts if referenced tables drifted")
+	flags.String("shadow-dsn", "", "Shadow database DSN; with --dry-run, referenced tables are copied there and operations are committed against it")
+	flags.Int("shadow-max-rows", executor.DefaultShadowRowLimit, "Most rows copied from a table into the shadow database; a table with more fails the run")
+	flags.Duration("tx-monitor-interval", defaultTxMonitorInterval, "Log the age, held locks and idleness of the run's transaction at this interval; 0 disables (can use OPSQL_TX_MONITOR_INTERVAL env)")
+	flags.Duration("max-tx-age", 0, "Abort the run and roll back once its transaction has been open this long, e.g. 30m; 0 disables (can use OPSQL_MAX_TX_AGE env)")
+	flags.Bool("pg-notify", false, "After a successful apply on PostgreSQL, NOTIFY --pg-notify-channel with the run ID as payload, delivered when the transaction commits")
+	flags.String("pg-notify-channel", defaultNotifyChannel, "Channel of --pg-notify (can use OPSQL_PG_NOTIFY_CHANNEL env)")
+	flags.Bool("skip-binlog-check", false, "Do not check that the changes of a MySQL run replicate safely (sql_log_bin, statements unsafe for binlog_format STATEMENT)")
+	flags.Bool("record-undo", false, "Store the statements restoring the rows deleted and updated by an apply, for opsql rollback (needs a state backend)")
+	flags.Bool("read-only", false, "Reject definitions with any operation other than a SELECT and run in a read-only transaction, for scheduled monitoring runs")
+	flags.Bool("offline", false, "Load, validate and render the definitions and print the SQL they would execute without connecting to any database or service")
+	flags.String("report-file", "", "Also write the run's outcome and reports as JSON to this file, even when the run fails (optional, can use OPSQL_REPORT_FILE env)")
+}
+
+var schemaNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
 type RunConfig struct {
-	ConfigFiles  []string
-	DatabaseDSN  string
-	DryRun       bool
-	Environment  string
-	GitHubRepo   string
-	GitHubPR     int
-	SlackWebhook string
+	ConfigFiles    []string
+	Params         map[string]string
+	DatabaseDSN    string
+	DryRun         bool
+	Environment    string
+	GitHubRepo     string
+	GitHubPR       int
+	SlackWebhook   string
+	SlackChannel   string
+	ShadowDSN      string
+	ShadowMaxRows  int
+	Ephemeral      string
+	Fixtures       []string
+	LoadFixtures   bool
+	SchemaBaseline string
+	SampleRows     int
+	Repeat         int
+	Emit           string
+	StateBackend   string
+	OpsqlSchema    string
+	AnomalyFactor  float64
+	Routing        string
+	// IdempotencyKey identifies the run across retries of its CI job for notifications
+	IdempotencyKey string
+	// ReadOnly rejects definitions that write and begins the transaction read-only
+	ReadOnly bool
+	// RecordUndo stores the undo plan of an apply in the state backend
+	RecordUndo bool
+	// SkipBinlogCheck leaves out the replication safety checks of MySQL runs
+	SkipBinlogCheck bool
+	// NotifyChannel is notified of the run ID when an apply commits on PostgreSQL
+	NotifyChannel string
+	// TxMonitorInterval and MaxTxAge watch the transaction of long runs
+	TxMonitorInterval time.Duration
+	MaxTxAge          time.Duration
+	// ReportFile receives the outcome and reports of the run as JSON
+	ReportFile string
+	// Offline renders the operations without connecting to the database or any other service
+	Offline bool
+	// RunID, Actor and PlanRunID are set by server mode; the CLI generates and detects them
+	RunID     string
+	Actor     string
+	PlanRunID string
+	// Definition is executed instead of loading ConfigFiles, which then only name the run;
+	// rollback sets it with RollbackOf, the apply it reverts
+	Definition *definition.Definition
+	RollbackOf string
+	// Progress receives an event as each operation starts and finishes
+	Progress executor.ProgressFunc
+	// Cancel stops the run before the next operation once closed
+	Cancel <-chan struct{}
 }
 
 func runRun(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
-
+	startedAt := time.Now()
 	config, err := loadRunConfig(cmd)
 	if err != nil {
-		return err
+		// 設定の誤りでも結果の行は出す
+		printRunSummary(os.Stderr, nil, nil, time.Since(startedAt), outcomeAborted)
+		return &exitError{code: ExitAborted, err: err}
 	}
 
-	def, err := definition.LoadDefinitions(config.ConfigFiles)
-	if err != nil {
-		definitionErr := fmt.Errorf("failed to load definition: %w", err)
-		sendNotifications(ctx, config, nil, definitionErr)
-		return definitionErr
+	// SIGUSR1 cancels the run before its next operation
+	cancel, stop := cancelOnSignal(cancelSignal)
+	defer stop()
+	config.Cancel = cancel
+
+	return executeRun(context.Background(), config)
+}
+
+// closedByEither returns a channel closed once a or b is closed; stop ends watching them
+func closedByEither(a, b <-chan struct{}) (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-a:
+			close(closed)
+		case <-b:
+			close(closed)
+		case <-done:
+		}
+	}()
+	return closed, func() { close(done) }
+}
+
+// cancelOnSignal returns a channel closed when the process receives sig; with a nil sig it is never closed
+func cancelOnSignal(sig os.Signal) (<-chan struct{}, func()) {
+	if sig == nil {
+		return make(chan struct{}), func() {}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig)
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintf(os.Stderr, "Received %v, cancelling before the next operation\n", sig)
+			close(cancel)
+		case <-done:
+		}
+	}()
+	return cancel, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// executeRun loads, executes and reports the definitions of a run
+func executeRun(ctx context.Context, config *RunConfig) (runErr error) {
+	startedAt := time.Now()
+
+	def := config.Definition
+	if def == nil {
+		var err error
+		def, err = definition.LoadDefinitionsWithParams(config.ConfigFiles, config.Params)
+		if err != nil {
+			return abortRun(ctx, config, nil, startedAt, fmt.Errorf("failed to load definition: %w", err))
+		}
+	}
+	if config.ReadOnly {
+		if err := def.CheckReadOnly(); err != nil {
+			return abortRun(ctx, config, def, startedAt, err)
+		}
+	}
+
+	warnings := def.Lint()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if config.Offline {
+		return executeOffline(config, def, warnings, startedAt)
+	}
+
+	var reports []definition.Report
+	var history *state.History
+	runID := config.RunID
+	cancel := config.Cancel
+	var undo *definition.UndoPlan
+	if config.RecordUndo && !config.DryRun {
+		undo = &definition.UndoPlan{}
+	}
+
+	if config.StateBackend != "" {
+		store, err := state.Open(ctx, config.StateBackend, config.DatabaseDSN, config.OpsqlSchema)
+		if err != nil {
+			return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to open state backend: %w", err))
+		}
+		defer func() {
+			if err := store.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+			}
+		}()
+
+		history = state.NewHistory(store)
+		record, err := newRunRecord(config, startedAt)
+		if err != nil {
+			return abortRun(ctx, config, def, startedAt, err)
+		}
+		runID = record.ID
+
+		lock, err := history.AcquireLock(ctx, state.LockKey(record.Definition, record.Environment), record.ID, record.Actor)
+		if err != nil {
+			return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to lock run: %w", err))
+		}
+		defer func() {
+			if err := lock.Release(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to release run lock: %v\n", err)
+			}
+		}()
+		// ロックを引き継いだ実行と重ならないよう、失ったら次の操作の前に止める
+		var stopWatching func()
+		cancel, stopWatching = closedByEither(cancel, lock.Lost())
+		defer stopWatching()
+		defer func() {
+			finishRunRecord(record, reports, runErr)
+			if err := history.SaveRun(ctx, *record); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to save run history: %v\n", err)
+			}
+			if record.Status == state.StatusPassed && !record.DryRun {
+				var err error
+				if record.RollbackOf == "" {
+					_, err = history.RecordRelease(ctx, *record)
+				} else {
+					_, err = history.RevertRelease(ctx, *record)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to record release: %v\n", err)
+				}
+			}
+			// 取り消せるのはコミットされた適用だけ
+			if undo != nil && record.Status == state.StatusPassed {
+				undo.RunID = record.ID
+				if err := history.SaveUndo(ctx, *undo); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to save undo plan: %v\n", err)
+				} else if len(undo.Skipped) > 0 {
+					fmt.Fprintf(os.Stderr, "Warning: the undo plan of run %s does not revert %d operations\n", record.ID, len(undo.Skipped))
+				}
+			}
+		}()
+	}
+
+	if runID == "" {
+		runID = state.NewRunID()
+	}
+
+	if config.Ephemeral != "" {
+		container, err := ephemeral.Start(ctx, config.Ephemeral)
+		if err != nil {
+			return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to start ephemeral database: %w", err))
+		}
+		defer func() {
+			if err := container.Terminate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to terminate ephemeral database: %v\n", err)
+			}
+		}()
+		config.DatabaseDSN = container.DSN
 	}
 
 	db, err := database.NewDatabase(config.DatabaseDSN)
 	if err != nil {
-		dbErr := fmt.Errorf("failed to connect to database: %w", err)
-		sendNotifications(ctx, config, nil, dbErr)
-		return dbErr
+		return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to connect to database: %w", err))
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -72,16 +319,108 @@ func runRun(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	var reports []definition.Report
+	if config.NotifyChannel != "" && db.DriverName() != "postgres" {
+		return abortRun(ctx, config, def, startedAt, fmt.Errorf("--pg-notify requires PostgreSQL, the database is %s", db.DriverName()))
+	}
+
+	fixtures := make([]definition.Fixture, 0, len(config.Fixtures))
+	for _, path := range config.Fixtures {
+		fixtures = append(fixtures, definition.Fixture{File: path})
+	}
+	if config.LoadFixtures {
+		fixtures = append(fixtures, def.Fixtures...)
+	}
+	for _, f := range fixtures {
+		if err := fixture.Load(ctx, db, f); err != nil {
+			return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to load fixture: %w", err))
+		}
+	}
+
+	if def.HasDerivedParams() {
+		if err := def.ResolveDerivedParams(ctx, db); err != nil {
+			return abortRun(ctx, config, def, startedAt, fmt.Errorf("failed to resolve derived params: %w", err))
+		}
+		for _, derived := range def.DerivedParams {
+			fmt.Fprintf(os.Stderr, "derived param %s = %v\n", derived.Name, def.Params[derived.Name])
+		}
+	}
+
+	if config.SchemaBaseline != "" {
+		if err := checkSchemaBaseline(ctx, db, def, config.SchemaBaseline); err != nil {
+			return abortRun(ctx, config, def, startedAt, err)
+		}
+	}
+
+	var binlog *database.BinlogSettings
+	if db.DriverName() == "mysql" && !config.SkipBinlogCheck && !config.ReadOnly && config.ShadowDSN == "" {
+		binlog, err = checkBinlog(ctx, db, config.DryRun)
+		if err != nil {
+			return abortRun(ctx, config, def, startedAt, err)
+		}
+	}
+
+	if len(def.FeatureFlags) > 0 {
+		if err := featureflag.NewChecker().Check(ctx, def.FeatureFlags); err != nil {
+			return abortRun(ctx, config, def, startedAt, err)
+		}
+	}
+
+	named := database.NewNamed()
+	defer func() {
+		if err := named.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close databases: %v\n", err)
+		}
+	}()
+
+	opts := []executor.Option{
+		executor.WithSampleRows(config.SampleRows),
+		executor.WithRepeat(config.Repeat),
+		executor.WithDatabases(named.Open),
+	}
+	if config.Progress != nil {
+		opts = append(opts, executor.WithProgress(config.Progress))
+	}
+	if cancel != nil {
+		opts = append(opts, executor.WithCancel(cancel))
+	}
+	if config.ReadOnly {
+		opts = append(opts, executor.WithReadOnly())
+	}
+	if undo != nil {
+		opts = append(opts, executor.WithUndo(undo))
+	}
+	if binlog != nil && binlog.StatementBased() {
+		opts = append(opts, executor.WithUnsafeStatementCheck())
+	}
+	if config.NotifyChannel != "" {
+		opts = append(opts, executor.WithNotify(config.NotifyChannel, runID))
+	}
+	if config.TxMonitorInterval > 0 || config.MaxTxAge > 0 {
+		opts = append(opts, executor.WithTxMonitor(config.TxMonitorInterval, config.MaxTxAge, printTxStatus(os.Stderr, config.TxMonitorInterval)))
+	}
+
 	var executionErr error
-	if config.DryRun {
-		planExecutor := executor.NewPlanExecutor(db)
+	if config.ShadowDSN != "" {
+		reports, executionErr = runShadow(ctx, db, config.ShadowDSN, def, append(opts, executor.WithShadowRowLimit(config.ShadowMaxRows))...)
+	} else if config.DryRun {
+		planExecutor := executor.NewPlanExecutor(db, opts...)
 		reports, executionErr = planExecutor.Execute(ctx, def)
 	} else {
-		applyExecutor := executor.NewApplyExecutor(db)
+		applyExecutor := executor.NewApplyExecutor(db, opts...)
 		reports, executionErr = applyExecutor.Execute(ctx, def)
 	}
 
+	definition.LocalizeReports(reports)
+	addWarnings(reports, warnings)
+
+	if history != nil && config.AnomalyFactor > 0 {
+		warnAnomalies(ctx, history, config, reports)
+	}
+
+	if config.Emit != "" && !config.DryRun && executionErr == nil {
+		emitEvents(ctx, config, reports)
+	}
+
 	// Always output reports and send notifications, even on failure
 	if len(reports) > 0 {
 		if err := outputRunReports(reports); err != nil {
@@ -92,32 +431,324 @@ func runRun(cmd *cobra.Command, args []string) error {
 	// Send notifications regardless of whether we have reports
 	sendNotifications(ctx, config, reports, executionErr)
 
+	outcome := outcomeCommitted
+	exitCode := ExitPassed
+	if errors.Is(executionErr, definition.ErrCancelled) {
+		outcome = outcomeCancelled
+		exitCode = ExitCancelled
+	} else if config.DryRun || executionErr != nil {
+		outcome = outcomeRolledBack
+	}
+	if executionErr != nil && exitCode == ExitPassed {
+		exitCode = ExitFailed
+	}
+	printRunSummary(os.Stderr, def, reports, time.Since(startedAt), outcome)
+	writeReportFile(config, runReportFile{Outcome: outcome, ExitCode: exitCode}, startedAt, reports, executionErr)
+
 	// Return the original execution error if it occurred
 	if executionErr != nil {
 		if config.DryRun {
-			return fmt.Errorf("failed to execute dry run: %w", executionErr)
+			executionErr = fmt.Errorf("failed to execute dry run: %w", executionErr)
+		} else {
+			executionErr = fmt.Errorf("failed to execute: %w", executionErr)
+		}
+		return &exitError{code: exitCode, err: executionErr}
+	}
+
+	return nil
+}
+
+// executeOffline reports the SQL of the operations and the rows they expect to change, without
+// connecting anywhere: no database, state backend, feature flags or notifications
+func executeOffline(config *RunConfig, def *definition.Definition, warnings []definition.Warning, startedAt time.Time) error {
+	if def.HasDerivedParams() {
+		names, err := def.PlaceholderDerivedParams()
+		if err != nil {
+			printRunSummary(os.Stderr, def, nil, time.Since(startedAt), outcomeAborted)
+			writeReportFile(config, runReportFile{Outcome: outcomeAborted, ExitCode: ExitAborted}, startedAt, nil, err)
+			return &exitError{code: ExitAborted, err: fmt.Errorf("failed to process templates: %w", err)}
+		}
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "Warning: derived param %s is not resolved offline, the SQL shows <%s> (pass --param %s=... to set it)\n", name, name, name)
 		}
-		return fmt.Errorf("failed to execute: %w", executionErr)
 	}
 
+	reports := executor.OfflineReports(def)
+	addWarnings(reports, warnings)
+	if err := outputRunReports(reports); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to output reports: %v\n", err)
+	}
+
+	impact := executor.OfflineImpact(def)
+	var changes []string
+	for _, opType := range []string{definition.TypeInsert, definition.TypeUpdate, definition.TypeDelete} {
+		if impact[opType] > 0 {
+			changes = append(changes, fmt.Sprintf("%s %d", opType, impact[opType]))
+		}
+	}
+	summary := "no row changes expected"
+	if len(changes) > 0 {
+		summary = "expects to " + strings.Join(changes, ", ") + " rows"
+	}
+	if tables := def.Tables(); len(tables) > 0 {
+		summary += " in " + strings.Join(tables, ", ")
+	}
+	fmt.Fprintf(os.Stderr, "opsql: %d operations not executed in %s — OFFLINE, %s\n", len(reports), time.Since(startedAt).Round(time.Millisecond), summary)
+	writeReportFile(config, runReportFile{Outcome: outcomeOffline, ExitCode: ExitPassed}, startedAt, reports, nil)
 	return nil
 }
 
+func newRunRecord(config *RunConfig, startedAt time.Time) (*state.RunRecord, error) {
+	// ロールバックは保存済みの文を実行するので、定義ファイルが変わっていても構わない
+	var checksum string
+	if config.RollbackOf == "" {
+		var err error
+		checksum, err = definition.Checksum(config.ConfigFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := &state.RunRecord{
+		ID:          config.RunID,
+		Definition:  definition.Name(config.ConfigFiles),
+		Configs:     config.ConfigFiles,
+		Params:      config.Params,
+		Checksum:    checksum,
+		Environment: config.Environment,
+		Actor:       config.Actor,
+		DryRun:      config.DryRun,
+		PlanRunID:   config.PlanRunID,
+		GitHubRepo:  config.GitHubRepo,
+		GitHubPR:    config.GitHubPR,
+		RollbackOf:  config.RollbackOf,
+		StartedAt:   startedAt.UTC(),
+	}
+	if record.ID == "" {
+		record.ID = state.NewRunID()
+	}
+	if record.Actor == "" {
+		record.Actor = state.CurrentActor()
+	}
+	return record, nil
+}
+
+func finishRunRecord(record *state.RunRecord, reports []definition.Report, runErr error) {
+	record.FinishedAt = time.Now().UTC()
+	record.Reports = reports
+
+	switch {
+	case runErr == nil:
+		record.Status = state.StatusPassed
+	case errors.Is(runErr, definition.ErrCancelled):
+		record.Status = state.StatusCancelled
+	case len(reports) == 0:
+		record.Status = state.StatusAborted
+	default:
+		record.Status = state.StatusFailed
+	}
+	if runErr != nil {
+		record.Error = runErr.Error()
+	}
+}
+
+// abortRun reports a failure that happened before any operation was executed
+func abortRun(ctx context.Context, config *RunConfig, def *definition.Definition, startedAt time.Time, err error) error {
+	sendNotifications(ctx, config, nil, err)
+	printRunSummary(os.Stderr, def, nil, time.Since(startedAt), outcomeAborted)
+	writeReportFile(config, runReportFile{Outcome: outcomeAborted, ExitCode: ExitAborted}, startedAt, nil, err)
+	return &exitError{code: ExitAborted, err: err}
+}
+
+// checkBinlog fails an apply whose changes would not replicate safely; a dry run only warns
+func checkBinlog(ctx context.Context, db database.DB, dryRun bool) (*database.BinlogSettings, error) {
+	settings, err := database.ReadBinlogSettings(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read binlog settings (use --skip-binlog-check to run anyway): %w", err)
+	}
+
+	problems := settings.Problems()
+	if len(problems) > 0 && !dryRun {
+		return nil, fmt.Errorf("binlog: %s (use --skip-binlog-check to apply anyway)", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "Warning: binlog: %s\n", problem)
+	}
+	return settings, nil
+}
+
+// checkSchemaBaseline fails when the tables referenced by the definition drifted from the baseline
+func checkSchemaBaseline(ctx context.Context, db database.DB, def *definition.Definition, baselinePath string) error {
+	baseline, err := os.ReadFile(baselinePath)
+	if err != nil {
+		return fmt.Errorf("failed to read schema baseline: %s %w", baselinePath, err)
+	}
+
+	ddl, err := schema.Dump(ctx, db, def.Tables())
+	if err != nil {
+		return err
+	}
+
+	drifts := schema.Compare(string(baseline), ddl)
+	if len(drifts) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(drifts))
+	for i, drift := range drifts {
+		messages[i] = drift.String()
+	}
+	return fmt.Errorf("schema drift detected against %s:\n%s", baselinePath, strings.Join(messages, "\n"))
+}
+
+// parseParamFlags reads the key=value pairs of --param
+func parseParamFlags(cmd *cobra.Command) (map[string]string, error) {
+	values, _ := cmd.Flags().GetStringArray("param")
+	params := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --param %q (expected key=value)", value)
+		}
+		params[key] = val
+	}
+	return params, nil
+}
+
 func loadRunConfig(cmd *cobra.Command) (*RunConfig, error) {
 	config := &RunConfig{}
 
 	config.ConfigFiles, _ = cmd.Flags().GetStringSlice("config")
+	params, err := parseParamFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	config.Params = params
 	config.DryRun, _ = cmd.Flags().GetBool("dry-run")
 	config.Environment, _ = cmd.Flags().GetString("environment")
 	config.GitHubRepo, _ = cmd.Flags().GetString("github-repo")
 	config.GitHubPR, _ = cmd.Flags().GetInt("github-pr")
 	config.SlackWebhook, _ = cmd.Flags().GetString("slack-webhook")
+	loadNotificationFlags(cmd, config)
+	config.Routing, _ = cmd.Flags().GetString("routing")
+	if config.Routing == "" {
+		config.Routing = os.Getenv("OPSQL_ROUTING")
+	}
+	config.ShadowDSN, _ = cmd.Flags().GetString("shadow-dsn")
+	config.ShadowMaxRows, _ = cmd.Flags().GetInt("shadow-max-rows")
+	config.Ephemeral, _ = cmd.Flags().GetString("ephemeral")
+	config.Fixtures, _ = cmd.Flags().GetStringSlice("fixture")
+	config.LoadFixtures, _ = cmd.Flags().GetBool("load-fixtures")
+	config.SchemaBaseline, _ = cmd.Flags().GetString("schema-baseline")
+	config.SampleRows, _ = cmd.Flags().GetInt("sample-rows")
+	config.Repeat, _ = cmd.Flags().GetInt("repeat")
+	if progress, _ := cmd.Flags().GetBool("progress"); progress {
+		config.Progress = printProgress(os.Stderr)
+	}
+	config.Emit, _ = cmd.Flags().GetString("emit")
+	if config.Emit == "" {
+		config.Emit = os.Getenv("OPSQL_EMIT")
+	}
+	config.AnomalyFactor, err = anomalyFactor(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTxMonitorFlags(cmd, config); err != nil {
+		return nil, err
+	}
+	config.ReportFile, _ = cmd.Flags().GetString("report-file")
+	if config.ReportFile == "" {
+		config.ReportFile = os.Getenv("OPSQL_REPORT_FILE")
+	}
+	config.StateBackend, _ = cmd.Flags().GetString("state-backend")
+	if config.StateBackend == "" {
+		config.StateBackend = os.Getenv("OPSQL_STATE_BACKEND")
+	}
+	// --state-dir is the local-file backend
+	if config.StateBackend == "" {
+		config.StateBackend, _ = cmd.Flags().GetString("state-dir")
+	}
+	if config.StateBackend == "" {
+		config.StateBackend = os.Getenv("OPSQL_STATE_DIR")
+	}
+
+	config.ReadOnly, _ = cmd.Flags().GetBool("read-only")
+	if config.ReadOnly && config.LoadFixtures {
+		return nil, fmt.Errorf("--read-only cannot be used with --load-fixtures")
+	}
+
+	config.Offline, _ = cmd.Flags().GetBool("offline")
+	if config.Offline {
+		for _, name := range []string{"shadow-dsn", "shadow-max-rows", "ephemeral", "fixture", "load-fixtures", "schema-baseline", "repeat", "emit", "sample-rows", "record-undo", "pg-notify"} {
+			if cmd.Flags().Changed(name) {
+				return nil, fmt.Errorf("--%s cannot be used with --offline", name)
+			}
+		}
+		// オフラインでは何も変更しないので常にdry-runとして扱う
+		config.DryRun = true
+		config.StateBackend = ""
+		config.Emit = ""
+		return config, nil
+	}
+
+	if config.ShadowDSN != "" && !config.DryRun {
+		return nil, fmt.Errorf("--shadow-dsn can only be used with --dry-run")
+	}
+
+	if notify, _ := cmd.Flags().GetBool("pg-notify"); notify {
+		config.NotifyChannel, _ = cmd.Flags().GetString("pg-notify-channel")
+		if !cmd.Flags().Changed("pg-notify-channel") && os.Getenv("OPSQL_PG_NOTIFY_CHANNEL") != "" {
+			config.NotifyChannel = os.Getenv("OPSQL_PG_NOTIFY_CHANNEL")
+		}
+		if config.NotifyChannel == "" {
+			return nil, fmt.Errorf("--pg-notify-channel must not be empty")
+		}
+	}
+
+	config.SkipBinlogCheck, _ = cmd.Flags().GetBool("skip-binlog-check")
+
+	config.RecordUndo, _ = cmd.Flags().GetBool("record-undo")
+	if config.RecordUndo && config.StateBackend == "" {
+		return nil, fmt.Errorf("--record-undo requires a state backend (--state-backend or OPSQL_STATE_BACKEND)")
+	}
+
+	if config.Repeat < 1 {
+		return nil, fmt.Errorf("--repeat must be at least 1")
+	}
+	if config.Repeat > 1 && (!config.DryRun || config.ShadowDSN != "") {
+		return nil, fmt.Errorf("--repeat can only be used with --dry-run without --shadow-dsn")
+	}
 
 	// Environment can also be set from OPSQL_ENVIRONMENT env var
 	if config.Environment == "" {
 		config.Environment = os.Getenv("OPSQL_ENVIRONMENT")
 	}
 
+	config.OpsqlSchema, _ = cmd.Flags().GetString("opsql-schema")
+	if config.OpsqlSchema == "" {
+		config.OpsqlSchema = os.Getenv("OPSQL_SCHEMA")
+	}
+	if config.OpsqlSchema != "" && !schemaNamePattern.MatchString(config.OpsqlSchema) {
+		return nil, fmt.Errorf("invalid --opsql-schema: %s", config.OpsqlSchema)
+	}
+
+	if config.StateBackend == "database" && config.Ephemeral != "" {
+		return nil, fmt.Errorf("--state-backend database cannot be used with --ephemeral")
+	}
+
+	// フィクスチャは自動コミットで読み込まれ、dry-runでも巻き戻らない
+	if len(config.Fixtures) > 0 && config.Ephemeral == "" {
+		return nil, fmt.Errorf("--fixture can only be used with --ephemeral")
+	}
+	if config.LoadFixtures && config.Ephemeral == "" {
+		return nil, fmt.Errorf("--load-fixtures can only be used with --ephemeral")
+	}
+
+	// The ephemeral container provides its own DSN
+	if config.Ephemeral != "" {
+		return config, nil
+	}
+
 	config.DatabaseDSN = os.Getenv("DATABASE_DSN")
 	if config.DatabaseDSN == "" {
 		return nil, fmt.Errorf("DATABASE_DSN environment variable is required")
@@ -126,6 +757,107 @@ func loadRunConfig(cmd *cobra.Command) (*RunConfig, error) {
 	return config, nil
 }
 
+const defaultAnomalyFactor = 10
+
+const defaultTxMonitorInterval = time.Minute
+
+const defaultNotifyChannel = "opsql_applied"
+
+// loadTxMonitorFlags reads --tx-monitor-interval and --max-tx-age, then their env vars; commands
+// without the flags only read the env vars
+func loadTxMonitorFlags(cmd *cobra.Command, config *RunConfig) error {
+	config.TxMonitorInterval = defaultTxMonitorInterval
+	settings := []struct {
+		flag, env string
+		value     *time.Duration
+	}{
+		{"tx-monitor-interval", "OPSQL_TX_MONITOR_INTERVAL", &config.TxMonitorInterval},
+		{"max-tx-age", "OPSQL_MAX_TX_AGE", &config.MaxTxAge},
+	}
+	for _, setting := range settings {
+		if flag := cmd.Flags().Lookup(setting.flag); flag != nil && flag.Changed {
+			*setting.value, _ = cmd.Flags().GetDuration(setting.flag)
+		} else if value := os.Getenv(setting.env); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", setting.env, value)
+			}
+			*setting.value = d
+		}
+		if *setting.value < 0 {
+			return fmt.Errorf("--%s must not be negative", setting.flag)
+		}
+	}
+	return nil
+}
+
+// anomalyFactor reads --anomaly-factor, then OPSQL_ANOMALY_FACTOR
+func anomalyFactor(cmd *cobra.Command) (float64, error) {
+	factor := float64(defaultAnomalyFactor)
+	if flag := cmd.Flags().Lookup("anomaly-factor"); flag != nil && flag.Changed {
+		factor, _ = cmd.Flags().GetFloat64("anomaly-factor")
+	} else if value := os.Getenv("OPSQL_ANOMALY_FACTOR"); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid OPSQL_ANOMALY_FACTOR: %s", value)
+		}
+		factor = f
+	}
+
+	if factor != 0 && factor <= 1 {
+		return 0, fmt.Errorf("--anomaly-factor must be greater than 1 (or 0 to disable), got %v", factor)
+	}
+	return factor, nil
+}
+
+// addWarnings adds the definition's warnings to the reports of their operations
+func addWarnings(reports []definition.Report, warnings []definition.Warning) {
+	for _, warning := range warnings {
+		for i := range reports {
+			if reports[i].ID == warning.OperationID {
+				reports[i].Warnings = append(reports[i].Warnings, warning.Message)
+			}
+		}
+	}
+}
+
+// warnAnomalies adds a warning to the reports whose affected rows are far from the historical median
+func warnAnomalies(ctx context.Context, history *state.History, config *RunConfig, reports []definition.Report) {
+	baselines, err := history.RowBaselines(ctx, state.StatsFilter{
+		Definition:  definition.Name(config.ConfigFiles),
+		Environment: config.Environment,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read run history for anomaly detection: %v\n", err)
+		return
+	}
+
+	for _, anomaly := range state.DetectAnomalies(reports, baselines, config.AnomalyFactor, state.DefaultAnomalyMinSamples) {
+		message := fmt.Sprintf("anomaly: %s (factor %v)", anomaly, config.AnomalyFactor)
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", message)
+		for i := range reports {
+			if reports[i].ID == anomaly.OperationID {
+				reports[i].Warnings = append(reports[i].Warnings, message)
+			}
+		}
+	}
+}
+
+// runShadow executes the definition against a shadow copy of the referenced tables
+func runShadow(ctx context.Context, db database.DB, shadowDSN string, def *definition.Definition, opts ...executor.Option) ([]definition.Report, error) {
+	shadowDB, err := database.NewDatabase(shadowDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to shadow database: %w", err)
+	}
+	defer func() {
+		if err := shadowDB.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close shadow database: %v\n", err)
+		}
+	}()
+
+	return executor.NewShadowExecutor(db, shadowDB, opts...).Execute(ctx, def)
+}
+
 func outputRunReports(reports []definition.Report) error {
 	jsonData, err := json.MarshalIndent(reports, "", "  ")
 	if err != nil {
@@ -136,16 +868,150 @@ func outputRunReports(reports []definition.Report) error {
 	return nil
 }
 
+// Exit codes of commands that run definitions, for schedulers that branch on them
+const (
+	ExitPassed = 0
+	// ExitFailed: an operation failed (an assertion or SQL error) and the transaction was rolled back
+	ExitFailed = 1
+	// ExitAborted: the run did not start, because of invalid flags or definitions, the connection,
+	// the lock or a precondition
+	ExitAborted = 2
+	// ExitCancelled: the run was cancelled and rolled back
+	ExitCancelled = 3
+)
+
+// exitError carries the exit code of a failed run; its message is the error's
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// runReportFile is the document --report-file writes
+type runReportFile struct {
+	Outcome     string              `json:"outcome"`
+	ExitCode    int                 `json:"exit_code"`
+	Error       string              `json:"error,omitempty"`
+	DryRun      bool                `json:"dry_run"`
+	Environment string              `json:"environment,omitempty"`
+	Configs     []string            `json:"configs,omitempty"`
+	StartedAt   string              `json:"started_at"`
+	FinishedAt  string              `json:"finished_at"`
+	Reports     []definition.Report `json:"reports"`
+}
+
+// writeReportFile writes the outcome of the run to config.ReportFile, replacing the file only once it is complete
+func writeReportFile(config *RunConfig, doc runReportFile, startedAt time.Time, reports []definition.Report, err error) {
+	if config.ReportFile == "" {
+		return
+	}
+	if err != nil {
+		doc.Error = err.Error()
+	}
+	doc.DryRun = config.DryRun
+	doc.Environment = config.Environment
+	doc.Configs = config.ConfigFiles
+	doc.StartedAt = definition.FormatReportTime(startedAt)
+	doc.FinishedAt = definition.FormatReportTime(time.Now())
+	doc.Reports = reports
+	if doc.Reports == nil {
+		doc.Reports = []definition.Report{}
+	}
+
+	data, marshalErr := json.MarshalIndent(doc, "", "  ")
+	if marshalErr == nil {
+		tmp := config.ReportFile + ".tmp"
+		if marshalErr = os.WriteFile(tmp, append(data, '\n'), 0644); marshalErr == nil {
+			marshalErr = os.Rename(tmp, config.ReportFile)
+		}
+	}
+	if marshalErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write report file: %v\n", marshalErr)
+	}
+}
+
+const (
+	outcomeCommitted  = "COMMITTED"
+	outcomeRolledBack = "ROLLED BACK"
+	outcomeAborted    = "ABORTED"
+	outcomeCancelled  = "CANCELLED, ROLLED BACK"
+	outcomeOffline    = "OFFLINE"
+)
+
+// printRunSummary writes a one-line outcome so the result is visible at the bottom of any log
+func printRunSummary(w io.Writer, def *definition.Definition, reports []definition.Report, elapsed time.Duration, outcome string) {
+	passed, failed := 0, 0
+	for _, report := range reports {
+		if report.Pass {
+			passed++
+		} else {
+			failed++
+		}
+	}
+
+	skipped := 0
+	if def != nil {
+		skipped = len(def.Operations) - len(reports)
+	}
+
+	fmt.Fprintf(w, "opsql: %d passed, %d failed, %d skipped in %s — %s\n",
+		passed, failed, skipped, elapsed.Round(time.Millisecond), outcome)
+}
+
+// printTxStatus logs the samples of the run's transaction, warning when it sat idle for a whole
+// interval while holding its locks
+func printTxStatus(w io.Writer, interval time.Duration) executor.TxMonitorFunc {
+	return func(status executor.TxStatus) {
+		locks := "unknown locks"
+		if status.Locks >= 0 {
+			locks = fmt.Sprintf("%d locks", status.Locks)
+		}
+		fmt.Fprintf(w, "transaction open for %s, holding %s\n", status.Age.Round(time.Second), locks)
+		if status.Idle >= interval {
+			fmt.Fprintf(w, "Warning: transaction idle in transaction for %s while holding %s\n", status.Idle.Round(time.Second), locks)
+		}
+	}
+}
+
+// printProgress writes a line per progress event, for following long runs in a terminal
+func printProgress(w io.Writer) executor.ProgressFunc {
+	return func(event executor.ProgressEvent) {
+		step := fmt.Sprintf("[%d/%d]", event.Index+1, event.Total)
+		if event.Type == executor.ProgressStarted {
+			fmt.Fprintf(w, "%s %s started\n", step, event.OperationID)
+			return
+		}
+
+		mark := "✔"
+		if !event.Pass {
+			mark = "✘"
+		}
+		detail := fmt.Sprintf("%dms", event.DurationMS)
+		if event.Affected != nil {
+			detail = fmt.Sprintf("%d rows, %s", *event.Affected, detail)
+		}
+		fmt.Fprintf(w, "%s %s %s (%s)\n", step, mark, event.OperationID, detail)
+	}
+}
+
 func sendRunGitHubCommentWithError(ctx context.Context, config *RunConfig, reports []definition.Report, executionErr error) error {
 	client := github.NewClient(config.GitHubRepo, config.GitHubPR)
 	if client == nil {
 		log.Printf("GitHub client not configured, skipping comment\n")
 		return nil // GitHub client not configured, skip sending comment
 	}
+	client.WithIdempotencyKey(notificationKey(config))
 	return client.PostCommentWithContextAndError(ctx, reports, config.DryRun, config.Environment, executionErr)
 }
 
 func sendRunSlackNotificationWithError(config *RunConfig, reports []definition.Report, executionErr error) error {
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" && config.SlackChannel != "" {
+		client := slack.NewBotClient(token, config.SlackChannel).WithIdempotencyKey(notificationKey(config))
+		return client.SendNotificationWithContextAndError(reports, config.DryRun, config.Environment, executionErr)
+	}
+
 	webhookURL := config.SlackWebhook
 	if webhookURL == "" {
 		webhookURL = os.Getenv("SLACK_WEBHOOK_URL")
@@ -159,7 +1025,64 @@ func sendRunSlackNotificationWithError(config *RunConfig, reports []definition.R
 	return client.SendNotificationWithContextAndError(reports, config.DryRun, config.Environment, executionErr)
 }
 
-// sendNotifications sends notifications to both Slack and GitHub
+// loadNotificationFlags reads the Slack channel and idempotency key of commands that start runs
+func loadNotificationFlags(cmd *cobra.Command, config *RunConfig) {
+	config.SlackChannel, _ = cmd.Flags().GetString("slack-channel")
+	if config.SlackChannel == "" {
+		config.SlackChannel = os.Getenv("SLACK_CHANNEL")
+	}
+	config.IdempotencyKey, _ = cmd.Flags().GetString("idempotency-key")
+	if config.IdempotencyKey == "" {
+		config.IdempotencyKey = os.Getenv("OPSQL_IDEMPOTENCY_KEY")
+	}
+}
+
+// notificationKey is the key notifications of a run are posted under, so that a retried CI job
+// updates them instead of posting again. It combines the idempotency key, or the CI job, with
+// what was run: the definitions, params, environment and mode. Runs outside CI have none.
+func notificationKey(config *RunConfig) string {
+	key := config.IdempotencyKey
+	if key == "" {
+		key = state.CIRunKey()
+	}
+	if key == "" {
+		return ""
+	}
+
+	names := make([]string, 0, len(config.Params))
+	for name := range config.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%t\n", key, definition.Name(config.ConfigFiles), config.Environment, config.DryRun)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, config.Params[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// emitEvents publishes the captured keys of an applied run. The changes are already
+// committed, so failures are reported as warnings.
+func emitEvents(ctx context.Context, config *RunConfig, reports []definition.Report) {
+	events := emitter.Events(reports, config.Environment, definition.Name(config.ConfigFiles), time.Now())
+	if len(events) == 0 {
+		return
+	}
+
+	e, err := emitter.Open(ctx, config.Emit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to emit %d events: %v\n", len(events), err)
+		return
+	}
+	defer func() { _ = e.Close() }()
+
+	if err := e.Emit(ctx, events); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to emit %d events: %v\n", len(events), err)
+	}
+}
+
+// sendNotifications sends notifications to GitHub, Slack and Kafka
 func sendNotifications(ctx context.Context, config *RunConfig, reports []definition.Report, err error) {
 	if err := sendRunGitHubCommentWithError(ctx, config, reports, err); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to send GitHub comment: %v\n", err)
@@ -168,4 +1091,51 @@ func sendNotifications(ctx context.Context, config *RunConfig, reports []definit
 	if err := sendRunSlackNotificationWithError(config, reports, err); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to send Slack notification: %v\n", err)
 	}
+
+	if client := kafka.NewClient(); client != nil {
+		if err := client.SendNotificationWithContextAndError(ctx, reports, config.DryRun, config.Environment, err); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to send Kafka notification: %v\n", err)
+		}
+	}
+
+	if config.Routing != "" {
+		routeFailures(ctx, config, reports)
+	}
+}
+
+// routeFailures sends the failed operations that have an owner to the owner's route
+func routeFailures(ctx context.Context, config *RunConfig, reports []definition.Report) {
+	failures := routing.FailuresByOwner(reports)
+	if len(failures) == 0 {
+		return
+	}
+
+	routes, err := routing.Load(ctx, config.Routing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to route failures: %v\n", err)
+		return
+	}
+
+	definitionName := definition.Name(config.ConfigFiles)
+	for _, failure := range failures {
+		route, ok := routes.Owners[failure.Owner]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: no route for owner %s in %s\n", failure.Owner, config.Routing)
+			continue
+		}
+
+		if route.SlackWebhook != "" {
+			client := slack.NewClient(route.SlackWebhook)
+			if err := client.SendNotificationWithContextAndError(failure.Reports, config.DryRun, config.Environment, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to notify %s on Slack: %v\n", failure.Owner, err)
+			}
+		}
+
+		if route.PagerDutyRoutingKey != "" {
+			pagerDuty := routing.NewPagerDuty(os.Getenv("OPSQL_PAGERDUTY_URL"))
+			if err := pagerDuty.Trigger(ctx, route.PagerDutyRoutingKey, failure.Owner, definitionName, config.Environment, failure.Reports); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to page %s: %v\n", failure.Owner, err)
+			}
+		}
+	}
 }
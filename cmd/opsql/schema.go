@@ -0,0 +1,77 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Inspect the schema of tables referenced by definitions",
+}
+
+var schemaDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Print the DDL of tables referenced by the definitions",
+	Long: `Dump prints the CREATE TABLE statements of every table referenced by the operations.
+The output can be saved and passed to "opsql run --schema-baseline".`,
+	RunE: runSchemaDump,
+}
+
+func init() {
+	schemaDumpCmd.Flags().StringSliceP("config", "c", []string{}, "YAML configuration file paths (required, can specify multiple)")
+	schemaDumpCmd.Flags().StringArrayP("param", "p", []string{}, "Override a definition param (key=value, can specify multiple; lists are comma-separated)")
+	_ = schemaDumpCmd.MarkFlagRequired("config")
+
+	schemaCmd.AddCommand(schemaDumpCmd)
+}
+
+func runSchemaDump(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	configFiles, _ := cmd.Flags().GetStringSlice("config")
+	params, err := parseParamFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	def, err := definition.LoadDefinitionsWithParams(configFiles, params)
+	if err != nil {
+		return fmt.Errorf("failed to load definition: %w", err)
+	}
+
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		return fmt.Errorf("DATABASE_DSN environment variable is required")
+	}
+
+	db, err := database.NewDatabase(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", err)
+		}
+	}()
+
+	if def.HasDerivedParams() {
+		if err := def.ResolveDerivedParams(ctx, db); err != nil {
+			return fmt.Errorf("failed to resolve derived params: %w", err)
+		}
+	}
+
+	ddl, err := schema.Dump(ctx, db, def.Tables())
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(schema.Format(ddl))
+	return nil
+}
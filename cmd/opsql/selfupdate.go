@@ -0,0 +1,91 @@
+package opsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyama86/opsql/internal/selfupdate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// version and releasePublicKey are set by the release build with -ldflags -X
+	version = "dev"
+	// releasePublicKey is the base64 ed25519 key that signs the checksums.txt of releases
+	releasePublicKey = ""
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace opsql with the latest release",
+	Long: `Self-update downloads the latest opsql release (or --version) for this platform from
+GitHub, verifies its archive against the release's checksums.txt and the signature of
+checksums.txt, and replaces the running binary. Without --version, it only updates to a
+release newer than the running build.`,
+	RunE: runSelfUpdate,
+}
+
+func init() {
+	selfUpdateCmd.Flags().Bool("check", false, "Only report whether a newer release is available")
+	selfUpdateCmd.Flags().String("version", "", "Release to install, such as v1.4.0, even if it is older than this build (default latest, only if newer)")
+	selfUpdateCmd.Flags().Bool("insecure", false, "Install without verifying the release signature when this build has no release public key")
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	check, _ := cmd.Flags().GetBool("check")
+	target, _ := cmd.Flags().GetString("version")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	updater, err := selfupdate.NewUpdater(releasePublicKey)
+	if err != nil {
+		return err
+	}
+	release, err := updater.Release(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	// 開発ビルドなど比べられない版は、最新のリリースに更新してよい
+	order, comparable := selfupdate.CompareVersions(release.Version(), version)
+	if release.Version() == version || comparable && order == 0 {
+		fmt.Printf("opsql %s is up to date\n", version)
+		return nil
+	}
+	// 最新のリリースより新しいビルドは、--versionで明示しない限り古い版に戻さない
+	if target == "" && comparable && order < 0 {
+		fmt.Printf("opsql %s is newer than the latest release %s\n", version, release.Version())
+		return nil
+	}
+	if check {
+		fmt.Printf("opsql %s is available (current: %s)\n", release.Version(), version)
+		return nil
+	}
+
+	if updater.PublicKey == nil {
+		if !insecure {
+			return fmt.Errorf("this build has no release public key to verify the signature of the release; reinstall from a release archive, or pass --insecure to verify only the checksum")
+		}
+		updater.AllowUnsigned = true
+		fmt.Fprintf(os.Stderr, "Warning: this build has no release public key, only the checksum of the download is verified\n")
+	}
+	binary, err := updater.Download(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	if err := selfupdate.Replace(executable, binary); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", executable, err)
+	}
+	fmt.Printf("Updated opsql %s to %s\n", version, release.Version())
+	return nil
+}
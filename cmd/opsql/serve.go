@@ -0,0 +1,245 @@
+package opsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/github"
+	"github.com/pyama86/opsql/internal/server"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the opsql API and web UI",
+	Long: `Serve starts server mode: an HTTP API and a web UI to list runs, view their reports,
+trigger plans of the definitions under --config-dir, and approve passed plans to apply them.
+Runs are recorded in the state backend, which is required.`,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().String("listen", ":8080", "Address to listen on")
+	serveCmd.Flags().String("grpc-listen", "", "Address to serve the gRPC API on (optional, disabled by default)")
+	serveCmd.Flags().String("config-dir", ".", "Directory containing the definitions that can be run")
+	serveCmd.Flags().String("token", "", "Bearer token shared by API callers, recorded as the actor opsql-server (a token or --tokens-file is required unless --insecure-no-auth, can use OPSQL_SERVER_TOKEN env)")
+	serveCmd.Flags().String("tokens-file", "", "YAML file mapping each API caller's name to a token of its own, recorded as the actor api:<name> of its runs (can use OPSQL_SERVER_TOKENS_FILE env)")
+	serveCmd.Flags().Bool("insecure-no-auth", false, "Serve the API without a token, letting anyone who can reach it plan and apply")
+	serveCmd.Flags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (required, can use OPSQL_STATE_BACKEND env)")
+	serveCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+	serveCmd.Flags().String("github-repo", "", "GitHub repository (owner/repo)")
+	serveCmd.Flags().String("slack-webhook", "", "Slack webhook URL (optional, can use SLACK_WEBHOOK_URL env)")
+	serveCmd.Flags().String("routing", "", "Routing file sending the failures of operations with an owner to that owner's Slack or PagerDuty (optional, can use OPSQL_ROUTING env)")
+	serveCmd.Flags().String("emit", "", "Publish affected-entity events for capture_keys after each successful apply (optional, can use OPSQL_EMIT env)")
+	serveCmd.Flags().Float64("anomaly-factor", defaultAnomalyFactor, "Warn when a DML operation affects this many times more or fewer rows than its historical median; 0 disables (can use OPSQL_ANOMALY_FACTOR env)")
+	serveCmd.Flags().Int("sample-rows", 0, "Include up to this many of the rows each DML operation touches in its report")
+	serveCmd.Flags().String("github-webhook-secret", "", "Enable POST /webhooks/github, verifying deliveries with this secret (optional, can use OPSQL_GITHUB_WEBHOOK_SECRET env)")
+	serveCmd.Flags().StringSlice("webhook-paths", []string{}, "Runbook paths planned when a pull request changes them: glob patterns or directories ending with / (default: every YAML file)")
+	serveCmd.Flags().String("webhook-environment", "", "Environment the plans of pull requests run against")
+	serveCmd.Flags().Bool("chatops", false, "Run /opsql commands from Slack slash commands (with --slack-signing-secret) and pull request comments (with --github-webhook-secret)")
+	serveCmd.Flags().String("slack-signing-secret", "", "Enable POST /chatops/slack for the /opsql slash command, verifying requests with this signing secret (optional, can use SLACK_SIGNING_SECRET env)")
+	serveCmd.Flags().StringSlice("chatops-approvers", []string{}, "Actors allowed to approve with /opsql approve, as slack:<user ID> or github:<login> (default: anyone who can run commands but the planner)")
+	serveCmd.Flags().String("webhook-dir", filepath.Join(os.TempDir(), "opsql-webhook"), "Directory receiving the runbooks of pull requests")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	base, err := loadCommonRunConfig(cmd)
+	if err != nil {
+		return err
+	}
+	listen, _ := cmd.Flags().GetString("listen")
+	configDir, _ := cmd.Flags().GetString("config-dir")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("OPSQL_SERVER_TOKEN")
+	}
+	tokensFile, _ := cmd.Flags().GetString("tokens-file")
+	if tokensFile == "" {
+		tokensFile = os.Getenv("OPSQL_SERVER_TOKENS_FILE")
+	}
+	var tokens map[string]string
+	if tokensFile != "" {
+		if tokens, err = server.LoadTokens(ctx, tokensFile); err != nil {
+			return err
+		}
+	}
+	if token == "" && len(tokens) == 0 {
+		if insecure, _ := cmd.Flags().GetBool("insecure-no-auth"); !insecure {
+			return fmt.Errorf("--token (or OPSQL_SERVER_TOKEN) or --tokens-file is required; pass --insecure-no-auth to serve the API without authentication")
+		}
+		fmt.Fprintf(os.Stderr, "Warning: serving the API without authentication; anyone who can reach %s can plan and apply\n", listen)
+	}
+
+	store, err := openStateStore(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+
+	opts := []server.Option{server.WithToken(token), server.WithTokens(tokens)}
+	secret, _ := cmd.Flags().GetString("github-webhook-secret")
+	if secret == "" {
+		secret = os.Getenv("OPSQL_GITHUB_WEBHOOK_SECRET")
+	}
+	if secret != "" {
+		hook := server.GitHubWebhook{Secret: secret, Files: githubPullRequestFiles{}}
+		hook.Paths, _ = cmd.Flags().GetStringSlice("webhook-paths")
+		hook.Environment, _ = cmd.Flags().GetString("webhook-environment")
+		hook.WorkDir, _ = cmd.Flags().GetString("webhook-dir")
+		opts = append(opts, server.WithGitHubWebhook(hook))
+	}
+	if chatops, _ := cmd.Flags().GetBool("chatops"); chatops {
+		signingSecret, _ := cmd.Flags().GetString("slack-signing-secret")
+		if signingSecret == "" {
+			signingSecret = os.Getenv("SLACK_SIGNING_SECRET")
+		}
+		if signingSecret == "" && secret == "" {
+			return fmt.Errorf("--chatops needs --slack-signing-secret or --github-webhook-secret")
+		}
+		config := server.ChatOps{SlackSigningSecret: signingSecret, Comments: githubPullRequestFiles{}}
+		config.Approvers, _ = cmd.Flags().GetStringSlice("chatops-approvers")
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("invalid --chatops-approvers: %w", err)
+		}
+		opts = append(opts, server.WithChatOps(config))
+	}
+
+	srv := server.New(state.NewHistory(store), serverRunner(base), configDir, opts...)
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		log.Printf("opsql server listening on %s", listen)
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	grpcListen, _ := cmd.Flags().GetString("grpc-listen")
+	if grpcListen != "" {
+		lis, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		grpcServer := srv.GRPCServer()
+		defer grpcServer.GracefulStop()
+		go func() {
+			log.Printf("opsql gRPC server listening on %s", grpcListen)
+			errCh <- grpcServer.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, grpc.ErrServerStopped) {
+			return err
+		}
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to shut down server: %v\n", err)
+		}
+	}
+
+	// 実行中のrunは中断せずに終わるまで待つ
+	srv.Wait()
+	return nil
+}
+
+// serverRunner executes server runs like opsql run with the server's shared settings
+func serverRunner(base *RunConfig) server.Runner {
+	return func(ctx context.Context, run server.Run) error {
+		config := *base
+		config.RunID = run.ID
+		config.ConfigFiles = run.Configs
+		config.Params = run.Params
+		config.Environment = run.Environment
+		config.DryRun = run.DryRun
+		config.Actor = run.Actor
+		config.PlanRunID = run.PlanRunID
+		config.Progress = run.Progress
+		config.Cancel = run.Cancel
+		if run.GitHubPR != 0 {
+			config.GitHubRepo = run.GitHubRepo
+			config.GitHubPR = run.GitHubPR
+		}
+
+		dsn, err := environmentDSN(run.Environment)
+		if err != nil {
+			return err
+		}
+		config.DatabaseDSN = dsn
+		return executeRun(ctx, &config)
+	}
+}
+
+// githubPullRequestFiles reads pull requests with the GitHub credentials of PR comments
+type githubPullRequestFiles struct{}
+
+func (githubPullRequestFiles) client(repo string, pr int) (*github.Client, error) {
+	client := github.NewClient(repo, pr)
+	if client == nil {
+		return nil, fmt.Errorf("GitHub authentication not configured (GITHUB_TOKEN or GitHub App credentials required)")
+	}
+	return client, nil
+}
+
+func (f githubPullRequestFiles) ChangedFiles(ctx context.Context, repo string, pr int) ([]string, error) {
+	client, err := f.client(repo, pr)
+	if err != nil {
+		return nil, err
+	}
+	return client.ChangedFiles(ctx, pr)
+}
+
+func (f githubPullRequestFiles) FileContent(ctx context.Context, repo, path, ref string) ([]byte, error) {
+	client, err := f.client(repo, 0)
+	if err != nil {
+		return nil, err
+	}
+	return client.FileContent(ctx, path, ref)
+}
+
+func (f githubPullRequestFiles) Reply(ctx context.Context, repo string, pr int, body string) error {
+	client, err := f.client(repo, pr)
+	if err != nil {
+		return err
+	}
+	return client.Reply(ctx, pr, body)
+}
+
+// environmentDSN returns DATABASE_DSN_<ENVIRONMENT>, or DATABASE_DSN for runs without an environment
+func environmentDSN(environment string) (string, error) {
+	name := "DATABASE_DSN"
+	// 環境を名乗る実行の記録と実際に書き込むデータベースを一致させるため、既定のDSNには頼らない
+	if environment != "" {
+		name = database.DSNEnv(environment)
+	}
+	dsn := os.Getenv(name)
+	if dsn == "" {
+		return "", fmt.Errorf("%s environment variable is required", name)
+	}
+	return dsn, nil
+}
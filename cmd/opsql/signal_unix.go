@@ -0,0 +1,11 @@
+//go:build !windows
+
+package opsql
+
+import (
+	"os"
+	"syscall"
+)
+
+// cancelSignal cancels a local run before its next operation
+var cancelSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,9 @@
+//go:build windows
+
+package opsql
+
+import "os"
+
+// cancelSignal is nil on Windows, which has no SIGUSR1: local runs there cannot be cancelled
+// by a signal and stop only when interrupted
+var cancelSignal os.Signal
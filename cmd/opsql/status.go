@@ -0,0 +1,102 @@
+package opsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the definitions applied to each environment",
+	Long: `Status lists, per environment, the version of each definition last applied
+successfully: its checksum, revision (the number of successful applies), when and by whom.
+The local column compares the checksum with the configuration files in the working tree.`,
+	RunE: runStatus,
+}
+
+func init() {
+	statusCmd.Flags().StringP("environment", "e", "", "Only definitions applied to this environment")
+	statusCmd.Flags().String("format", "text", "Output format: text or json")
+	statusCmd.Flags().String("state-backend", "", "Where run history and locks are kept: a directory, s3://bucket/prefix, database, or a DSN (required, can use OPSQL_STATE_BACKEND env)")
+	statusCmd.Flags().String("opsql-schema", "", "Schema for the tables opsql creates, such as the database state backend's (optional, can use OPSQL_SCHEMA env)")
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	environment, _ := cmd.Flags().GetString("environment")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported --format: %s (expected text or json)", format)
+	}
+
+	store, err := openStateStore(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close state backend: %v\n", err)
+		}
+	}()
+
+	releases, err := state.NewHistory(store).Releases(ctx, environment)
+	if err != nil {
+		return fmt.Errorf("failed to read releases: %w", err)
+	}
+
+	if format == "json" {
+		if releases == nil {
+			releases = []state.Release{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(releases)
+	}
+	printReleases(os.Stdout, releases)
+	return nil
+}
+
+func printReleases(out io.Writer, releases []state.Release) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEFINITION\tENVIRONMENT\tREVISION\tCHECKSUM\tAPPLIED AT\tACTOR\tRUN\tLOCAL")
+	for _, release := range releases {
+		environment := release.Environment
+		if environment == "" {
+			environment = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			release.Definition, environment, release.Revision, shortChecksum(release.Checksum),
+			release.AppliedAt.Local().Format(time.DateTime), release.Actor, release.RunID, localState(release))
+	}
+	_ = w.Flush()
+}
+
+// localState tells whether the configuration files in the working tree are the applied version
+func localState(release state.Release) string {
+	checksum, err := definition.Checksum(release.Configs)
+	switch {
+	case len(release.Configs) == 0 || err != nil:
+		return "-"
+	case checksum == release.Checksum:
+		return "up-to-date"
+	default:
+		return "changed"
+	}
+}
+
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
@@ -0,0 +1,297 @@
+package opsql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/spf13/cobra"
+)
+
+var verifyAllCmd = &cobra.Command{
+	Use:   "verify-all <dir|file>...",
+	Short: "Run the checks of every definition in a directory and report the data quality of a target",
+	Long: `Verify-all runs only the checks of the definitions found in the directories, the select
+and cross_check operations whose queries are SELECTs, against one target, so that the
+runbooks accumulated over time double as a data-quality regression suite. Operations that
+write are never run, each check runs alone in a read-only transaction that is rolled back,
+and a failing check does not stop the others.
+
+The target is DATABASE_DSN, or DATABASE_DSN_<NAME> with --target <name>. The command exits
+with 0 when every check passed, 1 when a check failed or a definition could not be run, and
+2 when nothing could be verified.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runVerifyAll,
+}
+
+func init() {
+	verifyAllCmd.Flags().StringArrayP("param", "p", []string{}, "Override a param of every definition (key=value, can specify multiple; lists are comma-separated)")
+	verifyAllCmd.Flags().String("target", "", "Name of the database to verify, whose DSN is read from DATABASE_DSN_<NAME> (default: DATABASE_DSN)")
+	verifyAllCmd.Flags().String("format", "text", "Output format: text or json")
+}
+
+const (
+	runbookPassed   = "PASSED"
+	runbookFailed   = "FAILED"
+	runbookError    = "ERROR"
+	runbookNoChecks = "NO_CHECKS"
+)
+
+// verifyAllResult is the JSON output of verify-all
+type verifyAllResult struct {
+	Target    string          `json:"target"`
+	Passed    bool            `json:"passed"`
+	Checks    int             `json:"checks"`
+	Failed    int             `json:"failed"`
+	Errors    int             `json:"errors"`
+	StartedAt string          `json:"started_at"`
+	Runbooks  []runbookResult `json:"runbooks"`
+}
+
+// runbookResult is the verification of one definition
+type runbookResult struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Checks int    `json:"checks"`
+	Failed int    `json:"failed"`
+	// Skipped is the number of operations that are not checks, which were not run
+	Skipped int                 `json:"skipped"`
+	Error   string              `json:"error,omitempty"`
+	Reports []definition.Report `json:"reports,omitempty"`
+}
+
+func runVerifyAll(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	startedAt := time.Now()
+
+	format, _ := cmd.Flags().GetString("format")
+	if format != "text" && format != "json" {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("unsupported --format %q (allowed: text, json)", format)}
+	}
+	params, err := parseParamFlags(cmd)
+	if err != nil {
+		return &exitError{code: ExitAborted, err: err}
+	}
+	files, err := verifyAllFiles(args)
+	if err != nil {
+		return &exitError{code: ExitAborted, err: err}
+	}
+
+	named := database.NewNamed()
+	defer func() {
+		if err := named.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close databases: %v\n", err)
+		}
+	}()
+
+	target, _ := cmd.Flags().GetString("target")
+	var db database.DB
+	if target != "" {
+		db, err = named.Open(target)
+	} else {
+		target = "DATABASE_DSN"
+		dsn := os.Getenv("DATABASE_DSN")
+		if dsn == "" {
+			return &exitError{code: ExitAborted, err: fmt.Errorf("DATABASE_DSN environment variable is required")}
+		}
+		db, err = database.NewDatabase(dsn)
+		if err == nil {
+			defer func() {
+				if err := db.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", err)
+				}
+			}()
+		}
+	}
+	if err != nil {
+		return &exitError{code: ExitAborted, err: fmt.Errorf("failed to connect to database: %w", err)}
+	}
+
+	result := verifyAllResult{Target: target, StartedAt: definition.FormatReportTime(startedAt)}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return &exitError{code: ExitCancelled, err: fmt.Errorf("verification interrupted: %w", definition.ErrCancelled)}
+		}
+		runbook := verifyRunbook(ctx, db, named, file, params)
+		result.Checks += runbook.Checks
+		result.Failed += runbook.Failed
+		if runbook.Status == runbookError {
+			result.Errors++
+		}
+		result.Runbooks = append(result.Runbooks, runbook)
+	}
+	result.Passed = result.Failed == 0 && result.Errors == 0
+
+	if format == "json" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return &exitError{code: ExitAborted, err: err}
+		}
+		fmt.Println(string(data))
+	} else {
+		printVerifyAll(result)
+	}
+
+	fmt.Fprintf(os.Stderr, "opsql verify-all: %d definitions, %d checks, %d failed, %d errors in %s\n",
+		len(result.Runbooks), result.Checks, result.Failed, result.Errors, time.Since(startedAt).Round(time.Millisecond))
+	switch {
+	case result.Checks == 0:
+		return &exitError{code: ExitAborted, err: fmt.Errorf("no checks could be run")}
+	case !result.Passed:
+		return &exitError{code: ExitFailed, err: fmt.Errorf("%d checks failed and %d definitions could not be run", result.Failed, result.Errors)}
+	}
+	return nil
+}
+
+// verifyAllFiles returns the definition files of the arguments: the files under each directory,
+// and the files given as they are
+func verifyAllFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := definition.FindDefinitions(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find definitions in %s: %w", arg, err)
+		}
+		for _, rel := range found {
+			files = append(files, filepath.Join(arg, filepath.FromSlash(rel)))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no definitions found in %v", args)
+	}
+	return files, nil
+}
+
+// verifyRunbook runs the checks of a definition, each in its own transaction so that a failing
+// check neither stops nor affects the next
+func verifyRunbook(ctx context.Context, db database.DB, named *database.Named, file string, params map[string]string) runbookResult {
+	result := runbookResult{File: filepath.ToSlash(file)}
+	fail := func(err error) runbookResult {
+		result.Status = runbookError
+		result.Error = err.Error()
+		result.Checks = len(result.Reports)
+		return result
+	}
+
+	def, err := definition.LoadDefinitionsWithParams([]string{file}, params)
+	if err != nil {
+		return fail(fmt.Errorf("failed to load definition: %w", err))
+	}
+	result.Checks = len(def.Checks())
+	result.Skipped = len(def.Operations) - result.Checks
+	if result.Checks == 0 {
+		result.Status = runbookNoChecks
+		return result
+	}
+
+	if def.HasDerivedParams() {
+		if err := def.CheckDerivedParamsReadOnly(); err != nil {
+			return fail(err)
+		}
+		if err := def.ResolveDerivedParams(ctx, db); err != nil {
+			return fail(fmt.Errorf("failed to resolve derived params: %w", err))
+		}
+	}
+
+	planExecutor := executor.NewPlanExecutor(db, executor.WithReadOnly(), executor.WithDatabases(named.Open))
+	reports, err := runChecks(ctx, planExecutor, def)
+	result.Reports = reports
+	if err != nil {
+		return fail(err)
+	}
+	for _, report := range reports {
+		if !report.Pass {
+			result.Failed++
+		}
+	}
+
+	result.Status = runbookPassed
+	if result.Failed > 0 {
+		result.Status = runbookFailed
+	}
+	return result
+}
+
+// runChecks runs each check of def alone, so that a failing check neither stops nor affects the
+// next. A check that could not be run is reported as a failed check; only cancellation stops it.
+func runChecks(ctx context.Context, planExecutor *executor.PlanExecutor, def *definition.Definition) ([]definition.Report, error) {
+	operations := def.Operations
+	defer func() { def.Operations = operations }()
+
+	var results []definition.Report
+	for _, op := range def.Checks() {
+		def.Operations = []definition.Operation{op}
+		reports, err := planExecutor.Execute(ctx, def)
+		if len(reports) == 0 {
+			if errors.Is(err, context.Canceled) {
+				return results, err
+			}
+			// トランザクションを開始できなかった場合もチェックの失敗として残す
+			message := err.Error()
+			report := definition.Report{
+				ID:          op.ID,
+				Description: op.Description,
+				Type:        op.Type,
+				SQL:         op.SQL,
+				Message:     message,
+				Failure:     &definition.Failure{Code: definition.FailureSQLError, Actual: message},
+			}
+			def.MaskReport(&report)
+			reports = []definition.Report{report}
+		}
+		definition.LocalizeReports(reports)
+		results = append(results, reports...)
+	}
+	return results, nil
+}
+
+// printVerifyAll writes the data-quality report: a line per definition and the failing checks under it
+func printVerifyAll(result verifyAllResult) {
+	fmt.Printf("verify-all against %s\n", result.Target)
+	for _, runbook := range result.Runbooks {
+		switch runbook.Status {
+		case runbookError:
+			fmt.Printf("%-9s %s: %s\n", runbook.Status, runbook.File, runbook.Error)
+		case runbookNoChecks:
+			fmt.Printf("%-9s %s (%d operations not run)\n", runbook.Status, runbook.File, runbook.Skipped)
+		default:
+			fmt.Printf("%-9s %s: %d/%d checks passed", runbook.Status, runbook.File, runbook.Checks-runbook.Failed, runbook.Checks)
+			if runbook.Skipped > 0 {
+				fmt.Printf(" (%d operations not run)", runbook.Skipped)
+			}
+			fmt.Println()
+		}
+		for _, report := range runbook.Reports {
+			if report.Pass {
+				continue
+			}
+			code := ""
+			if report.Failure != nil {
+				code = report.Failure.Code + ": "
+			}
+			owner := ""
+			if report.Owner != "" {
+				owner = " (" + report.Owner + ")"
+			}
+			fmt.Printf("  ✘ %s%s %s%s\n", report.ID, owner, code, report.Message)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package bluegreen
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+)
+
+// Missing stands for a value one side does not have: an operation it did not run, a row it
+// did not return or a column absent from its row
+const Missing = "<missing>"
+
+// Difference is a field whose value differs between the blue and green runs of an operation
+type Difference struct {
+	Operation string      `json:"operation"`
+	Field     string      `json:"field"`
+	Blue      interface{} `json:"blue"`
+	Green     interface{} `json:"green"`
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s: blue %v, green %v", d.Operation, d.Field, d.Blue, d.Green)
+}
+
+// Compare returns the differences between the reports of the same definition run against the
+// blue and green databases, in operation order. Operations are matched by ID; query results are
+// compared row by row and column by column with the equality of assertions, so an int and a
+// decimal of the same value are equal.
+func Compare(blue, green []definition.Report) []Difference {
+	greenByID := make(map[string]definition.Report, len(green))
+	for _, report := range green {
+		greenByID[report.ID] = report
+	}
+	blueIDs := make(map[string]bool, len(blue))
+
+	var diffs []Difference
+	for _, b := range blue {
+		blueIDs[b.ID] = true
+		g, ok := greenByID[b.ID]
+		if !ok {
+			diffs = append(diffs, Difference{Operation: b.ID, Field: "executed", Blue: true, Green: Missing})
+			continue
+		}
+		diffs = append(diffs, compareReports(b, g)...)
+	}
+	for _, g := range green {
+		if !blueIDs[g.ID] {
+			diffs = append(diffs, Difference{Operation: g.ID, Field: "executed", Blue: Missing, Green: true})
+		}
+	}
+	return diffs
+}
+
+func compareReports(blue, green definition.Report) []Difference {
+	var diffs []Difference
+	add := func(field string, b, g interface{}) {
+		diffs = append(diffs, Difference{Operation: blue.ID, Field: field, Blue: b, Green: g})
+	}
+
+	if blue.Pass != green.Pass {
+		add("pass", blue.Pass, green.Pass)
+	}
+	if b, g := failureCode(blue), failureCode(green); b != g {
+		add("failure.code", b, g)
+	}
+
+	blueRows, blueIsRows := blue.Result.([]map[string]interface{})
+	greenRows, greenIsRows := green.Result.([]map[string]interface{})
+	if !blueIsRows || !greenIsRows {
+		// DMLの影響行数などのスカラー値
+		if !executor.ValuesEqual(blue.Result, green.Result) {
+			add("result", blue.Result, green.Result)
+		}
+		return diffs
+	}
+
+	if len(blueRows) != len(greenRows) {
+		add("rows", len(blueRows), len(greenRows))
+	}
+	for i := 0; i < len(blueRows) || i < len(greenRows); i++ {
+		if i >= len(blueRows) {
+			add(fmt.Sprintf("result[%d]", i), Missing, greenRows[i])
+			continue
+		}
+		if i >= len(greenRows) {
+			add(fmt.Sprintf("result[%d]", i), blueRows[i], Missing)
+			continue
+		}
+		for _, column := range columns(blueRows[i], greenRows[i]) {
+			b, inBlue := blueRows[i][column]
+			g, inGreen := greenRows[i][column]
+			field := fmt.Sprintf("result[%d].%s", i, column)
+			switch {
+			case !inBlue:
+				add(field, Missing, g)
+			case !inGreen:
+				add(field, b, Missing)
+			case !executor.ValuesEqual(b, g):
+				add(field, b, g)
+			}
+		}
+	}
+	return diffs
+}
+
+func failureCode(report definition.Report) string {
+	if report.Failure == nil {
+		return ""
+	}
+	return report.Failure.Code
+}
+
+// columns returns the columns of both rows in name order
+func columns(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool, len(a))
+	var names []string
+	for _, row := range []map[string]interface{}{a, b} {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				names = append(names, column)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
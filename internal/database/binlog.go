@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ErUnsafeStatement is the code of MySQL's warning about a statement that is unsafe to log in statement format
+const ErUnsafeStatement = "1592"
+
+// BinlogSettings are the settings deciding whether and how the changes of a MySQL session replicate
+type BinlogSettings struct {
+	// LogBin is whether the server writes a binary log at all
+	LogBin bool
+	// SQLLogBin is whether the session's changes are written to it
+	SQLLogBin bool
+	// Format is the session's binlog_format: ROW, STATEMENT or MIXED
+	Format string
+}
+
+// ReadBinlogSettings reads the binary log settings of a session of a MySQL database
+func ReadBinlogSettings(ctx context.Context, db DB) (*BinlogSettings, error) {
+	rows, err := db.QueryRowsContext(ctx, "SELECT @@GLOBAL.log_bin AS log_bin, @@SESSION.sql_log_bin AS sql_log_bin, @@SESSION.binlog_format AS binlog_format")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("expected one row of binlog settings, got %d", len(rows))
+	}
+	return &BinlogSettings{
+		LogBin:    settingText(rows[0]["log_bin"]) == "1" || strings.EqualFold(settingText(rows[0]["log_bin"]), "ON"),
+		SQLLogBin: settingText(rows[0]["sql_log_bin"]) == "1" || strings.EqualFold(settingText(rows[0]["sql_log_bin"]), "ON"),
+		Format:    strings.ToUpper(settingText(rows[0]["binlog_format"])),
+	}, nil
+}
+
+// Problems returns why the changes of the session would not replicate safely, with what to do about it
+func (s *BinlogSettings) Problems() []string {
+	// バイナリログがなければレプリカもない
+	if !s.LogBin {
+		return nil
+	}
+	var problems []string
+	if !s.SQLLogBin {
+		problems = append(problems, "sql_log_bin is disabled for this session, so the changes would not reach the binary log and replicas would silently diverge; remove sql_log_bin from the DSN or the user's init_connect")
+	}
+	return problems
+}
+
+// StatementBased reports whether the session logs its changes as statements, which replicas execute again
+func (s *BinlogSettings) StatementBased() bool {
+	return s.LogBin && s.SQLLogBin && s.Format == "STATEMENT"
+}
+
+func settingText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case Binary:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
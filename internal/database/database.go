@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -15,6 +17,7 @@ type DB interface {
 	QueryRowsContext(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error)
 	ExecContext(ctx context.Context, query string, args ...interface{}) (int64, error)
 	BeginTransaction(ctx context.Context) (Transaction, error)
+	DriverName() string
 	Close() error
 }
 
@@ -25,6 +28,16 @@ type Transaction interface {
 	Commit() error
 }
 
+// ColumnQuerier is implemented by databases and transactions that report the columns of a query in order
+type ColumnQuerier interface {
+	QueryColumnsContext(ctx context.Context, query string, args ...interface{}) ([]string, []map[string]interface{}, error)
+}
+
+// ReadOnlyBeginner is implemented by databases that can begin transactions in which the server rejects writes
+type ReadOnlyBeginner interface {
+	BeginReadOnlyTransaction(ctx context.Context) (Transaction, error)
+}
+
 type Database struct {
 	*sqlx.DB
 	driver string
@@ -57,24 +70,16 @@ func NewDatabase(dsn string) (DB, error) {
 }
 
 func (d *Database) QueryRowsContext(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	_, results, err := d.QueryColumnsContext(ctx, query, args...)
+	return results, err
+}
+
+func (d *Database) QueryColumnsContext(ctx context.Context, query string, args ...interface{}) ([]string, []map[string]interface{}, error) {
 	rows, err := d.QueryxContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		_ = rows.Close()
-	}()
-
-	var results []map[string]interface{}
-	for rows.Next() {
-		row := make(map[string]interface{})
-		if err := rows.MapScan(row); err != nil {
-			return nil, err
-		}
-		results = append(results, row)
+		return nil, nil, err
 	}
-
-	return results, rows.Err()
+	return scanRows(rows)
 }
 
 func (d *Database) ExecContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
@@ -100,25 +105,78 @@ func (d *Database) BeginTransaction(ctx context.Context) (Transaction, error) {
 	return &Tx{Tx: tx}, nil
 }
 
+func (d *Database) BeginReadOnlyTransaction(ctx context.Context) (Transaction, error) {
+	tx, err := d.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx}, nil
+}
+
 func (t *Tx) QueryRowsContext(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	_, results, err := t.QueryColumnsContext(ctx, query, args...)
+	return results, err
+}
+
+func (t *Tx) QueryColumnsContext(ctx context.Context, query string, args ...interface{}) ([]string, []map[string]interface{}, error) {
 	rows, err := t.QueryxContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return scanRows(rows)
+}
+
+// scanRows reads and closes rows, returning the columns in the order of the query.
+// Values are normalized with normalizeValue.
+func scanRows(rows *sqlx.Rows) ([]string, []map[string]interface{}, error) {
 	defer func() {
 		_ = rows.Close()
 	}()
 
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkDuplicateColumns(columns); err != nil {
+		return nil, nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var results []map[string]interface{}
 	for rows.Next() {
 		row := make(map[string]interface{})
 		if err := rows.MapScan(row); err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		for _, columnType := range columnTypes {
+			name := columnType.Name()
+			row[name] = normalizeValue(row[name], columnType.DatabaseTypeName())
 		}
 		results = append(results, row)
 	}
 
-	return results, rows.Err()
+	return columns, results, rows.Err()
+}
+
+// checkDuplicateColumns rejects results in which two columns have the same name, such as
+// SELECT * over a join: rows are keyed by column name, so one value would silently hide the other
+func checkDuplicateColumns(columns []string) error {
+	seen := make(map[string]bool, len(columns))
+	var duplicates []string
+	for _, column := range columns {
+		if seen[column] && !slices.Contains(duplicates, column) {
+			duplicates = append(duplicates, column)
+		}
+		seen[column] = true
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("duplicate column names in result: %s; give them distinct aliases (e.g. SELECT u.id AS user_id, o.id AS order_id)", strings.Join(duplicates, ", "))
+	}
+	return nil
 }
 
 func (t *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
@@ -168,6 +226,63 @@ func convertDSN(dsn, driver string) (string, error) {
 	}
 }
 
+// QuoteIdentifier quotes a possibly schema-qualified identifier for the driver
+func QuoteIdentifier(driver, name string) string {
+	quote := `"`
+	if driver == "mysql" {
+		quote = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// QualifyName prefixes name with schema when one is given
+func QualifyName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// Rebind converts the ? placeholders of query for the driver, leaving quoted strings and identifiers alone
+func Rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+
+	var buf strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			buf.WriteString(Placeholder(driver, n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// Placeholder returns the n-th (1-origin) bind placeholder for the driver
+func Placeholder(driver string, n int) string {
+	if driver == "postgres" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
 func MaskSecret(dsn string) string {
 	re := regexp.MustCompile(`://([^:]+):([^@]+)@`)
 	return re.ReplaceAllString(dsn, "://$1:***@")
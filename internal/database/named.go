@@ -0,0 +1,60 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DSNEnv returns the environment variable holding the DSN of a named database or environment
+func DSNEnv(name string) string {
+	return "DATABASE_DSN_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Named opens the databases that operations refer to by name from DATABASE_DSN_<NAME>,
+// connecting to each once
+type Named struct {
+	mu  sync.Mutex
+	dbs map[string]DB
+}
+
+func NewNamed() *Named {
+	return &Named{dbs: make(map[string]DB)}
+}
+
+func (n *Named) Open(name string) (DB, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if db, ok := n.dbs[name]; ok {
+		return db, nil
+	}
+
+	dsn := os.Getenv(DSNEnv(name))
+	if dsn == "" {
+		return nil, fmt.Errorf("%s environment variable is required for database %q", DSNEnv(name), name)
+	}
+	db, err := NewDatabase(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database %q: %w", name, err)
+	}
+	n.dbs[name] = db
+	return db, nil
+}
+
+// Close closes every database opened so far
+func (n *Named) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+	for name, db := range n.dbs {
+		if err := db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("database %q: %w", name, err))
+		}
+	}
+	n.dbs = make(map[string]DB)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decimal is the text of a DECIMAL or NUMERIC value. Keeping the text instead of a float64
+// keeps every digit through comparisons and report output, where it is rendered as a string.
+type Decimal string
+
+// Binary is the value of a BLOB, BINARY or bytea column. Reports render it as base64 with its length.
+type Binary []byte
+
+func (b Binary) String() string {
+	return fmt.Sprintf("base64:%s (%d bytes)", base64.StdEncoding.EncodeToString(b), len(b))
+}
+
+func (b Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Base64 string `json:"base64"`
+		Length int    `json:"length"`
+	}{base64.StdEncoding.EncodeToString(b), len(b)})
+}
+
+// normalizeValue converts the value the driver returned for a column of the named database type.
+// Numbers keep their precision: decimals become Decimal and integers sent as text int64 or uint64.
+// Binary columns become Binary, and other bytes strings unless they are not valid UTF-8.
+func normalizeValue(value interface{}, typeName string) interface{} {
+	b, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+
+	typeName = strings.ToUpper(typeName)
+	switch {
+	case typeName == "DECIMAL" || typeName == "NUMERIC":
+		return Decimal(b)
+	case isIntegerType(typeName):
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+			return n
+		}
+		return Decimal(b)
+	case typeName == "FLOAT" || typeName == "DOUBLE" || typeName == "REAL" || typeName == "FLOAT4" || typeName == "FLOAT8":
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	case isBinaryType(typeName):
+		return Binary(b)
+	}
+
+	if !utf8.Valid(b) {
+		return Binary(b)
+	}
+	return string(b)
+}
+
+// isBinaryType reports whether a MySQL or PostgreSQL type name holds raw bytes
+func isBinaryType(typeName string) bool {
+	switch typeName {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "BYTEA":
+		return true
+	}
+	return false
+}
+
+// isIntegerType reports whether a MySQL or PostgreSQL type name is an integer type
+func isIntegerType(typeName string) bool {
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR", "INT2", "INT4", "INT8":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AnonymizeHash = "hash"
+	AnonymizeNull = "null"
+	AnonymizeFake = "fake"
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Anonymize describes a type: anonymize operation. The executor generates the UPDATE
+// applying the column rules to the rows matching Where, and a SELECT verifying them.
+type Anonymize struct {
+	Table string `yaml:"table"`
+	// Where selects the rows to anonymize; it is a SQL template like sql and must not refer to the anonymized columns
+	Where   string                   `yaml:"where"`
+	Columns map[string]AnonymizeRule `yaml:"columns"`
+}
+
+// AnonymizeRule is what a column becomes: hash (SHA-256 hex of the value), null, or {fake: value}
+type AnonymizeRule struct {
+	Kind  string
+	Value interface{}
+}
+
+func (r *AnonymizeRule) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Kind = value.Value
+		return nil
+	}
+
+	var fake struct {
+		Fake interface{} `yaml:"fake"`
+	}
+	if err := value.Decode(&fake); err != nil {
+		return err
+	}
+	r.Kind = AnonymizeFake
+	r.Value = fake.Fake
+	return nil
+}
+
+// SortedColumns returns the anonymized columns in a stable order
+func (a *Anonymize) SortedColumns() []string {
+	columns := make([]string, 0, len(a.Columns))
+	for column := range a.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func (a *Anonymize) validate() error {
+	if a.Table == "" || a.Where == "" || len(a.Columns) == 0 {
+		return fmt.Errorf("anonymize: table, where and columns are required")
+	}
+	if !identifierPattern.MatchString(a.Table) {
+		return fmt.Errorf("anonymize: invalid table name: %s", a.Table)
+	}
+
+	for _, column := range a.SortedColumns() {
+		rule := a.Columns[column]
+		if !identifierPattern.MatchString(column) {
+			return fmt.Errorf("anonymize: invalid column name: %s", column)
+		}
+		switch rule.Kind {
+		case "":
+			// "phone: null" はYAMLのnullとして読まれる
+			a.Columns[column] = AnonymizeRule{Kind: AnonymizeNull}
+		case AnonymizeHash, AnonymizeNull:
+		case AnonymizeFake:
+			if !isScalar(rule.Value) {
+				return fmt.Errorf("anonymize: column %s: fake value must be a string, number or boolean", column)
+			}
+		default:
+			return fmt.Errorf("anonymize: column %s: unsupported rule %q (allowed: hash, null, {fake: value})", column, rule.Kind)
+		}
+
+		// 条件が匿名化する列を参照すると更新後の検証で行を見失う
+		if regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(column) + `\b`).MatchString(a.Where) {
+			return fmt.Errorf("anonymize: where must not refer to the anonymized column %s", column)
+		}
+	}
+	return nil
+}
+
+func (a *Anonymize) copy() *Anonymize {
+	copied := &Anonymize{Table: a.Table, Where: a.Where}
+	if a.Columns != nil {
+		copied.Columns = make(map[string]AnonymizeRule, len(a.Columns))
+		for column, rule := range a.Columns {
+			copied.Columns[column] = rule
+		}
+	}
+	return copied
+}
@@ -0,0 +1,194 @@
+package definition
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name identifies a definition by its configuration file paths
+func Name(configPaths []string) string {
+	names := make([]string, len(configPaths))
+	for i, path := range configPaths {
+		names[i] = filepath.ToSlash(filepath.Clean(path))
+	}
+	return strings.Join(names, "+")
+}
+
+// Checksum returns the SHA-256 of the configuration files' contents in order, each followed by
+// the operation templates, import files, fixtures and files read by SQL templates it uses
+func Checksum(configPaths []string) (string, error) {
+	hash := sha256.New()
+	for _, path := range configPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read config file: %s %w", path, err)
+		}
+		// ファイル境界を含めてハッシュしないと連結結果が同じ別構成と衝突する
+		fmt.Fprintf(hash, "%d:", len(data))
+		hash.Write(data)
+
+		files, err := referencedFiles(data, path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		for _, file := range files {
+			referenced, err := os.ReadFile(file)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s referenced by %s: %w", file, path, err)
+			}
+			fmt.Fprintf(hash, "%d:", len(referenced))
+			hash.Write(referenced)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// referencedFiles returns the files a definition uses besides itself: the operation templates,
+// the CSV files of import operations, the fixtures and the files its SQL templates read with file
+func referencedFiles(data []byte, configPath string) ([]string, error) {
+	var raw struct {
+		Fixtures      []Fixture `yaml:"fixtures"`
+		DerivedParams []struct {
+			SQL string `yaml:"sql"`
+		} `yaml:"derived_params"`
+		Operations []Operation `yaml:"operations"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	addReads := func(dir string, sqls ...string) error {
+		for _, sql := range sqls {
+			names, err := fileReferences(sql)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				add(filepath.Join(dir, name))
+			}
+		}
+		return nil
+	}
+	resolve := func(dir, file string) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	dir := filepath.Dir(configPath)
+	// フィクスチャは --load-fixtures で読み込まれるので、変われば別の実行になる
+	for _, fixture := range raw.Fixtures {
+		if fixture.File != "" {
+			add(resolve(dir, fixture.File))
+		}
+	}
+	for _, derived := range raw.DerivedParams {
+		if err := addReads(dir, derived.SQL); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range raw.Operations {
+		switch {
+		case op.Use != "":
+			path, err := FindTemplate(configPath, op.Use)
+			if err != nil {
+				continue
+			}
+			add(path)
+			if tmpl, err := LoadTemplate(path); err == nil {
+				for _, snippet := range tmpl.Operations {
+					if err := addReads(filepath.Dir(path), operationSQL(snippet)...); err != nil {
+						return nil, fmt.Errorf("template %s: %w", op.Use, err)
+					}
+				}
+			}
+		case op.Type == TypeImport && op.File != "":
+			add(resolve(dir, op.File))
+		}
+		if err := addReads(dir, operationSQL(op)...); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Dependencies are what a definition or an operation template uses besides itself
+type Dependencies struct {
+	// Templates are the names of the operation templates it uses
+	Templates []string
+	// Files are the files it reads, relative to its directory: the CSV files of import operations
+	// and the files its SQL templates read with file
+	Files []string
+}
+
+// ParseDependencies returns the dependencies of a definition or an operation template without
+// looking them up, so that they can be copied along with it
+func ParseDependencies(data []byte) Dependencies {
+	var raw struct {
+		DerivedParams []struct {
+			SQL string `yaml:"sql"`
+		} `yaml:"derived_params"`
+		Operations []Operation `yaml:"operations"`
+	}
+	var deps Dependencies
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return deps
+	}
+
+	seen := map[*[]string]map[string]bool{&deps.Templates: {}, &deps.Files: {}}
+	add := func(list *[]string, name string) {
+		if name != "" && !seen[list][name] {
+			seen[list][name] = true
+			*list = append(*list, name)
+		}
+	}
+	// file に渡す名前がリテラルでない定義は読み込み時に弾かれるので、ここでは拾える分だけ返す
+	for _, derived := range raw.DerivedParams {
+		names, _ := fileReferences(derived.SQL)
+		for _, name := range names {
+			add(&deps.Files, name)
+		}
+	}
+	for _, op := range raw.Operations {
+		add(&deps.Templates, op.Use)
+		if op.Type == TypeImport && !filepath.IsAbs(op.File) {
+			add(&deps.Files, op.File)
+		}
+		for _, sql := range operationSQL(op) {
+			names, _ := fileReferences(sql)
+			for _, name := range names {
+				add(&deps.Files, name)
+			}
+		}
+	}
+	return deps
+}
+
+// operationSQL returns the SQL templates of an operation
+func operationSQL(op Operation) []string {
+	sqls := []string{op.SQL}
+	if op.Anonymize != nil {
+		sqls = append(sqls, op.Anonymize.Where)
+	}
+	for _, remote := range []*RemoteQuery{op.Secondary, op.Source} {
+		if remote != nil {
+			sqls = append(sqls, remote.SQL)
+		}
+	}
+	return sqls
+}
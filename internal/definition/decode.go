@@ -0,0 +1,155 @@
+package definition
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DecodeJSON = "json"
+	DecodeBool = "bool"
+	DecodeInt  = "int"
+	DecodeEnum = "enum"
+)
+
+// Decoder turns the raw value of a column into a meaningful one before it is compared and
+// reported: json (a JSON-encoded text), bool, int, or {enum: {stored value: name}}
+type Decoder struct {
+	Kind string
+	Enum map[string]interface{}
+}
+
+func (dec *Decoder) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		dec.Kind = value.Value
+		return nil
+	}
+
+	var enum struct {
+		Enum map[string]interface{} `yaml:"enum"`
+	}
+	if err := value.Decode(&enum); err != nil {
+		return err
+	}
+	dec.Kind = DecodeEnum
+	dec.Enum = enum.Enum
+	return nil
+}
+
+func (dec Decoder) validate() error {
+	switch dec.Kind {
+	case DecodeJSON, DecodeBool, DecodeInt:
+	case DecodeEnum:
+		if len(dec.Enum) == 0 {
+			return fmt.Errorf("enum has no values")
+		}
+	default:
+		return fmt.Errorf("unsupported decoder %q (allowed: json, bool, int, {enum: {...}})", dec.Kind)
+	}
+	return nil
+}
+
+// decode converts a non-NULL value of the column
+func (dec Decoder) decode(value interface{}) (interface{}, error) {
+	text, isText := decodeText(value)
+	if !isText {
+		text = fmt.Sprint(value)
+	}
+	switch dec.Kind {
+	case DecodeJSON:
+		if !isText {
+			return nil, fmt.Errorf("expected JSON text, got %T", value)
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(text), &decoded); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return decoded, nil
+	case DecodeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(text)) {
+		case "1", "t", "true", "y", "yes":
+			return true, nil
+		case "0", "f", "false", "n", "no":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%q is not a boolean", text)
+	case DecodeInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", text)
+		}
+		return n, nil
+	case DecodeEnum:
+		name, ok := dec.Enum[text]
+		if !ok {
+			return nil, fmt.Errorf("%q is not a value of the enum", text)
+		}
+		return name, nil
+	}
+	return value, nil
+}
+
+func decodeText(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case database.Binary:
+		return string(v), true
+	}
+	return "", false
+}
+
+// decoderFor returns the decoder of a column: the one named after it, or else the first
+// matching pattern in name order
+func (d *Definition) decoderFor(column string) (Decoder, bool) {
+	column = strings.ToLower(column)
+	patterns := make([]string, 0, len(d.Decoders))
+	for pattern, dec := range d.Decoders {
+		if strings.ToLower(pattern) == column {
+			return dec, true
+		}
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), column); matched {
+			return d.Decoders[pattern], true
+		}
+	}
+	return Decoder{}, false
+}
+
+// DecodeRows returns copies of query rows with the columns that have a decoder decoded
+func (d *Definition) DecodeRows(rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(d.Decoders) == 0 || rows == nil {
+		return rows, nil
+	}
+
+	decoded := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		decoded[i] = make(map[string]interface{}, len(row))
+		for column, value := range row {
+			if dec, ok := d.decoderFor(column); ok && value != nil {
+				v, err := dec.decode(value)
+				if err != nil {
+					return nil, fmt.Errorf("decoding column %s of row %d: %w", column, i, err)
+				}
+				value = v
+			}
+			decoded[i][column] = value
+		}
+	}
+	return decoded, nil
+}
@@ -0,0 +1,128 @@
+package definition
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+// DerivedParam is a param computed by a SELECT at run start (derived_params)
+type DerivedParam struct {
+	Name string `yaml:"name"`
+	// SQL must return a single column; it may use params and earlier derived params
+	SQL string `yaml:"sql"`
+	// List collects every row instead of requiring exactly one
+	List bool `yaml:"list,omitempty"`
+}
+
+// HasDerivedParams reports whether templates wait for ResolveDerivedParams
+func (d *Definition) HasDerivedParams() bool {
+	return len(d.DerivedParams) > 0
+}
+
+// PlaceholderDerivedParams stands in for the derived params, which need a database, with
+// "<name>" (a one-element list for list params) and then processes the operation templates.
+// It returns the names it stood in for; params given on the command line are kept.
+func (d *Definition) PlaceholderDerivedParams() ([]string, error) {
+	if d.Params == nil {
+		d.Params = make(map[string]interface{})
+	}
+
+	var names []string
+	for _, derived := range d.DerivedParams {
+		if _, ok := d.Params[derived.Name]; ok {
+			continue
+		}
+		placeholder := "<" + derived.Name + ">"
+		if derived.List {
+			d.Params[derived.Name] = []interface{}{placeholder}
+		} else {
+			d.Params[derived.Name] = placeholder
+		}
+		names = append(names, derived.Name)
+	}
+	return names, d.ProcessTemplates()
+}
+
+// ResolveDerivedParams runs the derived_params queries in order, stores the results
+// in params and then processes the operation templates
+func (d *Definition) ResolveDerivedParams(ctx context.Context, db database.DB) error {
+	if d.Params == nil {
+		d.Params = make(map[string]interface{})
+	}
+
+	for _, derived := range d.DerivedParams {
+		rendered, err := d.renderSQL("derived_params."+derived.Name, derived.SQL, nil, d.dir)
+		if err != nil {
+			return fmt.Errorf("derived_params[%s]: %w", derived.Name, err)
+		}
+		query := rendered.SQL
+		if len(rendered.Args) > 0 {
+			query = database.Rebind(db.DriverName(), query)
+		}
+
+		rows, err := db.QueryRowsContext(ctx, query, rendered.Args...)
+		if err != nil {
+			return fmt.Errorf("derived_params[%s]: query failed: %w", derived.Name, err)
+		}
+
+		value, err := derivedValue(rows, derived.List)
+		if err != nil {
+			return fmt.Errorf("derived_params[%s]: %w", derived.Name, err)
+		}
+		d.Params[derived.Name] = value
+	}
+
+	if err := d.ValidateParams(); err != nil {
+		return err
+	}
+	return d.ProcessTemplates()
+}
+
+func derivedValue(rows []map[string]interface{}, list bool) (interface{}, error) {
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 1 {
+			return nil, fmt.Errorf("query must return exactly one column, got %d", len(row))
+		}
+		for _, v := range row {
+			values = append(values, normalizeValue(v))
+		}
+	}
+
+	if list {
+		return values, nil
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("query must return exactly one row, got %d (set list: true to collect every row)", len(values))
+	}
+	return values[0], nil
+}
+
+// normalizeValue converts driver values to the types YAML params use
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return string(value)
+	case database.Decimal:
+		return string(value)
+	case database.Binary:
+		return string(value)
+	case uint64:
+		if value > math.MaxInt64 {
+			return strconv.FormatUint(value, 10)
+		}
+		return int(value)
+	case int64:
+		return int(value)
+	case int32:
+		return int(value)
+	case float32:
+		return float64(value)
+	default:
+		return v
+	}
+}
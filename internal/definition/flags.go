@@ -0,0 +1,47 @@
+package definition
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FeatureFlag is a precondition on a flag of a feature-flag provider (feature_flags).
+// The run starts only when the value at Path in the JSON returned by URL equals Expect.
+type FeatureFlag struct {
+	Name string `yaml:"name"`
+	// URL and the header values may refer to environment variables as ${NAME}, keeping tokens out of the definition
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Path is the dot-separated field holding the flag's value; empty means the whole response
+	Path   string      `yaml:"path,omitempty"`
+	Expect interface{} `yaml:"expect"`
+}
+
+// Request returns the URL and headers with environment variables expanded
+func (f FeatureFlag) Request() (string, map[string]string) {
+	headers := make(map[string]string, len(f.Headers))
+	for name, value := range f.Headers {
+		headers[name] = os.ExpandEnv(value)
+	}
+	return os.ExpandEnv(f.URL), headers
+}
+
+func (f FeatureFlag) validate() error {
+	if f.Name == "" || f.URL == "" {
+		return fmt.Errorf("name and url are required")
+	}
+	if !isScalar(f.Expect) {
+		return fmt.Errorf("%s: expect must be a string, number or boolean", f.Name)
+	}
+	// 環境変数を展開する前のURLでもスキームは確認できる
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: url must be an http or https URL, got %q", f.Name, f.URL)
+	}
+	if strings.Contains(f.Path, "..") || strings.HasPrefix(f.Path, ".") || strings.HasSuffix(f.Path, ".") {
+		return fmt.Errorf("%s: invalid path %q", f.Name, f.Path)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package definition
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// NowLayout is the format of the now template function
+	NowLayout = "2006-01-02 15:04:05"
+
+	randomAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// GeneratedValue is a value produced by a generator template function, echoed in the report
+type GeneratedValue struct {
+	Function string `json:"function"`
+	Name     string `json:"name,omitempty"`
+	Value    string `json:"value"`
+}
+
+type generatorState struct {
+	now   time.Time
+	named map[string]string
+}
+
+// generatorFuncs returns uuid, now and randomString.
+// A name argument makes every call with that name, in any operation, return the same value.
+// now is fixed for the whole definition so that operations agree on it.
+func (d *Definition) generatorFuncs(record func(GeneratedValue)) map[string]interface{} {
+	generate := func(function string, name []string, newValue func() (string, error)) (string, error) {
+		if len(name) > 1 {
+			return "", fmt.Errorf("%s: takes at most one name", function)
+		}
+
+		var value string
+		key := ""
+		if len(name) == 1 {
+			key = function + "/" + name[0]
+			value = d.generators.named[key]
+		}
+		if value == "" {
+			v, err := newValue()
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", function, err)
+			}
+			value = v
+			if key != "" {
+				if d.generators.named == nil {
+					d.generators.named = make(map[string]string)
+				}
+				d.generators.named[key] = value
+			}
+		}
+
+		generated := GeneratedValue{Function: function, Value: value}
+		if len(name) == 1 {
+			generated.Name = name[0]
+		}
+		record(generated)
+		return value, nil
+	}
+
+	return map[string]interface{}{
+		"uuid": func(name ...string) (string, error) {
+			return generate("uuid", name, func() (string, error) {
+				id, err := uuid.NewRandom()
+				if err != nil {
+					return "", err
+				}
+				return id.String(), nil
+			})
+		},
+		"now": func() (string, error) {
+			return generate("now", nil, func() (string, error) {
+				if d.generators.now.IsZero() {
+					d.generators.now = time.Now().UTC()
+				}
+				return d.generators.now.Format(NowLayout), nil
+			})
+		},
+		"randomString": func(n int, name ...string) (string, error) {
+			return generate("randomString", name, func() (string, error) {
+				return randomString(n)
+			})
+		},
+	}
+}
+
+func randomString(n int) (string, error) {
+	if n <= 0 {
+		return "", fmt.Errorf("length must be positive, got %d", n)
+	}
+
+	max := big.NewInt(int64(len(randomAlphabet)))
+	buf := make([]byte, n)
+	for i := range buf {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = randomAlphabet[idx.Int64()]
+	}
+	return string(buf), nil
+}
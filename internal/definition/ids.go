@@ -0,0 +1,130 @@
+package definition
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// IDsIndex names operations without an id operation_N by their position (the default)
+	IDsIndex = "index"
+	// IDsContent names them after a hash of what they execute, so inserting operations does not rename others
+	IDsContent = "content"
+)
+
+// contentIDs reports whether operations without an id get content-based IDs
+func (d *Definition) contentIDs() bool {
+	return d.IDs == IDsContent
+}
+
+// contentID returns the ID of an operation derived from what it executes: its type followed by
+// a hash of the SQL (whitespace-normalized), the blocks of anonymize, copy and cross_check
+// operations, and the file, table and columns of imports and exports. Descriptions, owners and
+// expectations are not part of it, so editing them keeps the ID.
+func contentID(op Operation) (string, error) {
+	opType := op.Type
+	if opType == "" {
+		opType = DetectSQLType(op.SQL)
+	}
+
+	file := op.File
+	if file != "" {
+		// 絶対パスはチェックアウト先で変わるので、定義からの相対パスを使う
+		if rel, err := filepath.Rel(op.dir, file); err == nil {
+			file = filepath.ToSlash(rel)
+		} else {
+			file = filepath.Base(file)
+		}
+	}
+
+	content := struct {
+		Type      string            `yaml:"type"`
+		SQL       string            `yaml:"sql,omitempty"`
+		Anonymize *Anonymize        `yaml:"anonymize,omitempty"`
+		Secondary *RemoteQuery      `yaml:"secondary,omitempty"`
+		Source    *RemoteQuery      `yaml:"source,omitempty"`
+		Into      string            `yaml:"into,omitempty"`
+		File      string            `yaml:"file,omitempty"`
+		Columns   map[string]string `yaml:"columns,omitempty"`
+		Staging   bool              `yaml:"staging,omitempty"`
+	}{
+		Type:      opType,
+		SQL:       strings.Join(strings.Fields(op.SQL), " "),
+		Anonymize: op.Anonymize,
+		Secondary: op.Secondary,
+		Source:    op.Source,
+		Into:      op.Into,
+		File:      file,
+		Columns:   op.Columns,
+		Staging:   op.Staging,
+	}
+	data, err := yaml.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s_%s", opType, hex.EncodeToString(sum[:4])), nil
+}
+
+// claimIDs assigns the IDs of a merged file's operations that have none and checks them against
+// the IDs of the files merged before. The operations of a file are numbered on their own, as when
+// the file is loaded alone: operation_N in the first file, <file name>.operation_N in the others.
+func (d *Definition) claimIDs(ops []Operation, file, prefix string) error {
+	own := make(map[string]bool)
+	for _, op := range ops {
+		if op.ID == "" {
+			continue
+		}
+		if origin, exists := d.idOrigins[op.ID]; exists && !own[op.ID] {
+			return fmt.Errorf("duplicate operation ID: %s (in %s and %s)", op.ID, origin, file)
+		}
+		own[op.ID] = true
+		d.idOrigins[op.ID] = file
+	}
+	if d.contentIDs() {
+		return nil
+	}
+
+	for i := range ops {
+		if ops[i].ID != "" {
+			continue
+		}
+		id := ""
+		for n := 0; ; n++ {
+			id = fmt.Sprintf("%soperation_%d", prefix, n)
+			if !own[id] {
+				break
+			}
+		}
+		if origin, exists := d.idOrigins[id]; exists {
+			return fmt.Errorf("duplicate operation ID: %s generated for operation[%d] of %s is already used in %s (give the operation an id)", id, i, file, origin)
+		}
+		own[id] = true
+		d.idOrigins[id] = file
+		ops[i].ID = id
+	}
+	return nil
+}
+
+// fileLabel names the definition in errors: its file, or its position among merged definitions
+func (d *Definition) fileLabel(index int) string {
+	if d.source != "" {
+		return d.source
+	}
+	return fmt.Sprintf("definition #%d", index+1)
+}
+
+// idPrefix is what the generated IDs of a definition merged after the first start with: the
+// name of its file without extension
+func (d *Definition) idPrefix(index int) string {
+	if d.source == "" {
+		return fmt.Sprintf("file%d.", index+1)
+	}
+	name := filepath.Base(d.source)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "."
+}
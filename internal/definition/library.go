@@ -0,0 +1,208 @@
+package definition
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplatesDirName is the directory searched for operation templates, next to a definition or in any of its parents
+const TemplatesDirName = "templates"
+
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// OperationTemplate is a parameterized snippet of operations that definitions instantiate with use
+type OperationTemplate struct {
+	Description string `yaml:"description,omitempty"`
+	// Args declares the arguments given with with; SQL refers to them as .args
+	Args       map[string]ParamSpec `yaml:"args,omitempty"`
+	Operations []Operation          `yaml:"operations"`
+}
+
+// FindTemplate returns the file of the named template: templates/<name>.yaml in the definition's
+// directory or the nearest parent that has one, then OPSQL_TEMPLATES_DIR
+func FindTemplate(configPath, name string) (string, error) {
+	if !templateNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid template name: %q", name)
+	}
+
+	var dirs []string
+	dir, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return "", err
+	}
+	for {
+		dirs = append(dirs, filepath.Join(dir, TemplatesDirName))
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if shared := os.Getenv("OPSQL_TEMPLATES_DIR"); shared != "" {
+		dirs = append(dirs, shared)
+	}
+
+	for _, dir := range dirs {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+	return "", fmt.Errorf("template %q not found in a %s/ directory or OPSQL_TEMPLATES_DIR", name, TemplatesDirName)
+}
+
+// LoadTemplate reads an operation template file
+func LoadTemplate(path string) (*OperationTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %s %w", path, err)
+	}
+
+	var tmpl OperationTemplate
+	if err := yaml.Unmarshal(data, &tmpl); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", path, err)
+	}
+	if len(tmpl.Operations) == 0 {
+		return nil, fmt.Errorf("template %s has no operations", path)
+	}
+	for i, op := range tmpl.Operations {
+		if op.Use != "" {
+			return nil, fmt.Errorf("template %s: operation[%d]: templates cannot use other templates", path, i)
+		}
+		if op.Type == TypeExport && op.File != "" && !filepath.IsLocal(op.File) {
+			return nil, fmt.Errorf("template %s: operation[%d]: export: file must be a relative path inside the template's directory: %s", path, i, op.File)
+		}
+		tmpl.Operations[i].dir = filepath.Dir(path)
+		if op.File != "" && !filepath.IsAbs(op.File) {
+			tmpl.Operations[i].File = filepath.Join(filepath.Dir(path), op.File)
+		}
+	}
+	return &tmpl, nil
+}
+
+// bindArgs checks the arguments of a use against the template's args
+func (t *OperationTemplate) bindArgs(with map[string]interface{}) (map[string]interface{}, error) {
+	for name := range with {
+		if _, ok := t.Args[name]; !ok {
+			return nil, fmt.Errorf("unknown argument %q", name)
+		}
+	}
+
+	names := make([]string, 0, len(t.Args))
+	for name := range t.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make(map[string]interface{}, len(with))
+	for _, name := range names {
+		spec := t.Args[name]
+		value, exists := with[name]
+		if !exists || value == nil {
+			if spec.Required {
+				return nil, fmt.Errorf("argument %q is required", name)
+			}
+			continue
+		}
+
+		var pattern *regexp.Regexp
+		if spec.Pattern != "" {
+			re, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("argument %q: invalid pattern: %w", name, err)
+			}
+			pattern = re
+		}
+		if err := checkParam(value, spec, pattern); err != nil {
+			return nil, fmt.Errorf("argument %q: %w", name, err)
+		}
+		args[name] = value
+	}
+	return args, nil
+}
+
+// expandTemplates replaces the operations that use a template with the template's operations.
+// A single-operation template takes the ID of the use (the template name by default);
+// the operations of larger ones are named <id>.<operation id or index>.
+func (d *Definition) expandTemplates(configPath string) error {
+	var expanded []Operation
+	instantiated := make(map[string]bool)
+	for i, op := range d.Operations {
+		if op.Use == "" {
+			if len(op.With) > 0 {
+				return fmt.Errorf("operation[%d]: with requires use", i)
+			}
+			expanded = append(expanded, op)
+			continue
+		}
+		if op.SQL != "" {
+			return fmt.Errorf("operation[%d]: use and sql cannot be combined", i)
+		}
+
+		path, err := FindTemplate(configPath, op.Use)
+		if err != nil {
+			return fmt.Errorf("operation[%d]: %w", i, err)
+		}
+		tmpl, err := LoadTemplate(path)
+		if err != nil {
+			return fmt.Errorf("operation[%d]: %w", i, err)
+		}
+		args, err := tmpl.bindArgs(op.With)
+		if err != nil {
+			return fmt.Errorf("operation[%d]: template %s: %w", i, op.Use, err)
+		}
+
+		prefix := op.ID
+		if prefix == "" {
+			prefix = op.Use
+		}
+		for j, snippet := range tmpl.Operations {
+			instance := deepCopyOperation(snippet)
+			instance.With = args
+			switch {
+			case len(tmpl.Operations) == 1:
+				instance.ID = prefix
+			case snippet.ID != "":
+				instance.ID = prefix + "." + snippet.ID
+				instance.group = prefix
+			default:
+				instance.ID = prefix + "." + strconv.Itoa(j)
+				instance.group = prefix
+			}
+			if instance.Description == "" {
+				instance.Description = op.Description
+			}
+			if instance.Description == "" {
+				instance.Description = tmpl.Description
+			}
+			if op.Owner != "" {
+				instance.Owner = op.Owner
+			}
+			instantiated[instance.ID] = true
+			expanded = append(expanded, instance)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, op := range expanded {
+		if seen[op.ID] && instantiated[op.ID] {
+			return fmt.Errorf("duplicate operation ID: %s (give each use of a template its own id)", op.ID)
+		}
+		seen[op.ID] = true
+	}
+
+	d.Operations = expanded
+	return nil
+}
@@ -0,0 +1,223 @@
+package definition
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const softDeleteUserTemplate = `description: Soft delete a user
+args:
+  user_id:
+    type: integer
+    required: true
+  reason:
+    type: string
+operations:
+  - id: check
+    sql: SELECT COUNT(*) AS cnt FROM users WHERE id = {{ bind .args.user_id }} AND deleted_at IS NULL
+    expected:
+      - cnt: 1
+  - id: delete
+    sql: UPDATE users SET deleted_at = NOW(), deleted_reason = {{ bind .args.reason }} WHERE id = {{ bind .args.user_id }}
+    expected_changes:
+      update: 1
+`
+
+func TestLoadDefinitionUsesTemplates(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(root, "templates", "soft_delete_user.yaml"), softDeleteUserTemplate); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(root, "templates", "archive.yaml"), `operations:
+  - sql: DELETE FROM {{ .args.table }} WHERE created_at < {{ bind .params.cutoff }}
+    expected_changes:
+      delete: 3
+args:
+  table:
+    type: string
+    pattern: ^[a-z_]+$
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	// テンプレートは親ディレクトリのtemplates/からも探す
+	dir := filepath.Join(root, "team")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "runbook.yaml")
+	if err := writeTestFile(configPath, `version: 1
+params:
+  cutoff: "2024-01-01"
+operations:
+  - id: alice
+    use: soft_delete_user
+    owner: team-accounts
+    with:
+      user_id: 42
+      reason: gdpr
+  - use: archive
+    with:
+      table: audit_logs
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ids []string
+	for _, op := range def.Operations {
+		ids = append(ids, op.ID)
+	}
+	if want := []string{"alice.check", "alice.delete", "archive"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("operation IDs = %v, want %v", ids, want)
+	}
+
+	deleteOp := def.Operations[1]
+	if !strings.Contains(deleteOp.SQL, "deleted_reason = ? WHERE id = ?") {
+		t.Errorf("unexpected SQL: %s", deleteOp.SQL)
+	}
+	if want := []interface{}{"gdpr", 42}; !reflect.DeepEqual(deleteOp.Args, want) {
+		t.Errorf("args = %v, want %v", deleteOp.Args, want)
+	}
+	if deleteOp.Type != TypeUpdate || deleteOp.Owner != "team-accounts" || deleteOp.Description != "Soft delete a user" {
+		t.Errorf("unexpected operation: %+v", deleteOp)
+	}
+
+	archive := def.Operations[2]
+	if archive.SQL != "DELETE FROM audit_logs WHERE created_at < ?" || !reflect.DeepEqual(archive.Args, []interface{}{"2024-01-01"}) {
+		t.Errorf("template should see both args and params, got %s %v", archive.SQL, archive.Args)
+	}
+}
+
+func TestLoadDefinitionTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations string
+		wantErr    string
+	}{
+		{
+			name: "missing required argument",
+			operations: `  - use: soft_delete_user
+    with:
+      reason: gdpr
+`,
+			wantErr: `argument "user_id" is required`,
+		},
+		{
+			name: "wrong argument type",
+			operations: `  - use: soft_delete_user
+    with:
+      user_id: alice
+`,
+			wantErr: `argument "user_id": expected an integer`,
+		},
+		{
+			name: "unknown argument",
+			operations: `  - use: soft_delete_user
+    with:
+      user_id: 1
+      email: a@example.com
+`,
+			wantErr: `unknown argument "email"`,
+		},
+		{
+			name: "unknown template",
+			operations: `  - use: purge_everything
+`,
+			wantErr: `template "purge_everything" not found`,
+		},
+		{
+			name: "use with sql",
+			operations: `  - use: soft_delete_user
+    sql: SELECT 1
+`,
+			wantErr: "use and sql cannot be combined",
+		},
+		{
+			name: "with without use",
+			operations: `  - sql: SELECT 1 AS one
+    expected:
+      - one: 1
+    with:
+      user_id: 1
+`,
+			wantErr: "with requires use",
+		},
+		{
+			name: "same template used twice without ids",
+			operations: `  - use: soft_delete_user
+    with:
+      user_id: 1
+  - use: soft_delete_user
+    with:
+      user_id: 2
+`,
+			wantErr: "duplicate operation ID: soft_delete_user.check",
+		},
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(dir, "templates", "soft_delete_user.yaml"), softDeleteUserTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(dir, "runbook.yaml")
+			if err := writeTestFile(configPath, "version: 1\noperations:\n"+tt.operations); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadDefinition(configPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecksumIncludesTemplates(t *testing.T) {
+	dir := t.TempDir()
+	templatesDir := filepath.Join(t.TempDir(), "shared")
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPSQL_TEMPLATES_DIR", templatesDir)
+
+	templatePath := filepath.Join(templatesDir, "soft_delete_user.yml")
+	if err := writeTestFile(templatePath, softDeleteUserTemplate); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "runbook.yaml")
+	if err := writeTestFile(configPath, "version: 1\noperations:\n  - use: soft_delete_user\n    with:\n      user_id: 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(templatePath, strings.Replace(softDeleteUserTemplate, "update: 1", "update: 2", 1)); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("checksum should change when a used template changes")
+	}
+}
@@ -0,0 +1,39 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var orderByPattern = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+
+// Warning is a problem of an operation that does not keep the definition from running
+type Warning struct {
+	OperationID string
+	Message     string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("operation[%s]: %s", w.OperationID, w.Message)
+}
+
+// Lint returns the warnings of the definition's operations: SELECTs and exports that expect several
+// rows in order without an ORDER BY, whose assertions pass or fail with the plan the database picks
+func (d *Definition) Lint() []Warning {
+	var warnings []Warning
+	for _, op := range d.Operations {
+		if op.Type != TypeSelect && op.Type != TypeExport {
+			continue
+		}
+		if len(op.Expected) < 2 || len(op.KeyColumns) > 0 || op.Unordered {
+			continue
+		}
+		if !orderByPattern.MatchString(op.SQL) {
+			warnings = append(warnings, Warning{
+				OperationID: op.ID,
+				Message:     fmt.Sprintf("%d expected rows are compared in order but the SQL has no ORDER BY; add one, key_columns or unordered: true", len(op.Expected)),
+			})
+		}
+	}
+	return warnings
+}
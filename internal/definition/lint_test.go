@@ -0,0 +1,23 @@
+package definition
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	rows := []map[string]interface{}{{"id": 1}, {"id": 2}}
+	def := &Definition{Operations: []Operation{
+		{ID: "unordered_select", Type: TypeSelect, SQL: "SELECT id FROM users", Expected: rows},
+		{ID: "ordered", Type: TypeSelect, SQL: "SELECT id FROM users\norder  by id", Expected: rows},
+		{ID: "single_row", Type: TypeSelect, SQL: "SELECT COUNT(*) AS c FROM users", Expected: rows[:1]},
+		{ID: "keyed", Type: TypeSelect, SQL: "SELECT id FROM users", Expected: rows, KeyColumns: Keys{"id"}},
+		{ID: "marked", Type: TypeSelect, SQL: "SELECT id FROM users", Expected: rows, Unordered: true},
+		{ID: "unordered_export", Type: TypeExport, SQL: "SELECT id FROM users", Expected: rows, File: "users.csv"},
+	}}
+
+	warnings := def.Lint()
+	if len(warnings) != 2 {
+		t.Fatalf("Lint() = %v, want 2 warnings", warnings)
+	}
+	if warnings[0].OperationID != "unordered_select" || warnings[1].OperationID != "unordered_export" {
+		t.Errorf("Lint() = %v, want warnings for unordered_select and unordered_export", warnings)
+	}
+}
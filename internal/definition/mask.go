@@ -0,0 +1,89 @@
+package definition
+
+import (
+	"path"
+	"strings"
+)
+
+// MaskedValue replaces the values of masked columns
+const MaskedValue = "***"
+
+// IsMasked reports whether the column matches one of the definition's mask patterns
+func (d *Definition) IsMasked(column string) bool {
+	column = strings.ToLower(column)
+	for _, pattern := range d.Mask {
+		if matched, _ := path.Match(strings.ToLower(pattern), column); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskRows returns copies of the rows with masked columns replaced by MaskedValue
+func (d *Definition) MaskRows(rows []map[string]interface{}) []map[string]interface{} {
+	if len(d.Mask) == 0 || rows == nil {
+		return rows
+	}
+
+	masked := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		masked[i] = make(map[string]interface{}, len(row))
+		for column, value := range row {
+			if value != nil && d.IsMasked(column) {
+				value = MaskedValue
+			}
+			masked[i][column] = value
+		}
+	}
+	return masked
+}
+
+// MaskReport replaces the values of masked columns in every field of the report that carries rows
+// or column values: the result, the expected rows, the sample, the captured keys and the failure,
+// whose message then shows MaskedValue too
+func (d *Definition) MaskReport(r *Report) {
+	if len(d.Mask) == 0 || r == nil {
+		return
+	}
+
+	r.Result = d.maskValue(r.Result)
+	r.Expected = d.maskValue(r.Expected)
+	r.Sample = d.MaskRows(r.Sample)
+	r.CapturedKeys = d.MaskRows(r.CapturedKeys)
+
+	f := r.Failure
+	if f == nil {
+		return
+	}
+	f.ExpectedRow = d.maskRow(f.ExpectedRow)
+	f.ActualRow = d.maskRow(f.ActualRow)
+	// 列の値ではない件数などはそのまま残す
+	if f.Code == FailureValueMismatch && d.IsMasked(f.Column) {
+		f.Expected = MaskedValue
+		f.Actual = MaskedValue
+		if i := strings.Index(r.Message, ": expected "); i >= 0 {
+			r.Message = r.Message[:i] + ": expected " + MaskedValue + ", got " + MaskedValue
+		}
+		return
+	}
+	f.Expected = d.maskValue(f.Expected)
+	f.Actual = d.maskValue(f.Actual)
+}
+
+// maskValue masks the rows of a result or expectation; other values are returned as they are
+func (d *Definition) maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		return d.MaskRows(v)
+	case map[string]interface{}:
+		return d.maskRow(v)
+	}
+	return value
+}
+
+func (d *Definition) maskRow(row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	return d.MaskRows([]map[string]interface{}{row})[0]
+}
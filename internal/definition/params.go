@@ -0,0 +1,206 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamTypeString  = "string"
+	ParamTypeInteger = "integer"
+	ParamTypeNumber  = "number"
+	ParamTypeBoolean = "boolean"
+	ParamTypeList    = "list"
+)
+
+var allowedParamTypes = []string{ParamTypeString, ParamTypeInteger, ParamTypeNumber, ParamTypeBoolean, ParamTypeList}
+
+// ParamSpec declares what a param must look like (param_schema)
+type ParamSpec struct {
+	Type     string `yaml:"type,omitempty"`
+	Required bool   `yaml:"required,omitempty"`
+	// Items is the element type of a list param
+	Items string `yaml:"items,omitempty"`
+	// Pattern is matched against the string form of the value (each element for lists)
+	Pattern string        `yaml:"pattern,omitempty"`
+	Allowed []interface{} `yaml:"allowed,omitempty"`
+}
+
+// SetParams overrides params with values given on the command line (--param key=value).
+// Values are converted to the type declared in param_schema; undeclared params stay strings.
+func (d *Definition) SetParams(values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if d.Params == nil {
+		d.Params = make(map[string]interface{})
+	}
+
+	for _, name := range sortedKeys(values) {
+		value, err := parseParam(values[name], d.ParamSchema[name])
+		if err != nil {
+			return fmt.Errorf("param %q: %w", name, err)
+		}
+		d.Params[name] = value
+	}
+	return nil
+}
+
+// ValidateParams checks params against param_schema
+func (d *Definition) ValidateParams() error {
+	names := make([]string, 0, len(d.ParamSchema))
+	for name := range d.ParamSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		spec := d.ParamSchema[name]
+		if spec.Type != "" && !contains(allowedParamTypes, spec.Type) {
+			return fmt.Errorf("param_schema[%s]: unsupported type: %s (allowed: %v)", name, spec.Type, allowedParamTypes)
+		}
+		if spec.Items != "" && (spec.Type != ParamTypeList || spec.Items == ParamTypeList || !contains(allowedParamTypes, spec.Items)) {
+			return fmt.Errorf("param_schema[%s]: items must be a scalar type of a list param", name)
+		}
+
+		var pattern *regexp.Regexp
+		if spec.Pattern != "" {
+			re, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				return fmt.Errorf("param_schema[%s]: invalid pattern: %w", name, err)
+			}
+			pattern = re
+		}
+
+		value, exists := d.Params[name]
+		if !exists || value == nil {
+			if spec.Required && !d.isDerived(name) {
+				return fmt.Errorf("param %q is required (set it in params or with --param %s=...)", name, name)
+			}
+			continue
+		}
+
+		if err := checkParam(value, spec, pattern); err != nil {
+			return fmt.Errorf("param %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// isDerived reports whether the param is set by derived_params, which have no value until ResolveDerivedParams
+func (d *Definition) isDerived(name string) bool {
+	for _, derived := range d.DerivedParams {
+		if derived.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseParam(raw string, spec ParamSpec) (interface{}, error) {
+	if spec.Type != ParamTypeList {
+		return parseScalar(raw, spec.Type)
+	}
+
+	items := []interface{}{}
+	for _, item := range strings.Split(raw, ",") {
+		value, err := parseScalar(strings.TrimSpace(item), spec.Items)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+func parseScalar(raw, typ string) (interface{}, error) {
+	switch typ {
+	case ParamTypeInteger:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", raw)
+		}
+		return v, nil
+	case ParamTypeNumber:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", raw)
+		}
+		return v, nil
+	case ParamTypeBoolean:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected a boolean, got %q", raw)
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+func checkParam(value interface{}, spec ParamSpec, pattern *regexp.Regexp) error {
+	if spec.Type == ParamTypeList {
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected a list, got %v", value)
+		}
+		for i, item := range items {
+			if err := checkScalar(item, spec.Items, spec.Allowed, pattern); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+	return checkScalar(value, spec.Type, spec.Allowed, pattern)
+}
+
+func checkScalar(value interface{}, typ string, allowed []interface{}, pattern *regexp.Regexp) error {
+	switch typ {
+	case ParamTypeString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+	case ParamTypeInteger:
+		if _, ok := value.(int); !ok {
+			return fmt.Errorf("expected an integer, got %v", value)
+		}
+	case ParamTypeNumber:
+		switch value.(type) {
+		case int, float64:
+		default:
+			return fmt.Errorf("expected a number, got %v", value)
+		}
+	case ParamTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected a boolean, got %v", value)
+		}
+	}
+
+	if pattern != nil && !pattern.MatchString(fmt.Sprint(value)) {
+		return fmt.Errorf("%v does not match pattern %s", value, pattern)
+	}
+
+	if len(allowed) > 0 {
+		for _, a := range allowed {
+			if fmt.Sprint(a) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %v", value, allowed)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package definition
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefinitionsWithParams(t *testing.T) {
+	content := `version: 1
+param_schema:
+  user_ids:
+    type: list
+    items: integer
+    required: true
+  env:
+    type: string
+    allowed: [staging, prod]
+  ticket:
+    pattern: "^OPS-[0-9]+$"
+params:
+  env: staging
+operations:
+  - id: op
+    sql: "SELECT * FROM users WHERE id IN ({{ bind .params.user_ids }})"
+    expected:
+      - id: 1
+`
+	configPath := filepath.Join(t.TempDir(), "params.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		wantArgs []interface{}
+		errorMsg string
+	}{
+		{name: "typed from the command line", params: map[string]string{"user_ids": "1, 2"}, wantArgs: []interface{}{1, 2}},
+		{name: "missing required", params: nil, errorMsg: `param "user_ids" is required`},
+		{name: "malformed element", params: map[string]string{"user_ids": "1,x"}, errorMsg: `param "user_ids": expected an integer, got "x"`},
+		{name: "not allowed", params: map[string]string{"user_ids": "1", "env": "dev"}, errorMsg: `param "env": dev is not one of [staging prod]`},
+		{name: "pattern mismatch", params: map[string]string{"user_ids": "1", "ticket": "123"}, errorMsg: `param "ticket": 123 does not match pattern`},
+		{name: "pattern match", params: map[string]string{"user_ids": "1", "ticket": "OPS-12"}, wantArgs: []interface{}{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := LoadDefinitionsWithParams([]string{configPath}, tt.params)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(def.Operations[0].Args, tt.wantArgs) {
+				t.Errorf("got args %#v, want %#v", def.Operations[0].Args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestValidateParamsTypes(t *testing.T) {
+	def := &Definition{
+		Params:      map[string]interface{}{"limit": "ten"},
+		ParamSchema: map[string]ParamSpec{"limit": {Type: ParamTypeInteger}},
+	}
+	if err := def.ValidateParams(); err == nil || !strings.Contains(err.Error(), "expected an integer") {
+		t.Errorf("expected type error, got %v", err)
+	}
+
+	def.ParamSchema["limit"] = ParamSpec{Type: "uuid"}
+	if err := def.ValidateParams(); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("expected unsupported type error, got %v", err)
+	}
+}
@@ -1,28 +1,36 @@
 package definition
 
 import (
-	"bytes"
 	"fmt"
+	"io/fs"
 	"os"
-	"text/template"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 func LoadDefinitions(configPaths []string) (*Definition, error) {
+	return LoadDefinitionsWithParams(configPaths, nil)
+}
+
+// LoadDefinitionsWithParams loads the definitions with params given on the command line
+// overriding those in the files
+func LoadDefinitionsWithParams(configPaths []string, params map[string]string) (*Definition, error) {
 	if len(configPaths) == 0 {
 		return nil, fmt.Errorf("no configuration files specified")
 	}
 
-	if len(configPaths) == 1 {
-		return LoadDefinition(configPaths[0])
-	}
-
 	// Load and merge multiple configuration files
 	var mergedDef *Definition
 	for i, configPath := range configPaths {
 		def, err := LoadDefinitionRaw(configPath)
 		if err != nil {
+			if len(configPaths) == 1 {
+				return nil, err
+			}
 			return nil, fmt.Errorf("failed to load config file %s: %w", configPath, err)
 		}
 
@@ -35,11 +43,20 @@ func LoadDefinitions(configPaths []string) (*Definition, error) {
 		}
 	}
 
+	if err := mergedDef.SetParams(params); err != nil {
+		return nil, err
+	}
+
 	// Validate and process templates after merging
 	if err := mergedDef.Validate(); err != nil {
 		return nil, err
 	}
 
+	// Templates that may refer to derived params wait for ResolveDerivedParams
+	if mergedDef.HasDerivedParams() {
+		return mergedDef, nil
+	}
+
 	if err := mergedDef.ProcessTemplates(); err != nil {
 		return nil, err
 	}
@@ -47,21 +64,38 @@ func LoadDefinitions(configPaths []string) (*Definition, error) {
 	return mergedDef, nil
 }
 
-func LoadDefinition(configPath string) (*Definition, error) {
-	def, err := LoadDefinitionRaw(configPath)
+// FindDefinitions returns the definition files under dir, as slash-separated paths relative to
+// it in name order. The templates directories are skipped, as their files cannot run on their own.
+func FindDefinitions(dir string) ([]string, error) {
+	var definitions []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == TemplatesDirName && path != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			definitions = append(definitions, filepath.ToSlash(rel))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	sort.Strings(definitions)
+	return definitions, nil
+}
 
-	if err := def.Validate(); err != nil {
-		return nil, err
-	}
-
-	if err := def.ProcessTemplates(); err != nil {
-		return nil, err
-	}
-
-	return def, nil
+func LoadDefinition(configPath string) (*Definition, error) {
+	return LoadDefinitionsWithParams([]string{configPath}, nil)
 }
 
 func LoadDefinitionRaw(configPath string) (*Definition, error) {
@@ -75,6 +109,32 @@ func LoadDefinitionRaw(configPath string) (*Definition, error) {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	// Fixture and import paths are relative to the definition file
+	for i, fixture := range def.Fixtures {
+		if fixture.File != "" && !filepath.IsAbs(fixture.File) {
+			def.Fixtures[i].File = filepath.Join(filepath.Dir(configPath), fixture.File)
+		}
+	}
+	def.dir = filepath.Dir(configPath)
+	def.source = configPath
+	for i, op := range def.Operations {
+		def.Operations[i].dir = def.dir
+		// exportはワークスペースの外に書き出させない
+		if op.Type == TypeExport && op.File != "" && !filepath.IsLocal(op.File) {
+			return nil, fmt.Errorf("operation[%d]: export: file must be a relative path inside the definition's directory: %s", i, op.File)
+		}
+		if op.File != "" && !filepath.IsAbs(op.File) {
+			def.Operations[i].File = filepath.Join(filepath.Dir(configPath), op.File)
+		}
+	}
+
+	if err := def.expandTemplates(configPath); err != nil {
+		return nil, err
+	}
+	if err := def.checkFileReferences(); err != nil {
+		return nil, err
+	}
+
 	return &def, nil
 }
 
@@ -82,6 +142,51 @@ func (d *Definition) Validate() error {
 	if d.Version != 1 && d.Version != 0 {
 		return fmt.Errorf("unsupported version: %d", d.Version)
 	}
+	if d.IDs != "" && d.IDs != IDsIndex && d.IDs != IDsContent {
+		return fmt.Errorf("unsupported ids: %q (allowed: %s, %s)", d.IDs, IDsIndex, IDsContent)
+	}
+
+	for i, fixture := range d.Fixtures {
+		if fixture.File == "" {
+			return fmt.Errorf("fixture[%d]: file is required", i)
+		}
+	}
+
+	for i, flag := range d.FeatureFlags {
+		if err := flag.validate(); err != nil {
+			return fmt.Errorf("feature_flags[%d]: %w", i, err)
+		}
+	}
+
+	derivedNames := make(map[string]bool)
+	for i, derived := range d.DerivedParams {
+		if derived.Name == "" || derived.SQL == "" {
+			return fmt.Errorf("derived_params[%d]: name and sql are required", i)
+		}
+		if derivedNames[derived.Name] {
+			return fmt.Errorf("derived_params[%d]: duplicate name: %s", i, derived.Name)
+		}
+		derivedNames[derived.Name] = true
+	}
+
+	if err := d.ValidateParams(); err != nil {
+		return err
+	}
+
+	for _, pattern := range d.Mask {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("mask: invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	for pattern, dec := range d.Decoders {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("decoders: invalid pattern %q: %w", pattern, err)
+		}
+		if err := dec.validate(); err != nil {
+			return fmt.Errorf("decoders: %s: %w", pattern, err)
+		}
+	}
 
 	// Build map of existing IDs and assign unique IDs to operations without IDs
 	existingIDs := make(map[string]bool)
@@ -95,12 +200,71 @@ func (d *Definition) Validate() error {
 
 	// Second pass: assign unique IDs to operations without IDs
 	for i, op := range d.Operations {
-		if op.SQL == "" {
+		switch {
+		case op.Type == TypeAnonymize:
+			if op.SQL != "" || op.Anonymize == nil {
+				return fmt.Errorf("operation[%d]: anonymize operations take an anonymize block instead of sql", i)
+			}
+			if err := op.Anonymize.validate(); err != nil {
+				return fmt.Errorf("operation[%d]: %w", i, err)
+			}
+		case op.Anonymize != nil:
+			return fmt.Errorf("operation[%d]: anonymize requires type: anonymize", i)
+		case op.Type == TypeCopy:
+			if op.SQL != "" || op.Source == nil {
+				return fmt.Errorf("operation[%d]: copy operations take a source instead of sql", i)
+			}
+			if err := op.Source.validate("source"); err != nil {
+				return fmt.Errorf("operation[%d]: %w", i, err)
+			}
+			if !identifierPattern.MatchString(op.Into) {
+				return fmt.Errorf("operation[%d]: copy: into must be a table name, got %q", i, op.Into)
+			}
+		case op.Source != nil:
+			return fmt.Errorf("operation[%d]: source requires type: copy", i)
+		case op.Type == TypeImport:
+			if op.SQL != "" || op.File == "" {
+				return fmt.Errorf("operation[%d]: import operations take a file instead of sql", i)
+			}
+			if !identifierPattern.MatchString(op.Into) {
+				return fmt.Errorf("operation[%d]: import: into must be a table name, got %q", i, op.Into)
+			}
+			for header, column := range op.Columns {
+				if !identifierPattern.MatchString(column) {
+					return fmt.Errorf("operation[%d]: import: invalid column name for %q: %q", i, header, column)
+				}
+			}
+		case op.Type == TypeExport:
+			if DetectSQLType(op.SQL) != TypeSelect {
+				return fmt.Errorf("operation[%d]: sql must be a SELECT for export", i)
+			}
+			if ext := strings.ToLower(filepath.Ext(op.File)); ext != ".csv" && ext != ".json" {
+				return fmt.Errorf("operation[%d]: export: file must be a .csv or .json file, got %q", i, op.File)
+			}
+			if op.Columns != nil || op.Staging || op.Into != "" {
+				return fmt.Errorf("operation[%d]: export takes only sql, file and expected", i)
+			}
+		case op.File != "" || op.Columns != nil || op.Staging:
+			return fmt.Errorf("operation[%d]: file, columns and staging require type: import", i)
+		case op.SQL == "":
 			return fmt.Errorf("operation[%d]: sql is required", i)
 		}
 
 		// IDが未指定の場合はユニークなIDを生成
 		opID := op.ID
+		if opID == "" && d.contentIDs() {
+			id, err := contentID(op)
+			if err != nil {
+				return fmt.Errorf("operation[%d]: %w", i, err)
+			}
+			// 同じ内容の操作が複数あれば出現順に番号を付ける
+			opID = id
+			for n := 2; existingIDs[opID]; n++ {
+				opID = fmt.Sprintf("%s_%d", id, n)
+			}
+			existingIDs[opID] = true
+			d.Operations[i].ID = opID
+		}
 		if opID == "" {
 			// Find next available operation_N ID
 			for idIndex := 0; ; idIndex++ {
@@ -129,12 +293,60 @@ func (d *Definition) Validate() error {
 			return fmt.Errorf("operation[%s]: unsupported type: %s (allowed: %v)", opID, opType, AllowedTypes)
 		}
 
+		if opType == TypeCrossCheck {
+			if op.Secondary == nil {
+				return fmt.Errorf("operation[%s]: secondary is required for cross_check", opID)
+			}
+			if op.Secondary.SQL == "" {
+				d.Operations[i].Secondary.SQL = op.SQL
+			}
+			if DetectSQLType(op.SQL) != TypeSelect {
+				return fmt.Errorf("operation[%s]: sql must be a SELECT for cross_check", opID)
+			}
+			if err := d.Operations[i].Secondary.validate("secondary"); err != nil {
+				return fmt.Errorf("operation[%s]: %w", opID, err)
+			}
+		} else if op.Secondary != nil {
+			return fmt.Errorf("operation[%s]: secondary requires type: cross_check", opID)
+		}
+
 		if opType == TypeSelect && len(op.Expected) == 0 {
 			return fmt.Errorf("operation[%s]: expected is required for SELECT", opID)
 		}
-		if opType != TypeSelect && len(op.ExpectedChanges) == 0 {
+		if !IsQuery(opType) && len(op.ExpectedChanges) == 0 {
 			return fmt.Errorf("operation[%s]: expected_changes is required for DML", opID)
 		}
+		if opType == TypeAnonymize {
+			if _, ok := op.ExpectedChanges[TypeUpdate]; !ok {
+				return fmt.Errorf("operation[%s]: expected_changes.update is required for anonymize", opID)
+			}
+		}
+		if opType == TypeCopy || opType == TypeImport {
+			if _, ok := op.ExpectedChanges[TypeInsert]; !ok {
+				return fmt.Errorf("operation[%s]: expected_changes.insert is required for %s", opID, opType)
+			}
+		}
+		if len(op.CaptureKeys) > 0 && opType != TypeUpdate && opType != TypeDelete && opType != TypeAnonymize {
+			return fmt.Errorf("operation[%s]: capture_keys is only supported for UPDATE, DELETE and anonymize", opID)
+		}
+		if op.Unordered && opType != TypeSelect && opType != TypeExport {
+			return fmt.Errorf("operation[%s]: unordered is only supported for SELECT and export", opID)
+		}
+		if len(op.KeyColumns) > 0 {
+			if opType != TypeSelect && opType != TypeExport {
+				return fmt.Errorf("operation[%s]: key_columns is only supported for SELECT and export", opID)
+			}
+			if op.Unordered {
+				return fmt.Errorf("operation[%s]: key_columns and unordered cannot be combined", opID)
+			}
+			for j, row := range op.Expected {
+				for _, column := range op.KeyColumns {
+					if _, ok := row[column]; !ok {
+						return fmt.Errorf("operation[%s]: expected[%d] has no value for key column %s", opID, j, column)
+					}
+				}
+			}
+		}
 	}
 
 	return nil
@@ -147,19 +359,39 @@ func (d *Definition) ProcessTemplates() error {
 			opID = fmt.Sprintf("operation_%d", i)
 		}
 
-		tmpl, err := template.New(opID).Parse(op.SQL)
+		sql := op.SQL
+		if op.Anonymize != nil {
+			sql = op.Anonymize.Where
+		}
+		rendered, err := d.renderSQL(opID, sql, op.With, op.dir)
 		if err != nil {
-			return fmt.Errorf("operation[%s]: failed to parse SQL template: %w", opID, err)
+			return fmt.Errorf("operation[%s]: %w", opID, err)
 		}
 
-		var buf bytes.Buffer
-		if err := tmpl.Execute(&buf, map[string]interface{}{
-			"params": d.Params,
-		}); err != nil {
-			return fmt.Errorf("operation[%s]: failed to execute SQL template: %w", opID, err)
+		if op.Anonymize != nil {
+			d.Operations[i].Anonymize.Where = rendered.SQL
+		} else {
+			d.Operations[i].SQL = rendered.SQL
+		}
+		d.Operations[i].Args = rendered.Args
+		d.Operations[i].Generated = rendered.Generated
+
+		remotes := []struct {
+			field string
+			query *RemoteQuery
+		}{{"secondary", op.Secondary}, {"source", op.Source}}
+		for _, remote := range remotes {
+			if remote.query == nil {
+				continue
+			}
+			rendered, err := d.renderSQL(opID+"."+remote.field, remote.query.SQL, op.With, op.dir)
+			if err != nil {
+				return fmt.Errorf("operation[%s]: %s: %w", opID, remote.field, err)
+			}
+			remote.query.SQL = rendered.SQL
+			remote.query.Args = rendered.Args
+			d.Operations[i].Generated = append(d.Operations[i].Generated, rendered.Generated...)
 		}
-
-		d.Operations[i].SQL = buf.String()
 	}
 
 	return nil
@@ -183,64 +415,81 @@ func MergeDefinitions(base, additional *Definition) error {
 
 	// Merge parameters - additional params override base params with deep copy
 	if base.Params == nil {
-		base.Params = make(map[string]string)
+		base.Params = make(map[string]interface{})
 	}
 
 	// Deep copy base params to avoid sharing references
 	if len(base.Params) > 0 {
-		copiedParams := make(map[string]string)
+		copiedParams := make(map[string]interface{})
 		for key, value := range base.Params {
 			copiedParams[key] = value
 		}
 		base.Params = copiedParams
 	}
 
-	// Add additional params (values are copied since string is a value type)
+	// Add additional params (lists are replaced as a whole, not merged)
 	for key, value := range additional.Params {
 		base.Params[key] = value
 	}
 
-	// Check for duplicate operation IDs among all IDs (explicit and auto-generated)
-	existingIDs := make(map[string]bool)
-	for _, op := range base.Operations {
-		if op.ID != "" {
-			existingIDs[op.ID] = true
-		} else {
-			// Reserve operation_0 for operations without ID in base
-			existingIDs["operation_0"] = true
+	// Additional param_schema entries replace those of the same name
+	if len(additional.ParamSchema) > 0 {
+		schema := make(map[string]ParamSpec, len(base.ParamSchema)+len(additional.ParamSchema))
+		for name, spec := range base.ParamSchema {
+			schema[name] = spec
+		}
+		for name, spec := range additional.ParamSchema {
+			schema[name] = spec
 		}
+		base.ParamSchema = schema
 	}
 
+	base.DerivedParams = append(base.DerivedParams, additional.DerivedParams...)
+	base.Fixtures = append(base.Fixtures, additional.Fixtures...)
+	base.Mask = append(base.Mask, additional.Mask...)
+
+	// Additional decoders replace those of the same column
+	if len(additional.Decoders) > 0 {
+		decoders := make(map[string]Decoder, len(base.Decoders)+len(additional.Decoders))
+		for column, dec := range base.Decoders {
+			decoders[column] = dec
+		}
+		for column, dec := range additional.Decoders {
+			decoders[column] = dec
+		}
+		base.Decoders = decoders
+	}
+	base.FeatureFlags = append(base.FeatureFlags, additional.FeatureFlags...)
+
+	// 先に読んだ操作にはもうIDが付いているので、idsは最初のファイルで決める
+	if additional.IDs != "" && additional.IDs != base.IDs {
+		return fmt.Errorf("ids mismatch: additional file has ids: %s, but the first file has %q (set ids in the first file)", additional.IDs, base.IDs)
+	}
+
+	// 最初のファイルのIDもここで確定させ、後のファイルとの衝突を検出できるようにする
+	if base.idOrigins == nil {
+		base.idOrigins = make(map[string]string)
+		if err := base.claimIDs(base.Operations, base.fileLabel(0), ""); err != nil {
+			return err
+		}
+	}
+	base.merged++
+
 	// Deep copy base operations to avoid sharing references
 	for i, op := range base.Operations {
 		base.Operations[i] = deepCopyOperation(op)
 	}
 
-	// Process additional operations
-	for _, op := range additional.Operations {
+	additionalOps := make([]Operation, len(additional.Operations))
+	for i, op := range additional.Operations {
 		// Deep copy the operation to avoid sharing references
-		copiedOp := deepCopyOperation(op)
-
-		// Check for duplicate explicit IDs
-		if copiedOp.ID != "" {
-			if existingIDs[copiedOp.ID] {
-				return fmt.Errorf("duplicate operation ID: %s", copiedOp.ID)
-			}
-			existingIDs[copiedOp.ID] = true
-		} else {
-			// Assign unique auto-generated ID if not set
-			for idIndex := 0; ; idIndex++ {
-				candidateID := fmt.Sprintf("operation_%d", idIndex)
-				if !existingIDs[candidateID] {
-					copiedOp.ID = candidateID
-					existingIDs[candidateID] = true
-					break
-				}
-			}
-		}
-
-		base.Operations = append(base.Operations, copiedOp)
+		additionalOps[i] = deepCopyOperation(op)
+	}
+	label := additional.fileLabel(base.merged)
+	if err := base.claimIDs(additionalOps, label, additional.idPrefix(base.merged)); err != nil {
+		return err
 	}
+	base.Operations = append(base.Operations, additionalOps...)
 
 	return nil
 }
@@ -252,6 +501,44 @@ func deepCopyOperation(op Operation) Operation {
 		Description: op.Description,
 		Type:        op.Type,
 		SQL:         op.SQL,
+		Unordered:   op.Unordered,
+		Owner:       op.Owner,
+		Use:         op.Use,
+		Into:        op.Into,
+		File:        op.File,
+		Staging:     op.Staging,
+		dir:         op.dir,
+		group:       op.group,
+	}
+
+	if op.With != nil {
+		copied.With = make(map[string]interface{}, len(op.With))
+		for key, value := range op.With {
+			copied.With[key] = value
+		}
+	}
+
+	if op.Anonymize != nil {
+		copied.Anonymize = op.Anonymize.copy()
+	}
+	if op.Secondary != nil {
+		copied.Secondary = op.Secondary.copy()
+	}
+	if op.Source != nil {
+		copied.Source = op.Source.copy()
+	}
+	if op.Columns != nil {
+		copied.Columns = make(map[string]string, len(op.Columns))
+		for header, column := range op.Columns {
+			copied.Columns[header] = column
+		}
+	}
+
+	if op.CaptureKeys != nil {
+		copied.CaptureKeys = append(Keys{}, op.CaptureKeys...)
+	}
+	if op.KeyColumns != nil {
+		copied.KeyColumns = append(Keys{}, op.KeyColumns...)
 	}
 
 	// Deep copy Expected slice
@@ -2,6 +2,8 @@ package definition
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -102,7 +104,7 @@ func TestMergeDefinitions(t *testing.T) {
 			name: "merge parameters",
 			base: &Definition{
 				Version: 1,
-				Params: map[string]string{
+				Params: map[string]interface{}{
 					"param1": "value1",
 					"param2": "value2",
 				},
@@ -112,7 +114,7 @@ func TestMergeDefinitions(t *testing.T) {
 			},
 			additional: &Definition{
 				Version: 1,
-				Params: map[string]string{
+				Params: map[string]interface{}{
 					"param2": "override",
 					"param3": "value3",
 				},
@@ -123,7 +125,7 @@ func TestMergeDefinitions(t *testing.T) {
 			wantError: false,
 		},
 		{
-			name: "merge with auto-generated IDs avoiding duplicates",
+			name: "merge with auto-generated IDs prefixed per file",
 			base: &Definition{
 				Version: 1,
 				Operations: []Operation{
@@ -133,11 +135,28 @@ func TestMergeDefinitions(t *testing.T) {
 			additional: &Definition{
 				Version: 1,
 				Operations: []Operation{
-					{SQL: "SELECT 2"}, // should be operation_1, not operation_0
+					{SQL: "SELECT 2"}, // will be file2.operation_0
 				},
 			},
 			wantError: false,
 		},
+		{
+			name: "explicit ID colliding with an auto-generated one",
+			base: &Definition{
+				Version: 1,
+				Operations: []Operation{
+					{SQL: "SELECT 1"}, // will be operation_0
+				},
+			},
+			additional: &Definition{
+				Version: 1,
+				Operations: []Operation{
+					{ID: "operation_0", SQL: "SELECT 2"},
+				},
+			},
+			wantError: true,
+			errorMsg:  "duplicate operation ID: operation_0 (in definition #1 and definition #2)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,7 +181,7 @@ func TestMergeDefinitions(t *testing.T) {
 
 			// Verify merge results
 			if tt.name == "merge parameters" {
-				expectedParams := map[string]string{
+				expectedParams := map[string]interface{}{
 					"param1": "value1",
 					"param2": "override", // should be overridden
 					"param3": "value3",
@@ -170,26 +189,54 @@ func TestMergeDefinitions(t *testing.T) {
 
 				for key, expectedValue := range expectedParams {
 					if actualValue, exists := tt.base.Params[key]; !exists || actualValue != expectedValue {
-						t.Errorf("expected param %s=%s, got %s=%s", key, expectedValue, key, actualValue)
+						t.Errorf("expected param %s=%v, got %s=%v", key, expectedValue, key, actualValue)
 					}
 				}
 			}
 
 			// Verify auto-generated ID uniqueness
-			if tt.name == "merge with auto-generated IDs avoiding duplicates" {
+			if tt.name == "merge with auto-generated IDs prefixed per file" {
 				if len(tt.base.Operations) != 2 {
 					t.Errorf("expected 2 operations, got %d", len(tt.base.Operations))
 				}
 
-				// Check that the additional operation has been assigned an ID during merge
-				if tt.base.Operations[1].ID != "operation_1" {
-					t.Errorf("second operation should have operation_1 as ID, got %s", tt.base.Operations[1].ID)
+				// Both files' operations are assigned IDs during merge, numbered within their file
+				if tt.base.Operations[0].ID != "operation_0" {
+					t.Errorf("first operation should have operation_0 as ID, got %s", tt.base.Operations[0].ID)
+				}
+				if tt.base.Operations[1].ID != "file2.operation_0" {
+					t.Errorf("second operation should have file2.operation_0 as ID, got %s", tt.base.Operations[1].ID)
 				}
 			}
 		})
 	}
 }
 
+func TestMergeDefinitionsKeepsMatchingOptions(t *testing.T) {
+	base := &Definition{
+		Version: 1,
+		Operations: []Operation{
+			{ID: "keyed", SQL: "SELECT id FROM users", KeyColumns: Keys{"id"}},
+		},
+	}
+	additional := &Definition{
+		Version: 1,
+		Operations: []Operation{
+			{ID: "unordered", SQL: "SELECT id FROM orders", Unordered: true},
+		},
+	}
+
+	if err := MergeDefinitions(base, additional); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := base.Operations[0].KeyColumns; len(got) != 1 || got[0] != "id" {
+		t.Errorf("key_columns = %v after merge", got)
+	}
+	if !base.Operations[1].Unordered {
+		t.Errorf("unordered should survive the merge")
+	}
+}
+
 func TestLoadDefinitionsMultipleFiles(t *testing.T) {
 	// Create temporary test files
 	tests := []struct {
@@ -322,6 +369,9 @@ operations:
 					}
 					ids[op.ID] = true
 				}
+				if def.Operations[1].ID != "test2.operation_0" {
+					t.Errorf("second file's operation should have test2.operation_0 as ID, got %s", def.Operations[1].ID)
+				}
 			}
 		})
 	}
@@ -331,3 +381,565 @@ operations:
 func writeTestFile(path, content string) error {
 	return os.WriteFile(path, []byte(content), 0644)
 }
+
+func TestLoadDefinitionFixturePaths(t *testing.T) {
+	dir := t.TempDir()
+	configPath := dir + "/runbook.yaml"
+	content := `version: 1
+fixtures:
+  - file: fixtures/schema.sql
+  - file: /abs/users.csv
+    table: users
+operations:
+  - sql: "SELECT 1 AS one"
+    expected:
+      - one: 1
+`
+	if err := writeTestFile(configPath, content); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(def.Fixtures) != 2 {
+		t.Fatalf("expected 2 fixtures, got %d", len(def.Fixtures))
+	}
+	if def.Fixtures[0].File != dir+"/fixtures/schema.sql" {
+		t.Errorf("relative fixture path should be resolved against the definition, got %s", def.Fixtures[0].File)
+	}
+	if def.Fixtures[1].File != "/abs/users.csv" || def.Fixtures[1].Table != "users" {
+		t.Errorf("absolute fixture should be kept as is, got %+v", def.Fixtures[1])
+	}
+}
+
+func TestLoadDefinitionCaptureKeys(t *testing.T) {
+	content := `version: 1
+operations:
+  - id: single
+    sql: "DELETE FROM users WHERE status = 'deleted'"
+    expected_changes:
+      delete: 1
+    capture_keys: id
+  - id: composite
+    sql: "UPDATE memberships SET role = 'member' WHERE role = 'guest'"
+    expected_changes:
+      update: 1
+    capture_keys: [tenant_id, user_id]
+`
+	configPath := filepath.Join(t.TempDir(), "capture.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := def.Operations[0].CaptureKeys; len(got) != 1 || got[0] != "id" {
+		t.Errorf("single capture_keys = %v", got)
+	}
+	if got := def.Operations[1].CaptureKeys; len(got) != 2 || got[1] != "user_id" {
+		t.Errorf("composite capture_keys = %v", got)
+	}
+}
+
+func TestLoadDefinitionAnonymize(t *testing.T) {
+	content := `version: 1
+params:
+  user_id: 42
+operations:
+  - id: erase_user
+    type: anonymize
+    anonymize:
+      table: users
+      where: id = {{ bind .params.user_id }}
+      columns:
+        email: hash
+        phone: null
+        name:
+          fake: Deleted User
+    expected_changes:
+      update: 1
+`
+	configPath := filepath.Join(t.TempDir(), "erase.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	op := def.Operations[0]
+	if op.Anonymize.Where != "id = ?" || len(op.Args) != 1 || op.Args[0] != 42 {
+		t.Errorf("where should be rendered like sql, got %q %v", op.Anonymize.Where, op.Args)
+	}
+	want := map[string]AnonymizeRule{
+		"email": {Kind: AnonymizeHash},
+		"phone": {Kind: AnonymizeNull},
+		"name":  {Kind: AnonymizeFake, Value: "Deleted User"},
+	}
+	for column, rule := range want {
+		if op.Anonymize.Columns[column] != rule {
+			t.Errorf("column %s = %+v, want %+v", column, op.Anonymize.Columns[column], rule)
+		}
+	}
+	if tables := def.Tables(); len(tables) != 1 || tables[0] != "users" {
+		t.Errorf("Tables() = %v", tables)
+	}
+
+	invalid := map[string]string{
+		"where on anonymized column": strings.Replace(content, "id = {{ bind .params.user_id }}", "email = 'a@example.com'", 1),
+		"unknown rule":               strings.Replace(content, "email: hash", "email: shuffle", 1),
+		"update count missing":       strings.Replace(content, "update: 1", "delete: 1", 1),
+		"sql instead of block":       strings.Replace(content, "    anonymize:\n", "    sql: UPDATE users SET email = NULL\n    anonymize:\n", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionCrossCheck(t *testing.T) {
+	content := `version: 1
+params:
+  order_id: 7
+operations:
+  - id: orders_match
+    type: cross_check
+    sql: SELECT id, total FROM orders WHERE id = {{ bind .params.order_id }}
+    secondary:
+      database: analytics
+`
+	configPath := filepath.Join(t.TempDir(), "check.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secondary := def.Operations[0].Secondary
+	if secondary.SQL != "SELECT id, total FROM orders WHERE id = ?" || len(secondary.Args) != 1 || secondary.Args[0] != 7 {
+		t.Errorf("secondary should default to the rendered sql, got %q %v", secondary.SQL, secondary.Args)
+	}
+
+	invalid := map[string]string{
+		"missing secondary":   strings.Replace(content, "    secondary:\n      database: analytics\n", "", 1),
+		"dml":                 strings.Replace(content, "SELECT id, total FROM orders", "DELETE FROM orders", 1),
+		"invalid database":    strings.Replace(content, "database: analytics", "database: \"mysql://root@tcp(db)/x\"", 1),
+		"secondary on select": strings.Replace(content, "type: cross_check", "type: select\n    expected:\n      - id: 7", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionCopy(t *testing.T) {
+	content := `version: 1
+operations:
+  - id: backfill_plans
+    type: copy
+    source:
+      database: billing
+      sql: SELECT id, name FROM plans WHERE id > {{ bind 100 }}
+    into: plans
+    expected_changes:
+      insert: 2
+`
+	configPath := filepath.Join(t.TempDir(), "copy.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source := def.Operations[0].Source
+	if source.SQL != "SELECT id, name FROM plans WHERE id > ?" || len(source.Args) != 1 {
+		t.Errorf("source should be rendered, got %q %v", source.SQL, source.Args)
+	}
+	if tables := def.Tables(); len(tables) != 1 || tables[0] != "plans" {
+		t.Errorf("Tables() = %v", tables)
+	}
+
+	invalid := map[string]string{
+		"missing into":         strings.Replace(content, "    into: plans\n", "", 1),
+		"insert count missing": strings.Replace(content, "insert: 2", "update: 2", 1),
+		"source on insert":     strings.Replace(content, "type: copy", "type: insert\n    sql: INSERT INTO plans VALUES (1)", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionImport(t *testing.T) {
+	dir := t.TempDir()
+	content := `version: 1
+operations:
+  - id: load_refunds
+    type: import
+    file: data/refunds.csv
+    into: refund_staging
+    staging: true
+    columns:
+      Order ID: order_id
+    expected_changes:
+      insert: 2
+`
+	configPath := filepath.Join(dir, "import.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	csvPath := filepath.Join(dir, "data", "refunds.csv")
+	if err := os.WriteFile(csvPath, []byte("Order ID\n1\n2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if op := def.Operations[0]; op.File != csvPath || op.Columns["Order ID"] != "order_id" {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+	if tables := def.Tables(); len(tables) != 0 {
+		t.Errorf("staging tables are not referenced tables, got %v", tables)
+	}
+
+	// 取り込むCSVが変わればチェックサムも変わる
+	before, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(csvPath, []byte("Order ID\n1\n3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("checksum should change when the imported CSV changes")
+	}
+
+	invalid := map[string]string{
+		"missing file":         strings.Replace(content, "    file: data/refunds.csv\n", "", 1),
+		"invalid column":       strings.Replace(content, "Order ID: order_id", "Order ID: order id", 1),
+		"file on insert":       strings.Replace(content, "type: import", "type: insert\n    sql: INSERT INTO refunds VALUES (1)", 1),
+		"insert count missing": strings.Replace(content, "insert: 2", "delete: 2", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionExport(t *testing.T) {
+	dir := t.TempDir()
+	content := `version: 1
+operations:
+  - id: backup_orders
+    type: export
+    sql: SELECT id, status FROM orders WHERE status = 'stale'
+    file: exports/orders.csv
+  - id: delete_orders
+    sql: DELETE FROM orders WHERE status = 'stale'
+    expected_changes:
+      delete: 2
+`
+	configPath := filepath.Join(dir, "export.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if op := def.Operations[0]; op.File != filepath.Join(dir, "exports", "orders.csv") || op.Type != TypeExport {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+
+	invalid := map[string]string{
+		"outside the workspace": strings.Replace(content, "file: exports/orders.csv", "file: ../orders.csv", 1),
+		"absolute path":         strings.Replace(content, "file: exports/orders.csv", "file: /tmp/orders.csv", 1),
+		"unsupported format":    strings.Replace(content, "exports/orders.csv", "exports/orders.xlsx", 1),
+		"not a select":          strings.Replace(content, "sql: SELECT id, status FROM orders", "sql: DELETE FROM orders", 1),
+		"with into":             strings.Replace(content, "file: exports/orders.csv", "file: exports/orders.csv\n    into: orders_backup", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionDecoders(t *testing.T) {
+	dir := t.TempDir()
+	content := `version: 1
+decoders:
+  settings: json
+  is_*: bool
+  status:
+    enum:
+      1: active
+      2: suspended
+operations:
+  - id: check_user
+    sql: SELECT status, is_admin, settings FROM users WHERE id = 1
+    expected:
+      - status: active
+        is_admin: false
+`
+	configPath := filepath.Join(dir, "decoders.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := def.DecodeRows([]map[string]interface{}{
+		{"STATUS": int64(2), "is_admin": []byte("1"), "settings": `{"theme": "dark", "limits": [1, 2]}`, "name": "alice"},
+		{"STATUS": int64(1), "is_admin": nil, "settings": nil, "name": "bob"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []map[string]interface{}{
+		{"STATUS": "suspended", "is_admin": true, "settings": map[string]interface{}{"theme": "dark", "limits": []interface{}{float64(1), float64(2)}}, "name": "alice"},
+		{"STATUS": "active", "is_admin": nil, "settings": nil, "name": "bob"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("unexpected rows: %#v", rows)
+	}
+
+	if _, err := def.DecodeRows([]map[string]interface{}{{"status": int64(3)}}); err == nil {
+		t.Error("expected an error for a value outside the enum")
+	}
+	if _, err := def.DecodeRows([]map[string]interface{}{{"settings": "{broken"}}); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+
+	invalid := map[string]string{
+		"unknown decoder": strings.Replace(content, "settings: json", "settings: yaml", 1),
+		"invalid pattern": strings.Replace(content, "is_*: bool", "\"is_[\": bool", 1),
+		"empty enum":      strings.Replace(content, "    enum:\n      1: active\n      2: suspended\n", "    enum: {}\n", 1),
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadDefinitionContentIDs(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, content string) *Definition {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		def, err := LoadDefinition(path)
+		if err != nil {
+			t.Fatalf("LoadDefinition: %v", err)
+		}
+		return def
+	}
+
+	before := load("before.yaml", `version: 1
+ids: content
+operations:
+  - sql: DELETE FROM sessions WHERE expired = 1
+    expected_changes:
+      delete: 3
+`)
+	after := load("after.yaml", `version: 1
+ids: content
+operations:
+  - sql: SELECT COUNT(*) AS c FROM sessions
+    expected:
+      - c: 10
+  - description: reformatted, with a new expectation
+    sql: |
+      DELETE FROM sessions
+      WHERE expired = 1
+    expected_changes:
+      delete: 4
+  - sql: DELETE FROM sessions WHERE expired = 1
+    expected_changes:
+      delete: 0
+`)
+
+	id := before.Operations[0].ID
+	if !strings.HasPrefix(id, "delete_") || len(id) != len("delete_")+8 {
+		t.Fatalf("unexpected content ID: %s", id)
+	}
+	if after.Operations[1].ID != id {
+		t.Errorf("ID changed after inserting an operation: %s, want %s", after.Operations[1].ID, id)
+	}
+	if after.Operations[2].ID != id+"_2" {
+		t.Errorf("identical operation got %s, want %s", after.Operations[2].ID, id+"_2")
+	}
+	if !strings.HasPrefix(after.Operations[0].ID, "select_") {
+		t.Errorf("unexpected content ID: %s", after.Operations[0].ID)
+	}
+
+	base := filepath.Join(dir, "base.yaml")
+	extra := filepath.Join(dir, "extra.yaml")
+	if err := os.WriteFile(base, []byte("version: 1\noperations:\n  - sql: SELECT 1 AS one\n    expected:\n      - one: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(extra, []byte("version: 1\nids: content\noperations: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDefinitions([]string{base, extra}); err == nil || !strings.Contains(err.Error(), "ids mismatch") {
+		t.Errorf("expected ids mismatch error, got %v", err)
+	}
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	load := func(content string) *Definition {
+		t.Helper()
+		path := filepath.Join(dir, "def.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		def, err := LoadDefinition(path)
+		if err != nil {
+			t.Fatalf("LoadDefinition: %v", err)
+		}
+		return def
+	}
+
+	monitoring := load(`version: 1
+operations:
+  - id: stuck_orders
+    sql: SELECT COUNT(*) AS c FROM orders WHERE status = 'pending'
+    expected:
+      - c: 0
+  - id: dump
+    type: export
+    sql: SELECT id FROM orders
+    file: orders.csv
+`)
+	if err := monitoring.CheckReadOnly(); err != nil {
+		t.Errorf("CheckReadOnly() = %v, want nil", err)
+	}
+
+	cleanup := load(`version: 1
+operations:
+  - id: count
+    sql: SELECT COUNT(*) AS c FROM sessions
+    expected:
+      - c: 3
+  - id: purge
+    sql: DELETE FROM sessions WHERE expired = 1
+    expected_changes:
+      delete: 3
+`)
+	err := cleanup.CheckReadOnly()
+	if err == nil || !strings.Contains(err.Error(), "operation[purge]") {
+		t.Errorf("CheckReadOnly() = %v, want an error naming purge", err)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "def.yaml")
+	content := `version: 1
+operations:
+  - id: before
+    sql: SELECT COUNT(*) AS c FROM sessions WHERE expired = 1
+    expected:
+      - c: 3
+  - id: purge
+    sql: DELETE FROM sessions WHERE expired = 1
+    expected_changes:
+      delete: 3
+  - id: dump
+    type: export
+    sql: SELECT id FROM sessions
+    file: sessions.csv
+  - id: replica
+    type: cross_check
+    sql: SELECT COUNT(*) AS c FROM sessions
+    secondary:
+      database: replica
+      sql: SELECT COUNT(*) AS c FROM sessions
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	def, err := LoadDefinition(path)
+	if err != nil {
+		t.Fatalf("LoadDefinition: %v", err)
+	}
+
+	var ids []string
+	for _, op := range def.Checks() {
+		ids = append(ids, op.ID)
+	}
+	if strings.Join(ids, ",") != "before,replica" {
+		t.Errorf("Checks() = %v, want [before replica]", ids)
+	}
+}
+
+func TestFindDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.yaml", "a.yml", "nested/c.yaml", "templates/t.yaml", "nested/templates/u.yaml", "README.md"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("version: 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := FindDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// テンプレートは単体では実行できないので含めない
+	if strings.Join(files, ",") != "a.yml,b.yaml,nested/c.yaml" {
+		t.Errorf("FindDefinitions() = %v", files)
+	}
+}
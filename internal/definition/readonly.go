@@ -0,0 +1,51 @@
+package definition
+
+import "fmt"
+
+// CheckReadOnly returns an error unless every query of the definition is a SELECT: only select,
+// cross_check and export operations, and derived_params, with SQL starting with SELECT (--read-only)
+func (d *Definition) CheckReadOnly() error {
+	if err := d.CheckDerivedParamsReadOnly(); err != nil {
+		return err
+	}
+
+	for _, op := range d.Operations {
+		switch op.Type {
+		case TypeSelect, TypeCrossCheck, TypeExport:
+		default:
+			return fmt.Errorf("read-only: operation[%s] is of type %s, only select, cross_check and export are allowed", op.ID, op.Type)
+		}
+		if DetectSQLType(op.SQL) != TypeSelect {
+			return fmt.Errorf("read-only: operation[%s] is not a SELECT", op.ID)
+		}
+		if op.Secondary != nil && DetectSQLType(op.Secondary.SQL) != TypeSelect {
+			return fmt.Errorf("read-only: operation[%s]: secondary query is not a SELECT", op.ID)
+		}
+	}
+	return nil
+}
+
+// Checks returns the operations that only read and assert (verify-all): the select and
+// cross_check operations whose queries are SELECTs. Exports are left out, as they write files.
+func (d *Definition) Checks() []Operation {
+	var checks []Operation
+	for _, op := range d.Operations {
+		if op.Type != TypeSelect && op.Type != TypeCrossCheck {
+			continue
+		}
+		if DetectSQLType(op.SQL) == TypeSelect && (op.Secondary == nil || DetectSQLType(op.Secondary.SQL) == TypeSelect) {
+			checks = append(checks, op)
+		}
+	}
+	return checks
+}
+
+// CheckDerivedParamsReadOnly returns an error unless every query of derived_params is a SELECT
+func (d *Definition) CheckDerivedParamsReadOnly() error {
+	for i, derived := range d.DerivedParams {
+		if DetectSQLType(derived.SQL) != TypeSelect {
+			return fmt.Errorf("read-only: derived_params[%d] %s is not a SELECT", i, derived.Name)
+		}
+	}
+	return nil
+}
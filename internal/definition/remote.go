@@ -0,0 +1,35 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var databaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// RemoteQuery is a query run on a database other than the target, named by DATABASE_DSN_<NAME>
+type RemoteQuery struct {
+	Database string `yaml:"database"`
+	// SQL is a SQL template like the sql of an operation
+	SQL string `yaml:"sql,omitempty"`
+	// Args are the values collected by the bind template function
+	Args []interface{} `yaml:"-"`
+}
+
+func (q *RemoteQuery) validate(field string) error {
+	if !databaseNamePattern.MatchString(q.Database) {
+		return fmt.Errorf("%s: database must be a name such as analytics (read from DATABASE_DSN_<NAME>), got %q", field, q.Database)
+	}
+	if DetectSQLType(q.SQL) != TypeSelect {
+		return fmt.Errorf("%s: sql must be a SELECT", field)
+	}
+	return nil
+}
+
+func (q *RemoteQuery) copy() *RemoteQuery {
+	copied := *q
+	if q.Args != nil {
+		copied.Args = append([]interface{}{}, q.Args...)
+	}
+	return &copied
+}
@@ -0,0 +1,217 @@
+package definition
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sqlToken is a word (an identifier or keyword, possibly quoted and qualified) or a punctuation
+// character of a statement; string literals and comments are not tokens
+type sqlToken struct {
+	text string
+	word bool
+	// call is set on a word directly followed by "(", like generate_series(
+	call bool
+}
+
+// tokenizeSQL splits a statement into words and punctuation, skipping literals and comments
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			// '' と \' はリテラルの中の引用符
+			i++
+			for i < len(sql) {
+				if sql[i] == '\\' {
+					i += 2
+					continue
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '`' || c == '"' || c == '_' || c == '$' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || c >= 0x80:
+			start := i
+			for i < len(sql) {
+				if q := sql[i]; q == '`' || q == '"' {
+					end := strings.IndexByte(sql[i+1:], q)
+					if end < 0 {
+						i = len(sql)
+						break
+					}
+					i += end + 2
+					continue
+				}
+				if r := rune(sql[i]); r == '_' || r == '$' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) || r >= 0x80 {
+					i++
+					continue
+				}
+				break
+			}
+			word := strings.NewReplacer("`", "", "\"", "").Replace(sql[start:i])
+			tokens = append(tokens, sqlToken{text: word, word: true, call: i < len(sql) && sql[i] == '('})
+		case unicode.IsSpace(rune(c)):
+			i++
+		default:
+			tokens = append(tokens, sqlToken{text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// fromListEnd are the keywords that end a comma-separated list of tables after FROM or UPDATE
+var fromListEnd = map[string]bool{
+	"WHERE": true, "SET": true, "ON": true, "USING": true, "GROUP": true, "ORDER": true, "HAVING": true,
+	"LIMIT": true, "OFFSET": true, "FETCH": true, "WINDOW": true, "UNION": true, "INTERSECT": true,
+	"EXCEPT": true, "RETURNING": true, "FOR": true, "JOIN": true, "INNER": true, "LEFT": true,
+	"RIGHT": true, "FULL": true, "CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true, "VALUES": true,
+	"SELECT": true, "INTO": true,
+}
+
+// ExtractTables returns the tables referenced by a SQL statement in order of first appearance.
+// It is a lightweight scanner, not a SQL parser: function calls and their arguments, string
+// literals, comments and the names of common table expressions are skipped, and subqueries are
+// scanned like the statement.
+func ExtractTables(sql string) []string {
+	tokens := tokenizeSQL(sql)
+
+	var referenced []string
+	ctes := make(map[string]bool)
+	// functions holds, for each open parenthesis, whether it holds the arguments of a function call
+	var functions []bool
+	// expectTable is set after a keyword followed by a table name; lists marks the depths where
+	// the comma-separated tables of a FROM or UPDATE can continue
+	expectTable := false
+	lists := make(map[int]bool)
+
+	for i, token := range tokens {
+		depth := len(functions)
+		inFunction := depth > 0 && functions[depth-1]
+		switch {
+		case token.text == "(":
+			// EXTRACT(YEAR FROM x) の FROM はテーブルではないが、関数内のサブクエリは読む
+			call := i > 0 && tokens[i-1].call && !(i+1 < len(tokens) && isSubqueryStart(tokens[i+1].text))
+			functions = append(functions, call)
+			expectTable = false
+			continue
+		case token.text == ")":
+			delete(lists, depth)
+			if depth > 0 {
+				functions = functions[:depth-1]
+			}
+			continue
+		case token.text == ",":
+			if lists[depth] && !inFunction {
+				expectTable = true
+			}
+			continue
+		case !token.word:
+			expectTable = false
+			continue
+		case inFunction:
+			continue
+		}
+
+		upper := strings.ToUpper(token.text)
+		var previous string
+		if i > 0 {
+			previous = strings.ToUpper(tokens[i-1].text)
+		}
+		if i+2 < len(tokens) && strings.EqualFold(tokens[i+1].text, "AS") && tokens[i+2].text == "(" &&
+			(previous == "WITH" || previous == "RECURSIVE" || previous == ",") {
+			ctes[token.text] = true
+			continue
+		}
+
+		if expectTable {
+			expectTable = false
+			if upper == "ONLY" || upper == "LATERAL" {
+				expectTable = true
+				continue
+			}
+			// FROM generate_series(...) のような関数呼び出しはテーブルではない
+			if !token.call || previous == "INTO" {
+				referenced = append(referenced, token.text)
+			}
+			continue
+		}
+
+		switch upper {
+		case "FROM":
+			// IS DISTINCT FROM は比較演算子
+			if previous != "DISTINCT" {
+				expectTable, lists[depth] = true, true
+			}
+		case "UPDATE":
+			// ON DUPLICATE KEY UPDATE, DO UPDATE, FOR UPDATE は代入やロック句
+			if previous != "KEY" && previous != "DO" && previous != "FOR" {
+				expectTable, lists[depth] = true, true
+			}
+		case "JOIN", "INTO":
+			expectTable = true
+		default:
+			if fromListEnd[upper] {
+				delete(lists, depth)
+			}
+		}
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, table := range referenced {
+		if ctes[table] || seen[table] || strings.Trim(table, "0123456789.") == "" || strings.EqualFold(table, "DUAL") {
+			continue
+		}
+		seen[table] = true
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+func isSubqueryStart(text string) bool {
+	return strings.EqualFold(text, "SELECT") || strings.EqualFold(text, "WITH")
+}
+
+// Tables returns the tables referenced by all operations in order of first appearance
+func (d *Definition) Tables() []string {
+	var tables []string
+	seen := make(map[string]bool)
+
+	for _, op := range d.Operations {
+		referenced := ExtractTables(op.SQL)
+		if op.Anonymize != nil {
+			referenced = []string{op.Anonymize.Table}
+		}
+		// ステージング用の一時テーブルは実行中に作られる
+		if op.Into != "" && !op.Staging {
+			referenced = append(referenced, op.Into)
+		}
+		for _, table := range referenced {
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+
+	return tables
+}
@@ -0,0 +1,286 @@
+package definition
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+var numberPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// templateFuncs are the helpers available in operation SQL templates.
+// renderSQL adds bind, file and the generators, which need per-operation state.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"inList": inList,
+		"lines":  lines,
+	}
+}
+
+// readTemplateFile returns the contents of a file the file template function reads.
+// The name is relative to dir and must stay inside it.
+func readTemplateFile(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("file: %q must be a relative path inside the definition's directory", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("file: %w", err)
+	}
+	return string(data), nil
+}
+
+// lines splits text into a list of its lines for inList or bind.
+// Lines are trimmed, and blank lines and lines starting with # are skipped.
+func lines(text string) []interface{} {
+	var items []interface{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, line)
+	}
+	return items
+}
+
+// fileReferences returns the names given to the file template function in a SQL template, so the
+// files can be part of the definition's checksum. Names that are not literals are an error, since
+// the file they read could not be hashed.
+func fileReferences(sql string) ([]string, error) {
+	tree := parse.New("sql")
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(sql, "", "", map[string]*parse.Tree{}); err != nil || tree.Root == nil {
+		return nil, nil
+	}
+
+	var names []string
+	var invalid bool
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for i, arg := range n.Args {
+				if ident, ok := arg.(*parse.IdentifierNode); ok && ident.Ident == "file" {
+					// パイプで受け取る名前や変数は実行するまで決まらないので、文字列リテラルだけを許す
+					var name *parse.StringNode
+					if i == 0 && len(n.Args) == 2 {
+						name, _ = n.Args[1].(*parse.StringNode)
+					}
+					if name == nil {
+						invalid = true
+					} else {
+						names = append(names, name.Text)
+					}
+				}
+				walk(arg)
+			}
+		}
+	}
+	walk(tree.Root)
+	if invalid {
+		return nil, fmt.Errorf(`file takes a literal file name, such as {{ file "ids.txt" }}, so the file is part of the checksum`)
+	}
+	return names, nil
+}
+
+// checkFileReferences refuses SQL templates that give file anything but a literal name
+func (d *Definition) checkFileReferences() error {
+	for i, derived := range d.DerivedParams {
+		if _, err := fileReferences(derived.SQL); err != nil {
+			return fmt.Errorf("derived_params[%d]: %w", i, err)
+		}
+	}
+	for i, op := range d.Operations {
+		for _, sql := range operationSQL(op) {
+			if _, err := fileReferences(sql); err != nil {
+				return fmt.Errorf("operation[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// inList expands a list into the body of an IN (...) clause.
+// A string is split on commas. Numbers are emitted as-is, quoted literals ('a') are
+// checked for stray quotes, and anything else is quoted with ' doubled.
+func inList(value interface{}) (string, error) {
+	var items []string
+	switch v := value.(type) {
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	case []string:
+		items = v
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, listItem(item))
+		}
+	case int, int64, float64, bool:
+		items = []string{listItem(v)}
+	case nil:
+		return "", fmt.Errorf("inList: value is not set")
+	default:
+		return "", fmt.Errorf("inList: unsupported value type %T", value)
+	}
+
+	if len(items) == 0 || (len(items) == 1 && items[0] == "") {
+		return "", fmt.Errorf("inList: list is empty")
+	}
+
+	literals := make([]string, 0, len(items))
+	for i, item := range items {
+		literal, err := sqlLiteral(item)
+		if err != nil {
+			return "", fmt.Errorf("inList: element %d: %w", i, err)
+		}
+		literals = append(literals, literal)
+	}
+	return strings.Join(literals, ", "), nil
+}
+
+func listItem(value interface{}) string {
+	if b, ok := value.(bool); ok {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return fmt.Sprint(value)
+}
+
+func sqlLiteral(item string) (string, error) {
+	if item == "" {
+		return "", fmt.Errorf("empty element")
+	}
+	// バックスラッシュの扱いはDBごとに異なるため拒否する
+	if strings.ContainsAny(item, "\\\x00") {
+		return "", fmt.Errorf("%q contains a backslash or NUL", item)
+	}
+
+	if numberPattern.MatchString(item) || item == "TRUE" || item == "FALSE" {
+		return item, nil
+	}
+
+	if len(item) >= 2 && strings.HasPrefix(item, "'") && strings.HasSuffix(item, "'") {
+		inner := item[1 : len(item)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return "", fmt.Errorf("%s is not a properly quoted literal", item)
+		}
+		return item, nil
+	}
+
+	return "'" + strings.ReplaceAll(item, "'", "''") + "'", nil
+}
+
+// bindValue returns the placeholders for a value passed to bind and the arguments behind them.
+// Lists expand to one placeholder per element.
+func bindValue(value interface{}) (string, []interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return "", nil, fmt.Errorf("bind: list is empty")
+		}
+		for _, item := range v {
+			if !isScalar(item) {
+				return "", nil, fmt.Errorf("bind: unsupported list element type %T", item)
+			}
+		}
+		return strings.TrimSuffix(strings.Repeat("?, ", len(v)), ", "), v, nil
+	case nil:
+		return "", nil, fmt.Errorf("bind: value is not set")
+	default:
+		if !isScalar(v) {
+			return "", nil, fmt.Errorf("bind: unsupported value type %T", value)
+		}
+		return "?", []interface{}{v}, nil
+	}
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, int, int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+// renderedSQL is an executed SQL template
+type renderedSQL struct {
+	SQL       string
+	Args      []interface{}
+	Generated []GeneratedValue
+}
+
+// renderSQL executes a SQL template, collecting the arguments of bind and the values of the generators.
+// args are the arguments of an operation instantiated from a template, and dir is where file reads from.
+func (d *Definition) renderSQL(name, sql string, args map[string]interface{}, dir string) (*renderedSQL, error) {
+	rendered := &renderedSQL{}
+	funcs := templateFuncs()
+	funcs["file"] = func(name string) (string, error) {
+		return readTemplateFile(dir, name)
+	}
+	funcs["bind"] = func(value interface{}) (string, error) {
+		placeholders, values, err := bindValue(value)
+		if err != nil {
+			return "", err
+		}
+		rendered.Args = append(rendered.Args, values...)
+		return placeholders, nil
+	}
+	for fn, generate := range d.generatorFuncs(func(v GeneratedValue) {
+		rendered.Generated = append(rendered.Generated, v)
+	}) {
+		funcs[fn] = generate
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SQL template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{
+		"params": d.Params,
+		"args":   args,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL template: %w", err)
+	}
+
+	rendered.SQL = buf.String()
+	return rendered, nil
+}
@@ -0,0 +1,269 @@
+package definition
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInList(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		want      string
+		wantError bool
+	}{
+		{name: "numbers", value: "1, 2,3", want: "1, 2, 3"},
+		{name: "bare strings are quoted", value: "alice,o'brien", want: "'alice', 'o''brien'"},
+		{name: "quoted literals kept", value: "'alice','it''s'", want: "'alice', 'it''s'"},
+		{name: "list value", value: []interface{}{1, "bob"}, want: "1, 'bob'"},
+		{name: "injection in quoted literal", value: "'a' OR '1'='1'", wantError: true},
+		{name: "backslash", value: `a\`, wantError: true},
+		{name: "empty", value: "", wantError: true},
+		{name: "empty element", value: "1,,2", wantError: true},
+		{name: "unset", value: nil, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := inList(tt.value)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessTemplatesInList(t *testing.T) {
+	def := &Definition{
+		Params:     map[string]interface{}{"user_ids": "1,2,3"},
+		Operations: []Operation{{ID: "op", SQL: "DELETE FROM users WHERE id IN ({{ inList .params.user_ids }})"}},
+	}
+	if err := def.ProcessTemplates(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "DELETE FROM users WHERE id IN (1, 2, 3)"; def.Operations[0].SQL != want {
+		t.Errorf("got %q, want %q", def.Operations[0].SQL, want)
+	}
+
+	def.Params["user_ids"] = "1); DROP TABLE users; --"
+	def.Operations[0].SQL = "DELETE FROM users WHERE id IN ({{ inList .params.user_ids }})"
+	if err := def.ProcessTemplates(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "DELETE FROM users WHERE id IN ('1); DROP TABLE users; --')"; def.Operations[0].SQL != want {
+		t.Errorf("got %q, want %q", def.Operations[0].SQL, want)
+	}
+}
+
+func TestProcessTemplatesTypedParams(t *testing.T) {
+	var def Definition
+	data := `
+params:
+  user_ids: [1, 2, 3]
+  dry: true
+  name: alice
+operations:
+  - id: op
+    sql: "UPDATE users SET notified = {{ bind .params.dry }} WHERE id IN ({{ bind .params.user_ids }}) AND name = {{ bind .params.name }}"
+  - id: literal
+    sql: "SELECT * FROM users WHERE id IN ({{ inList .params.user_ids }}) AND active = {{ inList .params.dry }}"
+`
+	if err := yaml.Unmarshal([]byte(data), &def); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if err := def.ProcessTemplates(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "UPDATE users SET notified = ? WHERE id IN (?, ?, ?) AND name = ?"; def.Operations[0].SQL != want {
+		t.Errorf("got %q, want %q", def.Operations[0].SQL, want)
+	}
+	wantArgs := []interface{}{true, 1, 2, 3, "alice"}
+	if !reflect.DeepEqual(def.Operations[0].Args, wantArgs) {
+		t.Errorf("got args %#v, want %#v", def.Operations[0].Args, wantArgs)
+	}
+
+	if want := "SELECT * FROM users WHERE id IN (1, 2, 3) AND active = TRUE"; def.Operations[1].SQL != want {
+		t.Errorf("got %q, want %q", def.Operations[1].SQL, want)
+	}
+	if len(def.Operations[1].Args) != 0 {
+		t.Errorf("expected no args, got %#v", def.Operations[1].Args)
+	}
+}
+
+func TestProcessTemplatesGenerators(t *testing.T) {
+	def := &Definition{
+		Operations: []Operation{
+			{ID: "insert", SQL: "INSERT INTO markers (id, token, created_at) VALUES ('{{ uuid \"trace\" }}', '{{ randomString 12 }}', '{{ now }}')"},
+			{ID: "check", SQL: "SELECT * FROM markers WHERE id = '{{ uuid \"trace\" }}' AND created_at = '{{ now }}'"},
+		},
+	}
+	if err := def.ProcessTemplates(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	insert, check := def.Operations[0].Generated, def.Operations[1].Generated
+	if len(insert) != 3 || len(check) != 2 {
+		t.Fatalf("unexpected generated values: %#v / %#v", insert, check)
+	}
+	if insert[0].Function != "uuid" || insert[0].Name != "trace" || len(insert[0].Value) != 36 {
+		t.Errorf("unexpected uuid: %#v", insert[0])
+	}
+	if insert[0].Value != check[0].Value {
+		t.Errorf("named uuid differs between operations: %s != %s", insert[0].Value, check[0].Value)
+	}
+	if len(insert[1].Value) != 12 {
+		t.Errorf("randomString length = %d, want 12", len(insert[1].Value))
+	}
+	if insert[2].Value != check[1].Value {
+		t.Errorf("now differs between operations: %s != %s", insert[2].Value, check[1].Value)
+	}
+	if !strings.Contains(def.Operations[1].SQL, insert[0].Value) {
+		t.Errorf("uuid not rendered into SQL: %s", def.Operations[1].SQL)
+	}
+
+	unnamed := &Definition{Operations: []Operation{{ID: "op", SQL: "SELECT '{{ uuid }}', '{{ uuid }}'"}}}
+	if err := unnamed.ProcessTemplates(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g := unnamed.Operations[0].Generated; g[0].Value == g[1].Value {
+		t.Errorf("unnamed uuids should differ: %s", g[0].Value)
+	}
+}
+
+func TestProcessTemplatesFileLines(t *testing.T) {
+	dir := t.TempDir()
+	idsPath := filepath.Join(dir, "ids.txt")
+	if err := writeTestFile(idsPath, "# users from the ticket\n101\n102\r\n\n103\n"); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "runbook.yaml")
+	content := `version: 1
+operations:
+  - id: check
+    sql: SELECT COUNT(*) AS cnt FROM users WHERE id IN ({{ file "ids.txt" | lines | inList }})
+    expected:
+      - cnt: 3
+  - id: delete
+    sql: DELETE FROM users WHERE id IN ({{ bind (file "ids.txt" | lines) }})
+    expected_changes:
+      delete: 3
+`
+	if err := writeTestFile(configPath, content); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := LoadDefinition(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "SELECT COUNT(*) AS cnt FROM users WHERE id IN (101, 102, 103)"; def.Operations[0].SQL != want {
+		t.Errorf("got %q, want %q", def.Operations[0].SQL, want)
+	}
+	if want := []interface{}{"101", "102", "103"}; !reflect.DeepEqual(def.Operations[1].Args, want) {
+		t.Errorf("args = %v, want %v", def.Operations[1].Args, want)
+	}
+
+	// 読み込むファイルが変わればチェックサムも変わる
+	before, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(idsPath, "101\n102\n104\n"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("checksum should change when a file read by a template changes")
+	}
+
+	outside := strings.ReplaceAll(content, `file "ids.txt"`, `file "../ids.txt"`)
+	if err := writeTestFile(configPath, outside); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDefinition(configPath); err == nil || !strings.Contains(err.Error(), "inside the definition's directory") {
+		t.Errorf("expected an error for a file outside the definition's directory, got %v", err)
+	}
+	if err := os.Remove(idsPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(configPath, content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDefinition(configPath); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFileRequiresLiteralName(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "definition.yaml")
+	if err := writeTestFile(filepath.Join(dir, "ids.txt"), "101\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 実行するまで決まらない名前では、読むファイルをチェックサムに入れられない
+	for _, sql := range []string{
+		`SELECT {{ file .params.ids_file }}`,
+		`SELECT {{ .params.ids_file | file }}`,
+		`SELECT {{ file (printf "%s.txt" "ids") }}`,
+	} {
+		content := "params:\n  ids_file: ids.txt\noperations:\n  - type: select\n    sql: '" + sql + "'\n    expected: []\n"
+		if err := writeTestFile(configPath, content); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadDefinition(configPath); err == nil || !strings.Contains(err.Error(), "file takes a literal file name") {
+			t.Errorf("%s: expected an error for a non-literal name, got %v", sql, err)
+		}
+		if _, err := Checksum([]string{configPath}); err == nil {
+			t.Errorf("%s: expected the checksum to be refused", sql)
+		}
+	}
+}
+
+func TestChecksumCoversFixtures(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "definition.yaml")
+	fixturePath := filepath.Join(dir, "fixtures", "users.sql")
+	if err := os.MkdirAll(filepath.Dir(fixturePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(fixturePath, "INSERT INTO users (id) VALUES (1);\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(configPath, "fixtures:\n  - file: fixtures/users.sql\noperations:\n  - type: select\n    sql: SELECT 1\n    expected: []\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(fixturePath, "INSERT INTO users (id) VALUES (2);\n"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Checksum([]string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("checksum should change when a fixture changes")
+	}
+}
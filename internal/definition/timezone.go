@@ -0,0 +1,93 @@
+package definition
+
+import (
+	"fmt"
+	"time"
+	// 最小構成のコンテナイメージにはタイムゾーンデータがない
+	_ "time/tzdata"
+)
+
+// reportLocation is the time zone of the timestamps in reports and notifications
+var reportLocation = time.UTC
+
+// SetReportLocation sets the time zone reports and notifications render timestamps in (--report-timezone)
+func SetReportLocation(loc *time.Location) {
+	reportLocation = loc
+}
+
+// LoadReportLocation returns the named time zone, such as Asia/Tokyo; empty means UTC
+func LoadReportLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report time zone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// ReportTime returns t in the report time zone
+func ReportTime(t time.Time) time.Time {
+	return t.In(reportLocation)
+}
+
+// FormatReportTime renders t in the report time zone as RFC 3339, with an explicit offset
+func FormatReportTime(t time.Time) string {
+	return ReportTime(t).Format(time.RFC3339)
+}
+
+// LocalizeReports replaces the timestamps read from the database in the reports' results, samples,
+// captured keys and failures with RFC 3339 strings in the report time zone, so every output
+// (JSON, GitHub, Slack, Kafka) shows the same time with its offset
+func LocalizeReports(reports []Report) {
+	for i := range reports {
+		r := &reports[i]
+		r.Expected = localizeValue(r.Expected)
+		r.Result = localizeValue(r.Result)
+		r.Sample = localizeRows(r.Sample)
+		r.CapturedKeys = localizeRows(r.CapturedKeys)
+		if f := r.Failure; f != nil {
+			f.Expected = localizeValue(f.Expected)
+			f.Actual = localizeValue(f.Actual)
+			f.ExpectedRow = localizeRow(f.ExpectedRow)
+			f.ActualRow = localizeRow(f.ActualRow)
+		}
+	}
+}
+
+func localizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return ReportTime(v).Format(time.RFC3339Nano)
+	case map[string]interface{}:
+		return localizeRow(v)
+	case []map[string]interface{}:
+		return localizeRows(v)
+	default:
+		return value
+	}
+}
+
+// localizeRows copies the rows, which may be shared with the definition
+func localizeRows(rows []map[string]interface{}) []map[string]interface{} {
+	if rows == nil {
+		return nil
+	}
+	localized := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		localized[i] = localizeRow(row)
+	}
+	return localized
+}
+
+func localizeRow(row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	localized := make(map[string]interface{}, len(row))
+	for column, value := range row {
+		localized[column] = localizeValue(value)
+	}
+	return localized
+}
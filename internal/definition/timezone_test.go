@@ -0,0 +1,58 @@
+package definition
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLocalizeReports(t *testing.T) {
+	tokyo, err := LoadReportLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetReportLocation(tokyo)
+	defer SetReportLocation(time.UTC)
+
+	createdAt := time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+	expected := []map[string]interface{}{{"id": 1, "created_at": createdAt}}
+	reports := []Report{
+		{
+			ID:       "select",
+			Expected: expected,
+			Result:   []map[string]interface{}{{"id": 1, "created_at": createdAt}},
+			Failure:  &Failure{Code: FailureValueMismatch, Expected: createdAt, ActualRow: map[string]interface{}{"created_at": createdAt}},
+		},
+		{ID: "update", Result: int64(3), Sample: []map[string]interface{}{{"created_at": createdAt}}},
+	}
+
+	LocalizeReports(reports)
+
+	want := "2024-03-02T00:30:00+09:00"
+	if got := reports[0].Result.([]map[string]interface{})[0]["created_at"]; got != want {
+		t.Errorf("result created_at = %v, want %v", got, want)
+	}
+	if got := reports[0].Failure.Expected; got != want {
+		t.Errorf("failure expected = %v, want %v", got, want)
+	}
+	if got := reports[0].Failure.ActualRow["created_at"]; got != want {
+		t.Errorf("failure actual_row = %v, want %v", got, want)
+	}
+	if got := reports[1].Sample[0]["created_at"]; got != want {
+		t.Errorf("sample created_at = %v, want %v", got, want)
+	}
+	if reports[1].Result != int64(3) {
+		t.Errorf("affected rows should be kept, got %v", reports[1].Result)
+	}
+	// 定義のexpectedは書き換えない
+	if !reflect.DeepEqual(expected[0]["created_at"], createdAt) {
+		t.Errorf("the definition's expected rows were modified: %v", expected)
+	}
+	if got := FormatReportTime(createdAt); got != want {
+		t.Errorf("FormatReportTime = %s, want %s", got, want)
+	}
+
+	if _, err := LoadReportLocation("Mars/Olympus"); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+}
@@ -1,11 +1,43 @@
 package definition
 
-import "strings"
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
 
 type Definition struct {
-	Version    int               `yaml:"version"`
-	Params     map[string]string `yaml:"params"`
-	Operations []Operation       `yaml:"operations"`
+	Version int `yaml:"version"`
+	// IDs is how operations without an id are named: index (operation_N) or content
+	IDs           string                 `yaml:"ids,omitempty"`
+	Params        map[string]interface{} `yaml:"params"`
+	ParamSchema   map[string]ParamSpec   `yaml:"param_schema,omitempty"`
+	DerivedParams []DerivedParam         `yaml:"derived_params,omitempty"`
+	Fixtures      []Fixture              `yaml:"fixtures,omitempty"`
+	Mask          []string               `yaml:"mask,omitempty"`
+	// Decoders maps column names or patterns to the decoding applied to query results
+	Decoders     map[string]Decoder `yaml:"decoders,omitempty"`
+	FeatureFlags []FeatureFlag      `yaml:"feature_flags,omitempty"`
+	Operations   []Operation        `yaml:"operations"`
+
+	// generators holds the state of uuid, now and randomString while templates are processed
+	generators generatorState
+	// dir is the directory of the definition file, where derived_params read files from
+	dir string
+	// source is the path of the definition file
+	source string
+	// idOrigins maps the operation IDs of merged definitions to the file that has them, and
+	// merged counts the files merged into the first
+	idOrigins map[string]string
+	merged    int
+}
+
+// Fixture is a SQL or CSV file loaded into the ephemeral database before the operations run (--load-fixtures)
+type Fixture struct {
+	File string `yaml:"file"`
+	// Table is the destination of a CSV fixture; defaults to the file name without extension
+	Table string `yaml:"table,omitempty"`
 }
 
 type Operation struct {
@@ -15,26 +47,140 @@ type Operation struct {
 	SQL             string                   `yaml:"sql"`
 	Expected        []map[string]interface{} `yaml:"expected,omitempty"`
 	ExpectedChanges map[string]int           `yaml:"expected_changes,omitempty"`
+	CaptureKeys     Keys                     `yaml:"capture_keys,omitempty"`
+	// KeyColumns matches expected rows with the result row of the same key instead of by position;
+	// the expected rows may then be a subset of the result in any order
+	KeyColumns Keys `yaml:"key_columns,omitempty"`
+	// Unordered matches expected rows with result rows in any order
+	Unordered bool `yaml:"unordered,omitempty"`
+	// Owner is the team whose notification route receives the operation's failures
+	Owner string `yaml:"owner,omitempty"`
+	// Use instantiates the named operation template with the arguments in With
+	Use  string                 `yaml:"use,omitempty"`
+	With map[string]interface{} `yaml:"with,omitempty"`
+	// Anonymize holds the column rules of a type: anonymize operation, which has no sql
+	Anonymize *Anonymize `yaml:"anonymize,omitempty"`
+	// Secondary is the query a type: cross_check operation compares its results with
+	Secondary *RemoteQuery `yaml:"secondary,omitempty"`
+	// Source is the query whose rows a type: copy operation inserts into the table Into
+	Source *RemoteQuery `yaml:"source,omitempty"`
+	Into   string       `yaml:"into,omitempty"`
+	// File is the CSV a type: import operation loads into Into, or the CSV or JSON file a type: export
+	// operation writes, relative to the definition
+	File string `yaml:"file,omitempty"`
+	// Columns maps CSV header names to table columns; without it every column keeps its name
+	Columns map[string]string `yaml:"columns,omitempty"`
+	// Staging creates Into as a temporary table of text columns before importing
+	Staging bool `yaml:"staging,omitempty"`
+	// Args are the values collected by the bind template function, in placeholder order
+	Args []interface{} `yaml:"-"`
+	// Generated are the values produced by uuid, now and randomString
+	Generated []GeneratedValue `yaml:"-"`
+
+	// dir is where the file template function reads from: the directory of the definition
+	// or of the template the operation comes from
+	dir string
+	// group is the use of a template the operation was instantiated from, when it has several operations
+	group string
+}
+
+// Group returns the ID of the template use the operation is one of the operations of, or ""
+func (o Operation) Group() string {
+	return o.group
 }
 
 type Report struct {
-	ID          string      `json:"id"`
-	Description string      `json:"description"`
-	Type        string      `json:"type"`
-	SQL         string      `json:"sql"`
-	Result      interface{} `json:"result"`
-	Pass        bool        `json:"pass"`
-	Message     string      `json:"message"`
+	ID           string                   `json:"id"`
+	Description  string                   `json:"description"`
+	Type         string                   `json:"type"`
+	SQL          string                   `json:"sql"`
+	Args         []interface{}            `json:"args,omitempty"`
+	Generated    []GeneratedValue         `json:"generated,omitempty"`
+	Expected     interface{}              `json:"expected,omitempty"`
+	Result       interface{}              `json:"result"`
+	Pass         bool                     `json:"pass"`
+	Message      string                   `json:"message"`
+	Failure      *Failure                 `json:"failure,omitempty"`
+	Sample       []map[string]interface{} `json:"sample,omitempty"`
+	CapturedKeys []map[string]interface{} `json:"captured_keys,omitempty"`
+	DurationMS   int64                    `json:"duration_ms"`
+	Warnings     []string                 `json:"warnings,omitempty"`
+	Owner        string                   `json:"owner,omitempty"`
+	File         string                   `json:"file,omitempty"`
+}
+
+// Keys is a list of column names that can also be written as a single name
+type Keys []string
+
+func (k *Keys) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*k = Keys{value.Value}
+		return nil
+	}
+
+	var keys []string
+	if err := value.Decode(&keys); err != nil {
+		return err
+	}
+	*k = keys
+	return nil
+}
+
+// Failure is the machine-readable reason an operation did not pass
+type Failure struct {
+	Code     string      `json:"code"`
+	Row      *int        `json:"row,omitempty"`
+	Column   string      `json:"column,omitempty"`
+	Expected interface{} `json:"expected,omitempty"`
+	Actual   interface{} `json:"actual,omitempty"`
+	// ExpectedRow and ActualRow hold the whole rows involved in a row-level mismatch
+	ExpectedRow map[string]interface{} `json:"expected_row,omitempty"`
+	ActualRow   map[string]interface{} `json:"actual_row,omitempty"`
 }
 
+const (
+	FailureSQLError               = "SQL_ERROR"
+	FailureRowCountMismatch       = "ROW_COUNT_MISMATCH"
+	FailureMissingColumn          = "MISSING_COLUMN"
+	FailureValueMismatch          = "VALUE_MISMATCH"
+	FailureAffectedRowsMismatch   = "AFFECTED_ROWS_MISMATCH"
+	FailureMissingExpectedChange  = "MISSING_EXPECTED_CHANGE"
+	FailureNondeterministicResult = "NONDETERMINISTIC_RESULT"
+	FailureNotAnonymized          = "NOT_ANONYMIZED"
+	FailureExportFailed           = "EXPORT_FAILED"
+	FailureDecodeFailed           = "DECODE_FAILED"
+	FailureMissingRow             = "MISSING_ROW"
+	FailureDuplicateKey           = "DUPLICATE_KEY"
+	FailureTransactionTooOld      = "TRANSACTION_TOO_OLD"
+	FailureUnsafeForBinlog        = "UNSAFE_FOR_BINLOG"
+)
+
 const (
 	TypeSelect = "select"
 	TypeInsert = "insert"
 	TypeUpdate = "update"
 	TypeDelete = "delete"
+	// TypeAnonymize updates the rows matching a condition with column rules and verifies the result
+	TypeAnonymize = "anonymize"
+	// TypeCrossCheck runs a SELECT on the target and on a secondary database and asserts the results match
+	TypeCrossCheck = "cross_check"
+	// TypeCopy inserts the rows of a query on another database into a table of the target
+	TypeCopy = "copy"
+	// TypeImport inserts the rows of a local CSV file into a table of the target
+	TypeImport = "import"
+	// TypeExport writes the rows of a SELECT to a CSV or JSON file next to the definition
+	TypeExport = "export"
 )
 
-var AllowedTypes = []string{TypeSelect, TypeInsert, TypeUpdate, TypeDelete}
+var AllowedTypes = []string{TypeSelect, TypeInsert, TypeUpdate, TypeDelete, TypeAnonymize, TypeCrossCheck, TypeCopy, TypeImport, TypeExport}
+
+// IsQuery reports whether operations of the type report rows rather than affected row counts
+func IsQuery(opType string) bool {
+	return opType == TypeSelect || opType == TypeCrossCheck || opType == TypeExport
+}
+
+// ErrCancelled is returned when a run is cancelled before it finished
+var ErrCancelled = errors.New("run cancelled")
 
 // DetectSQLType SQLクエリから操作タイプを自動判定
 func DetectSQLType(sql string) string {
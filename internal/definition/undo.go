@@ -0,0 +1,133 @@
+package definition
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+// UndoPlan reverts an apply recorded with --record-undo: the statements restoring the rows its
+// operations changed, last operation first, and the operations it cannot revert
+type UndoPlan struct {
+	RunID   string     `json:"run_id"`
+	Steps   []UndoStep `json:"steps"`
+	Skipped []UndoSkip `json:"skipped,omitempty"`
+}
+
+// UndoStep is a statement restoring one row an operation deleted (an insert) or updated (an update)
+type UndoStep struct {
+	OperationID string      `json:"operation_id"`
+	Type        string      `json:"type"`
+	SQL         string      `json:"sql"`
+	Args        []UndoValue `json:"args,omitempty"`
+}
+
+// UndoSkip is an operation of the apply that the undo plan does not revert, and why
+type UndoSkip struct {
+	OperationID string `json:"operation_id"`
+	Reason      string `json:"reason"`
+}
+
+// Definition returns the undo plan as a definition whose operations are the steps, each expected
+// to change exactly one row
+func (p *UndoPlan) Definition() *Definition {
+	def := &Definition{Version: 1, Params: map[string]interface{}{}}
+	counts := make(map[string]int)
+	for _, step := range p.Steps {
+		counts[step.OperationID]++
+		args := make([]interface{}, len(step.Args))
+		for i, arg := range step.Args {
+			args[i] = arg.Value
+		}
+		def.Operations = append(def.Operations, Operation{
+			ID:              fmt.Sprintf("undo.%s.%d", step.OperationID, counts[step.OperationID]),
+			Description:     "revert " + step.OperationID,
+			Type:            step.Type,
+			SQL:             step.SQL,
+			Args:            args,
+			ExpectedChanges: map[string]int{step.Type: 1},
+		})
+	}
+	return def
+}
+
+// UndoValue is a bind argument of an undo statement. Its JSON keeps what plain JSON would lose:
+// the digits of numbers, bytes and times.
+type UndoValue struct {
+	Value interface{}
+}
+
+type undoValueJSON struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+func (v UndoValue) MarshalJSON() ([]byte, error) {
+	var encoded undoValueJSON
+	switch value := v.Value.(type) {
+	case nil:
+		encoded.Type = "null"
+	case string:
+		encoded = undoValueJSON{"string", value}
+	case bool:
+		encoded = undoValueJSON{"bool", strconv.FormatBool(value)}
+	case int64:
+		encoded = undoValueJSON{"number", strconv.FormatInt(value, 10)}
+	case uint64:
+		encoded = undoValueJSON{"number", strconv.FormatUint(value, 10)}
+	case float64:
+		encoded = undoValueJSON{"number", strconv.FormatFloat(value, 'g', -1, 64)}
+	case database.Decimal:
+		encoded = undoValueJSON{"number", string(value)}
+	case database.Binary:
+		encoded = undoValueJSON{"bytes", base64.StdEncoding.EncodeToString(value)}
+	case []byte:
+		encoded = undoValueJSON{"bytes", base64.StdEncoding.EncodeToString(value)}
+	case time.Time:
+		encoded = undoValueJSON{"time", value.Format(time.RFC3339Nano)}
+	default:
+		encoded = undoValueJSON{"string", fmt.Sprint(value)}
+	}
+	return json.Marshal(encoded)
+}
+
+func (v *UndoValue) UnmarshalJSON(data []byte) error {
+	var encoded undoValueJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	switch encoded.Type {
+	case "null":
+		v.Value = nil
+	case "string":
+		v.Value = encoded.Value
+	case "bool":
+		b, err := strconv.ParseBool(encoded.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = b
+	case "number":
+		// 桁を落とさないよう数値は文字列のまま渡し、型変換はデータベースに任せる
+		v.Value = encoded.Value
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(encoded.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = b
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, encoded.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = t
+	default:
+		return fmt.Errorf("unknown undo value type %q", encoded.Type)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// IssueLabel marks the GitHub issues opened for drift
+const IssueLabel = "opsql-drift"
+
+// NewlyFailing returns the reports that fail now but passed, or did not exist, in the previous check.
+// Without a previous check every failing report is new.
+func NewlyFailing(previous, current []definition.Report) []definition.Report {
+	passed := make(map[string]bool, len(previous))
+	for _, report := range previous {
+		passed[report.ID] = report.Pass
+	}
+
+	var newly []definition.Report
+	for _, report := range current {
+		if report.Pass {
+			continue
+		}
+		if wasPassing, exists := passed[report.ID]; exists && !wasPassing {
+			continue
+		}
+		newly = append(newly, report)
+	}
+	return newly
+}
+
+// IssueTitle is the title of the issue tracking drift of a definition in an environment
+func IssueTitle(definitionName, environment string) string {
+	title := "opsql drift: " + definitionName
+	if environment != "" {
+		title += fmt.Sprintf(" [%s]", environment)
+	}
+	return title
+}
+
+// FormatIssue renders the newly failing assertions as an issue body or comment
+func FormatIssue(definitionName, environment string, newly []definition.Report, checkedAt time.Time) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("### %d assertion(s) newly failing\n\n", len(newly)))
+	buf.WriteString(fmt.Sprintf("**Definition:** %s\n", definitionName))
+	if environment != "" {
+		buf.WriteString(fmt.Sprintf("**Environment:** %s\n", environment))
+	}
+	buf.WriteString(fmt.Sprintf("**Checked At:** %s\n\n", definition.FormatReportTime(checkedAt)))
+
+	for _, report := range newly {
+		buf.WriteString(fmt.Sprintf("#### ❌ %s - %s\n", report.ID, report.Description))
+		buf.WriteString(fmt.Sprintf("**Status:** %s\n", report.Message))
+		if report.SQL != "" {
+			buf.WriteString("**Query:**\n```sql\n")
+			buf.WriteString(report.SQL)
+			buf.WriteString("\n```\n")
+		}
+		if diff := formatDiff(report); diff != "" {
+			buf.WriteString("**Diff:**\n```diff\n")
+			buf.WriteString(diff)
+			buf.WriteString("```\n")
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
+
+// formatDiff shows the expected side with - and the actual side with +
+func formatDiff(report definition.Report) string {
+	var expected, actual interface{}
+	switch {
+	case report.Failure != nil && (report.Failure.ExpectedRow != nil || report.Failure.ActualRow != nil):
+		expected, actual = report.Failure.ExpectedRow, report.Failure.ActualRow
+	case report.Failure != nil && (report.Failure.Expected != nil || report.Failure.Actual != nil):
+		expected, actual = report.Failure.Expected, report.Failure.Actual
+	case report.Expected != nil:
+		expected, actual = report.Expected, report.Result
+	default:
+		return ""
+	}
+
+	var buf strings.Builder
+	for _, side := range []struct {
+		prefix string
+		value  interface{}
+	}{{"-", expected}, {"+", actual}} {
+		data, err := json.MarshalIndent(side.value, "", "  ")
+		if err != nil {
+			data = []byte(fmt.Sprint(side.value))
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			buf.WriteString(side.prefix + " " + line + "\n")
+		}
+	}
+	return buf.String()
+}
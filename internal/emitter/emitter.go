@@ -0,0 +1,73 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// Event tells downstream systems (caches, search indexes) that an entity changed
+type Event struct {
+	Environment string                 `json:"environment,omitempty"`
+	Definition  string                 `json:"definition,omitempty"`
+	OperationID string                 `json:"operation_id"`
+	Type        string                 `json:"type"`
+	Table       string                 `json:"table,omitempty"`
+	Keys        map[string]interface{} `json:"keys"`
+	AppliedAt   time.Time              `json:"applied_at"`
+}
+
+// Emitter publishes affected-entity events after a successful apply
+type Emitter interface {
+	Emit(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// Open returns the emitter for a target:
+//
+//	https://..., http://...                  webhook receiving {"events": [...]} as JSON
+//	sqs://<queue URL without https://>       SQS queue, one message per event
+//	kafka://broker1:9092,broker2:9092/topic  Kafka topic, one message per event
+func Open(ctx context.Context, target string) (Emitter, error) {
+	switch {
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return NewWebhook(target), nil
+	case strings.HasPrefix(target, "sqs://"):
+		return NewSQS(ctx, "https://"+strings.TrimPrefix(target, "sqs://"))
+	case strings.HasPrefix(target, "kafka://"):
+		brokers, topic, _ := strings.Cut(strings.TrimPrefix(target, "kafka://"), "/")
+		if brokers == "" || topic == "" {
+			return nil, fmt.Errorf("invalid Kafka emit target: %s (expected kafka://broker:9092/topic)", target)
+		}
+		return NewKafka(strings.Split(brokers, ","), topic)
+	default:
+		return nil, fmt.Errorf("unsupported emit target: %s (expected https://..., sqs://... or kafka://...)", target)
+	}
+}
+
+// Events builds one event per captured key of the reports (capture_keys)
+func Events(reports []definition.Report, environment, definitionName string, appliedAt time.Time) []Event {
+	var events []Event
+	for _, report := range reports {
+		table := ""
+		if tables := definition.ExtractTables(report.SQL); len(tables) > 0 {
+			table = tables[0]
+		}
+
+		for _, keys := range report.CapturedKeys {
+			events = append(events, Event{
+				Environment: environment,
+				Definition:  definitionName,
+				OperationID: report.ID,
+				Type:        report.Type,
+				Table:       table,
+				Keys:        keys,
+				AppliedAt:   definition.ReportTime(appliedAt),
+			})
+		}
+	}
+	return events
+}
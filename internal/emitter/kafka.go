@@ -0,0 +1,48 @@
+package emitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/kafka"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Kafka publishes one message per event, keyed by table so events of an entity stay ordered.
+// TLS and SASL are configured like the Kafka notifier (OPSQL_KAFKA_*).
+type Kafka struct {
+	writer *kafkago.Writer
+}
+
+func NewKafka(brokers []string, topic string) (*Kafka, error) {
+	writer, err := kafka.NewWriter(brokers, topic)
+	if err != nil {
+		return nil, err
+	}
+	return &Kafka{writer: writer}, nil
+}
+
+func (k *Kafka) Emit(ctx context.Context, events []Event) error {
+	messages := make([]kafkago.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		key, err := json.Marshal(map[string]interface{}{"table": event.Table, "keys": event.Keys})
+		if err != nil {
+			return fmt.Errorf("failed to marshal event key: %w", err)
+		}
+		messages = append(messages, kafkago.Message{Key: key, Value: value})
+	}
+
+	if err := k.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to send events: %w", err)
+	}
+	return nil
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
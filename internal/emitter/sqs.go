@@ -0,0 +1,69 @@
+package emitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsBatchSize is the SendMessageBatch limit
+const sqsBatchSize = 10
+
+// SQS sends one message per event to a queue.
+// Credentials and region come from the standard AWS environment.
+type SQS struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func NewSQS(ctx context.Context, queueURL string) (*SQS, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return &SQS{
+		client:   sqs.NewFromConfig(cfg),
+		queueURL: queueURL,
+	}, nil
+}
+
+func (s *SQS) Emit(ctx context.Context, events []Event) error {
+	for start := 0; start < len(events); start += sqsBatchSize {
+		end := min(start+sqsBatchSize, len(events))
+
+		entries := make([]types.SendMessageBatchRequestEntry, 0, end-start)
+		for i, event := range events[start:end] {
+			body, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to marshal event: %w", err)
+			}
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+			})
+		}
+
+		out, err := s.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(s.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send events: %w", err)
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("failed to send %d events: %s", len(out.Failed), aws.ToString(out.Failed[0].Message))
+		}
+	}
+	return nil
+}
+
+func (s *SQS) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package ephemeral
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	databaseName = "opsql"
+	password     = "opsql"
+)
+
+// Database is a throwaway database running in a container
+type Database struct {
+	DSN       string
+	container testcontainers.Container
+}
+
+type engine struct {
+	port   nat.Port
+	driver string
+	env    map[string]string
+	dsn    func(host string, port nat.Port) string
+}
+
+var engines = map[string]engine{
+	"mysql": {
+		port:   "3306/tcp",
+		driver: "mysql",
+		env: map[string]string{
+			"MYSQL_ROOT_PASSWORD": password,
+			"MYSQL_DATABASE":      databaseName,
+		},
+		dsn: func(host string, port nat.Port) string {
+			return fmt.Sprintf("root:%s@tcp(%s:%s)/%s?parseTime=true", password, host, port.Port(), databaseName)
+		},
+	},
+	"postgres": {
+		port:   "5432/tcp",
+		driver: "postgres",
+		env: map[string]string{
+			"POSTGRES_PASSWORD": password,
+			"POSTGRES_DB":       databaseName,
+		},
+		dsn: func(host string, port nat.Port) string {
+			return fmt.Sprintf("postgres://postgres:%s@%s:%s/%s?sslmode=disable", password, host, port.Port(), databaseName)
+		},
+	},
+}
+
+// Start runs the given database image (e.g. mysql:8, postgres:16) and waits until it accepts queries
+func Start(ctx context.Context, image string) (*Database, error) {
+	e, err := detectEngine(image)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			Env:          e.env,
+			ExposedPorts: []string{string(e.port)},
+			WaitingFor:   wait.ForSQL(e.port, e.driver, e.dsn).WithStartupTimeout(3 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		if container != nil {
+			_ = testcontainers.TerminateContainer(container)
+		}
+		return nil, fmt.Errorf("failed to start container %s: %w", image, err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		_ = testcontainers.TerminateContainer(container)
+		return nil, fmt.Errorf("failed to get container host: %w", err)
+	}
+
+	port, err := container.MappedPort(ctx, e.port)
+	if err != nil {
+		_ = testcontainers.TerminateContainer(container)
+		return nil, fmt.Errorf("failed to get container port: %w", err)
+	}
+
+	return &Database{
+		DSN:       e.dsn(host, port),
+		container: container,
+	}, nil
+}
+
+// Terminate stops and removes the container
+func (d *Database) Terminate() error {
+	return testcontainers.TerminateContainer(d.container)
+}
+
+func detectEngine(image string) (engine, error) {
+	name := image
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+
+	switch name {
+	case "mysql", "mariadb":
+		return engines["mysql"], nil
+	case "postgres", "postgis":
+		return engines["postgres"], nil
+	}
+	return engine{}, fmt.Errorf("unsupported ephemeral database image: %s (supported: mysql, mariadb, postgres)", image)
+}
@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// executeAnonymize applies the column rules of an anonymize operation with a generated UPDATE,
+// then checks with a SELECT that no matching row still holds a value the rules do not allow
+func (e *BaseExecutor) executeAnonymize(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	driver := e.db.DriverName()
+	update, updateArgs, verify, verifyArgs := anonymizeStatements(driver, op)
+
+	dml := op
+	dml.SQL = update
+	dml.Args = updateArgs
+	report, err := e.executeDML(ctx, tx, def, dml)
+	if report != nil {
+		report.SQL = update + ";\n" + verify
+	}
+	if err != nil || !report.Pass {
+		return report, err
+	}
+
+	if len(verifyArgs) > 0 {
+		verify = database.Rebind(driver, verify)
+	}
+	rows, err := tx.QueryRowsContext(ctx, verify, verifyArgs...)
+	if err == nil && len(rows) != 1 {
+		err = fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+	if err != nil {
+		report.Pass = false
+		report.Message = fmt.Sprintf("verification failed: %v", err)
+		report.Failure = &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()}
+		return report, nil
+	}
+
+	for _, column := range op.Anonymize.SortedColumns() {
+		remaining := rows[0][column]
+		if fmt.Sprint(remaining) != "0" {
+			report.Pass = false
+			report.Message = fmt.Sprintf("verification failed: %v row(s) of column '%s' are not anonymized by the %s rule", remaining, column, op.Anonymize.Columns[column].Kind)
+			report.Failure = &definition.Failure{
+				Code:     definition.FailureNotAnonymized,
+				Column:   column,
+				Expected: 0,
+				Actual:   remaining,
+			}
+			return report, nil
+		}
+	}
+
+	return report, nil
+}
+
+// anonymizeStatements builds the UPDATE of an anonymize operation and the SELECT counting,
+// for each column, the matching rows its rule does not hold for
+func anonymizeStatements(driver string, op definition.Operation) (string, []interface{}, string, []interface{}) {
+	a := op.Anonymize
+
+	var sets, checks []string
+	var updateArgs, verifyArgs []interface{}
+	for _, column := range a.SortedColumns() {
+		rule := a.Columns[column]
+		quoted := database.QuoteIdentifier(driver, column)
+
+		var violated string
+		switch rule.Kind {
+		case definition.AnonymizeHash:
+			sets = append(sets, quoted+" = "+hashExpression(driver, quoted))
+			// SHA-256の16進表記は64文字
+			violated = fmt.Sprintf("%s IS NOT NULL AND LENGTH(%s) <> 64", quoted, quoted)
+		case definition.AnonymizeFake:
+			sets = append(sets, quoted+" = ?")
+			updateArgs = append(updateArgs, rule.Value)
+			violated = fmt.Sprintf("%s IS NULL OR %s <> ?", quoted, quoted)
+			verifyArgs = append(verifyArgs, rule.Value)
+		default:
+			sets = append(sets, quoted+" = NULL")
+			violated = quoted + " IS NOT NULL"
+		}
+		checks = append(checks, fmt.Sprintf("COUNT(CASE WHEN %s THEN 1 END) AS %s", violated, quoted))
+	}
+
+	table := database.QuoteIdentifier(driver, a.Table)
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), a.Where)
+	verify := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(checks, ", "), table, a.Where)
+
+	// whereのbind引数はどちらの文でも最後に来る
+	updateArgs = append(updateArgs, op.Args...)
+	verifyArgs = append(verifyArgs, op.Args...)
+	return update, updateArgs, verify, verifyArgs
+}
+
+// hashExpression returns the SHA-256 hex digest of column for the driver
+func hashExpression(driver, column string) string {
+	if driver == "postgres" {
+		return fmt.Sprintf("encode(sha256(convert_to(%s::text, 'UTF8')), 'hex')", column)
+	}
+	return fmt.Sprintf("SHA2(%s, 256)", column)
+}
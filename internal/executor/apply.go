@@ -12,22 +12,29 @@ type ApplyExecutor struct {
 	*BaseExecutor
 }
 
-func NewApplyExecutor(db database.DB) *ApplyExecutor {
+func NewApplyExecutor(db database.DB, opts ...Option) *ApplyExecutor {
 	return &ApplyExecutor{
-		BaseExecutor: NewBaseExecutor(db),
+		BaseExecutor: NewBaseExecutor(db, opts...),
 	}
 }
 
 func (e *ApplyExecutor) Execute(ctx context.Context, def *definition.Definition) ([]definition.Report, error) {
-	tx, err := e.db.BeginTransaction(ctx)
+	tx, err := e.beginTransaction(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	ctx, stop := e.monitorTransaction(ctx, tx)
+	defer stop()
 
 	var reports []definition.Report
 
-	for _, op := range def.Operations {
-		report, err := e.executeOperation(ctx, tx, op)
+	for i, op := range def.Operations {
+		if err := e.checkCancelled(op); err != nil {
+			_ = tx.Rollback()
+			return reports, err
+		}
+
+		report, err := e.executeOperation(ctx, tx, def, i, op)
 		if report != nil {
 			reports = append(reports, *report)
 		}
@@ -42,6 +49,16 @@ func (e *ApplyExecutor) Execute(ctx context.Context, def *definition.Definition)
 		}
 	}
 
+	if err := txAgeExceeded(ctx); err != nil {
+		_ = tx.Rollback()
+		return reports, err
+	}
+
+	if err := e.notify(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return reports, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
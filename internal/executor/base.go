@@ -2,47 +2,196 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/pyama86/opsql/internal/database"
 	"github.com/pyama86/opsql/internal/definition"
 )
 
 type BaseExecutor struct {
-	db database.DB
+	db         database.DB
+	sampleRows int
+	repeat     int
+	progress   ProgressFunc
+	cancel     <-chan struct{}
+	databases  func(name string) (database.DB, error)
+	// dryRun keeps operations from leaving effects outside the database, such as export files
+	dryRun bool
+	// readOnly begins the transaction read-only where the database supports it
+	readOnly bool
+	// undo receives the statements reverting the applied operations
+	undo *definition.UndoPlan
+	// txInterval, maxTxAge and txMonitor watch the transaction of long runs
+	txInterval time.Duration
+	maxTxAge   time.Duration
+	txMonitor  TxMonitorFunc
+	// unsafeStatementCheck fails DML that MySQL warns is unsafe for statement-based replication
+	unsafeStatementCheck bool
+	// notifyChannel receives notifyPayload when an apply commits
+	notifyChannel string
+	notifyPayload string
+	// shadowRowLimit is the most rows the shadow executor copies from a table
+	shadowRowLimit int
 }
 
-func NewBaseExecutor(db database.DB) *BaseExecutor {
-	return &BaseExecutor{db: db}
+// Option configures an executor
+type Option func(*BaseExecutor)
+
+// WithSampleRows makes DML reports include up to n of the rows the operation touched
+func WithSampleRows(n int) Option {
+	return func(e *BaseExecutor) {
+		e.sampleRows = n
+	}
+}
+
+// WithRepeat makes the plan executor run the definition n times and fail when results differ
+func WithRepeat(n int) Option {
+	return func(e *BaseExecutor) {
+		e.repeat = n
+	}
+}
+
+// WithCancel stops the execution before the next operation once cancel is closed;
+// the transaction is rolled back and definition.ErrCancelled returned
+func WithCancel(cancel <-chan struct{}) Option {
+	return func(e *BaseExecutor) {
+		e.cancel = cancel
+	}
 }
 
-func (e *BaseExecutor) executeOperation(ctx context.Context, tx database.Transaction, op definition.Operation) (*definition.Report, error) {
+// WithDatabases opens the databases other than the target that operations refer to by name
+func WithDatabases(open func(name string) (database.DB, error)) Option {
+	return func(e *BaseExecutor) {
+		e.databases = open
+	}
+}
+
+// WithReadOnly begins the run's transaction read-only, so the database itself rejects writes
+func WithReadOnly() Option {
+	return func(e *BaseExecutor) {
+		e.readOnly = true
+	}
+}
+
+func NewBaseExecutor(db database.DB, opts ...Option) *BaseExecutor {
+	e := &BaseExecutor{db: db}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// beginTransaction begins the transaction operations run in
+func (e *BaseExecutor) beginTransaction(ctx context.Context) (database.Transaction, error) {
+	if e.readOnly {
+		if beginner, ok := e.db.(database.ReadOnlyBeginner); ok {
+			return beginner.BeginReadOnlyTransaction(ctx)
+		}
+	}
+	return e.db.BeginTransaction(ctx)
+}
+
+// checkCancelled returns an error wrapping definition.ErrCancelled once the run was cancelled
+func (e *BaseExecutor) checkCancelled(op definition.Operation) error {
+	select {
+	case <-e.cancel:
+		return fmt.Errorf("cancelled before operation[%s]: %w", op.ID, definition.ErrCancelled)
+	default:
+		return nil
+	}
+}
+
+func (e *BaseExecutor) executeOperation(ctx context.Context, tx database.Transaction, def *definition.Definition, index int, op definition.Operation) (*definition.Report, error) {
+	e.operationStarted(def, index, op)
+	startedAt := time.Now()
+
+	var finishUndo func()
+	if e.undo != nil && !e.dryRun {
+		finishUndo = e.recordUndo(ctx, tx, op)
+	}
+
+	var report *definition.Report
+	var err error
 	switch op.Type {
 	case definition.TypeSelect:
-		return e.executeSelect(ctx, tx, op)
+		report, err = e.executeSelect(ctx, tx, def, op)
 	case definition.TypeInsert, definition.TypeUpdate, definition.TypeDelete:
-		return e.executeDML(ctx, tx, op)
+		report, err = e.executeDML(ctx, tx, def, op)
+	case definition.TypeAnonymize:
+		report, err = e.executeAnonymize(ctx, tx, def, op)
+	case definition.TypeCrossCheck:
+		report, err = e.executeCrossCheck(ctx, tx, def, op)
+	case definition.TypeCopy:
+		report, err = e.executeCopy(ctx, tx, def, op)
+	case definition.TypeImport:
+		report, err = e.executeImport(ctx, tx, def, op)
+	case definition.TypeExport:
+		report, err = e.executeExport(ctx, tx, def, op)
 	default:
-		return nil, fmt.Errorf("unsupported operation type: %s", op.Type)
+		err = fmt.Errorf("unsupported operation type: %s", op.Type)
 	}
+
+	if finishUndo != nil && err == nil {
+		finishUndo()
+	}
+
+	if tooOld := txAgeExceeded(ctx); tooOld != nil && (err != nil || (report != nil && !report.Pass)) {
+		if report != nil {
+			failTooOld(report, tooOld)
+		}
+		err = tooOld
+	}
+
+	if report != nil {
+		report.DurationMS = time.Since(startedAt).Milliseconds()
+		report.Owner = op.Owner
+		// レポートは通知や履歴に出ていくので、行を持つすべての項目をここでマスクする
+		def.MaskReport(report)
+	}
+	e.operationFinished(def, index, op, report, err)
+	return report, err
 }
 
-func (e *BaseExecutor) executeSelect(ctx context.Context, tx database.Transaction, op definition.Operation) (*definition.Report, error) {
-	rows, err := tx.QueryRowsContext(ctx, op.SQL)
+func (e *BaseExecutor) executeSelect(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	rows, err := tx.QueryRowsContext(ctx, e.query(op), op.Args...)
+	if err == nil {
+		rows, err = def.DecodeRows(rows)
+		if err != nil {
+			return &definition.Report{
+				ID:          op.ID,
+				Description: op.Description,
+				Type:        op.Type,
+				SQL:         op.SQL,
+				Args:        op.Args,
+				Generated:   op.Generated,
+				Expected:    op.Expected,
+				Message:     fmt.Sprintf("decode failed: %v", err),
+				Failure:     &definition.Failure{Code: definition.FailureDecodeFailed, Actual: err.Error()},
+			}, nil
+		}
+	}
 	if err != nil {
 		return &definition.Report{
 			ID:          op.ID,
 			Description: op.Description,
 			Type:        op.Type,
 			SQL:         op.SQL,
+			Args:        op.Args,
+			Generated:   op.Generated,
+			Expected:    op.Expected,
 			Result:      nil,
 			Pass:        false,
 			Message:     fmt.Sprintf("query failed: %v", err),
+			Failure:     &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()},
 		}, nil
 	}
 
-	pass, message := e.validateSelectResult(rows, op.Expected)
-	if !pass {
+	message, failure := e.validateExpectedRows(rows, op)
+	if failure != nil {
 		err = fmt.Errorf("assertion failed: %s", message)
 	}
 
@@ -51,74 +200,332 @@ func (e *BaseExecutor) executeSelect(ctx context.Context, tx database.Transactio
 		Description: op.Description,
 		Type:        op.Type,
 		SQL:         op.SQL,
+		Args:        op.Args,
+		Generated:   op.Generated,
+		Expected:    op.Expected,
 		Result:      rows,
-		Pass:        pass,
+		Pass:        failure == nil,
 		Message:     message,
+		Failure:     failure,
 	}, err
 }
 
-func (e *BaseExecutor) executeDML(ctx context.Context, tx database.Transaction, op definition.Operation) (*definition.Report, error) {
-	affected, err := tx.ExecContext(ctx, op.SQL)
+func (e *BaseExecutor) executeDML(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	var sample []map[string]interface{}
+	// 匿名化前の行は消したい個人情報そのものなのでレポートに残さない
+	if e.sampleRows > 0 && op.Type != definition.TypeAnonymize {
+		sample = e.sampleAffectedRows(ctx, tx, op)
+	}
+
+	var capturedKeys []map[string]interface{}
+	if len(op.CaptureKeys) > 0 {
+		keys, err := e.captureKeys(ctx, tx, op)
+		if err != nil {
+			return &definition.Report{
+				ID:          op.ID,
+				Description: op.Description,
+				Type:        op.Type,
+				SQL:         op.SQL,
+				Args:        op.Args,
+				Generated:   op.Generated,
+				Expected:    op.ExpectedChanges,
+				Pass:        false,
+				Message:     err.Error(),
+				Failure:     &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()},
+			}, nil
+		}
+		capturedKeys = keys
+	}
+
+	affected, err := tx.ExecContext(ctx, e.query(op), op.Args...)
 	if err != nil {
 		return &definition.Report{
 			ID:          op.ID,
 			Description: op.Description,
 			Type:        op.Type,
 			SQL:         op.SQL,
+			Args:        op.Args,
+			Generated:   op.Generated,
+			Expected:    op.ExpectedChanges,
 			Result:      nil,
 			Pass:        false,
 			Message:     fmt.Sprintf("execution failed: %v", err),
+			Failure:     &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()},
 		}, nil
 	}
 
-	pass, message := e.validateDMLResult(affected, op.ExpectedChanges, op.Type)
+	changeType := op.Type
+	if changeType == definition.TypeAnonymize {
+		changeType = definition.TypeUpdate
+	}
+	message, failure := e.validateDMLResult(affected, op.ExpectedChanges, changeType)
+	if failure == nil {
+		if unsafe, unsafeFailure := e.unsafeStatementFailure(ctx, tx); unsafeFailure != nil {
+			message, failure = unsafe, unsafeFailure
+		}
+	}
 
 	return &definition.Report{
-		ID:          op.ID,
-		Description: op.Description,
-		Type:        op.Type,
-		SQL:         op.SQL,
-		Result:      affected,
-		Pass:        pass,
-		Message:     message,
+		ID:           op.ID,
+		Description:  op.Description,
+		Type:         op.Type,
+		SQL:          op.SQL,
+		Args:         op.Args,
+		Generated:    op.Generated,
+		Expected:     op.ExpectedChanges,
+		Result:       affected,
+		Pass:         failure == nil,
+		Message:      message,
+		Failure:      failure,
+		Sample:       sample,
+		CapturedKeys: capturedKeys,
 	}, nil
 }
 
-func (e *BaseExecutor) validateSelectResult(actual []map[string]interface{}, expected []map[string]interface{}) (bool, string) {
+// errNotDerivable means no SELECT of the affected rows can be derived from the statement (e.g. INSERT ... VALUES)
+var errNotDerivable = errors.New("cannot derive the affected rows from the statement")
+
+// sampleAffectedRows reads some of the rows a DML operation is about to touch
+func (e *BaseExecutor) sampleAffectedRows(ctx context.Context, tx database.Transaction, op definition.Operation) []map[string]interface{} {
+	rows, err := e.selectAffectedRows(ctx, tx, op, "*", e.sampleRows)
+	if errors.Is(err, errNotDerivable) {
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: operation[%s]: failed to sample affected rows: %v\n", op.ID, err)
+		return nil
+	}
+
+	if len(rows) > e.sampleRows {
+		rows = rows[:e.sampleRows]
+	}
+	return rows
+}
+
+// captureKeys reads the capture_keys columns of every row a DML operation is about to touch
+func (e *BaseExecutor) captureKeys(ctx context.Context, tx database.Transaction, op definition.Operation) ([]map[string]interface{}, error) {
+	columns := make([]string, 0, len(op.CaptureKeys))
+	for _, key := range op.CaptureKeys {
+		columns = append(columns, database.QuoteIdentifier(e.db.DriverName(), key))
+	}
+
+	rows, err := e.selectAffectedRows(ctx, tx, op, strings.Join(columns, ", "), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture keys: %w", err)
+	}
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	return rows, nil
+}
+
+// selectAffectedRows runs a SELECT of columns over the rows a DML operation is about to touch.
+// A savepoint keeps a failing query from aborting the surrounding transaction.
+func (e *BaseExecutor) selectAffectedRows(ctx context.Context, tx database.Transaction, op definition.Operation, columns string, limit int) ([]map[string]interface{}, error) {
+	query, args, ok := affectedRowsQuery(op.SQL, op.Args, columns, limit)
+	if !ok {
+		return nil, errNotDerivable
+	}
+	if len(args) > 0 {
+		query = database.Rebind(e.db.DriverName(), query)
+	}
+	return queryInSavepoint(ctx, tx, query, args...)
+}
+
+// queryInSavepoint runs a query of opsql's own in a savepoint, so that its failure does not
+// abort the transaction of the operations
+func queryInSavepoint(ctx context.Context, tx database.Transaction, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT opsql_sample"); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT opsql_sample")
+		return nil, err
+	}
+	_, _ = tx.ExecContext(ctx, "RELEASE SAVEPOINT opsql_sample")
+
+	return rows, nil
+}
+
+// query returns the operation's SQL with placeholders for the driver
+func (e *BaseExecutor) query(op definition.Operation) string {
+	if len(op.Args) == 0 {
+		return op.SQL
+	}
+	return database.Rebind(e.db.DriverName(), op.SQL)
+}
+
+// validateExpectedRows returns a nil failure when the rows of a query operation match its expected
+// rows, compared by position or as its key_columns or unordered say
+func (e *BaseExecutor) validateExpectedRows(actual []map[string]interface{}, op definition.Operation) (string, *definition.Failure) {
+	switch {
+	case len(op.KeyColumns) > 0:
+		return validateKeyedRows(actual, op.Expected, op.KeyColumns)
+	case op.Unordered:
+		return validateUnorderedRows(actual, op.Expected)
+	}
+	return e.validateSelectResult(actual, op.Expected)
+}
+
+// validateSelectResult returns a nil failure when the rows match the expectation row by row
+func (e *BaseExecutor) validateSelectResult(actual []map[string]interface{}, expected []map[string]interface{}) (string, *definition.Failure) {
 	if len(actual) != len(expected) {
-		return false, fmt.Sprintf("row count mismatch: expected %d, got %d", len(expected), len(actual))
+		return fmt.Sprintf("row count mismatch: expected %d, got %d", len(expected), len(actual)), &definition.Failure{
+			Code:     definition.FailureRowCountMismatch,
+			Expected: len(expected),
+			Actual:   len(actual),
+		}
 	}
 
 	for i, expectedRow := range expected {
-		if i >= len(actual) {
-			return false, fmt.Sprintf("missing row at index %d", i)
+		if message, failure := validateRow(fmt.Sprintf("row %d", i), i, actual[i], expectedRow); failure != nil {
+			return message, failure
+		}
+	}
+
+	return "assertion passed", nil
+}
+
+// validateKeyedRows looks up the result row of each expected row by its key columns; result rows
+// that no expected row names are not checked
+func validateKeyedRows(actual []map[string]interface{}, expected []map[string]interface{}, keyColumns []string) (string, *definition.Failure) {
+	for i, expectedRow := range expected {
+		keys := make([]string, len(keyColumns))
+		for j, column := range keyColumns {
+			keys[j] = fmt.Sprintf("%s=%v", column, expectedRow[column])
+		}
+		key := strings.Join(keys, ", ")
+
+		var matches []map[string]interface{}
+		for _, actualRow := range actual {
+			if rowHasKey(actualRow, expectedRow, keyColumns) {
+				matches = append(matches, actualRow)
+			}
 		}
 
-		actualRow := actual[i]
-		for key, expectedValue := range expectedRow {
-			actualValue, exists := actualRow[key]
-			if !exists {
-				return false, fmt.Sprintf("missing column '%s' in row %d", key, i)
+		row := i
+		switch len(matches) {
+		case 0:
+			return fmt.Sprintf("no row with %s", key), &definition.Failure{
+				Code:        definition.FailureMissingRow,
+				Row:         &row,
+				Expected:    key,
+				ExpectedRow: expectedRow,
+			}
+		case 1:
+		default:
+			return fmt.Sprintf("%d rows with %s: key_columns must identify a single row", len(matches), key), &definition.Failure{
+				Code:        definition.FailureDuplicateKey,
+				Row:         &row,
+				Expected:    key,
+				Actual:      len(matches),
+				ExpectedRow: expectedRow,
 			}
+		}
+
+		if message, failure := validateRow("row with "+key, i, matches[0], expectedRow); failure != nil {
+			return message, failure
+		}
+	}
+
+	return "assertion passed", nil
+}
 
-			if !compareValues(actualValue, expectedValue) {
-				return false, fmt.Sprintf("value mismatch in row %d, column '%s': expected %v, got %v", i, key, expectedValue, actualValue)
+// validateUnorderedRows matches each expected row with the first result row not matched yet that
+// has all of its values
+func validateUnorderedRows(actual []map[string]interface{}, expected []map[string]interface{}) (string, *definition.Failure) {
+	if len(actual) != len(expected) {
+		return fmt.Sprintf("row count mismatch: expected %d, got %d", len(expected), len(actual)), &definition.Failure{
+			Code:     definition.FailureRowCountMismatch,
+			Expected: len(expected),
+			Actual:   len(actual),
+		}
+	}
+
+	matched := make([]bool, len(actual))
+	for i, expectedRow := range expected {
+		found := false
+		for j, actualRow := range actual {
+			if matched[j] {
+				continue
+			}
+			if _, failure := validateRow("", i, actualRow, expectedRow); failure == nil {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			row := i
+			return fmt.Sprintf("no row matches expected row %d", i), &definition.Failure{
+				Code:        definition.FailureMissingRow,
+				Row:         &row,
+				ExpectedRow: expectedRow,
 			}
 		}
 	}
 
-	return true, "assertion passed"
+	return "assertion passed", nil
+}
+
+func rowHasKey(actualRow, expectedRow map[string]interface{}, keyColumns []string) bool {
+	for _, column := range keyColumns {
+		value, exists := actualRow[column]
+		if !exists || !compareValues(value, expectedRow[column]) {
+			return false
+		}
+	}
+	return true
 }
 
-func (e *BaseExecutor) validateDMLResult(actual int64, expected map[string]int, opType string) (bool, string) {
+// validateRow compares the columns of an expected row; name is how messages refer to the row
+// and row its index in the expectation
+func validateRow(name string, row int, actualRow, expectedRow map[string]interface{}) (string, *definition.Failure) {
+	for key, expectedValue := range expectedRow {
+		actualValue, exists := actualRow[key]
+		if !exists {
+			return fmt.Sprintf("missing column '%s' in %s", key, name), &definition.Failure{
+				Code:        definition.FailureMissingColumn,
+				Row:         &row,
+				Column:      key,
+				ExpectedRow: expectedRow,
+				ActualRow:   actualRow,
+			}
+		}
+
+		if !compareValues(actualValue, expectedValue) {
+			return fmt.Sprintf("value mismatch in %s, column '%s': expected %v, got %v", name, key, expectedValue, actualValue), &definition.Failure{
+				Code:        definition.FailureValueMismatch,
+				Row:         &row,
+				Column:      key,
+				Expected:    expectedValue,
+				Actual:      actualValue,
+				ExpectedRow: expectedRow,
+				ActualRow:   actualRow,
+			}
+		}
+	}
+	return "", nil
+}
+
+// validateDMLResult returns a nil failure when the affected row count matches the expectation
+func (e *BaseExecutor) validateDMLResult(actual int64, expected map[string]int, opType string) (string, *definition.Failure) {
 	expectedCount, exists := expected[opType]
 	if !exists {
-		return false, fmt.Sprintf("no expected count specified for operation type '%s'", opType)
+		return fmt.Sprintf("no expected count specified for operation type '%s'", opType), &definition.Failure{
+			Code: definition.FailureMissingExpectedChange,
+		}
 	}
 
 	if actual != int64(expectedCount) {
-		return false, fmt.Sprintf("affected rows mismatch: expected %d, got %d", expectedCount, actual)
+		return fmt.Sprintf("affected rows mismatch: expected %d, got %d", expectedCount, actual), &definition.Failure{
+			Code:     definition.FailureAffectedRowsMismatch,
+			Expected: expectedCount,
+			Actual:   actual,
+		}
 	}
 
-	return true, "assertion passed"
+	return "assertion passed", nil
 }
@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// WithUnsafeStatementCheck makes DML operations fail when MySQL warns that they are unsafe for
+// statement-based replication, for sessions whose binlog_format is STATEMENT
+func WithUnsafeStatementCheck() Option {
+	return func(e *BaseExecutor) {
+		e.unsafeStatementCheck = true
+	}
+}
+
+// unsafeStatementFailure returns the failure of a statement MySQL has just warned is unsafe to
+// log as a statement, or nil
+func (e *BaseExecutor) unsafeStatementFailure(ctx context.Context, tx database.Transaction) (string, *definition.Failure) {
+	if !e.unsafeStatementCheck {
+		return "", nil
+	}
+	warnings, err := tx.QueryRowsContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return "", nil
+	}
+	for _, warning := range warnings {
+		if fmt.Sprint(warning["Code"]) != database.ErUnsafeStatement {
+			continue
+		}
+		message := fmt.Sprintf("unsafe for statement-based replication, replicas could end up with different rows: %v. "+
+			"Make the statement deterministic (ORDER BY a unique key with LIMIT, no UUID(), RAND(), SYSDATE() or similar) "+
+			"or use binlog_format ROW for the session", warning["Message"])
+		return message, &definition.Failure{Code: definition.FailureUnsafeForBinlog, Actual: fmt.Sprint(warning["Message"])}
+	}
+	return "", nil
+}
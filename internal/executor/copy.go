@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// executeCopy reads the rows of the source query from the source database and inserts them
+// into the target table in the run's transaction, one INSERT per row
+func (e *BaseExecutor) executeCopy(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	report := &definition.Report{
+		ID:          op.ID,
+		Description: op.Description,
+		Type:        op.Type,
+		SQL:         fmt.Sprintf("-- %s:\n%s;\nINSERT INTO %s ...", op.Source.Database, op.Source.SQL, op.Into),
+		Args:        op.Source.Args,
+		Generated:   op.Generated,
+		Expected:    op.ExpectedChanges,
+	}
+	failed := func(message string, err error) (*definition.Report, error) {
+		report.Message = fmt.Sprintf("%s: %v", message, err)
+		report.Failure = &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()}
+		return report, nil
+	}
+
+	rows, err := e.queryRemote(ctx, op.Source)
+	if err != nil {
+		return failed(fmt.Sprintf("query on %s failed", op.Source.Database), err)
+	}
+
+	var inserted int64
+	for i, row := range rows {
+		affected, err := insertRow(ctx, tx, e.db.DriverName(), op.Into, row)
+		if err != nil {
+			return failed(fmt.Sprintf("insert of row %d failed", i), err)
+		}
+		inserted += affected
+	}
+
+	if e.sampleRows > 0 {
+		sample := rows
+		if len(sample) > e.sampleRows {
+			sample = sample[:e.sampleRows]
+		}
+		report.Sample = sample
+	}
+
+	report.Result = inserted
+	report.Message, report.Failure = e.validateDMLResult(inserted, op.ExpectedChanges, definition.TypeInsert)
+	report.Pass = report.Failure == nil
+	return report, nil
+}
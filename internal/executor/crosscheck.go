@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// executeCrossCheck runs the operation's SELECT in the transaction and its secondary query on the
+// secondary database, and asserts the rows match in order (the secondary's rows are the expected ones)
+func (e *BaseExecutor) executeCrossCheck(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	report := &definition.Report{
+		ID:          op.ID,
+		Description: op.Description,
+		Type:        op.Type,
+		SQL:         fmt.Sprintf("%s;\n-- %s:\n%s", op.SQL, op.Secondary.Database, op.Secondary.SQL),
+		Args:        op.Args,
+		Generated:   op.Generated,
+	}
+	queryFailed := func(message string, err error) (*definition.Report, error) {
+		report.Message = fmt.Sprintf("%s: %v", message, err)
+		report.Failure = &definition.Failure{Code: definition.FailureSQLError, Actual: err.Error()}
+		return report, nil
+	}
+
+	primary, err := tx.QueryRowsContext(ctx, e.query(op), op.Args...)
+	if err != nil {
+		return queryFailed("query failed", err)
+	}
+	secondary, err := e.queryRemote(ctx, op.Secondary)
+	if err != nil {
+		return queryFailed(fmt.Sprintf("query on %s failed", op.Secondary.Database), err)
+	}
+	// 両側を同じ規則で復号してから比較する
+	if primary, err = def.DecodeRows(primary); err == nil {
+		secondary, err = def.DecodeRows(secondary)
+	}
+	if err != nil {
+		report.Message = fmt.Sprintf("decode failed: %v", err)
+		report.Failure = &definition.Failure{Code: definition.FailureDecodeFailed, Actual: err.Error()}
+		return report, nil
+	}
+
+	message, failure := e.validateSelectResult(primary, secondary)
+	if failure != nil {
+		message = fmt.Sprintf("results differ from %s: %s", op.Secondary.Database, message)
+		err = fmt.Errorf("assertion failed: %s", message)
+	}
+
+	report.Expected = secondary
+	report.Result = primary
+	report.Pass = failure == nil
+	report.Message = message
+	report.Failure = failure
+	return report, err
+}
+
+// queryRemote runs a query on the named database it is addressed to
+func (e *BaseExecutor) queryRemote(ctx context.Context, q *definition.RemoteQuery) ([]map[string]interface{}, error) {
+	if e.databases == nil {
+		return nil, fmt.Errorf("no databases other than the target are available")
+	}
+	db, err := e.databases(q.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	query := q.SQL
+	if len(q.Args) > 0 {
+		query = database.Rebind(db.DriverName(), query)
+	}
+	return db.QueryRowsContext(ctx, query, q.Args...)
+}
@@ -0,0 +1,172 @@
+package executor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// executeExport runs the operation's SELECT in the run's transaction and writes the rows to its
+// file as CSV or JSON. Plans run the query without writing the file.
+func (e *BaseExecutor) executeExport(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	report := &definition.Report{
+		ID:          op.ID,
+		Description: op.Description,
+		Type:        op.Type,
+		SQL:         op.SQL,
+		Args:        op.Args,
+		Generated:   op.Generated,
+		File:        op.File,
+	}
+	if len(op.Expected) > 0 {
+		report.Expected = op.Expected
+	}
+	failed := func(code, message string, err error) (*definition.Report, error) {
+		report.Message = fmt.Sprintf("%s: %v", message, err)
+		report.Failure = &definition.Failure{Code: code, Actual: err.Error()}
+		return report, nil
+	}
+
+	columns, rows, err := queryColumns(ctx, tx, e.query(op), op.Args...)
+	if err != nil {
+		return failed(definition.FailureSQLError, "query failed", err)
+	}
+
+	report.Result = int64(len(rows))
+
+	// expectedがあればSELECTと同じく書き出す前に結果を検証する。ファイルには復号前の値を書く
+	if len(op.Expected) > 0 {
+		decoded, err := def.DecodeRows(rows)
+		if err != nil {
+			return failed(definition.FailureDecodeFailed, "decode failed", err)
+		}
+		message, failure := e.validateExpectedRows(decoded, op)
+		if failure != nil {
+			report.Message = message
+			report.Failure = failure
+			return report, fmt.Errorf("assertion failed: %s", message)
+		}
+	}
+
+	if e.dryRun {
+		report.Message = fmt.Sprintf("would export %d rows to %s", len(rows), op.File)
+	} else {
+		if err := writeExport(op.File, columns, rows); err != nil {
+			return failed(definition.FailureExportFailed, "export failed", err)
+		}
+		report.Message = fmt.Sprintf("exported %d rows to %s", len(rows), op.File)
+	}
+	report.Pass = true
+	return report, nil
+}
+
+// queryColumns runs a query keeping the order of its columns when the transaction can tell it,
+// and falls back to the column names in alphabetical order
+func queryColumns(ctx context.Context, tx database.Transaction, query string, args ...interface{}) ([]string, []map[string]interface{}, error) {
+	if querier, ok := tx.(database.ColumnQuerier); ok {
+		return querier.QueryColumnsContext(ctx, query, args...)
+	}
+
+	rows, err := tx.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []string
+	if len(rows) > 0 {
+		for column := range rows[0] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+	}
+	return columns, rows, nil
+}
+
+// writeExport writes the rows to path in the format of its extension, replacing the file only
+// once it is completely written
+func writeExport(path string, columns []string, rows []map[string]interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = writeExportJSON(tmp, rows)
+	default:
+		err = writeExportCSV(tmp, columns, rows)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeExportCSV(f *os.File, columns []string, rows []map[string]interface{}) error {
+	w := csv.NewWriter(f)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = exportValue(row[column])
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeExportJSON(f *os.File, rows []map[string]interface{}) error {
+	values := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = make(map[string]interface{}, len(row))
+		for column, value := range row {
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			values[i][column] = value
+		}
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
+
+// exportValue formats a value for CSV; NULL is written as \N, as import and CSV fixtures read it,
+// and binary values as base64
+func exportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case []byte:
+		return string(v)
+	case database.Binary:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,123 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/fixture"
+)
+
+// executeImport inserts the rows of the operation's CSV file into its table in the run's transaction,
+// creating the table first as a temporary staging table when asked to
+func (e *BaseExecutor) executeImport(ctx context.Context, tx database.Transaction, def *definition.Definition, op definition.Operation) (*definition.Report, error) {
+	report := &definition.Report{
+		ID:          op.ID,
+		Description: op.Description,
+		Type:        op.Type,
+		Generated:   op.Generated,
+		Expected:    op.ExpectedChanges,
+	}
+	failed := func(code string, err error) (*definition.Report, error) {
+		report.Message = err.Error()
+		report.Failure = &definition.Failure{Code: code, Actual: err.Error()}
+		return report, nil
+	}
+
+	header, rows, err := fixture.ReadCSV(op.File)
+	if err != nil {
+		return failed(definition.FailureSQLError, err)
+	}
+
+	// マッピングがあればその列だけを取り込む
+	var indexes []int
+	var columns []string
+	for i, name := range header {
+		column := name
+		if op.Columns != nil {
+			mapped, ok := op.Columns[name]
+			if !ok {
+				continue
+			}
+			column = mapped
+		}
+		indexes = append(indexes, i)
+		columns = append(columns, column)
+	}
+	for name := range op.Columns {
+		if !slices.Contains(header, name) {
+			return failed(definition.FailureMissingColumn, fmt.Errorf("column %q is not in the header of %s", name, op.File))
+		}
+	}
+	if len(columns) == 0 {
+		return failed(definition.FailureMissingColumn, fmt.Errorf("no column of %s is imported", op.File))
+	}
+
+	driver := e.db.DriverName()
+	table := database.QuoteIdentifier(driver, op.Into)
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = database.QuoteIdentifier(driver, column)
+		placeholders[i] = database.Placeholder(driver, i+1)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	report.SQL = fmt.Sprintf("-- %s (%d rows)\n%s", op.File, len(rows), insert)
+
+	if op.Staging {
+		create := stagingTable(driver, table, quoted)
+		report.SQL = create + ";\n" + report.SQL
+		if _, err := tx.ExecContext(ctx, create); err != nil {
+			return failed(definition.FailureSQLError, fmt.Errorf("failed to create staging table: %w", err))
+		}
+	}
+
+	var inserted int64
+	var sample []map[string]interface{}
+	for line, row := range rows {
+		if len(row) != len(header) {
+			return failed(definition.FailureSQLError, fmt.Errorf("%s line %d: expected %d fields, got %d", op.File, line+2, len(header), len(row)))
+		}
+		args := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			args[i] = row[index]
+		}
+
+		affected, err := tx.ExecContext(ctx, insert, args...)
+		if err != nil {
+			return failed(definition.FailureSQLError, fmt.Errorf("%s line %d: %w", op.File, line+2, err))
+		}
+		inserted += affected
+
+		if len(sample) < e.sampleRows {
+			values := make(map[string]interface{}, len(columns))
+			for i, column := range columns {
+				values[column] = args[i]
+			}
+			sample = append(sample, values)
+		}
+	}
+
+	report.Result = inserted
+	report.Sample = sample
+	report.Message, report.Failure = e.validateDMLResult(inserted, op.ExpectedChanges, definition.TypeInsert)
+	report.Pass = report.Failure == nil
+	return report, nil
+}
+
+// stagingTable creates a temporary table of text columns, dropped with the transaction on PostgreSQL
+// and with the connection on MySQL
+func stagingTable(driver, table string, columns []string) string {
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = column + " TEXT"
+	}
+	create := fmt.Sprintf("CREATE TEMPORARY TABLE %s (%s)", table, strings.Join(definitions, ", "))
+	if driver == "postgres" {
+		create += " ON COMMIT DROP"
+	}
+	return create
+}
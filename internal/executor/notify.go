@@ -0,0 +1,27 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+// WithNotify makes the apply executor call pg_notify(channel, payload) in its transaction right
+// before committing, so that PostgreSQL delivers the notification only when the changes are committed
+func WithNotify(channel, payload string) Option {
+	return func(e *BaseExecutor) {
+		e.notifyChannel = channel
+		e.notifyPayload = payload
+	}
+}
+
+func (e *ApplyExecutor) notify(ctx context.Context, tx database.Transaction) error {
+	if e.notifyChannel == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", e.notifyChannel, e.notifyPayload); err != nil {
+		return fmt.Errorf("failed to notify channel %s: %w", e.notifyChannel, err)
+	}
+	return nil
+}
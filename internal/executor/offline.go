@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// OfflineReports describes what each operation of a loaded definition would execute, without a
+// database (--offline). The reports pass: nothing was checked beyond loading and validation.
+func OfflineReports(def *definition.Definition) []definition.Report {
+	reports := make([]definition.Report, 0, len(def.Operations))
+	for _, op := range def.Operations {
+		report := definition.Report{
+			ID:          op.ID,
+			Description: op.Description,
+			Type:        op.Type,
+			SQL:         op.SQL,
+			Args:        op.Args,
+			Generated:   op.Generated,
+			Owner:       op.Owner,
+			File:        op.File,
+			Pass:        true,
+		}
+		if len(op.Expected) > 0 {
+			report.Expected = op.Expected
+		} else if len(op.ExpectedChanges) > 0 {
+			report.Expected = op.ExpectedChanges
+		}
+
+		var impact string
+		switch op.Type {
+		case definition.TypeInsert, definition.TypeUpdate, definition.TypeDelete:
+			impact = fmt.Sprintf("would %s %d rows", op.Type, op.ExpectedChanges[op.Type])
+		case definition.TypeAnonymize:
+			report.SQL = fmt.Sprintf("-- anonymize %s where %s", op.Anonymize.Table, op.Anonymize.Where)
+			impact = fmt.Sprintf("would anonymize %s of %d rows", strings.Join(op.Anonymize.SortedColumns(), ", "), op.ExpectedChanges[definition.TypeUpdate])
+		case definition.TypeCopy:
+			impact = fmt.Sprintf("would copy %d rows from %s into %s", op.ExpectedChanges[definition.TypeInsert], op.Source.Database, op.Into)
+		case definition.TypeImport:
+			impact = fmt.Sprintf("would import %d rows from %s into %s", op.ExpectedChanges[definition.TypeInsert], op.File, op.Into)
+		case definition.TypeExport:
+			impact = fmt.Sprintf("would export rows to %s", op.File)
+		case definition.TypeCrossCheck:
+			impact = fmt.Sprintf("would compare with %s", op.Secondary.Database)
+		default:
+			impact = fmt.Sprintf("would query, expecting %d rows", len(op.Expected))
+		}
+		if tables := operationTables(op); len(tables) > 0 {
+			impact += " (" + strings.Join(tables, ", ") + ")"
+		}
+		report.Message = "not executed (offline): " + impact
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// OfflineImpact sums the rows the DML operations of a definition expect to change, per type
+func OfflineImpact(def *definition.Definition) map[string]int {
+	impact := make(map[string]int)
+	for _, op := range def.Operations {
+		switch op.Type {
+		case definition.TypeInsert, definition.TypeUpdate, definition.TypeDelete:
+			impact[op.Type] += op.ExpectedChanges[op.Type]
+		case definition.TypeAnonymize:
+			impact[definition.TypeUpdate] += op.ExpectedChanges[definition.TypeUpdate]
+		case definition.TypeCopy, definition.TypeImport:
+			impact[definition.TypeInsert] += op.ExpectedChanges[definition.TypeInsert]
+		}
+	}
+	return impact
+}
+
+func operationTables(op definition.Operation) []string {
+	single := definition.Definition{Operations: []definition.Operation{op}}
+	tables := single.Tables()
+	sort.Strings(tables)
+	return tables
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
 
 	"github.com/pyama86/opsql/internal/database"
 	"github.com/pyama86/opsql/internal/definition"
@@ -13,23 +14,62 @@ type PlanExecutor struct {
 	*BaseExecutor
 }
 
-func NewPlanExecutor(db database.DB) *PlanExecutor {
+func NewPlanExecutor(db database.DB, opts ...Option) *PlanExecutor {
+	base := NewBaseExecutor(db, opts...)
+	base.dryRun = true
 	return &PlanExecutor{
-		BaseExecutor: NewBaseExecutor(db),
+		BaseExecutor: base,
 	}
 }
 
+// Execute runs the definition in a transaction that is always rolled back.
+// With WithRepeat it runs the definition again in fresh transactions and fails when
+// any operation's result differs from the first run.
 func (e *PlanExecutor) Execute(ctx context.Context, def *definition.Definition) ([]definition.Report, error) {
-	tx, err := e.db.BeginTransaction(ctx)
+	reports, err := e.executeOnce(ctx, def)
+	if err != nil {
+		return reports, err
+	}
+
+	for run := 2; run <= e.repeat; run++ {
+		// 2回目以降はアサーション失敗も結果の差分として扱う
+		again, err := e.executeOnce(ctx, def)
+		if i := firstDifference(reports, again); i >= 0 && i < len(again) {
+			reports[i].Pass = false
+			reports[i].Message = fmt.Sprintf("result differs between run 1 and run %d", run)
+			reports[i].Failure = &definition.Failure{
+				Code:     definition.FailureNondeterministicResult,
+				Expected: reports[i].Result,
+				Actual:   again[i].Result,
+			}
+			fmt.Fprintf(os.Stderr, "Operation[%s] failed: %s\n", reports[i].ID, reports[i].Message)
+			return reports, fmt.Errorf("operation[%s]: %s", reports[i].ID, reports[i].Message)
+		}
+		if err != nil {
+			return reports, fmt.Errorf("run %d: %w", run, err)
+		}
+	}
+
+	return reports, nil
+}
+
+func (e *PlanExecutor) executeOnce(ctx context.Context, def *definition.Definition) ([]definition.Report, error) {
+	tx, err := e.beginTransaction(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer func() { _ = tx.Rollback() }()
+	ctx, stop := e.monitorTransaction(ctx, tx)
+	defer stop()
 
 	var reports []definition.Report
 
-	for _, op := range def.Operations {
-		report, err := e.executeOperation(ctx, tx, op)
+	for i, op := range def.Operations {
+		if err := e.checkCancelled(op); err != nil {
+			return reports, err
+		}
+
+		report, err := e.executeOperation(ctx, tx, def, i, op)
 		if report != nil {
 			reports = append(reports, *report)
 			if !report.Pass {
@@ -43,3 +83,16 @@ func (e *PlanExecutor) Execute(ctx context.Context, def *definition.Definition)
 
 	return reports, nil
 }
+
+// firstDifference returns the index of the first report whose result differs, or -1
+func firstDifference(first, second []definition.Report) int {
+	for i := range first {
+		if i >= len(second) {
+			return i
+		}
+		if first[i].Pass != second[i].Pass || !reflect.DeepEqual(first[i].Result, second[i].Result) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// Progress event types
+const (
+	ProgressStarted  = "started"
+	ProgressFinished = "finished"
+)
+
+// ProgressEvent tells that an operation started or finished
+type ProgressEvent struct {
+	Type        string `json:"type"`
+	OperationID string `json:"operation_id"`
+	// Index is the position of the operation in the definition, from 0
+	Index int `json:"index"`
+	Total int `json:"total"`
+	// Pass, Affected, DurationMS and Message are set on finished events
+	Pass       bool      `json:"pass"`
+	Affected   *int64    `json:"affected,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// ProgressFunc receives progress events while a definition executes
+type ProgressFunc func(ProgressEvent)
+
+// WithProgress sends an event to fn when each operation starts and finishes
+func WithProgress(fn ProgressFunc) Option {
+	return func(e *BaseExecutor) {
+		e.progress = fn
+	}
+}
+
+func (e *BaseExecutor) operationStarted(def *definition.Definition, index int, op definition.Operation) {
+	if e.progress == nil {
+		return
+	}
+	e.progress(ProgressEvent{
+		Type:        ProgressStarted,
+		OperationID: op.ID,
+		Index:       index,
+		Total:       len(def.Operations),
+		Time:        definition.ReportTime(time.Now()),
+	})
+}
+
+func (e *BaseExecutor) operationFinished(def *definition.Definition, index int, op definition.Operation, report *definition.Report, err error) {
+	if e.progress == nil {
+		return
+	}
+	event := ProgressEvent{
+		Type:        ProgressFinished,
+		OperationID: op.ID,
+		Index:       index,
+		Total:       len(def.Operations),
+		Time:        definition.ReportTime(time.Now()),
+	}
+	switch {
+	case report != nil:
+		event.Pass = report.Pass
+		event.DurationMS = report.DurationMS
+		event.Message = report.Message
+		if affected, ok := report.Result.(int64); ok && !definition.IsQuery(report.Type) {
+			event.Affected = &affected
+		}
+	case err != nil:
+		event.Message = err.Error()
+	}
+	e.progress(event)
+}
@@ -0,0 +1,143 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// sampleQuery derives a SELECT returning the rows a DML statement is about to touch.
+// It returns false when no such query can be derived (e.g. INSERT ... VALUES).
+func sampleQuery(sql string, limit int) (string, bool) {
+	query, _, ok := affectedRowsQuery(sql, nil, "*", limit)
+	return query, ok
+}
+
+// span is a part of a statement copied into a derived query
+type span struct {
+	start, end int
+}
+
+// affectedRowsQuery derives a SELECT of columns over the rows a DML statement is about to touch,
+// together with the bind arguments of the parts it keeps. A limit of 0 adds no LIMIT.
+// Columns other than * are only supported for UPDATE and DELETE.
+func affectedRowsQuery(sql string, args []interface{}, columns string, limit int) (string, []interface{}, bool) {
+	query := strings.TrimSuffix(strings.TrimSpace(sql), ";")
+	if i := findKeyword(query, "RETURNING", 0); i >= 0 {
+		query = strings.TrimSpace(query[:i])
+	}
+
+	var derived string
+	var kept []span
+	switch definition.DetectSQLType(query) {
+	case definition.TypeDelete:
+		from := findKeyword(query, "FROM", 0)
+		if from < 0 {
+			return "", nil, false
+		}
+		derived = "SELECT " + columns + " " + query[from:]
+		kept = append(kept, span{from, len(query)})
+	case definition.TypeUpdate:
+		set := findKeyword(query, "SET", 0)
+		if set < 0 {
+			return "", nil, false
+		}
+		derived = "SELECT " + columns + " FROM " + strings.TrimSpace(query[len("UPDATE"):set])
+		kept = append(kept, span{len("UPDATE"), set})
+		where := findKeyword(query, "WHERE", set)
+		// PostgreSQL: UPDATE t SET ... FROM other WHERE ...
+		if from := findKeyword(query, "FROM", set); from >= 0 && (where < 0 || from < where) {
+			end := len(query)
+			if where >= 0 {
+				end = where
+			}
+			derived += ", " + strings.TrimSpace(query[from+len("FROM"):end])
+			kept = append(kept, span{from, end})
+		}
+		if where >= 0 {
+			derived += " " + query[where:]
+			kept = append(kept, span{where, len(query)})
+		}
+	case definition.TypeInsert:
+		selectIndex := findKeyword(query, "SELECT", 0)
+		if selectIndex < 0 || columns != "*" {
+			return "", nil, false
+		}
+		derived = query[selectIndex:]
+		kept = append(kept, span{selectIndex, len(query)})
+	default:
+		return "", nil, false
+	}
+
+	if limit > 0 && findKeyword(derived, "LIMIT", 0) < 0 {
+		derived += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return derived, keptArgs(query, kept, args), true
+}
+
+// keptArgs returns the arguments of the ? placeholders inside the kept spans
+func keptArgs(query string, kept []span, args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	var result []interface{}
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			for _, s := range kept {
+				if i >= s.start && i < s.end && n < len(args) {
+					result = append(result, args[n])
+					break
+				}
+			}
+			n++
+		}
+	}
+	return result
+}
+
+// findKeyword returns the index of the first keyword outside of parentheses and quotes at or after start
+func findKeyword(sql, keyword string, start int) int {
+	depth := 0
+	var quote byte
+	upper := strings.ToUpper(sql)
+
+	for i := start; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(upper[i:], keyword):
+			before := i == 0 || !isWordChar(rune(sql[i-1]))
+			after := i+len(keyword) == len(sql) || !isWordChar(rune(sql[i+len(keyword)]))
+			if before && after {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
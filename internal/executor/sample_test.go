@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampleQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+		ok       bool
+	}{
+		{
+			name:     "delete",
+			sql:      "DELETE FROM user_sessions WHERE user_id IN (SELECT id FROM users WHERE status = 'deleted');",
+			expected: "SELECT * FROM user_sessions WHERE user_id IN (SELECT id FROM users WHERE status = 'deleted') LIMIT 5",
+			ok:       true,
+		},
+		{
+			name:     "delete with limit",
+			sql:      "DELETE FROM logs WHERE created_at < '2025-01-01' ORDER BY id LIMIT 100",
+			expected: "SELECT * FROM logs WHERE created_at < '2025-01-01' ORDER BY id LIMIT 100",
+			ok:       true,
+		},
+		{
+			name:     "update",
+			sql:      "UPDATE users SET status = 'inactive', note = 'where set' WHERE id IN (1,2,3)",
+			expected: "SELECT * FROM users WHERE id IN (1,2,3) LIMIT 5",
+			ok:       true,
+		},
+		{
+			name:     "update without where",
+			sql:      "UPDATE users SET status = 'inactive'",
+			expected: "SELECT * FROM users LIMIT 5",
+			ok:       true,
+		},
+		{
+			name:     "postgres update from",
+			sql:      "UPDATE users u SET status = b.reason FROM bans b WHERE b.user_id = u.id RETURNING u.id",
+			expected: "SELECT * FROM users u, bans b WHERE b.user_id = u.id LIMIT 5",
+			ok:       true,
+		},
+		{
+			name:     "insert select",
+			sql:      "INSERT INTO user_logs (user_id, action) SELECT id, 'updated' FROM users WHERE id IN (1,2)",
+			expected: "SELECT id, 'updated' FROM users WHERE id IN (1,2) LIMIT 5",
+			ok:       true,
+		},
+		{
+			name: "insert values",
+			sql:  "INSERT INTO users (name) VALUES ('select')",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := sampleQuery(tt.sql, 5)
+			if ok != tt.ok {
+				t.Fatalf("sampleQuery() ok = %v, want %v", ok, tt.ok)
+			}
+			if result != tt.expected {
+				t.Errorf("sampleQuery() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAffectedRowsQueryArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		args     []interface{}
+		expected string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "update skips set arguments",
+			sql:      "UPDATE users SET status = ?, note = '?' WHERE id IN (?, ?)",
+			args:     []interface{}{"inactive", 1, 2},
+			expected: "SELECT `id` FROM users WHERE id IN (?, ?)",
+			wantArgs: []interface{}{1, 2},
+		},
+		{
+			name:     "delete keeps every argument",
+			sql:      "DELETE FROM sessions WHERE user_id = ? AND created_at < ?",
+			args:     []interface{}{7, "2025-01-01"},
+			expected: "SELECT `id` FROM sessions WHERE user_id = ? AND created_at < ?",
+			wantArgs: []interface{}{7, "2025-01-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, ok := affectedRowsQuery(tt.sql, tt.args, "`id`", 0)
+			if !ok {
+				t.Fatalf("expected a query")
+			}
+			if query != tt.expected {
+				t.Errorf("query = %q, want %q", query, tt.expected)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+
+	if _, _, ok := affectedRowsQuery("INSERT INTO archive SELECT * FROM users", nil, "`id`", 0); ok {
+		t.Errorf("INSERT ... SELECT should not support key columns")
+	}
+}
@@ -0,0 +1,194 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// DefaultShadowRowLimit is the most rows copied from a table into the shadow database
+const DefaultShadowRowLimit = 10000
+
+// WithShadowRowLimit sets the most rows the shadow executor copies from a table; a table with
+// more relevant rows fails the run rather than being copied in part
+func WithShadowRowLimit(n int) Option {
+	return func(e *BaseExecutor) {
+		e.shadowRowLimit = n
+	}
+}
+
+// ShadowExecutor copies the relevant rows of the referenced tables from the source database into a
+// shadow database and applies the operations there, so triggers and constraints fire without
+// touching the source.
+type ShadowExecutor struct {
+	source database.DB
+	shadow database.DB
+	opts   []Option
+}
+
+func NewShadowExecutor(source, shadow database.DB, opts ...Option) *ShadowExecutor {
+	return &ShadowExecutor{
+		source: source,
+		shadow: shadow,
+		opts:   opts,
+	}
+}
+
+func (e *ShadowExecutor) Execute(ctx context.Context, def *definition.Definition) ([]definition.Report, error) {
+	limit := NewBaseExecutor(e.source, e.opts...).shadowRowLimit
+	if limit <= 0 {
+		limit = DefaultShadowRowLimit
+	}
+	if err := e.cloneTables(ctx, shadowQueries(e.source.DriverName(), def), limit); err != nil {
+		return nil, fmt.Errorf("failed to prepare shadow database: %w", err)
+	}
+
+	return NewApplyExecutor(e.shadow, e.opts...).Execute(ctx, def)
+}
+
+// shadowQuery reads rows of a table to copy into the shadow database
+type shadowQuery struct {
+	sql  string
+	args []interface{}
+}
+
+// shadowTable is a table copied into the shadow database and the queries of its rows
+type shadowTable struct {
+	name    string
+	queries []shadowQuery
+}
+
+// shadowQueries returns, for each referenced table, the queries of the rows to copy: the rows the
+// WHERE clauses of UPDATE and DELETE operations on that single table select, or the whole table
+// when another operation, such as a join or an INSERT, refers to it
+func shadowQueries(driver string, def *definition.Definition) []shadowTable {
+	tables := def.Tables()
+	restricted := make(map[string][]shadowQuery)
+	whole := make(map[string]bool)
+	for _, op := range def.Operations {
+		var target string
+		var ok bool
+		if op.Type == definition.TypeUpdate || op.Type == definition.TypeDelete {
+			target, ok = singleTableTarget(op.SQL)
+		}
+		var query string
+		var args []interface{}
+		if ok {
+			query, args, ok = affectedRowsQuery(op.SQL, op.Args, "*", 0)
+		}
+		referenced := definition.ExtractTables(op.SQL)
+		if op.Anonymize != nil {
+			referenced = []string{op.Anonymize.Table}
+		}
+		if op.Into != "" && !op.Staging {
+			referenced = append(referenced, op.Into)
+		}
+		for _, table := range referenced {
+			if ok && table == target {
+				restricted[table] = append(restricted[table], shadowQuery{query, args})
+			} else {
+				whole[table] = true
+			}
+		}
+	}
+
+	copies := make([]shadowTable, len(tables))
+	for i, table := range tables {
+		copies[i] = shadowTable{name: table, queries: restricted[table]}
+		if whole[table] || len(restricted[table]) == 0 {
+			copies[i].queries = []shadowQuery{{sql: "SELECT * FROM " + database.QuoteIdentifier(driver, table)}}
+		}
+	}
+	return copies
+}
+
+// singleTableTarget returns the table of an UPDATE or DELETE that changes one table without joins
+func singleTableTarget(sql string) (string, bool) {
+	query := strings.TrimSuffix(strings.TrimSpace(sql), ";")
+	var table, rest string
+	switch definition.DetectSQLType(query) {
+	case definition.TypeDelete:
+		from := findKeyword(query, "FROM", 0)
+		if from < 0 {
+			return "", false
+		}
+		table = strings.TrimSpace(query[from+len("FROM"):])
+		if i := strings.IndexFunc(table, unicode.IsSpace); i >= 0 {
+			table, rest = table[:i], table[i:]
+		}
+		if !startsWithClause(rest, "WHERE", "ORDER", "LIMIT", "RETURNING") {
+			return "", false
+		}
+	case definition.TypeUpdate:
+		set := findKeyword(query, "SET", 0)
+		if set < 0 || findKeyword(query, "FROM", set) >= 0 {
+			return "", false
+		}
+		table = strings.TrimSpace(query[len("UPDATE"):set])
+	default:
+		return "", false
+	}
+	if !tableNamePattern.MatchString(table) {
+		return "", false
+	}
+	return strings.NewReplacer("`", "", "\"", "").Replace(table), true
+}
+
+// cloneTables replaces the rows of each table in the shadow database with the rows its queries read
+// from the source. The shadow database must already have the tables created.
+func (e *ShadowExecutor) cloneTables(ctx context.Context, tables []shadowTable, limit int) error {
+	tx, err := e.shadow.BeginTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, copied := range tables {
+		table := copied.name
+		var rows []map[string]interface{}
+		seen := make(map[string]bool)
+		for _, query := range copied.queries {
+			// 上限を超えたかどうか分かるよう1行多く読む
+			sql := fmt.Sprintf("SELECT * FROM (%s) opsql_shadow LIMIT %d", query.sql, limit+1)
+			if len(query.args) > 0 {
+				sql = database.Rebind(e.source.DriverName(), sql)
+			}
+			read, err := e.source.QueryRowsContext(ctx, sql, query.args...)
+			if err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("failed to read table %s: %w", table, err)
+			}
+			for _, row := range read {
+				// 複数の操作が同じ行を選ぶことがある
+				key, err := json.Marshal(row)
+				if err == nil && seen[string(key)] {
+					continue
+				}
+				seen[string(key)] = true
+				rows = append(rows, row)
+			}
+			if len(rows) > limit {
+				_ = tx.Rollback()
+				return fmt.Errorf("table %s has more than %d relevant rows to copy; narrow the operations or raise the shadow row limit", table, limit)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+database.QuoteIdentifier(e.shadow.DriverName(), table)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to clear shadow table %s: %w", table, err)
+		}
+
+		for _, row := range rows {
+			if _, err := insertRow(ctx, tx, e.shadow.DriverName(), table, row); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("failed to copy row into shadow table %s: %w", table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
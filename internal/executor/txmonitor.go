@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// ErrTransactionTooOld is wrapped by the error of a run whose transaction outlived WithTxMonitor's maxAge
+var ErrTransactionTooOld = errors.New("transaction too old")
+
+// TxStatus is a sample of the run's transaction taken by the monitor
+type TxStatus struct {
+	Age time.Duration
+	// Locks is the number of locks the transaction holds (row locks on MySQL), or -1 when unknown
+	Locks int64
+	// Idle is how long the transaction has been waiting for opsql without a statement running
+	Idle time.Duration
+}
+
+// TxMonitorFunc receives the samples of the run's transaction
+type TxMonitorFunc func(TxStatus)
+
+// WithTxMonitor sends fn a sample of the transaction every interval while it is open, and aborts
+// it once it has been open for maxAge: the running statement is cancelled and the run fails.
+// A zero interval or maxAge disables that part.
+func WithTxMonitor(interval, maxAge time.Duration, fn TxMonitorFunc) Option {
+	return func(e *BaseExecutor) {
+		e.txInterval = interval
+		e.maxTxAge = maxAge
+		e.txMonitor = fn
+	}
+}
+
+// txStatusQueries returns the query of the transaction's session ID and the query of its
+// status by that ID, which runs on another connection while the transaction is busy
+func txStatusQueries(driver string) (string, string, bool) {
+	switch driver {
+	case "mysql":
+		return "SELECT CONNECTION_ID() AS id",
+			"SELECT t.trx_rows_locked AS locks, CASE WHEN p.COMMAND = 'Sleep' THEN p.TIME ELSE 0 END AS idle_seconds " +
+				"FROM information_schema.innodb_trx t JOIN information_schema.PROCESSLIST p ON p.ID = t.trx_mysql_thread_id " +
+				"WHERE t.trx_mysql_thread_id = ?", true
+	case "postgres":
+		return "SELECT pg_backend_pid() AS id",
+			"SELECT (SELECT COUNT(*) FROM pg_locks WHERE pid = a.pid AND granted) AS locks, " +
+				"CASE WHEN a.state = 'idle in transaction' THEN EXTRACT(EPOCH FROM now() - a.state_change) ELSE 0 END AS idle_seconds " +
+				"FROM pg_stat_activity a WHERE a.pid = $1", true
+	}
+	return "", "", false
+}
+
+// monitorTransaction starts monitoring a transaction that has just begun. Operations must run with
+// the returned context, which ends once the transaction is too old; stop ends the monitoring.
+func (e *BaseExecutor) monitorTransaction(ctx context.Context, tx database.Transaction) (context.Context, func()) {
+	begunAt := time.Now()
+	cancel := func() {}
+	if e.maxTxAge > 0 {
+		cause := fmt.Errorf("%w: open for longer than %s", ErrTransactionTooOld, e.maxTxAge)
+		ctx, cancel = context.WithDeadlineCause(ctx, begunAt.Add(e.maxTxAge), cause)
+	}
+	if e.txInterval <= 0 || e.txMonitor == nil {
+		return ctx, cancel
+	}
+
+	// セッションIDが取れなくても経過時間だけは報告する
+	var session interface{}
+	idQuery, statusQuery, ok := txStatusQueries(e.db.DriverName())
+	if ok {
+		rows, err := tx.QueryRowsContext(ctx, idQuery)
+		if err == nil && len(rows) == 1 {
+			session = rows[0]["id"]
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(e.txInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				status := TxStatus{Age: time.Since(begunAt), Locks: -1}
+				if session != nil {
+					e.sampleTransaction(ctx, statusQuery, session, &status)
+				}
+				e.txMonitor(status)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		wg.Wait()
+		cancel()
+	}
+}
+
+// sampleTransaction reads the locks and idleness of the transaction's session, leaving status
+// unchanged when the database does not tell
+func (e *BaseExecutor) sampleTransaction(ctx context.Context, query string, session interface{}, status *TxStatus) {
+	ctx, cancel := context.WithTimeout(ctx, min(e.txInterval, 10*time.Second))
+	defer cancel()
+
+	rows, err := e.db.QueryRowsContext(ctx, query, session)
+	if err != nil || len(rows) != 1 {
+		return
+	}
+	if locks, ok := statusNumber(rows[0]["locks"]); ok {
+		status.Locks = int64(locks)
+	}
+	if idle, ok := statusNumber(rows[0]["idle_seconds"]); ok {
+		status.Idle = time.Duration(idle * float64(time.Second))
+	}
+}
+
+func statusNumber(value interface{}) (float64, bool) {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	r, ok := exactNumber(value, true)
+	if !ok {
+		return 0, false
+	}
+	f, _ := r.Float64()
+	return f, true
+}
+
+// txAgeExceeded returns the error of a transaction that outlived its maximum age, or nil
+func txAgeExceeded(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrTransactionTooOld) {
+		return cause
+	}
+	return nil
+}
+
+// failTooOld turns the report of an operation cut short by the maximum transaction age into its failure
+func failTooOld(report *definition.Report, err error) {
+	report.Pass = false
+	report.Message = err.Error()
+	report.Failure = &definition.Failure{Code: definition.FailureTransactionTooOld, Actual: err.Error()}
+}
@@ -0,0 +1,280 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// tableNamePattern matches a table name as written in a statement, possibly quoted and qualified
+var tableNamePattern = regexp.MustCompile("^[`\"]?[\\w$]+[`\"]?(\\.[`\"]?[\\w$]+[`\"]?)?$")
+
+// WithUndo makes the apply executor add to plan the statements that restore the rows its DELETE
+// and UPDATE operations change, read in the transaction right before each of them runs
+func WithUndo(plan *definition.UndoPlan) Option {
+	return func(e *BaseExecutor) {
+		e.undo = plan
+	}
+}
+
+// recordUndo adds the statements reverting the operation to the undo plan, or the reason it cannot
+// be reverted. It never fails the operation: the plan then lists it as skipped. The returned
+// function, when not nil, is called once the operation has run and completes the statements
+// that depend on the rows as the operation left them.
+func (e *BaseExecutor) recordUndo(ctx context.Context, tx database.Transaction, op definition.Operation) func() {
+	var steps []definition.UndoStep
+	var finish func() ([]definition.UndoStep, error)
+	var err error
+	switch op.Type {
+	case definition.TypeSelect, definition.TypeCrossCheck, definition.TypeExport:
+		return nil
+	case definition.TypeDelete:
+		steps, err = e.undoDelete(ctx, tx, op)
+	case definition.TypeUpdate:
+		finish, err = e.undoUpdate(ctx, tx, op)
+	case definition.TypeAnonymize:
+		// 消したい個人情報を元に戻す手順は残さない
+		err = fmt.Errorf("anonymized values are not restored")
+	default:
+		err = fmt.Errorf("%s operations cannot be reverted", op.Type)
+	}
+
+	add := func(steps []definition.UndoStep, err error) {
+		if err != nil {
+			e.undo.Skipped = append(e.undo.Skipped, definition.UndoSkip{OperationID: op.ID, Reason: err.Error()})
+			return
+		}
+		// 後の操作から順に戻す
+		e.undo.Steps = append(steps, e.undo.Steps...)
+	}
+	if finish == nil {
+		add(steps, err)
+		return nil
+	}
+	return func() { add(finish()) }
+}
+
+// undoDelete reads the rows a DELETE is about to remove and returns the INSERTs restoring them
+func (e *BaseExecutor) undoDelete(ctx context.Context, tx database.Transaction, op definition.Operation) ([]definition.UndoStep, error) {
+	query := strings.TrimSuffix(strings.TrimSpace(op.SQL), ";")
+	from := findKeyword(query, "FROM", 0)
+	if from < 0 {
+		return nil, errNotDerivable
+	}
+	target := strings.TrimSpace(query[from+len("FROM"):])
+	table, rest := target, ""
+	if i := strings.IndexFunc(target, unicode.IsSpace); i >= 0 {
+		table, rest = target[:i], target[i:]
+	}
+	if !tableNamePattern.MatchString(table) || !startsWithClause(rest, "WHERE", "ORDER", "LIMIT", "RETURNING") {
+		return nil, fmt.Errorf("only DELETEs from a single table without an alias can be reverted")
+	}
+
+	rows, err := e.selectAffectedRows(ctx, tx, op, "*", 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the deleted rows: %w", err)
+	}
+
+	steps := make([]definition.UndoStep, len(rows))
+	for i, row := range rows {
+		sql, args := insertStatement(e.db.DriverName(), table, row)
+		steps[i] = definition.UndoStep{OperationID: op.ID, Type: definition.TypeInsert, SQL: sql, Args: undoValues(args)}
+	}
+	return steps, nil
+}
+
+// undoUpdate reads the assigned columns of the rows an UPDATE is about to change and returns the
+// function that, once the UPDATE has run, returns the UPDATEs setting them back. Each finds its
+// row by the capture_keys and by the values the UPDATE left, so that it matches no row once the
+// row was modified again and the rollback fails instead of overwriting the newer change.
+func (e *BaseExecutor) undoUpdate(ctx context.Context, tx database.Transaction, op definition.Operation) (func() ([]definition.UndoStep, error), error) {
+	if len(op.CaptureKeys) == 0 {
+		return nil, fmt.Errorf("capture_keys are required to find the updated rows again")
+	}
+
+	query := strings.TrimSuffix(strings.TrimSpace(op.SQL), ";")
+	set := findKeyword(query, "SET", 0)
+	if set < 0 {
+		return nil, errNotDerivable
+	}
+	table := strings.TrimSpace(query[len("UPDATE"):set])
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("only UPDATEs of a single table without an alias can be reverted")
+	}
+
+	if findKeyword(query, "FROM", set) >= 0 {
+		return nil, fmt.Errorf("UPDATEs joining other tables cannot be reverted")
+	}
+	end := len(query)
+	for _, keyword := range []string{"WHERE", "ORDER", "LIMIT", "RETURNING"} {
+		if i := findKeyword(query, keyword, set); i >= 0 && i < end {
+			end = i
+		}
+	}
+
+	var columns []string
+	for _, assignment := range splitTopLevel(query[set+len("SET") : end]) {
+		column, _, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return nil, errNotDerivable
+		}
+		column = strings.TrimSpace(column)
+		if i := strings.LastIndex(column, "."); i >= 0 {
+			column = column[i+1:]
+		}
+		column = strings.Trim(column, "`\"")
+		for _, key := range op.CaptureKeys {
+			if strings.EqualFold(column, key) {
+				return nil, fmt.Errorf("the update changes the key column %s", key)
+			}
+		}
+		columns = append(columns, column)
+	}
+
+	driver := e.db.DriverName()
+	selected := make([]string, 0, len(op.CaptureKeys)+len(columns))
+	for _, column := range append(append([]string{}, op.CaptureKeys...), columns...) {
+		selected = append(selected, database.QuoteIdentifier(driver, column))
+	}
+	before, err := e.selectAffectedRows(ctx, tx, op, strings.Join(selected, ", "), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the updated rows: %w", err)
+	}
+
+	return func() ([]definition.UndoStep, error) {
+		after, err := e.selectRowsByKeys(ctx, tx, table, strings.Join(selected, ", "), op.CaptureKeys, before)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the rows as updated: %w", err)
+		}
+
+		steps := make([]definition.UndoStep, len(before))
+		for i, row := range before {
+			updated, ok := after[rowKey(row, op.CaptureKeys)]
+			if !ok {
+				return nil, fmt.Errorf("an updated row was not found again by its capture_keys")
+			}
+
+			var assignments, conditions []string
+			var args []interface{}
+			for _, column := range columns {
+				args = append(args, row[column])
+				assignments = append(assignments, database.QuoteIdentifier(driver, column)+" = "+database.Placeholder(driver, len(args)))
+			}
+			for _, key := range op.CaptureKeys {
+				args = append(args, row[key])
+				conditions = append(conditions, database.QuoteIdentifier(driver, key)+" = "+database.Placeholder(driver, len(args)))
+			}
+			for _, column := range columns {
+				if updated[column] == nil {
+					conditions = append(conditions, database.QuoteIdentifier(driver, column)+" IS NULL")
+					continue
+				}
+				args = append(args, updated[column])
+				conditions = append(conditions, database.QuoteIdentifier(driver, column)+" = "+database.Placeholder(driver, len(args)))
+			}
+			steps[i] = definition.UndoStep{
+				OperationID: op.ID,
+				Type:        definition.TypeUpdate,
+				SQL:         fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(assignments, ", "), strings.Join(conditions, " AND ")),
+				Args:        undoValues(args),
+			}
+		}
+		return steps, nil
+	}, nil
+}
+
+// undoReadBatch is the number of rows selectRowsByKeys finds per query
+const undoReadBatch = 500
+
+// selectRowsByKeys reads the columns of the rows of table whose keys are those of rows, and
+// returns them by rowKey
+func (e *BaseExecutor) selectRowsByKeys(ctx context.Context, tx database.Transaction, table, columns string, keys []string, rows []map[string]interface{}) (map[string]map[string]interface{}, error) {
+	driver := e.db.DriverName()
+	found := make(map[string]map[string]interface{}, len(rows))
+	for start := 0; start < len(rows); start += undoReadBatch {
+		batch := rows[start:min(start+undoReadBatch, len(rows))]
+
+		var matches []string
+		var args []interface{}
+		for _, row := range batch {
+			conditions := make([]string, len(keys))
+			for i, key := range keys {
+				args = append(args, row[key])
+				conditions[i] = database.QuoteIdentifier(driver, key) + " = " + database.Placeholder(driver, len(args))
+			}
+			matches = append(matches, "("+strings.Join(conditions, " AND ")+")")
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", columns, table, strings.Join(matches, " OR "))
+		result, err := queryInSavepoint(ctx, tx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range result {
+			found[rowKey(row, keys)] = row
+		}
+	}
+	return found, nil
+}
+
+// rowKey identifies a row by the values of its keys
+func rowKey(row map[string]interface{}, keys []string) string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = fmt.Sprint(row[key])
+	}
+	return strings.Join(values, "\x00")
+}
+
+// startsWithClause reports whether the rest of a statement is empty or starts with one of the keywords
+func startsWithClause(rest string, keywords ...string) bool {
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return true
+	}
+	for _, keyword := range keywords {
+		if findKeyword(rest, keyword, 0) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTopLevel splits a list on the commas outside of parentheses and quotes
+func splitTopLevel(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, list[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, list[start:])
+}
+
+func undoValues(args []interface{}) []definition.UndoValue {
+	values := make([]definition.UndoValue, len(args))
+	for i, arg := range args {
+		values[i] = definition.UndoValue{Value: arg}
+	}
+	return values
+}
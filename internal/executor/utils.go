@@ -1,10 +1,26 @@
 package executor
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
 )
 
+// ValuesEqual reports whether two column values are equal as assertions compare them: numbers
+// by value, decoded JSON element by element, and otherwise by their text when types differ
+func ValuesEqual(a, b interface{}) bool {
+	return compareValues(a, b)
+}
+
 func compareValues(actual, expected interface{}) bool {
 	if actual == nil && expected == nil {
 		return true
@@ -13,6 +29,46 @@ func compareValues(actual, expected interface{}) bool {
 		return false
 	}
 
+	if binary, ok := actual.(database.Binary); ok {
+		return compareBinary(binary, expected)
+	}
+
+	// 数値同士は桁を落とさないよう有理数として比べる
+	_, actualDecimal := actual.(database.Decimal)
+	_, expectedDecimal := expected.(database.Decimal)
+	if a, ok := exactNumber(actual, expectedDecimal); ok {
+		if e, ok := exactNumber(expected, actualDecimal); ok {
+			return a.Cmp(e) == 0
+		}
+	}
+
+	// 復号したJSONは要素ごとに比べ、JSONの1.0とYAMLの1を等しく扱う
+	switch a := actual.(type) {
+	case map[string]interface{}:
+		e, ok := expected.(map[string]interface{})
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for key, value := range a {
+			want, exists := e[key]
+			if !exists || !compareValues(value, want) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		e, ok := expected.([]interface{})
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for i := range a {
+			if !compareValues(a[i], e[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
 	actualValue := reflect.ValueOf(actual)
 	expectedValue := reflect.ValueOf(expected)
 
@@ -24,3 +80,100 @@ func compareValues(actual, expected interface{}) bool {
 
 	return reflect.DeepEqual(actual, expected)
 }
+
+// compareBinary compares the bytes of a binary column with an expected value written as
+// {base64: ...}, {hex: ...} or a plain string of the same bytes
+func compareBinary(actual database.Binary, expected interface{}) bool {
+	var want []byte
+	switch v := expected.(type) {
+	case database.Binary:
+		want = v
+	case string:
+		want = []byte(v)
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return false
+		}
+		var err error
+		switch {
+		case isString(v["base64"]):
+			want, err = base64.StdEncoding.DecodeString(v["base64"].(string))
+		case isString(v["hex"]):
+			want, err = hex.DecodeString(strings.TrimPrefix(v["hex"].(string), "0x"))
+		default:
+			return false
+		}
+		if err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return bytes.Equal(actual, want)
+}
+
+func isString(value interface{}) bool {
+	_, ok := value.(string)
+	return ok
+}
+
+// exactNumber returns the exact value of a number. Strings are numbers only when parseStrings is set,
+// which is when they are compared with a Decimal: "12.50" and 12.5 then match.
+func exactNumber(value interface{}, parseStrings bool) (*big.Rat, bool) {
+	var text string
+	switch v := value.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int32:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case uint64:
+		text = strconv.FormatUint(v, 10)
+	case float32:
+		text = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case database.Decimal:
+		text = string(v)
+	case string:
+		if !parseStrings {
+			return nil, false
+		}
+		text = strings.TrimSpace(v)
+	default:
+		return nil, false
+	}
+
+	// NaNやInfは有理数にならないので通常の比較に任せる
+	r, ok := new(big.Rat).SetString(text)
+	return r, ok
+}
+
+// insertRow inserts a row given as a column map, with the columns in name order
+func insertRow(ctx context.Context, tx database.Transaction, driver, table string, row map[string]interface{}) (int64, error) {
+	query, args := insertStatement(driver, database.QuoteIdentifier(driver, table), row)
+	return tx.ExecContext(ctx, query, args...)
+}
+
+// insertStatement returns the INSERT of a row given as a column map into an already quoted table,
+// with the columns in name order
+func insertStatement(driver, table string, row map[string]interface{}) (string, []interface{}) {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		quoted[i] = database.QuoteIdentifier(driver, column)
+		placeholders[i] = database.Placeholder(driver, i+1)
+		args[i] = row[column]
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	return query, args
+}
@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+		want     bool
+	}{
+		{name: "decimal with trailing zeros", actual: database.Decimal("12.50"), expected: 12.5, want: true},
+		{name: "decimal and quoted expected", actual: database.Decimal("12345678901234567890.12"), expected: "12345678901234567890.12", want: true},
+		{name: "decimal differs beyond float precision", actual: database.Decimal("12345678901234567890.12"), expected: "12345678901234567890.13", want: false},
+		{name: "decimals of two databases", actual: database.Decimal("1.10"), expected: database.Decimal("1.1"), want: true},
+		{name: "decimal and integer", actual: database.Decimal("42"), expected: 42, want: true},
+		{name: "bigint", actual: int64(9007199254740993), expected: 9007199254740993, want: true},
+		{name: "bigint off by one", actual: int64(9007199254740993), expected: 9007199254740992, want: false},
+		{name: "unsigned bigint", actual: uint64(18446744073709551615), expected: uint64(18446744073709551615), want: true},
+		{name: "integer and float", actual: int64(3), expected: 3.0, want: true},
+		{name: "strings are not parsed as numbers", actual: "007", expected: 7, want: false},
+		{name: "decimal and text", actual: database.Decimal("1.5"), expected: "abc", want: false},
+		{name: "binary and base64", actual: database.Binary{0x00, 0xff, 0x10}, expected: map[string]interface{}{"base64": "AP8Q"}, want: true},
+		{name: "binary and hex", actual: database.Binary{0x00, 0xff, 0x10}, expected: map[string]interface{}{"hex": "00ff10"}, want: true},
+		{name: "binary and prefixed hex", actual: database.Binary{0x00, 0xff, 0x10}, expected: map[string]interface{}{"hex": "0x00FF10"}, want: true},
+		{name: "binary differs", actual: database.Binary{0x00, 0xff, 0x11}, expected: map[string]interface{}{"hex": "00ff10"}, want: false},
+		{name: "binary and invalid base64", actual: database.Binary{0x00}, expected: map[string]interface{}{"base64": "!!"}, want: false},
+		{name: "binary and plain string", actual: database.Binary("abc"), expected: "abc", want: true},
+		{name: "nil", actual: nil, expected: nil, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareValues(tt.actual, tt.expected); got != tt.want {
+				t.Errorf("compareValues(%v, %v) = %v, want %v", tt.actual, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package featureflag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// maxResponseSize caps the flag responses read, which are small JSON documents
+const maxResponseSize = 1 << 20
+
+// Checker reads flags from feature-flag providers (LaunchDarkly, Unleash or anything
+// answering JSON over HTTP) and compares them with the values a definition expects
+type Checker struct {
+	client *http.Client
+}
+
+func NewChecker() *Checker {
+	return &Checker{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Check returns an error naming every flag whose value differs from the expected one
+func (c *Checker) Check(ctx context.Context, flags []definition.FeatureFlag) error {
+	var mismatches []string
+	for _, flag := range flags {
+		value, err := c.Value(ctx, flag)
+		if err != nil {
+			return fmt.Errorf("feature flag %s: %w", flag.Name, err)
+		}
+		if !equal(value, flag.Expect) {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %v, the runbook requires %v", flag.Name, value, flag.Expect))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("feature flag precondition failed: %s", strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// Value returns the flag's current value: the field at its path in the provider's JSON response
+func (c *Checker) Value(ctx context.Context, flag definition.FeatureFlag) (interface{}, error) {
+	url, headers := flag.Request()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("provider returned %s", resp.Status)
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return lookup(body, flag.Path)
+}
+
+// lookup follows a dot-separated path through JSON objects
+func lookup(value interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return value, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not in an object", path, key)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("%s: field %q not found", path, key)
+		}
+	}
+	return value, nil
+}
+
+// equal compares through JSON, so a YAML 1 matches a JSON 1.0
+func equal(actual, expected interface{}) bool {
+	a, err := json.Marshal(actual)
+	if err != nil {
+		return false
+	}
+	e, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	return string(a) == string(e)
+}
@@ -0,0 +1,178 @@
+package fixture
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// csvNull is the CSV field value loaded as NULL
+const csvNull = `\N`
+
+// Load loads a SQL or CSV fixture depending on the file extension
+func Load(ctx context.Context, db database.DB, fixture definition.Fixture) error {
+	switch strings.ToLower(filepath.Ext(fixture.File)) {
+	case ".sql":
+		return LoadSQLFile(ctx, db, fixture.File)
+	case ".csv":
+		table := fixture.Table
+		if table == "" {
+			table = strings.TrimSuffix(filepath.Base(fixture.File), filepath.Ext(fixture.File))
+		}
+		return LoadCSVFile(ctx, db, fixture.File, table)
+	default:
+		return fmt.Errorf("unsupported fixture file type: %s (allowed: .sql, .csv)", fixture.File)
+	}
+}
+
+// LoadSQLFile executes every statement of a SQL file against the database
+func LoadSQLFile(ctx context.Context, db database.DB, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read fixture file: %s %w", path, err)
+	}
+
+	for i, statement := range SplitStatements(string(data)) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("fixture %s statement[%d]: %w", path, i, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadCSVFile inserts the rows of a CSV file into the table; the first line is the column header
+func LoadCSVFile(ctx context.Context, db database.DB, path, table string) error {
+	header, rows, err := ReadCSV(path)
+	if err != nil {
+		return err
+	}
+
+	driver := db.DriverName()
+	columns := make([]string, len(header))
+	placeholders := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = database.QuoteIdentifier(driver, column)
+		placeholders[i] = database.Placeholder(driver, i+1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		database.QuoteIdentifier(driver, table), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	for line, args := range rows {
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("fixture %s line %d: %w", path, line+2, err)
+		}
+	}
+
+	return nil
+}
+
+// ReadCSV returns the trimmed header of a CSV file and its rows, with \N fields as nil
+func ReadCSV(path string) ([]string, [][]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV file: %s %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CSV file %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("CSV file %s has no header", path)
+	}
+
+	header := make([]string, len(records[0]))
+	for i, column := range records[0] {
+		header[i] = strings.TrimSpace(column)
+	}
+
+	rows := make([][]interface{}, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make([]interface{}, len(record))
+		for i, value := range record {
+			if value == csvNull {
+				row[i] = nil
+			} else {
+				row[i] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+// SplitStatements splits a SQL script on semicolons outside of quotes, comments and $$ bodies
+func SplitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case r == '-' && next == '-':
+			// 行コメントは捨てる
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case r == '/' && next == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '\'' || r == '"' || r == '`':
+			current.WriteRune(r)
+			for i++; i < len(runes); i++ {
+				current.WriteRune(runes[i])
+				if runes[i] == '\\' && r != '`' && i+1 < len(runes) {
+					i++
+					current.WriteRune(runes[i])
+					continue
+				}
+				if runes[i] == r {
+					break
+				}
+			}
+		case r == '$' && next == '$':
+			current.WriteString("$$")
+			for i += 2; i < len(runes); i++ {
+				if runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '$' {
+					current.WriteString("$$")
+					i++
+					break
+				}
+				current.WriteRune(runes[i])
+			}
+		case r == ';':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return statements
+}
@@ -0,0 +1,44 @@
+package fixture
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{
+			name:     "multiple statements",
+			script:   "CREATE TABLE users (id INT);\nINSERT INTO users VALUES (1);\n",
+			expected: []string{"CREATE TABLE users (id INT)", "INSERT INTO users VALUES (1)"},
+		},
+		{
+			name:     "semicolon inside string",
+			script:   "INSERT INTO notes VALUES ('a;b'); INSERT INTO notes VALUES ('it''s')",
+			expected: []string{"INSERT INTO notes VALUES ('a;b')", "INSERT INTO notes VALUES ('it''s')"},
+		},
+		{
+			name:     "comments are dropped",
+			script:   "-- seed data; do not edit\nINSERT INTO users VALUES (1); /* trailing; comment */",
+			expected: []string{"INSERT INTO users VALUES (1)"},
+		},
+		{
+			name:     "dollar quoted body",
+			script:   "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;",
+			expected: []string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := SplitStatements(tt.script)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("SplitStatements() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package github
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/google/go-github/v73/github"
 	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/sqlformat"
 	"golang.org/x/oauth2"
 )
 
@@ -20,6 +22,7 @@ type Client struct {
 	client *github.Client
 	repo   string
 	pr     int
+	key    string
 }
 
 func NewClient(repo string, pr int) *Client {
@@ -51,6 +54,13 @@ func NewClient(repo string, pr int) *Client {
 	}
 }
 
+// WithIdempotencyKey marks the comment with key, so that posting again with the same key, as a
+// retried CI job does, updates that comment
+func (c *Client) WithIdempotencyKey(key string) *Client {
+	c.key = key
+	return c
+}
+
 func (c *Client) PostComment(ctx context.Context, reports []definition.Report) error {
 	return c.PostCommentWithContext(ctx, reports, false, "")
 }
@@ -84,6 +94,9 @@ func (c *Client) PostCommentWithContextAndError(ctx context.Context, reports []d
 
 	owner, repoName := parts[0], parts[1]
 	comment := formatCommentWithContextAndError(reports, isDryRun, environment, executionErr)
+	if c.key != "" {
+		comment += "\n" + keyMarker(c.key) + "\n"
+	}
 
 	// Try to find and update existing opsql comment
 	existingComment, err := c.findExistingOpsqlComment(ctx, owner, repoName, environment)
@@ -138,7 +151,13 @@ func formatCommentWithContextAndError(reports []definition.Report, isDryRun bool
 	buf.WriteString(fmt.Sprintf("**Summary:** %d passed, %d failed\n\n", passCount, failCount))
 
 	// Add execution error if present
-	if executionErr != nil {
+	if errors.Is(executionErr, definition.ErrCancelled) {
+		buf.WriteString("### 🛑 Cancelled\n")
+		buf.WriteString("The run was stopped and its changes rolled back.\n")
+		buf.WriteString("```\n")
+		buf.WriteString(executionErr.Error())
+		buf.WriteString("\n```\n\n")
+	} else if executionErr != nil {
 		buf.WriteString("### 🚨 Execution Error\n")
 		buf.WriteString("```\n")
 		buf.WriteString(executionErr.Error())
@@ -153,16 +172,33 @@ func formatCommentWithContextAndError(reports []definition.Report, isDryRun bool
 
 		buf.WriteString(fmt.Sprintf("### %s %s - %s\n", status, report.ID, report.Description))
 		buf.WriteString(fmt.Sprintf("**Type:** %s\n", report.Type))
+		if report.Owner != "" {
+			buf.WriteString(fmt.Sprintf("**Owner:** %s\n", report.Owner))
+		}
 		buf.WriteString(fmt.Sprintf("**Status:** %s\n", report.Message))
+		for _, warning := range report.Warnings {
+			buf.WriteString(fmt.Sprintf("**⚠️ Warning:** %s\n", warning))
+		}
 
 		// Add SQL query
 		if report.SQL != "" {
 			buf.WriteString("**Query:**\n```sql\n")
-			buf.WriteString(report.SQL)
+			buf.WriteString(sqlformat.Format(report.SQL))
 			buf.WriteString("\n```\n")
 		}
 
-		if report.Type == definition.TypeSelect && report.Result != nil {
+		if len(report.Generated) > 0 {
+			buf.WriteString("**Generated Values:**\n")
+			for _, g := range report.Generated {
+				label := g.Function
+				if g.Name != "" {
+					label += " " + g.Name
+				}
+				buf.WriteString(fmt.Sprintf("- %s: `%s`\n", label, g.Value))
+			}
+		}
+
+		if definition.IsQuery(report.Type) && report.Result != nil {
 			if rows, ok := report.Result.([]map[string]interface{}); ok && len(rows) > 0 {
 				buf.WriteString("**Result:**\n```json\n")
 				jsonData, _ := json.MarshalIndent(rows, "", "  ")
@@ -173,6 +209,20 @@ func formatCommentWithContextAndError(reports []definition.Report, isDryRun bool
 			buf.WriteString(fmt.Sprintf("**Affected Rows:** %v\n", report.Result))
 		}
 
+		if len(report.Sample) > 0 {
+			buf.WriteString(fmt.Sprintf("**Sample of Affected Rows (%d):**\n```json\n", len(report.Sample)))
+			jsonData, _ := json.MarshalIndent(report.Sample, "", "  ")
+			buf.WriteString(string(jsonData))
+			buf.WriteString("\n```\n")
+		}
+
+		if report.CapturedKeys != nil {
+			buf.WriteString(fmt.Sprintf("**Captured Keys (%d):**\n```json\n", len(report.CapturedKeys)))
+			jsonData, _ := json.Marshal(report.CapturedKeys)
+			buf.WriteString(string(jsonData))
+			buf.WriteString("\n```\n")
+		}
+
 		buf.WriteString("\n")
 	}
 
@@ -263,16 +313,9 @@ func newGitHubAppClient() *github.Client {
 	)
 }
 
-// findExistingOpsqlComment searches for existing opsql comments in the PR
+// findExistingOpsqlComment searches the PR for the comment to update: the one marked with the
+// client's idempotency key, or else the first opsql comment of the environment
 func (c *Client) findExistingOpsqlComment(ctx context.Context, owner, repoName, environment string) (*github.IssueComment, error) {
-	// List all comments for the PR
-	comments, _, err := c.client.Issues.ListComments(ctx, owner, repoName, c.pr, &github.IssueListCommentsOptions{
-		ListOptions: github.ListOptions{PerPage: 100},
-	})
-	if err != nil {
-		return nil, err
-	}
-
 	// Build the expected comment prefix to identify opsql comments
 	expectedPrefix := "## "
 	if environment != "" {
@@ -280,12 +323,33 @@ func (c *Client) findExistingOpsqlComment(ctx context.Context, owner, repoName,
 	}
 	expectedPrefix += "opsql Execution Results"
 
-	// Search for existing opsql comment
-	for _, comment := range comments {
-		if comment.Body != nil && strings.HasPrefix(*comment.Body, expectedPrefix) {
-			return comment, nil
+	// 100件を超えるPRでも重複投稿しないよう全ページを見る
+	var first *github.IssueComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.client.Issues.ListComments(ctx, owner, repoName, c.pr, opts)
+		if err != nil {
+			return nil, err
 		}
+		for _, comment := range comments {
+			if comment.Body == nil {
+				continue
+			}
+			if c.key != "" && strings.Contains(*comment.Body, keyMarker(c.key)) {
+				return comment, nil
+			}
+			if first == nil && strings.HasPrefix(*comment.Body, expectedPrefix) {
+				first = comment
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return first, nil
+		}
+		opts.Page = resp.NextPage
 	}
+}
 
-	return nil, nil
+// keyMarker is the hidden line identifying the comment of an idempotency key
+func keyMarker(key string) string {
+	return fmt.Sprintf("<!-- opsql-idempotency-key: %s -->", key)
 }
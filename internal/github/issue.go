@@ -0,0 +1,66 @@
+package github
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v73/github"
+)
+
+// UpsertIssue comments on the open issue with the title and label, or opens one.
+// It returns the issue number and whether the issue was created.
+func (c *Client) UpsertIssue(ctx context.Context, title, body, label string) (int, bool, error) {
+	owner, repoName, err := c.ownerRepo()
+	if err != nil {
+		return 0, false, err
+	}
+
+	existing, err := c.findOpenIssue(ctx, owner, repoName, title, label)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to search for existing issues: %w", err)
+	}
+
+	if existing != nil {
+		_, _, err := c.client.Issues.CreateComment(ctx, owner, repoName, existing.GetNumber(), &github.IssueComment{
+			Body: &body,
+		})
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to comment on issue #%d: %w", existing.GetNumber(), err)
+		}
+		return existing.GetNumber(), false, nil
+	}
+
+	issue, _, err := c.client.Issues.Create(ctx, owner, repoName, &github.IssueRequest{
+		Title:  &title,
+		Body:   &body,
+		Labels: &[]string{label},
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create issue: %w", err)
+	}
+	return issue.GetNumber(), true, nil
+}
+
+// findOpenIssue searches the open issues with the label for the title
+func (c *Client) findOpenIssue(ctx context.Context, owner, repoName, title, label string) (*github.Issue, error) {
+	opts := &github.IssueListByRepoOptions{
+		State:       "open",
+		Labels:      []string{label},
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		issues, resp, err := c.client.Issues.ListByRepo(ctx, owner, repoName, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			if issue.GetTitle() == title && !issue.IsPullRequest() {
+				return issue, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+}
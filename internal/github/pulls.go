@@ -0,0 +1,91 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v73/github"
+)
+
+// ChangedFiles returns the files a pull request adds or modifies; removed files are left out
+func (c *Client) ChangedFiles(ctx context.Context, pr int) ([]string, error) {
+	owner, repoName, err := c.ownerRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := c.client.PullRequests.ListFiles(ctx, owner, repoName, pr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of PR #%d: %w", pr, err)
+		}
+		for _, file := range page {
+			if file.GetStatus() != "removed" {
+				files = append(files, file.GetFilename())
+			}
+		}
+		if resp.NextPage == 0 {
+			return files, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// FileContent returns a file of the repository at ref; the error wraps fs.ErrNotExist when ref has no such file
+func (c *Client) FileContent(ctx context.Context, path, ref string) ([]byte, error) {
+	owner, repoName, err := c.ownerRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	content, _, resp, err := c.client.Repositories.GetContents(ctx, owner, repoName, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s not found at %s: %w", path, ref, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s at %s: %w", path, ref, err)
+	}
+	if content == nil {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+
+	text, err := content.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return []byte(text), nil
+}
+
+// Reply adds a plain comment to a pull request
+func (c *Client) Reply(ctx context.Context, pr int, body string) error {
+	owner, repoName, err := c.ownerRepo()
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := c.client.Issues.CreateComment(ctx, owner, repoName, pr, &github.IssueComment{Body: &body}); err != nil {
+		return fmt.Errorf("failed to comment on PR #%d: %w", pr, err)
+	}
+	return nil
+}
+
+func (c *Client) ownerRepo() (string, string, error) {
+	if c.client == nil {
+		return "", "", fmt.Errorf("GitHub authentication not configured (GITHUB_TOKEN or GitHub App credentials required)")
+	}
+	if c.repo == "" {
+		c.repo = os.Getenv("GITHUB_REPOSITORY")
+	}
+
+	owner, repoName, ok := strings.Cut(c.repo, "/")
+	if !ok || owner == "" || repoName == "" || strings.Contains(repoName, "/") {
+		return "", "", fmt.Errorf("invalid repository format: %s (expected owner/repo)", c.repo)
+	}
+	return owner, repoName, nil
+}
@@ -0,0 +1,216 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// Graph is the execution flow of a definition: its operations in the order they run, the
+// template uses they were instantiated from, and the tables each reads and writes
+type Graph struct {
+	Operations []Operation
+	Tables     []string
+}
+
+type Operation struct {
+	ID          string
+	Type        string
+	Description string
+	// Group is the template use the operation comes from, when the template has several operations
+	Group  string
+	Reads  []string
+	Writes []string
+}
+
+// Build returns the graph of a loaded definition
+func Build(def *definition.Definition) *Graph {
+	g := &Graph{}
+	seen := make(map[string]bool)
+	addTables := func(tables []string) {
+		for _, table := range tables {
+			if !seen[table] {
+				seen[table] = true
+				g.Tables = append(g.Tables, table)
+			}
+		}
+	}
+
+	for _, op := range def.Operations {
+		node := Operation{ID: op.ID, Type: op.Type, Description: op.Description, Group: op.Group()}
+		referenced := definition.ExtractTables(op.SQL)
+		switch op.Type {
+		case definition.TypeInsert, definition.TypeUpdate, definition.TypeDelete:
+			// 先頭に現れるテーブルが書き込み先で、残りはサブクエリやJOINで読むだけ
+			if len(referenced) > 0 {
+				node.Writes = referenced[:1]
+				node.Reads = referenced[1:]
+			}
+		case definition.TypeAnonymize:
+			node.Writes = []string{op.Anonymize.Table}
+		case definition.TypeCopy, definition.TypeImport:
+			// ステージング用の一時テーブルは実行中に作られるので含めない
+			if !op.Staging {
+				node.Writes = []string{op.Into}
+			}
+		default:
+			node.Reads = referenced
+		}
+		addTables(node.Writes)
+		addTables(node.Reads)
+		g.Operations = append(g.Operations, node)
+	}
+	return g
+}
+
+// Render returns the graph in format: dot (Graphviz) or mermaid
+func (g *Graph) Render(format string) (string, error) {
+	switch format {
+	case FormatDOT:
+		return g.DOT(), nil
+	case FormatMermaid:
+		return g.Mermaid(), nil
+	}
+	return "", fmt.Errorf("unsupported format %q (allowed: %s, %s)", format, FormatDOT, FormatMermaid)
+}
+
+// DOT renders the graph for Graphviz: operations as boxes linked in execution order, template
+// uses as clusters, and tables as cylinders with dashed read and write edges
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph opsql {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, fontname=\"Helvetica\"];\n")
+
+	g.eachGroup(func(group string, index int, ops []int) {
+		indent := "  "
+		if group != "" {
+			fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%s;\n    style=rounded;\n", index, dotQuote("use: "+group))
+			indent = "    "
+		}
+		for _, i := range ops {
+			fmt.Fprintf(&b, "%s%s [label=%s];\n", indent, operationNode(i), dotQuote(g.label(i, "\n")))
+		}
+		if group != "" {
+			b.WriteString("  }\n")
+		}
+	})
+	for i, table := range g.Tables {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=cylinder];\n", tableNode(i), dotQuote(table))
+	}
+
+	for i := 1; i < len(g.Operations); i++ {
+		fmt.Fprintf(&b, "  %s -> %s;\n", operationNode(i-1), operationNode(i))
+	}
+	tables := g.tableIndex()
+	for i, op := range g.Operations {
+		for _, table := range op.Reads {
+			fmt.Fprintf(&b, "  %s -> %s [style=dashed, color=gray40, label=\"reads\"];\n", tableNode(tables[table]), operationNode(i))
+		}
+		for _, table := range op.Writes {
+			fmt.Fprintf(&b, "  %s -> %s [style=dashed, color=firebrick, label=\"writes\"];\n", operationNode(i), tableNode(tables[table]))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, which GitHub renders in Markdown
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+
+	g.eachGroup(func(group string, index int, ops []int) {
+		indent := "  "
+		if group != "" {
+			fmt.Fprintf(&b, "  subgraph group_%d [%s]\n", index, mermaidQuote("use: "+group))
+			indent = "    "
+		}
+		for _, i := range ops {
+			fmt.Fprintf(&b, "%s%s[%s]\n", indent, operationNode(i), mermaidQuote(g.label(i, "<br/>")))
+		}
+		if group != "" {
+			b.WriteString("  end\n")
+		}
+	})
+	for i, table := range g.Tables {
+		fmt.Fprintf(&b, "  %s[(%s)]\n", tableNode(i), mermaidQuote(table))
+	}
+
+	for i := 1; i < len(g.Operations); i++ {
+		fmt.Fprintf(&b, "  %s --> %s\n", operationNode(i-1), operationNode(i))
+	}
+	tables := g.tableIndex()
+	for i, op := range g.Operations {
+		for _, table := range op.Reads {
+			fmt.Fprintf(&b, "  %s -. reads .-> %s\n", tableNode(tables[table]), operationNode(i))
+		}
+		for _, table := range op.Writes {
+			fmt.Fprintf(&b, "  %s -. writes .-> %s\n", operationNode(i), tableNode(tables[table]))
+		}
+	}
+	return b.String()
+}
+
+// eachGroup calls fn with runs of consecutive operations of the same group, numbering the groups
+func (g *Graph) eachGroup(fn func(group string, index int, ops []int)) {
+	groups := 0
+	for start := 0; start < len(g.Operations); {
+		group := g.Operations[start].Group
+		end := start + 1
+		for group != "" && end < len(g.Operations) && g.Operations[end].Group == group {
+			end++
+		}
+		ops := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			ops = append(ops, i)
+		}
+		fn(group, groups, ops)
+		if group != "" {
+			groups++
+		}
+		start = end
+	}
+}
+
+func (g *Graph) label(i int, newline string) string {
+	op := g.Operations[i]
+	lines := []string{op.ID, op.Type}
+	if op.Description != "" {
+		lines = append(lines, op.Description)
+	}
+	return strings.Join(lines, newline)
+}
+
+func (g *Graph) tableIndex() map[string]int {
+	index := make(map[string]int, len(g.Tables))
+	for i, table := range g.Tables {
+		index[table] = i
+	}
+	return index
+}
+
+func operationNode(i int) string {
+	return fmt.Sprintf("op%d", i)
+}
+
+func tableNode(i int) string {
+	return fmt.Sprintf("table%d", i)
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+func mermaidQuote(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
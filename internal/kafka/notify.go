@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Client publishes run reports to a Kafka topic.
+// Brokers, topic and credentials come from OPSQL_KAFKA_* environment variables.
+type Client struct {
+	brokers []string
+	topic   string
+}
+
+// Message is the value published for each run
+type Message struct {
+	Environment string              `json:"environment,omitempty"`
+	DryRun      bool                `json:"dry_run"`
+	Passed      int                 `json:"passed"`
+	Failed      int                 `json:"failed"`
+	Error       string              `json:"error,omitempty"`
+	Cancelled   bool                `json:"cancelled,omitempty"`
+	Reports     []definition.Report `json:"reports"`
+	SentAt      time.Time           `json:"sent_at"`
+}
+
+// NewClient returns nil when OPSQL_KAFKA_BROKERS or OPSQL_KAFKA_TOPIC is not set
+func NewClient() *Client {
+	brokers := os.Getenv("OPSQL_KAFKA_BROKERS")
+	topic := os.Getenv("OPSQL_KAFKA_TOPIC")
+	if brokers == "" || topic == "" {
+		return nil
+	}
+
+	return &Client{
+		brokers: strings.Split(brokers, ","),
+		topic:   topic,
+	}
+}
+
+func (c *Client) SendNotificationWithContextAndError(ctx context.Context, reports []definition.Report, isDryRun bool, environment string, executionErr error) error {
+	msg := NewMessage(reports, isDryRun, environment, executionErr)
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	writer, err := NewWriter(c.brokers, c.topic)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = writer.Close() }()
+
+	return writer.WriteMessages(ctx, kafkago.Message{
+		Key:   []byte(environment),
+		Value: value,
+	})
+}
+
+func NewMessage(reports []definition.Report, isDryRun bool, environment string, executionErr error) Message {
+	msg := Message{
+		Environment: environment,
+		DryRun:      isDryRun,
+		Reports:     reports,
+		SentAt:      definition.ReportTime(time.Now()),
+	}
+	for _, report := range reports {
+		if report.Pass {
+			msg.Passed++
+		} else {
+			msg.Failed++
+		}
+	}
+	if executionErr != nil {
+		msg.Error = executionErr.Error()
+		msg.Cancelled = errors.Is(executionErr, definition.ErrCancelled)
+	}
+	return msg
+}
+
+// NewWriter returns a writer for the topic configured from the environment:
+// OPSQL_KAFKA_TLS=true enables TLS, and OPSQL_KAFKA_SASL_MECHANISM (plain, scram-sha-256
+// or scram-sha-512) with OPSQL_KAFKA_USERNAME/OPSQL_KAFKA_PASSWORD enables SASL.
+func NewWriter(brokers []string, topic string) (*kafkago.Writer, error) {
+	transport := &kafkago.Transport{}
+	if os.Getenv("OPSQL_KAFKA_TLS") == "true" {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	mechanism, err := saslMechanism(os.Getenv("OPSQL_KAFKA_SASL_MECHANISM"), os.Getenv("OPSQL_KAFKA_USERNAME"), os.Getenv("OPSQL_KAFKA_PASSWORD"))
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+
+	return &kafkago.Writer{
+		Addr:         kafkago.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: kafkago.RequireAll,
+		Transport:    transport,
+	}, nil
+}
+
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported OPSQL_KAFKA_SASL_MECHANISM: %s", name)
+	}
+}
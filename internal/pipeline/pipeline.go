@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyama86/opsql/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDSNEnv is the environment variable holding a stage's DSN when dsn_env is omitted
+const DefaultDSNEnv = "DATABASE_DSN"
+
+// Pipeline is a pipeline.yaml: definitions run stage by stage, in order
+type Pipeline struct {
+	Stages []Stage `yaml:"stages"`
+}
+
+// Stage is a single opsql run of the pipeline
+type Stage struct {
+	Name string `yaml:"name"`
+	// Configs are definition files, relative to the pipeline file
+	Configs     []string `yaml:"configs"`
+	Environment string   `yaml:"environment,omitempty"`
+	// DSNEnv names the environment variable with the stage's DSN
+	// (default DATABASE_DSN_<ENVIRONMENT>, then DATABASE_DSN)
+	DSNEnv string            `yaml:"dsn_env,omitempty"`
+	DryRun bool              `yaml:"dry_run,omitempty"`
+	Params map[string]string `yaml:"params,omitempty"`
+	// Approval gates the stage: it only runs once someone approves it
+	Approval bool `yaml:"approval,omitempty"`
+}
+
+// StageFunc executes a stage
+type StageFunc func(ctx context.Context, stage Stage) error
+
+// ApproveFunc decides whether a gated stage may run
+type ApproveFunc func(stage Stage) (bool, error)
+
+// Load reads and validates a pipeline file
+func Load(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline: %s %w", path, err)
+	}
+
+	var p Pipeline
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline: %s %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range p.Stages {
+		for j, config := range p.Stages[i].Configs {
+			if !filepath.IsAbs(config) {
+				p.Stages[i].Configs[j] = filepath.Join(dir, config)
+			}
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks that stages are named uniquely and reference definitions
+func (p *Pipeline) Validate() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("pipeline has no stages")
+	}
+
+	seen := make(map[string]bool, len(p.Stages))
+	for i, stage := range p.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stages[%d]: name is required", i)
+		}
+		if seen[stage.Name] {
+			return fmt.Errorf("stages[%d]: duplicate stage name: %s", i, stage.Name)
+		}
+		seen[stage.Name] = true
+
+		if len(stage.Configs) == 0 {
+			return fmt.Errorf("stage %s: configs is required", stage.Name)
+		}
+	}
+	return nil
+}
+
+// DSN returns the stage's database DSN from its dsn_env variable. Without dsn_env, the DSN of
+// the stage's environment is read from DATABASE_DSN_<ENVIRONMENT> as opsql serve does, and
+// DATABASE_DSN is used only by stages without an environment.
+func (s Stage) DSN() (string, error) {
+	name := s.DSNEnv
+	if name == "" {
+		name = DefaultDSNEnv
+		// 環境を名乗るステージの記録と実際に書き込むデータベースを一致させるため、既定のDSNには頼らない
+		if s.Environment != "" {
+			name = database.DSNEnv(s.Environment)
+		}
+	}
+
+	dsn := os.Getenv(name)
+	if dsn == "" {
+		return "", fmt.Errorf("stage %s: %s environment variable is required", s.Name, name)
+	}
+	return dsn, nil
+}
+
+// Run executes the stages in order and stops at the first failure or rejected approval
+func (p *Pipeline) Run(ctx context.Context, run StageFunc, approve ApproveFunc) error {
+	for i, stage := range p.Stages {
+		if stage.Approval {
+			approved, err := approve(stage)
+			if err != nil {
+				return fmt.Errorf("stage %s: approval failed: %w", stage.Name, err)
+			}
+			if !approved {
+				return fmt.Errorf("stage %s: not approved, %d of %d stages completed", stage.Name, i, len(p.Stages))
+			}
+		}
+
+		if err := run(ctx, stage); err != nil {
+			return fmt.Errorf("stage %s failed: %w", stage.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// DefaultPagerDutyURL is the PagerDuty Events API v2 endpoint
+const DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers incidents through the Events API v2
+type PagerDuty struct {
+	url    string
+	client *http.Client
+}
+
+// NewPagerDuty returns a client for url, or the public endpoint when url is empty
+func NewPagerDuty(url string) *PagerDuty {
+	if url == "" {
+		url = DefaultPagerDutyURL
+	}
+	return &PagerDuty{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Component     string                 `json:"component,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+// Trigger opens (or adds to) an incident for the failed operations of an owner.
+// Retries of the same definition and environment share a dedup key.
+func (p *PagerDuty) Trigger(ctx context.Context, routingKey, owner, definitionName, environment string, reports []definition.Report) error {
+	ids := make([]string, len(reports))
+	failures := make([]map[string]interface{}, len(reports))
+	for i, report := range reports {
+		ids[i] = report.ID
+		failures[i] = map[string]interface{}{
+			"id":      report.ID,
+			"sql":     report.SQL,
+			"message": report.Message,
+		}
+	}
+
+	summary := fmt.Sprintf("opsql: %d operations of %s failed: %s", len(reports), owner, strings.Join(ids, ", "))
+	if environment != "" {
+		summary = fmt.Sprintf("[%s] %s", environment, summary)
+	}
+
+	event := pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    fmt.Sprintf("opsql/%s/%s/%s", owner, definitionName, environment),
+		Payload: pagerDutyPayload{
+			Summary:   summary,
+			Source:    "opsql",
+			Severity:  "error",
+			Component: definitionName,
+			CustomDetails: map[string]interface{}{
+				"environment": environment,
+				"definition":  definitionName,
+				"failures":    failures,
+			},
+		},
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PagerDuty responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// Routing maps operation owners to where their failures are sent
+type Routing struct {
+	Owners map[string]Route `yaml:"owners"`
+}
+
+// Route is the notification destinations of an owner
+type Route struct {
+	SlackWebhook string `yaml:"slack_webhook,omitempty"`
+	// PagerDutyRoutingKey is the integration key of a PagerDuty Events API v2 service
+	PagerDutyRoutingKey string `yaml:"pagerduty_routing_key,omitempty"`
+}
+
+// Load reads a routing file, resolving the webhooks and routing keys given as secret references
+func Load(ctx context.Context, path string) (*Routing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing file: %s %w", path, err)
+	}
+
+	var r Routing
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse routing file: %s %w", path, err)
+	}
+
+	for owner, route := range r.Owners {
+		if route.SlackWebhook == "" && route.PagerDutyRoutingKey == "" {
+			return nil, fmt.Errorf("routing file: owner %s has neither slack_webhook nor pagerduty_routing_key", owner)
+		}
+		if route.SlackWebhook, err = secret.Resolve(ctx, route.SlackWebhook); err != nil {
+			return nil, fmt.Errorf("routing file: owner %s: %w", owner, err)
+		}
+		if route.PagerDutyRoutingKey, err = secret.Resolve(ctx, route.PagerDutyRoutingKey); err != nil {
+			return nil, fmt.Errorf("routing file: owner %s: %w", owner, err)
+		}
+		r.Owners[owner] = route
+	}
+	return &r, nil
+}
+
+// OwnerFailures is the failed reports of one owner
+type OwnerFailures struct {
+	Owner   string
+	Reports []definition.Report
+}
+
+// FailuresByOwner groups the failed reports that have an owner, in owner order
+func FailuresByOwner(reports []definition.Report) []OwnerFailures {
+	byOwner := make(map[string][]definition.Report)
+	for _, report := range reports {
+		if report.Pass || report.Owner == "" {
+			continue
+		}
+		byOwner[report.Owner] = append(byOwner[report.Owner], report)
+	}
+
+	owners := make([]string, 0, len(byOwner))
+	for owner := range byOwner {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	failures := make([]OwnerFailures, len(owners))
+	for i, owner := range owners {
+		failures[i] = OwnerFailures{Owner: owner, Reports: byOwner[owner]}
+	}
+	return failures
+}
@@ -0,0 +1,112 @@
+// Package scaffold generates runbooks for common operational patterns
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"gopkg.in/yaml.v3"
+)
+
+// CutoffLayout is how the cutoff param of a retention runbook is written
+const CutoffLayout = "2006-01-02 15:04:05"
+
+// Retention describes a sweep deleting the rows of Table whose Column is older than Cutoff
+type Retention struct {
+	Table  string
+	Column string
+	// Key orders the batches; PostgreSQL, which has no DELETE ... LIMIT, also selects each batch by it
+	Key    string
+	Cutoff time.Time
+	Batch  int
+	// Rows is the number of rows older than Cutoff, which sets the batches and the precondition
+	Rows   int64
+	Driver string
+}
+
+// CountQuery counts the rows older than the cutoff given as its only bind argument
+func (r Retention) CountQuery() string {
+	return fmt.Sprintf("SELECT COUNT(*) AS expired FROM %s WHERE %s < ?", r.table(), r.column())
+}
+
+// Definition returns the runbook: a count precondition, one DELETE per batch, and a count postcondition
+func (r Retention) Definition() *definition.Definition {
+	count := fmt.Sprintf("SELECT COUNT(*) AS expired FROM %s WHERE %s < {{ bind .params.cutoff }}", r.table(), r.column())
+
+	def := &definition.Definition{
+		Version: 1,
+		Params: map[string]interface{}{
+			"cutoff": r.Cutoff.UTC().Format(CutoffLayout),
+			"batch":  r.Batch,
+		},
+	}
+	def.Operations = append(def.Operations, definition.Operation{
+		ID:          "count_before",
+		Description: fmt.Sprintf("%d rows of %s are older than the cutoff", r.Rows, r.Table),
+		SQL:         count,
+		Expected:    []map[string]interface{}{{"expired": r.Rows}},
+	})
+
+	batches := int((r.Rows + int64(r.Batch) - 1) / int64(r.Batch))
+	for i := 1; i <= batches; i++ {
+		deleted := r.Batch
+		if i == batches {
+			deleted = int(r.Rows - int64(r.Batch)*int64(batches-1))
+		}
+		def.Operations = append(def.Operations, definition.Operation{
+			ID:              fmt.Sprintf("delete_batch_%d", i),
+			Description:     fmt.Sprintf("Batch %d of %d", i, batches),
+			SQL:             r.deleteBatch(),
+			ExpectedChanges: map[string]int{definition.TypeDelete: deleted},
+		})
+	}
+
+	def.Operations = append(def.Operations, definition.Operation{
+		ID:          "count_after",
+		Description: "No rows older than the cutoff are left",
+		SQL:         count,
+		Expected:    []map[string]interface{}{{"expired": 0}},
+	})
+	return def
+}
+
+// YAML renders the runbook with a header recording how it was generated
+func (r Retention) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Retention sweep of %s: rows with %s before %s UTC, %d per batch.\n", r.Table, r.Column, r.Cutoff.UTC().Format(CutoffLayout), r.Batch)
+	fmt.Fprintf(&buf, "# Generated by opsql gen retention. The counts were taken at generation time;\n")
+	fmt.Fprintf(&buf, "# regenerate the runbook if the plan shows they changed.\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(r.Definition()); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r Retention) deleteBatch() string {
+	if r.Driver == "postgres" {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s IN (SELECT %s FROM %s WHERE %s < {{ bind .params.cutoff }} ORDER BY %s LIMIT {{ .params.batch }})",
+			r.table(), r.key(), r.key(), r.table(), r.column(), r.key())
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s < {{ bind .params.cutoff }} ORDER BY %s LIMIT {{ .params.batch }}", r.table(), r.column(), r.key())
+}
+
+func (r Retention) table() string {
+	return database.QuoteIdentifier(r.Driver, r.Table)
+}
+
+func (r Retention) column() string {
+	return database.QuoteIdentifier(r.Driver, r.Column)
+}
+
+func (r Retention) key() string {
+	return database.QuoteIdentifier(r.Driver, r.Key)
+}
@@ -0,0 +1,324 @@
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	PlatformArgo   = "argo"
+	PlatformTekton = "tekton"
+
+	// ConfigMountPath is where the workflow mounts the ConfigMap holding the definitions
+	ConfigMountPath = "/work"
+)
+
+// Workflow describes a plan, approve and apply pipeline of opsql entrypoint steps for a workflow engine
+type Workflow struct {
+	Platform string
+	Name     string
+	Image    string
+	// ConfigMap holds the definition files, which are mounted under ConfigMountPath
+	ConfigMap string
+	Configs   []string
+	// Secret holds the DSN of the target database under SecretKey
+	Secret      string
+	SecretKey   string
+	Environment string
+	// Params become workflow parameters passed to the runs as OPSQL_PARAM_<NAME>
+	Params []WorkflowParam
+	// Approvers may approve the Tekton approval task: user names or group:<name>
+	Approvers []string
+}
+
+// WorkflowParam is a definition param exposed as a workflow parameter
+type WorkflowParam struct {
+	Name    string
+	Default string
+}
+
+// WorkflowParams returns the params and param_schema entries of definitions as workflow
+// parameters, with the definitions' values as defaults
+func WorkflowParams(params map[string]interface{}, schema map[string]bool) []WorkflowParam {
+	names := make(map[string]bool, len(params)+len(schema))
+	for name := range params {
+		names[name] = true
+	}
+	for name := range schema {
+		names[name] = true
+	}
+
+	var result []WorkflowParam
+	for name := range names {
+		param := WorkflowParam{Name: name}
+		switch v := params[name].(type) {
+		case nil:
+		case []interface{}:
+			// --param と同じくリストはカンマ区切りで渡す
+			values := make([]string, len(v))
+			for i, value := range v {
+				values[i] = fmt.Sprint(value)
+			}
+			param.Default = strings.Join(values, ",")
+		default:
+			param.Default = fmt.Sprint(v)
+		}
+		result = append(result, param)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// ConfigPaths returns the definition files as the steps see them, under ConfigMountPath
+func (w Workflow) ConfigPaths() string {
+	paths := make([]string, len(w.Configs))
+	for i, config := range w.Configs {
+		paths[i] = ConfigMountPath + "/" + filepath.Base(config)
+	}
+	return strings.Join(paths, ",")
+}
+
+// YAML renders the workflow with a header describing how to install it
+func (w Workflow) YAML() ([]byte, error) {
+	var source string
+	switch w.Platform {
+	case PlatformArgo:
+		source = argoWorkflowTemplate
+	case PlatformTekton:
+		source = tektonPipelineTemplate
+	default:
+		return nil, fmt.Errorf("unsupported platform: %s (expected %s or %s)", w.Platform, PlatformArgo, PlatformTekton)
+	}
+
+	// ワークフローエンジン自身の {{ }} 式と衝突しないよう区切りを変える
+	tmpl, err := template.New(w.Platform).Delims("[[", "]]").Funcs(template.FuncMap{
+		"quote": strconv.Quote,
+		"env":   paramEnv,
+		"list":  quoteList,
+	}).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	var files []string
+	for _, config := range w.Configs {
+		files = append(files, "--from-file="+config)
+	}
+	fmt.Fprintf(&buf, "# opsql %s for %s, generated by opsql gen workflow.\n", w.Name, w.Platform)
+	fmt.Fprintf(&buf, "# Create the ConfigMap with the definitions and the Secret with the DSN first:\n")
+	fmt.Fprintf(&buf, "#   kubectl create configmap %s %s\n", w.ConfigMap, strings.Join(files, " "))
+	fmt.Fprintf(&buf, "#   kubectl create secret generic %s --from-literal=%s=<DATABASE_DSN>\n", w.Secret, w.SecretKey)
+	if err := tmpl.Execute(&buf, w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TaskName is the name of the Tekton task running opsql
+func (w Workflow) TaskName() string {
+	return w.Name + "-run"
+}
+
+// Stages are the steps of the workflow in order
+func (w Workflow) Stages() []string {
+	return []string{"plan", "approve", "apply"}
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func paramEnv(name string) string {
+	return "OPSQL_PARAM_" + strings.ToUpper(name)
+}
+
+// argoWorkflowTemplate plans the definitions, suspends until the plan is approved
+// (argo resume) and applies them. Each run's report.json is kept as an artifact.
+const argoWorkflowTemplate = `apiVersion: argoproj.io/v1alpha1
+kind: WorkflowTemplate
+metadata:
+  name: [[ quote .Name ]]
+spec:
+  entrypoint: plan-approve-apply
+  arguments:
+    parameters:
+      - name: environment
+        value: [[ quote .Environment ]]
+[[- range .Params ]]
+      - name: [[ quote .Name ]]
+        value: [[ quote .Default ]]
+[[- end ]]
+  volumes:
+    - name: definitions
+      configMap:
+        name: [[ quote .ConfigMap ]]
+    - name: reports
+      emptyDir: {}
+  templates:
+    - name: plan-approve-apply
+      steps:
+        - - name: plan
+            template: opsql
+            arguments:
+              parameters:
+                - name: dry-run
+                  value: "true"
+        - - name: approve
+            template: approve
+        - - name: apply
+            template: opsql
+            arguments:
+              parameters:
+                - name: dry-run
+                  value: "false"
+    # Resume the workflow (argo resume, or the UI) once the plan's report is reviewed
+    - name: approve
+      suspend: {}
+    - name: opsql
+      inputs:
+        parameters:
+          - name: dry-run
+      container:
+        image: [[ quote .Image ]]
+        args: ["entrypoint"]
+        env:
+          - name: OPSQL_CONFIG
+            value: [[ quote .ConfigPaths ]]
+          - name: OPSQL_DRY_RUN
+            value: "{{inputs.parameters.dry-run}}"
+          - name: OPSQL_ENVIRONMENT
+            value: "{{workflow.parameters.environment}}"
+[[- range .Params ]]
+          - name: [[ env .Name ]]
+            value: "{{workflow.parameters.[[ .Name ]]}}"
+[[- end ]]
+          - name: DATABASE_DSN
+            valueFrom:
+              secretKeyRef:
+                name: [[ quote .Secret ]]
+                key: [[ quote .SecretKey ]]
+        volumeMounts:
+          - name: definitions
+            mountPath: /work
+          - name: reports
+            mountPath: /reports
+      outputs:
+        artifacts:
+          - name: report
+            path: /reports/report.json
+`
+
+// tektonPipelineTemplate plans the definitions, waits for the approval of the manual approval
+// gate custom task (ApprovalTask) and applies them. The reports are written to the reports workspace.
+const tektonPipelineTemplate = `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: [[ quote .TaskName ]]
+spec:
+  params:
+    - name: stage
+      type: string
+    - name: dry-run
+      type: string
+    - name: environment
+      type: string
+[[- range .Params ]]
+    - name: [[ quote .Name ]]
+      type: string
+[[- end ]]
+  workspaces:
+    - name: reports
+  volumes:
+    - name: definitions
+      configMap:
+        name: [[ quote .ConfigMap ]]
+  steps:
+    - name: opsql
+      image: [[ quote .Image ]]
+      args: ["entrypoint"]
+      env:
+        - name: OPSQL_CONFIG
+          value: [[ quote .ConfigPaths ]]
+        - name: OPSQL_DRY_RUN
+          value: $(params.dry-run)
+        - name: OPSQL_ENVIRONMENT
+          value: $(params.environment)
+        - name: OPSQL_REPORT_FILE
+          value: $(workspaces.reports.path)/$(params.stage).json
+[[- range .Params ]]
+        - name: [[ env .Name ]]
+          value: $(params.[[ .Name ]])
+[[- end ]]
+        - name: DATABASE_DSN
+          valueFrom:
+            secretKeyRef:
+              name: [[ quote .Secret ]]
+              key: [[ quote .SecretKey ]]
+      volumeMounts:
+        - name: definitions
+          mountPath: /work
+---
+apiVersion: tekton.dev/v1
+kind: Pipeline
+metadata:
+  name: [[ quote .Name ]]
+spec:
+  params:
+    - name: environment
+      type: string
+      default: [[ quote .Environment ]]
+[[- range .Params ]]
+    - name: [[ quote .Name ]]
+      type: string
+      default: [[ quote .Default ]]
+[[- end ]]
+  workspaces:
+    - name: reports
+  tasks:
+[[- range $stage := .Stages ]]
+[[- if eq $stage "approve" ]]
+    # Needs the manual approval gate controller (github.com/openshift-pipelines/manual-approval-gate)
+    - name: approve
+      runAfter: ["plan"]
+      taskRef:
+        apiVersion: openshift-pipelines.org/v1alpha1
+        kind: ApprovalTask
+      params:
+        - name: approvers
+          value: [[ list $.Approvers ]]
+        - name: numberOfApprovalsRequired
+          value: "1"
+[[- else ]]
+    - name: [[ $stage ]]
+[[- if eq $stage "apply" ]]
+      runAfter: ["approve"]
+[[- end ]]
+      taskRef:
+        name: [[ quote $.TaskName ]]
+      workspaces:
+        - name: reports
+          workspace: reports
+      params:
+        - name: stage
+          value: [[ $stage ]]
+        - name: dry-run
+          value: "[[ eq $stage "plan" ]]"
+        - name: environment
+          value: $(params.environment)
+[[- range $.Params ]]
+        - name: [[ quote .Name ]]
+          value: $(params.[[ .Name ]])
+[[- end ]]
+[[- end ]]
+[[- end ]]
+`
@@ -0,0 +1,211 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/fixture"
+)
+
+// Drift describes how a table differs from the baseline
+type Drift struct {
+	Table string
+	// NotInBaseline is set when the baseline has no CREATE TABLE for the table
+	NotInBaseline bool
+	Removed       []string
+	Added         []string
+}
+
+func (d Drift) String() string {
+	if d.NotInBaseline {
+		return fmt.Sprintf("table %s is not defined in the schema baseline", d.Table)
+	}
+
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("table %s drifted from the schema baseline:", d.Table))
+	for _, line := range d.Removed {
+		buf.WriteString("\n  - " + line)
+	}
+	for _, line := range d.Added {
+		buf.WriteString("\n  + " + line)
+	}
+	return buf.String()
+}
+
+var (
+	createTablePattern   = regexp.MustCompile("(?is)^CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?([`\"\\w.]+)")
+	autoIncrementPattern = regexp.MustCompile(`(?i)\s*AUTO_INCREMENT=\d+`)
+)
+
+// Dump returns the CREATE TABLE statement of each table keyed by table name
+func Dump(ctx context.Context, db database.DB, tables []string) (map[string]string, error) {
+	ddl := make(map[string]string, len(tables))
+	for _, table := range tables {
+		var statement string
+		var err error
+		switch db.DriverName() {
+		case "mysql":
+			statement, err = dumpMySQL(ctx, db, table)
+		case "postgres":
+			statement, err = dumpPostgres(ctx, db, table)
+		default:
+			err = fmt.Errorf("unsupported driver: %s", db.DriverName())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump schema of %s: %w", table, err)
+		}
+		ddl[table] = statement
+	}
+	return ddl, nil
+}
+
+// Format renders dumped DDL as a baseline file
+func Format(ddl map[string]string) string {
+	tables := make([]string, 0, len(ddl))
+	for table := range ddl {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var buf strings.Builder
+	for _, table := range tables {
+		buf.WriteString(ddl[table])
+		buf.WriteString(";\n\n")
+	}
+	return buf.String()
+}
+
+// Compare diffs dumped DDL against the CREATE TABLE statements of a baseline script
+func Compare(baseline string, ddl map[string]string) []Drift {
+	expected := make(map[string]string)
+	for _, statement := range fixture.SplitStatements(baseline) {
+		if match := createTablePattern.FindStringSubmatch(statement); match != nil {
+			expected[normalizeName(match[1])] = statement
+		}
+	}
+
+	tables := make([]string, 0, len(ddl))
+	for table := range ddl {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var drifts []Drift
+	for _, table := range tables {
+		baselineDDL, ok := expected[normalizeName(table)]
+		if !ok {
+			drifts = append(drifts, Drift{Table: table, NotInBaseline: true})
+			continue
+		}
+
+		removed, added := diffLines(normalizeDDL(baselineDDL), normalizeDDL(ddl[table]))
+		if len(removed) > 0 || len(added) > 0 {
+			drifts = append(drifts, Drift{Table: table, Removed: removed, Added: added})
+		}
+	}
+	return drifts
+}
+
+func dumpMySQL(ctx context.Context, db database.DB, table string) (string, error) {
+	rows, err := db.QueryRowsContext(ctx, "SHOW CREATE TABLE "+database.QuoteIdentifier("mysql", table))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("table not found")
+	}
+	return toString(rows[0]["Create Table"]), nil
+}
+
+func dumpPostgres(ctx context.Context, db database.DB, table string) (string, error) {
+	columns, err := db.QueryRowsContext(ctx, `
+		SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type, a.attnotnull AS not_null,
+		       pg_get_expr(d.adbin, d.adrelid) AS default_value
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, table)
+	if err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("table not found")
+	}
+
+	constraints, err := db.QueryRowsContext(ctx, `
+		SELECT conname AS name, pg_get_constraintdef(oid) AS definition
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass
+		ORDER BY conname`, table)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, column := range columns {
+		line := fmt.Sprintf("  %s %s", toString(column["name"]), toString(column["type"]))
+		if notNull, ok := column["not_null"].(bool); ok && notNull {
+			line += " NOT NULL"
+		}
+		if column["default_value"] != nil {
+			line += " DEFAULT " + toString(column["default_value"])
+		}
+		lines = append(lines, line)
+	}
+	for _, constraint := range constraints {
+		lines = append(lines, fmt.Sprintf("  CONSTRAINT %s %s", toString(constraint["name"]), toString(constraint["definition"])))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)", table, strings.Join(lines, ",\n")), nil
+}
+
+// normalizeDDL returns the comparable lines of a CREATE TABLE statement
+func normalizeDDL(ddl string) []string {
+	ddl = autoIncrementPattern.ReplaceAllString(ddl, "")
+	ddl = strings.NewReplacer("`", "", `"`, "").Replace(ddl)
+
+	var lines []string
+	for _, line := range strings.Split(ddl, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		line = strings.TrimSuffix(line, ",")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.NewReplacer("`", "", `"`, "").Replace(name))
+}
+
+func diffLines(expected, actual []string) (removed, added []string) {
+	actualSet := make(map[string]bool, len(actual))
+	for _, line := range actual {
+		actualSet[line] = true
+	}
+	expectedSet := make(map[string]bool, len(expected))
+	for _, line := range expected {
+		expectedSet[line] = true
+		if !actualSet[line] {
+			removed = append(removed, line)
+		}
+	}
+	for _, line := range actual {
+		if !expectedSet[line] {
+			added = append(added, line)
+		}
+	}
+	return removed, added
+}
+
+func toString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprintf("%v", value)
+}
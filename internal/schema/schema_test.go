@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := "CREATE TABLE `users` (\n" +
+		"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(100) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=10 DEFAULT CHARSET=utf8mb4;\n"
+
+	t.Run("no drift", func(t *testing.T) {
+		ddl := map[string]string{
+			"users": "CREATE TABLE `users` (\n" +
+				"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(100) DEFAULT NULL,\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				") ENGINE=InnoDB AUTO_INCREMENT=42 DEFAULT CHARSET=utf8mb4",
+		}
+		if drifts := Compare(baseline, ddl); len(drifts) != 0 {
+			t.Errorf("expected no drift, got %v", drifts)
+		}
+	})
+
+	t.Run("column changed", func(t *testing.T) {
+		ddl := map[string]string{
+			"users": "CREATE TABLE `users` (\n" +
+				"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(255) DEFAULT NULL,\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		}
+		drifts := Compare(baseline, ddl)
+		if len(drifts) != 1 {
+			t.Fatalf("expected 1 drift, got %d", len(drifts))
+		}
+		if len(drifts[0].Removed) != 1 || drifts[0].Removed[0] != "name varchar(100) DEFAULT NULL" {
+			t.Errorf("unexpected removed lines: %v", drifts[0].Removed)
+		}
+		if len(drifts[0].Added) != 1 || drifts[0].Added[0] != "name varchar(255) DEFAULT NULL" {
+			t.Errorf("unexpected added lines: %v", drifts[0].Added)
+		}
+	})
+
+	t.Run("table missing from baseline", func(t *testing.T) {
+		drifts := Compare(baseline, map[string]string{"orders": "CREATE TABLE orders (id int)"})
+		if len(drifts) != 1 || !drifts[0].NotInBaseline {
+			t.Errorf("expected orders to be reported as not in baseline, got %v", drifts)
+		}
+	})
+}
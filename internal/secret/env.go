@@ -0,0 +1,152 @@
+package secret
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// EnvKeyVar holds the key of the encrypted env file, or a reference to it
+	EnvKeyVar = "OPSQL_ENV_KEY"
+	// EncryptedEnvFile is loaded after .env, like it, when it exists
+	EncryptedEnvFile = ".env.enc"
+
+	envHeader = "opsql-env:v1:"
+)
+
+// NewEnvKey returns a random key for EncryptEnv
+func NewEnvKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func envCipher(key string) (cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes in base64", EnvKeyVar)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptEnv encrypts the contents of an env file with AES-256-GCM
+func EncryptEnv(plain []byte, key string) ([]byte, error) {
+	aead, err := envCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(envHeader))
+	return []byte(envHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptEnv returns the contents of an env file encrypted by EncryptEnv
+func DecryptEnv(data []byte, key string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(string(data)), envHeader)
+	if !ok {
+		return nil, fmt.Errorf("not an encrypted env file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("not an encrypted env file: %w", err)
+	}
+	aead, err := envCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted env file is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(envHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the env file, wrong %s?", EnvKeyVar)
+	}
+	return plain, nil
+}
+
+// EnvKey returns the key of the encrypted env file from OPSQL_ENV_KEY, resolving it when it is a
+// reference such as keychain://
+func EnvKey(ctx context.Context) (string, error) {
+	key := os.Getenv(EnvKeyVar)
+	if key == "" {
+		return "", fmt.Errorf("%s is not set", EnvKeyVar)
+	}
+	return Resolve(ctx, key)
+}
+
+// LoadEncryptedEnv sets the variables of an encrypted env file that are not set yet, as .env is
+// loaded. A missing file is not an error.
+func LoadEncryptedEnv(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := EnvKey(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	plain, err := DecryptEnv(data, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	values, err := godotenv.UnmarshalBytes(plain)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, value := range values {
+		if _, exists := os.LookupEnv(name); !exists {
+			if err := os.Setenv(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveEnv replaces the environment variables whose value is a reference of a registered scheme
+// with the secret it points to, so that DSNs and tokens need not be kept in plain text
+func ResolveEnv(ctx context.Context) error {
+	var names []string
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if IsReference(value) && name != EnvKeyVar {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := Resolve(ctx, os.Getenv(name))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KeychainScheme prefixes references to a secret of the OS keychain: keychain://<service>/<account>
+const KeychainScheme = "keychain://"
+
+// ParseKeychain returns the service and account of a keychain:// reference
+func ParseKeychain(ref string) (string, string, error) {
+	rest, ok := strings.CutPrefix(ref, KeychainScheme)
+	if !ok {
+		return "", "", fmt.Errorf("not a keychain reference: %s", ref)
+	}
+	service, account, ok := strings.Cut(rest, "/")
+	if !ok || service == "" || account == "" || strings.Contains(account, "/") {
+		return "", "", fmt.Errorf("invalid keychain reference %q, expected %s<service>/<account>", ref, KeychainScheme)
+	}
+	return service, account, nil
+}
+
+// keychainLookup reads a secret of the OS keychain; tests replace it
+var keychainLookup = lookupKeychain
+
+// Keychain reads the secret a keychain:// reference points to: a generic password of the macOS
+// Keychain, a Windows Credential Manager generic credential named <service>/<account>, or an item of
+// the Secret Service (GNOME Keyring, KWallet) with the attributes service and account
+func Keychain(ctx context.Context, ref string) (string, error) {
+	service, account, err := ParseKeychain(ref)
+	if err != nil {
+		return "", err
+	}
+	return keychainLookup(ctx, service, account)
+}
@@ -0,0 +1,23 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func lookupKeychain(ctx context.Context, service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("security find-generic-password: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("security find-generic-password: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
@@ -0,0 +1,30 @@
+//go:build !darwin && !windows
+
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// lookupKeychain asks the Secret Service through secret-tool (libsecret)
+func lookupKeychain(ctx context.Context, service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("secret-tool lookup: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("secret-tool lookup: %w", err)
+	}
+	// secret-toolは見つからないときも成功して何も出力しない
+	if len(out) == 0 {
+		return "", fmt.Errorf("no item with service %s and account %s", service, account)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
@@ -0,0 +1,85 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretResolver reads the secret that a <scheme>://... reference of its scheme points to
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// ResolverFunc lets a function be a SecretResolver
+type ResolverFunc func(ctx context.Context, ref string) (string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = make(map[string]SecretResolver)
+)
+
+// Register makes the references of a scheme, given without ://, resolve with resolver. It replaces
+// the resolver already registered for the scheme, built-in ones included.
+func Register(scheme string, resolver SecretResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[strings.ToLower(scheme)] = resolver
+}
+
+// Schemes returns the registered schemes in name order
+func Schemes() []string {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	schemes := make([]string, 0, len(resolvers))
+	for scheme := range resolvers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func init() {
+	Register("keychain", ResolverFunc(Keychain))
+	Register("vault", ResolverFunc(Vault))
+	Register("awssm", ResolverFunc(AWSSecretsManager))
+}
+
+// resolverOf returns the resolver of the scheme value starts with
+func resolverOf(value string) (SecretResolver, bool) {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, false
+	}
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	resolver, ok := resolvers[strings.ToLower(scheme)]
+	return resolver, ok
+}
+
+// IsReference reports whether value is a reference of a registered scheme
+func IsReference(value string) bool {
+	_, ok := resolverOf(value)
+	return ok
+}
+
+// Resolve returns the secret a reference points to. Any other value, such as a DSN or a webhook
+// URL whose scheme is not registered, is returned as is.
+func Resolve(ctx context.Context, value string) (string, error) {
+	resolver, ok := resolverOf(value)
+	if !ok {
+		return value, nil
+	}
+	secret, err := resolver.Resolve(ctx, value)
+	if err != nil {
+		// 参照の中身は秘密ではないので、どれが読めなかったかを示す
+		return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+	}
+	return secret, nil
+}
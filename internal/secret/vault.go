@@ -0,0 +1,95 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Vault reads a field of a HashiCorp Vault secret referenced as vault://<path>#<field>, such as
+// vault://secret/data/opsql/prod#dsn, from VAULT_ADDR with VAULT_TOKEN or ~/.vault-token. The field
+// can be left out of secrets with a single one.
+func Vault(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(strings.TrimPrefix(ref, "vault://"), "#")
+	if path == "" {
+		return "", fmt.Errorf("expected vault://<path>#<field>")
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to parse the vault response: %w", err)
+	}
+	data := body.Data
+	// KV v2は値をdata.dataに、v1はdataに入れる
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = inner
+		}
+	}
+	return secretField(data, field)
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err == nil {
+		if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	return "", fmt.Errorf("VAULT_TOKEN is not set and there is no ~/.vault-token")
+}
+
+// secretField returns a field of a secret with several, or the value of a secret with one
+func secretField(data map[string]interface{}, field string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("the secret has %d fields, name one after #", len(data))
+		}
+		for name := range data {
+			field = name
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("the secret has no field %s", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,283 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRepository is where opsql releases are published
+	DefaultRepository = "pyama86/opsql"
+
+	checksumsName = "checksums.txt"
+	signatureName = checksumsName + ".sig"
+	// maxAssetSize caps downloads; release archives are a few tens of megabytes
+	maxAssetSize = 256 << 20
+)
+
+// Release is a GitHub release and the download URLs of its assets
+type Release struct {
+	Tag    string
+	Assets map[string]string
+}
+
+// Version returns the release's tag without its v prefix, as release archives are named
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.Tag, "v")
+}
+
+// Updater downloads opsql releases from GitHub and verifies them against the release's
+// checksums.txt and its signature
+type Updater struct {
+	client *http.Client
+	// APIURL is the GitHub API endpoint, overridable for GitHub Enterprise and tests
+	APIURL     string
+	Repository string
+	// Token authenticates API requests, which raises the rate limit
+	Token string
+	// PublicKey verifies the ed25519 signature of checksums.txt (checksums.txt.sig)
+	PublicKey ed25519.PublicKey
+	// AllowUnsigned lets Download verify only the checksum when there is no public key
+	AllowUnsigned bool
+}
+
+// NewUpdater returns an updater of the default repository. publicKey is the base64 ed25519 key
+// releases are signed with; without it downloads are refused unless AllowUnsigned is set.
+func NewUpdater(publicKey string) (*Updater, error) {
+	u := &Updater{
+		client:     &http.Client{Timeout: 5 * time.Minute},
+		APIURL:     "https://api.github.com",
+		Repository: DefaultRepository,
+		Token:      os.Getenv("GITHUB_TOKEN"),
+	}
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid release public key")
+		}
+		u.PublicKey = key
+	}
+	return u, nil
+}
+
+// Release returns the release of tag, or the latest release when tag is empty
+func (u *Updater) Release(ctx context.Context, tag string) (*Release, error) {
+	endpoint := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimSuffix(u.APIURL, "/"), u.Repository)
+	if tag != "" {
+		if !strings.HasPrefix(tag, "v") {
+			tag = "v" + tag
+		}
+		endpoint = fmt.Sprintf("%s/repos/%s/releases/tags/%s", strings.TrimSuffix(u.APIURL, "/"), u.Repository, tag)
+	}
+
+	body, err := u.get(ctx, endpoint, "application/vnd.github+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release: %w", err)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+
+	r := &Release{Tag: release.TagName, Assets: make(map[string]string, len(release.Assets))}
+	for _, asset := range release.Assets {
+		r.Assets[asset.Name] = asset.URL
+	}
+	return r, nil
+}
+
+// AssetName returns the name of the release archive for a platform
+func AssetName(version, goos, goarch string) string {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("opsql_%s_%s_%s%s", version, goos, goarch, ext)
+}
+
+// Download fetches the release's archive for the running platform, verifies it and returns
+// the opsql binary it contains
+func (u *Updater) Download(ctx context.Context, release *Release) ([]byte, error) {
+	name := AssetName(release.Version(), runtime.GOOS, runtime.GOARCH)
+	url, ok := release.Assets[name]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no archive for %s/%s (%s)", release.Tag, runtime.GOOS, runtime.GOARCH, name)
+	}
+	if u.PublicKey == nil && !u.AllowUnsigned {
+		return nil, fmt.Errorf("no release public key to verify the signature of release %s", release.Tag)
+	}
+	checksumsURL, ok := release.Assets[checksumsName]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", release.Tag, checksumsName)
+	}
+
+	checksums, err := u.get(ctx, checksumsURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", checksumsName, err)
+	}
+	if u.PublicKey != nil {
+		signatureURL, ok := release.Assets[signatureName]
+		if !ok {
+			return nil, fmt.Errorf("release %s is not signed (no %s)", release.Tag, signatureName)
+		}
+		signature, err := u.get(ctx, signatureURL, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", signatureName, err)
+		}
+		if !ed25519.Verify(u.PublicKey, checksums, signature) {
+			return nil, fmt.Errorf("signature of %s does not match the release public key", checksumsName)
+		}
+	}
+
+	archive, err := u.get(ctx, url, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if err := verifyChecksum(checksums, name, archive); err != nil {
+		return nil, err
+	}
+	return extractBinary(name, archive)
+}
+
+func (u *Updater) get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if u.Token != "" && strings.HasPrefix(url, u.APIURL) {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxAssetSize))
+}
+
+// verifyChecksum checks data against its line in a checksums.txt ("<sha256>  <name>")
+func verifyChecksum(checksums []byte, name string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[1] != name {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("checksum of %s does not match %s", name, checksumsName)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s has no checksum for %s", checksumsName, name)
+}
+
+// extractBinary returns the opsql executable in a release archive
+func extractBinary(name string, archive []byte) ([]byte, error) {
+	binary := "opsql"
+	if strings.HasSuffix(name, ".zip") {
+		binary = "opsql.exe"
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		for _, file := range reader.File {
+			if path.Base(file.Name) != binary {
+				continue
+			}
+			f, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = f.Close() }()
+			return io.ReadAll(io.LimitReader(f, maxAssetSize))
+		}
+		return nil, fmt.Errorf("%s has no %s", name, binary)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no %s", name, binary)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == binary {
+			return io.ReadAll(io.LimitReader(reader, maxAssetSize))
+		}
+	}
+}
+
+// Replace swaps the executable at path for binary. The new file is written next to it first,
+// so a failed update leaves the old one in place. Windows cannot overwrite a running executable,
+// so there the old one is moved aside to <path>.old.
+func Replace(executable string, binary []byte) error {
+	dir := filepath.Dir(executable)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(executable)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %w", dir, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(binary); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := executable + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(executable, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), executable); err != nil {
+			_ = os.Rename(old, executable)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), executable)
+}
@@ -0,0 +1,101 @@
+package selfupdate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two semantic versions such as 1.4.0 or v1.5.0-rc.1, returning -1, 0 or
+// +1 as a is older than, the same as or newer than b. ok is false when either is not a semantic
+// version, such as the dev of builds without a release version.
+func CompareVersions(a, b string) (result int, ok bool) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+
+	for i := range va.core {
+		if va.core[i] != vb.core[i] {
+			return sign(va.core[i] - vb.core[i]), true
+		}
+	}
+	return comparePrerelease(va.prerelease, vb.prerelease), true
+}
+
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+func parseVersion(v string) (semver, bool) {
+	v = strings.TrimPrefix(v, "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, prerelease, hasPrerelease := strings.Cut(v, "-")
+
+	var parsed semver
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		parsed.core[i] = n
+	}
+	if hasPrerelease {
+		if prerelease == "" {
+			return semver{}, false
+		}
+		parsed.prerelease = strings.Split(prerelease, ".")
+	}
+	return parsed, true
+}
+
+// comparePrerelease orders pre-releases as semver does: a release is newer than its pre-releases,
+// numeric identifiers compare as numbers and are older than alphanumeric ones
+func comparePrerelease(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, errA := strconv.Atoi(a[i])
+		nb, errB := strconv.Atoi(b[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return sign(na - nb)
+			}
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(len(a) - len(b))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/state"
+)
+
+// slackRequestMaxAge is how old a slash command may be, which keeps captured requests from being replayed
+const slackRequestMaxAge = 5 * time.Minute
+
+// chatUsage is the reply to /opsql help and to commands that cannot be parsed
+const chatUsage = "Usage:\n" +
+	"/opsql plan <definition>... [env=<environment>] [<param>=<value>...]\n" +
+	"/opsql approve <run id>\n" +
+	"/opsql status <run id>\n" +
+	"/opsql cancel <run id>"
+
+// PullRequestCommenter replies to the commands given in pull request comments
+type PullRequestCommenter interface {
+	Reply(ctx context.Context, repo string, pr int, body string) error
+}
+
+// ChatOps runs /opsql commands given as Slack slash commands or pull request comments
+type ChatOps struct {
+	// SlackSigningSecret verifies the slash commands posted to /chatops/slack
+	SlackSigningSecret string
+	// Approvers are the actors allowed to approve, as slack:<user ID> or github:<login>; anyone but
+	// the actor of the plan can when empty
+	Approvers []string
+	// Comments replies to pull request comments; the reports of plans and applies are commented
+	// by the runs themselves
+	Comments PullRequestCommenter
+	// Client posts to the response_url of slash commands
+	Client *http.Client
+}
+
+// Validate checks that every approver names where it is verified, so a GitHub login cannot pass
+// for a Slack user ID or the other way round
+func (c ChatOps) Validate() error {
+	for _, approver := range c.Approvers {
+		if !strings.HasPrefix(approver, slackActorPrefix) && !strings.HasPrefix(approver, githubActorPrefix) {
+			return fmt.Errorf("approver %q must be slack:<user ID> or github:<login>", approver)
+		}
+	}
+	return nil
+}
+
+// WithChatOps serves POST /chatops/slack and, with WithGitHubWebhook, runs the commands of
+// pull request comments
+func WithChatOps(chatops ChatOps) Option {
+	return func(s *Server) {
+		if chatops.Client == nil {
+			chatops.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		s.chatops = &chatops
+	}
+}
+
+// chatCommand is a parsed /opsql command
+type chatCommand struct {
+	action      string
+	args        []string
+	environment string
+	params      map[string]string
+}
+
+// parseChatCommand parses the text after /opsql; in plan, env= sets the environment and the
+// other key=value words are params
+func parseChatCommand(text string) (chatCommand, error) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return chatCommand{action: "help"}, nil
+	}
+
+	command := chatCommand{action: strings.ToLower(words[0])}
+	switch command.action {
+	case "help":
+	case "plan":
+		for _, word := range words[1:] {
+			key, value, ok := strings.Cut(word, "=")
+			switch {
+			case !ok:
+				command.args = append(command.args, word)
+			case key == "env" || key == "environment":
+				command.environment = value
+			default:
+				if command.params == nil {
+					command.params = make(map[string]string)
+				}
+				command.params[key] = value
+			}
+		}
+		if len(command.args) == 0 {
+			return chatCommand{}, errors.New("plan needs a definition")
+		}
+	case "approve", "status", "cancel":
+		if len(words) != 2 {
+			return chatCommand{}, fmt.Errorf("%s needs a run id", command.action)
+		}
+		command.args = words[1:]
+	default:
+		return chatCommand{}, fmt.Errorf("unknown command %q", words[0])
+	}
+	return command, nil
+}
+
+// execChat executes a command given by actor in the pull request repo#pr, or in Slack when pr is 0.
+// It returns the run the command started or looked up, or nil for help.
+func (s *Server) execChat(ctx context.Context, command chatCommand, actor, repo string, pr int) (*state.RunRecord, error) {
+	var run Run
+	var err error
+	switch command.action {
+	case "plan":
+		run, err = s.planRun(PlanRequest{Configs: command.args, Environment: command.environment, Params: command.params}, actor)
+	case "approve":
+		if err := s.checkChatApproval(ctx, command.args[0], actor, repo, pr); err != nil {
+			return nil, err
+		}
+		run, err = s.approveRun(ctx, command.args[0], actor)
+	case "status":
+		return s.run(ctx, command.args[0])
+	case "cancel":
+		record, err := s.cancel(ctx, command.args[0], actor)
+		if err != nil {
+			return nil, err
+		}
+		return &record, nil
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if pr != 0 {
+		run.GitHubRepo = repo
+		run.GitHubPR = pr
+	}
+	record := s.start(run)
+	return &record, nil
+}
+
+// checkChatApproval refuses approvals by anyone but the approvers, of plans made for a pull request
+// anywhere but in its comments and, in a pull request, of plans made for another pull request or
+// outside of it
+func (s *Server) checkChatApproval(ctx context.Context, id, actor, repo string, pr int) error {
+	if len(s.chatops.Approvers) > 0 && !slices.Contains(s.chatops.Approvers, actor) {
+		return &statusError{http.StatusForbidden, fmt.Errorf("%s is not allowed to approve", actor)}
+	}
+
+	plan, err := s.run(ctx, id)
+	if err != nil {
+		return err
+	}
+	// プルリクエストの計画はそのプルリクエストでだけ承認させ、Slackの別の名前で通させない
+	if plan.GitHubPR != 0 && pr == 0 {
+		return &statusError{http.StatusForbidden, fmt.Errorf("run %s was planned for %s#%d and can only be approved there", plan.ID, plan.GitHubRepo, plan.GitHubPR)}
+	}
+	if plan.GitHubRepo != repo || plan.GitHubPR != pr {
+		return &statusError{http.StatusForbidden, fmt.Errorf("run %s was not planned for %s#%d", plan.ID, repo, pr)}
+	}
+	return nil
+}
+
+// wait returns the run once it has finished
+func (s *Server) wait(ctx context.Context, id string) (*state.RunRecord, error) {
+	var last *state.RunRecord
+	err := s.follow(ctx, id,
+		func(record *state.RunRecord) error { last = record; return nil },
+		func(executor.ProgressEvent) error { return nil })
+	return last, err
+}
+
+// chatStarted is the reply to a command that started a run
+func chatStarted(record *state.RunRecord) string {
+	verb := "Applying"
+	if record.DryRun {
+		verb = "Planning"
+	}
+	return fmt.Sprintf("%s %s on %s as run %s", verb, record.Definition, environmentName(record.Environment), record.ID)
+}
+
+// chatSummary describes a run and the operations that failed
+func chatSummary(record *state.RunRecord) string {
+	kind := "apply"
+	if record.DryRun {
+		kind = "plan"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s of %s on %s: %s", kind, record.ID, record.Definition, environmentName(record.Environment), record.Status)
+	if record.Status == StatusRunning {
+		return b.String()
+	}
+
+	passed := 0
+	for _, report := range record.Reports {
+		if report.Pass {
+			passed++
+		}
+	}
+	fmt.Fprintf(&b, " (%d of %d operations passed)", passed, len(record.Reports))
+	for _, report := range record.Reports {
+		if !report.Pass {
+			fmt.Fprintf(&b, "\n✖ %s: %s", report.ID, report.Message)
+		}
+	}
+	if record.Error != "" {
+		fmt.Fprintf(&b, "\nerror: %s", record.Error)
+	}
+	if record.DryRun && record.Status == state.StatusPassed {
+		fmt.Fprintf(&b, "\nApply it with /opsql approve %s", record.ID)
+	}
+	return b.String()
+}
+
+func environmentName(environment string) string {
+	if environment == "" {
+		return "the default environment"
+	}
+	return environment
+}
+
+// slackMessage is the reply to a slash command
+type slackMessage struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+func (s *Server) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !validSlackSignature(s.chatops.SlackSigningSecret, body, r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), time.Now()) {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid signature"))
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+
+	reply := func(text string) {
+		writeJSON(w, http.StatusOK, slackMessage{ResponseType: "in_channel", Text: text})
+	}
+	command, err := parseChatCommand(form.Get("text"))
+	if err != nil {
+		// 誤入力はチャンネルに流さず本人にだけ返す
+		writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: err.Error() + "\n" + chatUsage})
+		return
+	}
+
+	// user_nameは本人が変えられるので、変わらないuser_idで承認者を見分ける
+	actor := "slack"
+	if userID := form.Get("user_id"); userID != "" {
+		actor = slackActorPrefix + userID
+	}
+	record, err := s.execChat(r.Context(), command, actor, "", 0)
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "error: " + err.Error()})
+	case record == nil:
+		writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: chatUsage})
+	case command.action == "plan" || command.action == "approve":
+		reply(chatStarted(record))
+		if responseURL := form.Get("response_url"); responseURL != "" {
+			s.replyWhenFinished(record.ID, responseURL)
+		}
+	default:
+		reply(chatSummary(record))
+	}
+}
+
+// replyWhenFinished posts the summary of the run to the slash command's response_url once it has finished
+func (s *Server) replyWhenFinished(id, responseURL string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		record, err := s.wait(context.Background(), id)
+		if err != nil {
+			log.Printf("failed to wait for run %s: %v", id, err)
+			return
+		}
+		if err := s.postSlackResponse(responseURL, slackMessage{ResponseType: "in_channel", Text: chatSummary(record)}); err != nil {
+			log.Printf("failed to reply with run %s: %v", id, err)
+		}
+	}()
+}
+
+func (s *Server) postSlackResponse(responseURL string, message slackMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := s.chatops.Client.Post(responseURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("response_url returned %s", resp.Status)
+	}
+	return nil
+}
+
+// validSlackSignature checks X-Slack-Signature, the HMAC of "v0:<timestamp>:<body>", and that the
+// request is recent
+func validSlackSignature(secret string, body []byte, timestamp, signature string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return false
+	}
+
+	given, ok := strings.CutPrefix(signature, "v0=")
+	if !ok {
+		return false
+	}
+	decoded, err := hex.DecodeString(given)
+	if err != nil {
+		return false
+	}
+
+	expected := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(expected, "v0:%s:", timestamp)
+	expected.Write(body)
+	return hmac.Equal(decoded, expected.Sum(nil))
+}
+
+type issueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int       `json:"number"`
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	Comment struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// trustedAssociations are the commenters whose commands run; anyone can comment on public repositories
+var trustedAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// handleGitHubCommand runs the /opsql command of a new pull request comment. Plans and applies
+// comment their reports on the pull request; other replies are comments of their own.
+func (s *Server) handleGitHubCommand(w http.ResponseWriter, r *http.Request, body []byte) {
+	var event issueCommentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+	text, ok := commentCommand(event.Comment.Body)
+	if event.Action != "created" || event.Issue.PullRequest == nil || !ok {
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "comment ignored"})
+		return
+	}
+	if !slices.Contains(trustedAssociations, event.Comment.AuthorAssociation) {
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "commenter is not a collaborator"})
+		return
+	}
+
+	repo, pr := event.Repository.FullName, event.Issue.Number
+	reply := func(text string) {
+		if s.chatops.Comments == nil {
+			return
+		}
+		if err := s.chatops.Comments.Reply(r.Context(), repo, pr, text); err != nil {
+			log.Printf("failed to reply on %s#%d: %v", repo, pr, err)
+		}
+	}
+
+	command, err := parseChatCommand(text)
+	if err != nil {
+		reply(fmt.Sprintf("%s\n\n```\n%s\n```", err, chatUsage))
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	actor := "github"
+	if login := event.Comment.User.Login; login != "" {
+		actor = githubActorPrefix + login
+	}
+	record, err := s.execChat(r.Context(), command, actor, repo, pr)
+	switch {
+	case err != nil:
+		reply("error: " + err.Error())
+		writeError(w, statusOf(err), err)
+	case record == nil:
+		reply("```\n" + chatUsage + "\n```")
+		writeJSON(w, http.StatusOK, map[string]string{"message": "usage"})
+	case command.action == "plan" || command.action == "approve":
+		writeJSON(w, http.StatusAccepted, record)
+	default:
+		reply("```\n" + chatSummary(record) + "\n```")
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+// commentCommand returns the text after /opsql on the first line of a comment that starts with it.
+// Later lines are ignored, so quoting a command in a reply does not run it again.
+func commentCommand(body string) (string, bool) {
+	line, _, _ := strings.Cut(body, "\n")
+	line = strings.TrimSpace(line)
+	if line == "/opsql" {
+		return "", true
+	}
+	return strings.CutPrefix(line, "/opsql ")
+}
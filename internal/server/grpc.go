@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	opsqlv1 "github.com/pyama86/opsql/api/opsql/v1"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/state"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer returns a gRPC server serving the opsql.v1.Opsql service over the same runs as the HTTP API
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.unaryAuth),
+		grpc.ChainStreamInterceptor(s.streamAuth),
+	)
+	g := grpc.NewServer(opts...)
+	opsqlv1.RegisterOpsqlServer(g, &grpcService{server: s})
+	return g
+}
+
+func (s *Server) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorizeGRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorizeGRPC(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizeGRPC returns the context carrying the actor of the call's token
+func (s *Server) authorizeGRPC(ctx context.Context) (context.Context, error) {
+	actor, ok := s.authenticate(firstMetadata(ctx, "authorization"))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return context.WithValue(ctx, actorKey{}, actor), nil
+}
+
+// authorizedStream is a stream whose context carries the actor of its token
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+type grpcService struct {
+	opsqlv1.UnimplementedOpsqlServer
+	server *Server
+}
+
+func (g *grpcService) ListDefinitions(ctx context.Context, req *opsqlv1.ListDefinitionsRequest) (*opsqlv1.ListDefinitionsResponse, error) {
+	definitions, err := g.server.definitions()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &opsqlv1.ListDefinitionsResponse{Definitions: definitions}, nil
+}
+
+func (g *grpcService) Plan(ctx context.Context, req *opsqlv1.PlanRequest) (*opsqlv1.Run, error) {
+	record, err := g.server.plan(PlanRequest{
+		Configs:     req.GetConfigs(),
+		Environment: req.GetEnvironment(),
+		Params:      req.GetParams(),
+	}, requestActor(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoRun(&record)
+}
+
+func (g *grpcService) Apply(ctx context.Context, req *opsqlv1.ApplyRequest) (*opsqlv1.Run, error) {
+	record, err := g.server.approve(ctx, req.GetPlanRunId(), requestActor(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoRun(&record)
+}
+
+func (g *grpcService) Cancel(ctx context.Context, req *opsqlv1.CancelRequest) (*opsqlv1.Run, error) {
+	record, err := g.server.cancel(ctx, req.GetId(), requestActor(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoRun(&record)
+}
+
+func (g *grpcService) GetRun(ctx context.Context, req *opsqlv1.GetRunRequest) (*opsqlv1.Run, error) {
+	record, err := g.server.run(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoRun(record)
+}
+
+func (g *grpcService) ListRuns(ctx context.Context, req *opsqlv1.ListRunsRequest) (*opsqlv1.ListRunsResponse, error) {
+	records, err := g.server.listRuns(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &opsqlv1.ListRunsResponse{}
+	for i := range records {
+		run, err := toProtoRun(&records[i])
+		if err != nil {
+			return nil, err
+		}
+		resp.Runs = append(resp.Runs, run)
+	}
+	return resp, nil
+}
+
+func (g *grpcService) WatchRun(req *opsqlv1.WatchRunRequest, stream grpc.ServerStreamingServer[opsqlv1.RunEvent]) error {
+	err := g.server.follow(stream.Context(), req.GetId(),
+		func(record *state.RunRecord) error {
+			run, err := toProtoRun(record)
+			if err != nil {
+				return err
+			}
+			return stream.Send(&opsqlv1.RunEvent{Event: &opsqlv1.RunEvent_Run{Run: run}})
+		},
+		func(event executor.ProgressEvent) error {
+			return stream.Send(&opsqlv1.RunEvent{Event: &opsqlv1.RunEvent_Progress{Progress: toProtoProgress(event)}})
+		})
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if ctxErr := stream.Context().Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return grpcError(err)
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcError maps the errors of the HTTP API to gRPC codes
+func grpcError(err error) error {
+	switch statusOf(err) {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func toProtoRun(record *state.RunRecord) (*opsqlv1.Run, error) {
+	run := &opsqlv1.Run{
+		Id:          record.ID,
+		Definition:  record.Definition,
+		Configs:     record.Configs,
+		Params:      record.Params,
+		Checksum:    record.Checksum,
+		Environment: record.Environment,
+		Actor:       record.Actor,
+		DryRun:      record.DryRun,
+		PlanRunId:   record.PlanRunID,
+		Status:      record.Status,
+		Error:       record.Error,
+		StartedAt:   timestamppb.New(record.StartedAt),
+	}
+	if !record.FinishedAt.IsZero() {
+		run.FinishedAt = timestamppb.New(record.FinishedAt)
+	}
+
+	for _, report := range record.Reports {
+		r, err := toProtoReport(report)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to convert report %s: %v", report.ID, err)
+		}
+		run.Reports = append(run.Reports, r)
+	}
+	return run, nil
+}
+
+func toProtoProgress(event executor.ProgressEvent) *opsqlv1.ProgressEvent {
+	return &opsqlv1.ProgressEvent{
+		Type:        event.Type,
+		OperationId: event.OperationID,
+		Index:       int32(event.Index),
+		Total:       int32(event.Total),
+		Pass:        event.Pass,
+		Affected:    event.Affected,
+		DurationMs:  event.DurationMS,
+		Message:     event.Message,
+		Time:        timestamppb.New(event.Time),
+	}
+}
+
+func toProtoReport(report definition.Report) (*opsqlv1.Report, error) {
+	r := &opsqlv1.Report{
+		Id:          report.ID,
+		Description: report.Description,
+		Type:        report.Type,
+		Sql:         report.SQL,
+		Pass:        report.Pass,
+		Message:     report.Message,
+		DurationMs:  report.DurationMS,
+		Warnings:    report.Warnings,
+		Owner:       report.Owner,
+		File:        report.File,
+	}
+
+	var err error
+	if r.Expected, err = toProtoValue(report.Expected); err != nil {
+		return nil, err
+	}
+	if r.Result, err = toProtoValue(report.Result); err != nil {
+		return nil, err
+	}
+
+	if failure := report.Failure; failure != nil {
+		f := &opsqlv1.Failure{Code: failure.Code, Column: failure.Column}
+		if failure.Row != nil {
+			row := int32(*failure.Row)
+			f.Row = &row
+		}
+		if f.Expected, err = toProtoValue(failure.Expected); err != nil {
+			return nil, err
+		}
+		if f.Actual, err = toProtoValue(failure.Actual); err != nil {
+			return nil, err
+		}
+		if f.ExpectedRow, err = toProtoStruct(failure.ExpectedRow); err != nil {
+			return nil, err
+		}
+		if f.ActualRow, err = toProtoStruct(failure.ActualRow); err != nil {
+			return nil, err
+		}
+		r.Failure = f
+	}
+	return r, nil
+}
+
+// toProtoValue converts through JSON, so values are seen the same as in JSON reports
+func toProtoValue(v interface{}) (*structpb.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	value := &structpb.Value{}
+	if err := value.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func toProtoStruct(row map[string]interface{}) (*structpb.Struct, error) {
+	if row == nil {
+		return nil, nil
+	}
+	value, err := toProtoValue(row)
+	if err != nil {
+		return nil, err
+	}
+	return value.GetStructValue(), nil
+}
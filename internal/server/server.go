@@ -0,0 +1,599 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/state"
+)
+
+// StatusRunning marks runs that are still executing; they are not in the history yet
+const StatusRunning = "running"
+
+// Run is a run the server asks the Runner to execute
+type Run struct {
+	ID          string
+	Configs     []string
+	Params      map[string]string
+	Environment string
+	DryRun      bool
+	Actor       string
+	// PlanRunID is set on applies approved from a dry run
+	PlanRunID string
+	// GitHubRepo and GitHubPR receive the result comment of runs triggered by a pull request
+	GitHubRepo string
+	GitHubPR   int
+	// Progress receives the progress events of the run and Cancel is closed when it is
+	// cancelled; the server sets them
+	Progress executor.ProgressFunc
+	Cancel   <-chan struct{}
+}
+
+// Runner executes a run and records it in the history under run.ID
+type Runner func(ctx context.Context, run Run) error
+
+// Server serves the HTTP API and web UI of server mode
+type Server struct {
+	history   *state.History
+	runner    Runner
+	configDir string
+	tokens    map[string]string // API tokens and the actor each one's callers are recorded as
+	webhook   *GitHubWebhook
+	chatops   *ChatOps
+
+	mu     sync.Mutex
+	active map[string]*activeRun
+	wg     sync.WaitGroup
+}
+
+// activeRun is a run that has not finished yet; done is closed once it is in the history
+type activeRun struct {
+	record state.RunRecord
+	done   chan struct{}
+	// events is the progress so far; changed is closed and replaced on each new event
+	events  []executor.ProgressEvent
+	changed chan struct{}
+	// cancel is closed once the run is cancelled
+	cancel    chan struct{}
+	cancelled bool
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithToken requires "Authorization: Bearer <token>" on API requests. Its callers share the
+// actor opsql-server, so they cannot approve each other's plans; use WithTokens for that.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		if token != "" {
+			s.tokens[token] = apiActor
+		}
+	}
+}
+
+// WithTokens accepts the token of each name in tokens on API requests and records its callers
+// with the name as their actor, as api:<name>
+func WithTokens(tokens map[string]string) Option {
+	return func(s *Server) {
+		for name, token := range tokens {
+			s.tokens[token] = apiActorPrefix + name
+		}
+	}
+}
+
+// New returns a server running definitions under configDir
+func New(history *state.History, runner Runner, configDir string, opts ...Option) *Server {
+	s := &Server{
+		history:   history,
+		runner:    runner,
+		configDir: configDir,
+		tokens:    make(map[string]string),
+		active:    make(map[string]*activeRun),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Handler returns the routes of the API and the UI
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/definitions", s.auth(s.handleDefinitions))
+	mux.HandleFunc("GET /api/runs", s.auth(s.handleListRuns))
+	mux.HandleFunc("GET /api/runs/{id}", s.auth(s.handleGetRun))
+	mux.HandleFunc("GET /api/runs/{id}/events", s.auth(s.handleRunEvents))
+	mux.HandleFunc("POST /api/plan", s.auth(s.handlePlan))
+	mux.HandleFunc("POST /api/runs/{id}/approve", s.auth(s.handleApprove))
+	mux.HandleFunc("POST /api/runs/{id}/cancel", s.auth(s.handleCancel))
+	if s.webhook != nil {
+		mux.HandleFunc("POST /webhooks/github", s.handleGitHubWebhook)
+	}
+	if s.chatops != nil && s.chatops.SlackSigningSecret != "" {
+		mux.HandleFunc("POST /chatops/slack", s.handleSlackCommand)
+	}
+	mux.Handle("GET /", uiHandler())
+	return mux
+}
+
+// Wait blocks until the runs started by the server have finished
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// PlanRequest is the body of POST /api/plan
+type PlanRequest struct {
+	Configs     []string          `json:"configs"`
+	Environment string            `json:"environment"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+func (s *Server) handleDefinitions(w http.ResponseWriter, r *http.Request) {
+	definitions, err := s.definitions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, definitions)
+}
+
+// definitions returns the definition files under the config directory
+func (s *Server) definitions() ([]string, error) {
+	return definition.FindDefinitions(s.configDir)
+}
+
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.listRuns(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// listRuns returns the runs newest first, running ones on top, without reports
+func (s *Server) listRuns(ctx context.Context) ([]state.RunRecord, error) {
+	records, err := s.history.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := s.activeRuns()
+	for i := len(records) - 1; i >= 0; i-- {
+		records[i].Reports = nil
+		runs = append(runs, records[i])
+	}
+	return runs, nil
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	record, err := s.run(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+// handleRunEvents streams the run's progress as server-sent events: "run" with the run when
+// the stream starts and once it has finished, and "progress" with each operation event
+func (s *Server) handleRunEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	started := false
+	send := func(name string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	err := s.follow(r.Context(), r.PathValue("id"),
+		func(record *state.RunRecord) error { return send("run", record) },
+		func(event executor.ProgressEvent) error { return send("progress", event) })
+	if err != nil && !started {
+		writeError(w, statusOf(err), err)
+	}
+}
+
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	run, err := s.plan(req, requestActor(r.Context()))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	run, err := s.approve(r.Context(), r.PathValue("id"), requestActor(r.Context()))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	run, err := s.cancel(r.Context(), r.PathValue("id"), requestActor(r.Context()))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// plan starts a dry run of the requested definitions
+func (s *Server) plan(req PlanRequest, actor string) (state.RunRecord, error) {
+	run, err := s.planRun(req, actor)
+	if err != nil {
+		return state.RunRecord{}, err
+	}
+	return s.start(run), nil
+}
+
+// planRun returns the dry run of the requested definitions
+func (s *Server) planRun(req PlanRequest, actor string) (Run, error) {
+	if len(req.Configs) == 0 {
+		return Run{}, &statusError{http.StatusBadRequest, errors.New("configs is required")}
+	}
+
+	configs, err := s.resolveConfigs(req.Configs)
+	if err != nil {
+		return Run{}, &statusError{http.StatusBadRequest, err}
+	}
+
+	return Run{
+		ID:          state.NewRunID(),
+		Configs:     configs,
+		Params:      req.Params,
+		Environment: req.Environment,
+		DryRun:      true,
+		Actor:       actor,
+	}, nil
+}
+
+// approve applies a passed dry run, provided the definitions did not change since
+func (s *Server) approve(ctx context.Context, id, actor string) (state.RunRecord, error) {
+	run, err := s.approveRun(ctx, id, actor)
+	if err != nil {
+		return state.RunRecord{}, err
+	}
+	return s.start(run), nil
+}
+
+// approveRun returns the apply of a passed dry run and records the approval, so that each
+// plan is applied at most once and never by the actor who planned it
+func (s *Server) approveRun(ctx context.Context, id, actor string) (Run, error) {
+	plan, err := s.run(ctx, id)
+	if err != nil {
+		return Run{}, err
+	}
+	if !plan.DryRun || plan.Status != state.StatusPassed {
+		return Run{}, &statusError{http.StatusConflict, fmt.Errorf("run %s is not a passed dry run", plan.ID)}
+	}
+	if plan.Actor == actor {
+		return Run{}, &statusError{http.StatusForbidden, fmt.Errorf("%s planned run %s and cannot approve it", actor, plan.ID)}
+	}
+
+	checksum, err := definition.Checksum(plan.Configs)
+	if err != nil {
+		return Run{}, err
+	}
+	if checksum != plan.Checksum {
+		return Run{}, &statusError{http.StatusConflict, fmt.Errorf("the definitions changed since run %s, plan again", plan.ID)}
+	}
+
+	run := Run{
+		ID:          state.NewRunID(),
+		Configs:     plan.Configs,
+		Params:      plan.Params,
+		Environment: plan.Environment,
+		Actor:       actor,
+		PlanRunID:   plan.ID,
+	}
+	err = s.history.ClaimApproval(ctx, state.Approval{PlanRunID: plan.ID, RunID: run.ID, Actor: actor, ApprovedAt: time.Now().UTC()})
+	var approved *state.ApprovedError
+	if errors.As(err, &approved) {
+		return Run{}, &statusError{http.StatusConflict, err}
+	}
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to record the approval of run %s: %w", plan.ID, err)
+	}
+	return run, nil
+}
+
+// start executes the run in the background and returns its running record
+func (s *Server) start(run Run) state.RunRecord {
+	record := state.RunRecord{
+		ID:          run.ID,
+		Definition:  definition.Name(run.Configs),
+		Configs:     run.Configs,
+		Params:      run.Params,
+		Environment: run.Environment,
+		Actor:       run.Actor,
+		DryRun:      run.DryRun,
+		PlanRunID:   run.PlanRunID,
+		GitHubRepo:  run.GitHubRepo,
+		GitHubPR:    run.GitHubPR,
+		Status:      StatusRunning,
+		StartedAt:   time.Now().UTC(),
+	}
+
+	active := &activeRun{record: record, done: make(chan struct{}), changed: make(chan struct{}), cancel: make(chan struct{})}
+	run.Cancel = active.cancel
+	run.Progress = func(event executor.ProgressEvent) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		active.events = append(active.events, event)
+		close(active.changed)
+		active.changed = make(chan struct{})
+	}
+
+	s.mu.Lock()
+	s.active[run.ID] = active
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.active, run.ID)
+			s.mu.Unlock()
+			close(active.done)
+		}()
+
+		err := s.runner(context.Background(), run)
+		if err == nil {
+			return
+		}
+		log.Printf("run %s failed: %v", run.ID, err)
+
+		// 定義の読み込み失敗などで履歴に残らなかった実行も一覧に出す
+		if _, getErr := s.history.GetRun(context.Background(), run.ID); errors.Is(getErr, state.ErrNotFound) {
+			aborted := record
+			aborted.Status = state.StatusAborted
+			aborted.Error = err.Error()
+			aborted.FinishedAt = time.Now().UTC()
+			if err := s.history.SaveRun(context.Background(), aborted); err != nil {
+				log.Printf("failed to record run %s: %v", run.ID, err)
+			}
+		}
+	}()
+
+	return record
+}
+
+// cancel makes a running run stop before its next operation
+func (s *Server) cancel(ctx context.Context, id, actor string) (state.RunRecord, error) {
+	s.mu.Lock()
+	active, ok := s.active[id]
+	if ok && !active.cancelled {
+		active.cancelled = true
+		close(active.cancel)
+		log.Printf("run %s cancelled by %s", id, actor)
+	}
+	s.mu.Unlock()
+	if ok {
+		return active.record, nil
+	}
+
+	if _, err := s.history.GetRun(ctx, id); err != nil {
+		return state.RunRecord{}, err
+	}
+	return state.RunRecord{}, &statusError{http.StatusConflict, fmt.Errorf("run %s is not running", id)}
+}
+
+func (s *Server) run(ctx context.Context, id string) (*state.RunRecord, error) {
+	s.mu.Lock()
+	active, ok := s.active[id]
+	s.mu.Unlock()
+	if ok {
+		record := active.record
+		return &record, nil
+	}
+	return s.history.GetRun(ctx, id)
+}
+
+// follow passes the run to onRun, then each of its progress events to onProgress as they
+// happen, and the run again once it has finished. A finished run is passed only once.
+func (s *Server) follow(ctx context.Context, id string, onRun func(*state.RunRecord) error, onProgress func(executor.ProgressEvent) error) error {
+	s.mu.Lock()
+	active, ok := s.active[id]
+	s.mu.Unlock()
+	if !ok {
+		record, err := s.history.GetRun(ctx, id)
+		if err != nil {
+			return err
+		}
+		return onRun(record)
+	}
+
+	record := active.record
+	if err := onRun(&record); err != nil {
+		return err
+	}
+
+	sent := 0
+	for {
+		s.mu.Lock()
+		events := active.events[sent:]
+		changed := active.changed
+		s.mu.Unlock()
+
+		for _, event := range events {
+			if err := onProgress(event); err != nil {
+				return err
+			}
+		}
+		sent += len(events)
+
+		select {
+		case <-changed:
+			continue
+		case <-active.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// 終了までに届いたイベントを送り切ってから結果を返す
+		s.mu.Lock()
+		events = active.events[sent:]
+		s.mu.Unlock()
+		for _, event := range events {
+			if err := onProgress(event); err != nil {
+				return err
+			}
+		}
+
+		finished, err := s.history.GetRun(ctx, id)
+		if err != nil {
+			return err
+		}
+		return onRun(finished)
+	}
+}
+
+func (s *Server) activeRuns() []state.RunRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := make([]state.RunRecord, 0, len(s.active))
+	for _, active := range s.active {
+		runs = append(runs, active.record)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs
+}
+
+// resolveConfigs turns paths relative to the config directory into paths that cannot leave it
+func (s *Server) resolveConfigs(configs []string) ([]string, error) {
+	resolved := make([]string, len(configs))
+	for i, config := range configs {
+		if !filepath.IsLocal(config) {
+			return nil, fmt.Errorf("config must be a relative path inside the config directory: %s", config)
+		}
+		path := filepath.Join(s.configDir, config)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("config not found: %s", config)
+		}
+		resolved[i] = path
+	}
+	return resolved, nil
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := s.authenticate(r.Header.Get("Authorization"))
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actor)))
+	}
+}
+
+// authenticate returns the actor of the bearer token in authorization. Every token is compared,
+// so the time taken does not tell which one came close.
+func (s *Server) authenticate(authorization string) (string, bool) {
+	if len(s.tokens) == 0 {
+		return apiActor, true
+	}
+
+	given, _ := strings.CutPrefix(authorization, "Bearer ")
+	actor := ""
+	for token, name := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			actor = name
+		}
+	}
+	return actor, actor != ""
+}
+
+// apiActor is the actor of API callers without a token of their own. Nothing callers send about
+// themselves is verified, so no caller-supplied name is recorded. It has no prefix, so no named
+// token stands for it.
+const apiActor = "opsql-server"
+
+// Actors are prefixed with where they were verified, so a Slack user ID, a GitHub login and the
+// name of an API token never stand for one another
+const (
+	apiActorPrefix    = "api:"
+	slackActorPrefix  = "slack:"
+	githubActorPrefix = "github:"
+)
+
+// actorKey is the context key of the actor of an authenticated API request
+type actorKey struct{}
+
+// requestActor returns the actor the request was authenticated as
+func requestActor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return apiActor
+}
+
+// statusError is an error the client caused, with the HTTP status it answers
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+func statusOf(err error) int {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status
+	}
+	if errors.Is(err, state.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
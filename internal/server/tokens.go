@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pyama86/opsql/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadTokens reads a tokens file mapping each API caller's name to its token, for WithTokens.
+// Tokens may be secret references such as vault://path#field.
+func LoadTokens(ctx context.Context, path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %s %w", path, err)
+	}
+
+	var tokens map[string]string
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file: %s %w", path, err)
+	}
+
+	names := make(map[string]string, len(tokens))
+	for name, token := range tokens {
+		if token, err = secret.Resolve(ctx, token); err != nil {
+			return nil, fmt.Errorf("tokens file: %s: %w", name, err)
+		}
+		if token == "" {
+			return nil, fmt.Errorf("tokens file: %s has an empty token", name)
+		}
+		// 同じトークンを二人に配ると、どちらが計画したか区別できない
+		if other, ok := names[token]; ok {
+			return nil, fmt.Errorf("tokens file: %s and %s share a token", other, name)
+		}
+		names[token] = name
+		tokens[name] = token
+	}
+	return tokens, nil
+}
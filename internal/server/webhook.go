@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/state"
+)
+
+// maxWebhookBody bounds the size of webhook payloads
+const maxWebhookBody = 10 << 20
+
+// PullRequestFiles reads pull requests from GitHub
+type PullRequestFiles interface {
+	// ChangedFiles returns the files the pull request adds or modifies
+	ChangedFiles(ctx context.Context, repo string, pr int) ([]string, error)
+	// FileContent returns a file at ref; the error wraps fs.ErrNotExist when there is no such file
+	FileContent(ctx context.Context, repo, path, ref string) ([]byte, error)
+}
+
+// GitHubWebhook plans the runbooks a pull request changes and comments the result on it
+type GitHubWebhook struct {
+	// Secret verifies X-Hub-Signature-256
+	Secret string
+	// Paths select the runbooks: path.Match patterns, or directory prefixes ending with /
+	Paths []string
+	// Environment is the environment the plans run against
+	Environment string
+	Files       PullRequestFiles
+	// WorkDir receives the runbooks of each delivery, with the templates and files they use
+	WorkDir string
+}
+
+// WithGitHubWebhook serves POST /webhooks/github
+func WithGitHubWebhook(hook GitHubWebhook) Option {
+	return func(s *Server) {
+		s.webhook = &hook
+	}
+}
+
+type pullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		AuthorAssociation string `json:"author_association"`
+		Head              struct {
+			SHA  string `json:"sha"`
+			Repo *struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !validSignature(s.webhook.Secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid signature"))
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "ping":
+		writeJSON(w, http.StatusOK, map[string]string{"message": "pong"})
+		return
+	case "pull_request":
+	case "issue_comment":
+		if s.chatops != nil {
+			s.handleGitHubCommand(w, r, body)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "event ignored"})
+		return
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "event ignored"})
+		return
+	}
+
+	var event pullRequestEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+	switch event.Action {
+	case "opened", "synchronize", "reopened":
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "action ignored"})
+		return
+	}
+	// フォークや外部からのPRのrunbookはサーバーの資格情報で実行しない
+	if !slices.Contains(trustedAssociations, event.PullRequest.AuthorAssociation) {
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "pull request author is not a collaborator"})
+		return
+	}
+	if event.PullRequest.Head.Repo == nil || event.PullRequest.Head.Repo.FullName != event.Repository.FullName {
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "pull request from another repository ignored"})
+		return
+	}
+
+	runID := state.NewRunID()
+	configs, err := s.fetchRunbooks(r.Context(), event, runID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if len(configs) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "no runbooks changed"})
+		return
+	}
+
+	actor := "github"
+	if login := event.Sender.Login; login != "" {
+		actor = githubActorPrefix + login
+	}
+	run := s.start(Run{
+		ID:          runID,
+		Configs:     configs,
+		Environment: s.webhook.Environment,
+		DryRun:      true,
+		Actor:       actor,
+		GitHubRepo:  event.Repository.FullName,
+		GitHubPR:    event.Number,
+	})
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// fetchRunbooks writes the changed runbooks at the head commit, with the operation templates and
+// files they use, into a directory of the delivery's own, named after the run that plans them
+func (s *Server) fetchRunbooks(ctx context.Context, event pullRequestEvent, runID string) ([]string, error) {
+	repo := event.Repository.FullName
+	changed, err := s.webhook.Files.ChangedFiles(ctx, repo, event.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	var runbooks []string
+	for _, file := range changed {
+		if s.webhook.matches(file) && filepath.IsLocal(filepath.FromSlash(file)) {
+			runbooks = append(runbooks, file)
+		}
+	}
+	if len(runbooks) == 0 {
+		return nil, nil
+	}
+
+	prDir := filepath.Join(s.webhook.WorkDir, filepath.FromSlash(repo), fmt.Sprintf("pr-%d", event.Number))
+	if err := s.pruneDeliveries(ctx, prDir); err != nil {
+		return nil, err
+	}
+
+	fetch := &deliveryFetch{files: s.webhook.Files, repo: repo, ref: event.PullRequest.Head.SHA, dir: filepath.Join(prDir, runID), fetched: make(map[string][]byte)}
+	var configs []string
+	for _, file := range runbooks {
+		content, err := fetch.file(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		if err := fetch.dependencies(ctx, file, content); err != nil {
+			return nil, err
+		}
+		configs = append(configs, filepath.Join(fetch.dir, filepath.FromSlash(file)))
+	}
+	return configs, nil
+}
+
+// pruneDeliveries removes the directories of the pull request's earlier deliveries, which a new
+// delivery supersedes, once their plans are recorded and no apply of them is running. The
+// directories of deliveries still being fetched have no record yet and are kept.
+func (s *Server) pruneDeliveries(ctx context.Context, prDir string) error {
+	entries, err := os.ReadDir(prDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || s.inUse(id) {
+			continue
+		}
+		if _, err := s.history.GetRun(ctx, id); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(prDir, id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inUse reports whether the run, or an apply approved from it, is running
+func (s *Server) inUse(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, active := range s.active {
+		if active.record.ID == id || active.record.PlanRunID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryFetch writes files of the repository at ref under dir, each once
+type deliveryFetch struct {
+	files   PullRequestFiles
+	repo    string
+	ref     string
+	dir     string
+	fetched map[string][]byte
+}
+
+// file writes a file of the repository and returns its content
+func (f *deliveryFetch) file(ctx context.Context, file string) ([]byte, error) {
+	if content, ok := f.fetched[file]; ok {
+		return content, nil
+	}
+	content, err := f.files.FileContent(ctx, f.repo, file, f.ref)
+	if err != nil {
+		return nil, err
+	}
+	f.fetched[file] = content
+
+	dest := filepath.Join(f.dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(dest, content, 0644); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// optionalFile writes a file of the repository if it exists; a missing file is left for the
+// plan to report
+func (f *deliveryFetch) optionalFile(ctx context.Context, file string) ([]byte, bool, error) {
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return nil, false, nil
+	}
+	content, err := f.file(ctx, file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	return content, err == nil, err
+}
+
+// dependencies writes the files a runbook reads and the operation templates it uses, looked up
+// like definition.FindTemplate does in the templates/ directories of its directory and its parents
+func (f *deliveryFetch) dependencies(ctx context.Context, file string, content []byte) error {
+	deps := definition.ParseDependencies(content)
+	for _, name := range deps.Files {
+		if _, _, err := f.optionalFile(ctx, path.Join(path.Dir(file), name)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range deps.Templates {
+		if strings.ContainsAny(name, `/\`) {
+			continue
+		}
+	search:
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			for _, ext := range []string{".yaml", ".yml"} {
+				tmplPath := path.Join(dir, definition.TemplatesDirName, name+ext)
+				tmpl, found, err := f.optionalFile(ctx, tmplPath)
+				if err != nil {
+					return err
+				}
+				if found {
+					for _, read := range definition.ParseDependencies(tmpl).Files {
+						if _, _, err := f.optionalFile(ctx, path.Join(path.Dir(tmplPath), read)); err != nil {
+							return err
+						}
+					}
+					break search
+				}
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (h *GitHubWebhook) matches(file string) bool {
+	if ext := path.Ext(file); ext != ".yaml" && ext != ".yml" {
+		return false
+	}
+	if slices.Contains(strings.Split(path.Dir(file), "/"), definition.TemplatesDirName) {
+		return false
+	}
+	if len(h.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range h.Paths {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(file, pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validSignature(secret string, body []byte, signature string) bool {
+	given, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	expected := hmac.New(sha256.New, []byte(secret))
+	expected.Write(body)
+
+	decoded, err := hex.DecodeString(given)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(decoded, expected.Sum(nil))
+}
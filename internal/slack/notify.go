@@ -1,15 +1,31 @@
 package slack
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/sqlformat"
 	"github.com/slack-go/slack"
 )
 
+// metadataEventType is the event type of the message metadata opsql attaches to bot messages,
+// by which a retry finds the message to update
+const metadataEventType = "opsql_run"
+
+// historyWindow is how far back a retry looks for the message of its idempotency key
+const historyWindow = 7 * 24 * time.Hour
+
 type Client struct {
 	webhookURL string
+	// api and channel post with a bot token, which can update messages
+	api     *slack.Client
+	channel string
+	key     string
 }
 
 func NewClient(webhookURL string) *Client {
@@ -19,6 +35,20 @@ func NewClient(webhookURL string) *Client {
 	return &Client{webhookURL: webhookURL}
 }
 
+// NewBotClient posts to channel with a bot token (chat:write and channels:history scopes), which
+// unlike webhooks can update the message of an idempotency key
+func NewBotClient(token, channel string, options ...slack.Option) *Client {
+	return &Client{api: slack.New(token, options...), channel: channel}
+}
+
+// WithIdempotencyKey makes a bot client update the message posted with the same key, as by an
+// earlier attempt of a retried CI job, instead of posting another one. Webhooks cannot update
+// messages and ignore it.
+func (c *Client) WithIdempotencyKey(key string) *Client {
+	c.key = key
+	return c
+}
+
 func (c *Client) SendNotification(reports []definition.Report) error {
 	return c.SendNotificationWithContext(reports, false, "")
 }
@@ -28,6 +58,10 @@ func (c *Client) SendNotificationWithContext(reports []definition.Report, isDryR
 }
 
 func (c *Client) SendNotificationWithContextAndError(reports []definition.Report, isDryRun bool, environment string, executionErr error) error {
+	if c.api != nil {
+		blocks := c.buildBlocksWithContextAndError(reports, isDryRun, environment, executionErr)
+		return c.postMessage(context.Background(), blocks)
+	}
 	if c.webhookURL == "" {
 		return fmt.Errorf("SLACK_WEBHOOK_URL is not set")
 	}
@@ -43,6 +77,59 @@ func (c *Client) SendNotificationWithContextAndError(reports []definition.Report
 	return slack.PostWebhook(c.webhookURL, msg)
 }
 
+// postMessage posts the blocks with the bot token, or updates the message of the client's
+// idempotency key when the channel's recent history has one
+func (c *Client) postMessage(ctx context.Context, blocks []slack.Block) error {
+	options := []slack.MsgOption{
+		slack.MsgOptionBlocks(blocks...),
+		slack.MsgOptionText("opsql Execution Results", false),
+	}
+	if c.key == "" {
+		_, _, err := c.api.PostMessageContext(ctx, c.channel, options...)
+		return err
+	}
+
+	options = append(options, slack.MsgOptionMetadata(slack.SlackMetadata{
+		EventType:    metadataEventType,
+		EventPayload: map[string]interface{}{"key": c.key},
+	}))
+	timestamp, err := c.findMessage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to search channel history: %w", err)
+	}
+	if timestamp != "" {
+		_, _, _, err := c.api.UpdateMessageContext(ctx, c.channel, timestamp, options...)
+		return err
+	}
+	_, _, err = c.api.PostMessageContext(ctx, c.channel, options...)
+	return err
+}
+
+// findMessage returns the timestamp of the message posted with the client's idempotency key
+func (c *Client) findMessage(ctx context.Context) (string, error) {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID:          c.channel,
+		Oldest:             strconv.FormatInt(time.Now().Add(-historyWindow).Unix(), 10),
+		Limit:              200,
+		IncludeAllMetadata: true,
+	}
+	for {
+		history, err := c.api.GetConversationHistoryContext(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		for _, message := range history.Messages {
+			if message.Metadata.EventType == metadataEventType && message.Metadata.EventPayload["key"] == c.key {
+				return message.Timestamp, nil
+			}
+		}
+		if !history.HasMore || history.ResponseMetaData.NextCursor == "" {
+			return "", nil
+		}
+		params.Cursor = history.ResponseMetaData.NextCursor
+	}
+}
+
 func (c *Client) buildBlocksWithContextAndError(reports []definition.Report, isDryRun bool, environment string, executionErr error) []slack.Block {
 	passCount := 0
 	failCount := 0
@@ -69,8 +156,11 @@ func (c *Client) buildBlocksWithContextAndError(reports []definition.Report, isD
 	blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", headerText, false, false)))
 
 	// Summary section
+	cancelled := errors.Is(executionErr, definition.ErrCancelled)
 	summaryEmoji := "✅"
-	if failCount > 0 || executionErr != nil {
+	if cancelled {
+		summaryEmoji = "🛑"
+	} else if failCount > 0 || executionErr != nil {
 		summaryEmoji = "❌"
 	}
 
@@ -81,7 +171,12 @@ func (c *Client) buildBlocksWithContextAndError(reports []definition.Report, isD
 	))
 
 	// Error section if execution error occurred
-	if executionErr != nil {
+	if cancelled {
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("🛑 *Cancelled:* the run was stopped and its changes rolled back\n```%s```", executionErr.Error()), false, false),
+			nil, nil,
+		))
+	} else if executionErr != nil {
 		blocks = append(blocks, slack.NewSectionBlock(
 			slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("🚨 *Execution Error:*\n```%s```", executionErr.Error()), false, false),
 			nil, nil,
@@ -118,11 +213,11 @@ func (c *Client) buildOperationBlock(report definition.Report) slack.Block {
 
 	// SQL Query field
 	if report.SQL != "" {
-		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*Query:*\n```%s```", report.SQL), false, false))
+		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*Query:*\n```%s```", sqlformat.Format(report.SQL)), false, false))
 	}
 
 	// Result field for DML operations
-	if report.Result != nil && report.Type != definition.TypeSelect {
+	if report.Result != nil && !definition.IsQuery(report.Type) {
 		fields = append(fields, slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*Affected Rows:*\n%v", report.Result), false, false))
 	}
 
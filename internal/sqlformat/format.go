@@ -0,0 +1,368 @@
+// Package sqlformat pretty-prints SQL for people reading reports: clauses start on their own
+// lines, subqueries are indented, keywords are upper-cased and long lines are wrapped.
+// Only whitespace and the case of keywords change, so the result runs the same as the input.
+package sqlformat
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Width is the line length long lists and expressions are wrapped at
+const Width = 80
+
+const indentWidth = 2
+
+var keywords = wordSet(`select from where and or not in is null as on join left right inner outer cross full
+group by order having limit offset insert into values update set delete union intersect except all
+distinct case when then else end between like ilike exists asc desc returning with using true false
+interval duplicate key conflict do nothing for`)
+
+// clauseStarters begin a new line when they appear at the top level or directly in a subquery
+var clauseStarters = wordSet(`select from where group order having limit offset union intersect except
+set values returning insert update delete join left right inner cross full`)
+
+// conditionClauses are those whose AND and OR start a new line
+var conditionClauses = wordSet(`where having on`)
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[strings.ToUpper(word)] = true
+	}
+	return set
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenQuoted
+	tokenLineComment
+	tokenBlockComment
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	space bool // whitespace or a comment preceded it in the input
+}
+
+// Format returns sql pretty-printed. SQL it cannot tokenize safely, such as an unterminated
+// string or a dollar-quoted body, is returned unchanged.
+func Format(sql string) string {
+	tokens, ok := tokenize(sql)
+	if !ok || len(tokens) == 0 {
+		return sql
+	}
+	f := &formatter{tokens: tokens, stack: []frame{{clauses: true}}}
+	f.format()
+	return strings.TrimRight(f.b.String(), " \n")
+}
+
+func tokenize(sql string) ([]token, bool) {
+	if strings.Contains(sql, "$$") {
+		return nil, false
+	}
+
+	var tokens []token
+	space := false
+	for i := 0; i < len(sql); {
+		r, size := utf8.DecodeRuneInString(sql[i:])
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			i += size
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			tokens = append(tokens, token{kind: tokenLineComment, text: strings.TrimRight(sql[i:i+end], " \t\r"), space: space})
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, token{kind: tokenBlockComment, text: sql[i : i+end+4], space: space})
+			i += end + 4
+		case r == '\'' || r == '"' || r == '`':
+			end, ok := quoteEnd(sql, i)
+			if !ok {
+				return nil, false
+			}
+			tokens = append(tokens, token{kind: tokenQuoted, text: sql[i:end], space: space})
+			i = end
+		case isWordRune(r):
+			end := i
+			for end < len(sql) {
+				r, size := utf8.DecodeRuneInString(sql[end:])
+				if !isWordRune(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: sql[i:end], space: space})
+			i = end
+		default:
+			tokens = append(tokens, token{kind: tokenPunct, text: sql[i : i+size], space: space})
+			i += size
+		}
+		space = false
+	}
+	return tokens, true
+}
+
+// quoteEnd returns the end of the quoted string or identifier starting at start. A doubled quote
+// stands for itself, and a backslash escapes the next character in strings, as in MySQL.
+func quoteEnd(sql string, start int) (int, bool) {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if quote == '\'' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '$' || r == '@' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// frame is a level of parentheses; clauses is set for the top level and subqueries, where
+// clauses start new lines, and not for function calls or value lists
+type frame struct {
+	clauses bool
+	// base is the indentation of the frame's clauses
+	base int
+	// indent is that of the line the parenthesis was opened on
+	indent  int
+	clause  string
+	between bool
+}
+
+type formatter struct {
+	tokens []token
+	stack  []frame
+	b      strings.Builder
+
+	lineLen    int
+	lineIndent int
+	lineStart  bool
+}
+
+func (f *formatter) top() *frame {
+	return &f.stack[len(f.stack)-1]
+}
+
+func (f *formatter) newline(indent int) {
+	if f.b.Len() == 0 {
+		return
+	}
+	if f.lineStart {
+		// 空行を作らず、字下げだけ直す
+		s := strings.TrimRight(f.b.String(), " ")
+		f.b.Reset()
+		f.b.WriteString(s)
+	} else {
+		f.b.WriteString("\n")
+	}
+	f.b.WriteString(strings.Repeat(" ", indent))
+	f.lineLen = indent
+	f.lineIndent = indent
+	f.lineStart = true
+}
+
+// write appends text, after a space when space is set, wrapping the line when it gets too long
+func (f *formatter) write(text string, space bool) {
+	if space && !f.lineStart && f.b.Len() > 0 {
+		if f.lineLen+1+utf8.RuneCountInString(text) > Width && f.lineLen > f.lineIndent {
+			f.newline(f.top().base + 2*indentWidth)
+		} else {
+			f.b.WriteString(" ")
+			f.lineLen++
+		}
+	}
+	f.b.WriteString(text)
+	f.lineLen += utf8.RuneCountInString(text)
+	f.lineStart = false
+}
+
+// keyword returns the upper-cased keyword of a token, or "" when it is not one; a word next to
+// a dot is a qualified identifier
+func (f *formatter) keyword(i int) string {
+	if i < 0 || i >= len(f.tokens) || f.tokens[i].kind != tokenWord {
+		return ""
+	}
+	if (i > 0 && f.tokens[i-1].text == ".") || (i+1 < len(f.tokens) && f.tokens[i+1].text == ".") {
+		return ""
+	}
+	word := strings.ToUpper(f.tokens[i].text)
+	if !keywords[word] {
+		return ""
+	}
+	return word
+}
+
+// next returns the index of the next token that is not a comment
+func (f *formatter) next(i int) int {
+	for i++; i < len(f.tokens); i++ {
+		if k := f.tokens[i].kind; k != tokenLineComment && k != tokenBlockComment {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *formatter) format() {
+	prevKeyword := ""
+	for i, tok := range f.tokens {
+		space := tok.space
+		if i > 0 {
+			switch f.tokens[i-1].text {
+			case "(":
+				space = false
+			case ",":
+				space = true
+			}
+		}
+
+		switch tok.kind {
+		case tokenLineComment:
+			f.write(tok.text, space)
+			f.newline(f.lineIndent)
+			continue
+		case tokenBlockComment, tokenQuoted:
+			f.write(tok.text, space)
+			continue
+		case tokenPunct:
+			f.punct(i, tok, space)
+			prevKeyword = ""
+			continue
+		}
+
+		kw := f.keyword(i)
+		if kw == "" {
+			f.write(tok.text, space)
+			prevKeyword = ""
+			continue
+		}
+		fr := f.top()
+		if fr.clauses && f.startsClause(i, kw, prevKeyword) {
+			f.newline(fr.base)
+			fr.clause = kw
+			fr.between = false
+			space = false
+		}
+		switch {
+		case kw == "ON" && fr.clauses:
+			fr.clause = kw
+		case kw == "BETWEEN":
+			fr.between = true
+		case (kw == "AND" || kw == "OR") && fr.clauses && conditionClauses[fr.clause]:
+			if kw == "AND" && fr.between {
+				fr.between = false
+			} else {
+				f.newline(fr.base + indentWidth)
+				space = false
+			}
+		}
+		f.write(kw, space)
+		prevKeyword = kw
+	}
+}
+
+func (f *formatter) startsClause(i int, kw, prevKeyword string) bool {
+	next := f.keyword(f.next(i))
+	switch kw {
+	case "LEFT", "RIGHT", "INNER", "CROSS", "FULL":
+		return next == "JOIN" || next == "OUTER"
+	case "JOIN":
+		// LEFT JOIN などは先頭の語で改行済み
+		return prevKeyword != "LEFT" && prevKeyword != "RIGHT" && prevKeyword != "INNER" && prevKeyword != "OUTER" &&
+			prevKeyword != "CROSS" && prevKeyword != "FULL"
+	case "FROM":
+		return prevKeyword != "DELETE"
+	case "VALUES":
+		// ON DUPLICATE KEY UPDATE の VALUES(col) は関数
+		return f.top().clause == "INSERT"
+	case "UPDATE", "DELETE":
+		// FOR UPDATE, ON DUPLICATE KEY UPDATE, DO UPDATE, ON DELETE は句の始まりではない
+		prev := strings.ToUpper(f.prevWord(i))
+		return prev != "FOR" && prev != "KEY" && prev != "DO" && prev != "ON"
+	case "ON":
+		next := strings.ToUpper(f.tokenText(f.next(i)))
+		return next == "DUPLICATE" || next == "CONFLICT"
+	}
+	return clauseStarters[kw]
+}
+
+func (f *formatter) prevWord(i int) string {
+	for i--; i >= 0; i-- {
+		switch f.tokens[i].kind {
+		case tokenLineComment, tokenBlockComment:
+			continue
+		case tokenWord:
+			return f.tokens[i].text
+		}
+		return ""
+	}
+	return ""
+}
+
+func (f *formatter) tokenText(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return f.tokens[i].text
+}
+
+func (f *formatter) punct(i int, tok token, space bool) {
+	fr := f.top()
+	switch tok.text {
+	case "(":
+		next := f.keyword(f.next(i))
+		if next == "SELECT" || next == "WITH" {
+			f.write("(", space)
+			f.stack = append(f.stack, frame{clauses: true, base: f.lineIndent + indentWidth, indent: f.lineIndent})
+			return
+		}
+		f.write("(", space)
+		f.stack = append(f.stack, frame{base: fr.base, indent: f.lineIndent})
+	case ")":
+		if len(f.stack) > 1 {
+			closed := *fr
+			f.stack = f.stack[:len(f.stack)-1]
+			if closed.clauses {
+				f.newline(closed.indent)
+			}
+		}
+		f.write(")", false)
+	case ",":
+		f.write(",", false)
+		// VALUES の各行は1行ずつ並べる
+		if fr.clauses && fr.clause == "VALUES" && i > 0 && f.tokens[i-1].text == ")" {
+			f.newline(fr.base + indentWidth)
+		}
+	case ";":
+		f.write(";", false)
+		f.stack = f.stack[:1]
+		f.stack[0] = frame{clauses: true}
+		f.newline(0)
+	default:
+		f.write(tok.text, space)
+	}
+}
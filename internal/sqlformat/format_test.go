@@ -0,0 +1,90 @@
+package sqlformat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "clauses and conditions",
+			sql:  "select id, name from users where status = 'active' and created_at between '2024-01-01' and '2024-02-01' order by id desc limit 10",
+			want: `SELECT id, name
+FROM users
+WHERE status = 'active'
+  AND created_at BETWEEN '2024-01-01' AND '2024-02-01'
+ORDER BY id DESC
+LIMIT 10`,
+		},
+		{
+			name: "subquery",
+			sql:  "DELETE FROM sessions WHERE expired = 1 OR user_id IN (select id from users where deleted = 1)",
+			want: `DELETE FROM sessions
+WHERE expired = 1
+  OR user_id IN (
+    SELECT id
+    FROM users
+    WHERE deleted = 1
+  )`,
+		},
+		{
+			name: "long list wrapped",
+			sql:  "UPDATE users SET status = 'closed' WHERE id IN (1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008, 1009, 1010, 1011, 1012, 1013)",
+			want: `UPDATE users
+SET status = 'closed'
+WHERE id IN (1001, 1002, 1003, 1004, 1005, 1006, 1007, 1008, 1009, 1010, 1011,
+    1012, 1013)`,
+		},
+		{
+			name: "insert with upsert",
+			sql:  "insert into t (a,b) values (1,'x'),(2,'it''s') on duplicate key update b = values(b)",
+			want: `INSERT INTO t (a, b)
+VALUES (1, 'x'),
+  (2, 'it''s')
+ON DUPLICATE KEY UPDATE b = VALUES(b)`,
+		},
+		{
+			name: "joins, qualified keywords and operators",
+			sql:  "select extract(year from o.created_at) as y, o.data->>'from' as k from orders o left join users u on u.id = o.user_id where o.order = $1 for update",
+			want: `SELECT extract(year FROM o.created_at) AS y, o.data->>'from' AS k
+FROM orders o
+LEFT JOIN users u ON u.id = o.user_id
+WHERE o.order = $1 FOR UPDATE`,
+		},
+		{
+			name: "comments and statements",
+			sql:  "select count(*) as c from t -- where from\nwhere x = 1; select 2",
+			want: `SELECT count(*) AS c
+FROM t -- where from
+WHERE x = 1;
+SELECT 2`,
+		},
+		{
+			name: "unterminated string left alone",
+			sql:  "select 'oops from t",
+			want: "select 'oops from t",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Format(tt.sql)
+			if got != tt.want {
+				t.Errorf("Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+			// 空白と大文字小文字以外は変わらない
+			if normalize(got) != normalize(tt.sql) {
+				t.Errorf("Format() changed more than whitespace and case:\n%s", got)
+			}
+		})
+	}
+}
+
+func normalize(sql string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ",", " , ").Replace(sql)), " "))
+}
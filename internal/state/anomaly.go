@@ -0,0 +1,82 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// DefaultAnomalyMinSamples is how many earlier executions an operation needs before it is checked
+const DefaultAnomalyMinSamples = 3
+
+// Anomaly is an operation whose row count is far from its historical median
+type Anomaly struct {
+	OperationID string
+	Rows        int64
+	Baseline    Summary
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("operation %s touched %d rows, the median of its last %d runs is %.0f", a.OperationID, a.Rows, a.Baseline.Count, a.Baseline.Median)
+}
+
+// RowBaselines summarizes the row counts of every operation in the matching runs.
+// Failed executions are left out because their counts do not describe normal behaviour.
+func (h *History) RowBaselines(ctx context.Context, filter StatsFilter) (map[string]Summary, error) {
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string][]float64)
+	for _, record := range records {
+		if !filter.matches(record) {
+			continue
+		}
+		for _, report := range record.Reports {
+			if !report.Pass {
+				continue
+			}
+			if count, ok := RowCount(report); ok {
+				rows[report.ID] = append(rows[report.ID], float64(count))
+			}
+		}
+	}
+
+	baselines := make(map[string]Summary, len(rows))
+	for id, values := range rows {
+		baselines[id] = Summarize(values)
+	}
+	return baselines, nil
+}
+
+// DetectAnomalies returns the DML reports whose affected rows are more than factor times
+// above or below the baseline median. Operations with fewer than minSamples earlier
+// executions are not checked.
+func DetectAnomalies(reports []definition.Report, baselines map[string]Summary, factor float64, minSamples int) []Anomaly {
+	var anomalies []Anomaly
+	for _, report := range reports {
+		if definition.IsQuery(report.Type) {
+			continue
+		}
+		rows, ok := RowCount(report)
+		if !ok {
+			continue
+		}
+		baseline, exists := baselines[report.ID]
+		if !exists || baseline.Count < minSamples {
+			continue
+		}
+
+		// 中央値が0でも「普段0件なのに大量」を検知できるよう1件を下限にする
+		median := baseline.Median
+		if median < 1 {
+			median = 1
+		}
+		if float64(rows) > median*factor || float64(rows)*factor < baseline.Median {
+			anomalies = append(anomalies, Anomaly{OperationID: report.ID, Rows: rows, Baseline: baseline})
+		}
+	}
+	return anomalies
+}
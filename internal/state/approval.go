@@ -0,0 +1,60 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+const approvalsPrefix = "approvals/"
+
+// Approval records that a plan was approved and which apply it started
+type Approval struct {
+	PlanRunID  string    `json:"plan_run_id"`
+	RunID      string    `json:"run_id"`
+	Actor      string    `json:"actor"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// ApprovedError is returned when the plan was already approved
+type ApprovedError struct {
+	Approval Approval
+}
+
+func (e *ApprovedError) Error() string {
+	return fmt.Sprintf("run %s was already approved by %s at %s (run %s)",
+		e.Approval.PlanRunID, e.Approval.Actor, definition.FormatReportTime(e.Approval.ApprovedAt), e.Approval.RunID)
+}
+
+func approvalKey(planRunID string) string {
+	return approvalsPrefix + planRunID + ".json"
+}
+
+// ClaimApproval records the approval of a plan, or returns an *ApprovedError when the plan
+// was already approved. The claim is a conditional write, so a plan is applied only once
+// even when several approvals race.
+func (h *History) ClaimApproval(ctx context.Context, approval Approval) error {
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = h.store.PutIfAbsent(ctx, approvalKey(approval.PlanRunID), data)
+	if !errors.Is(err, ErrExists) {
+		return err
+	}
+
+	existing, err := h.store.Get(ctx, approvalKey(approval.PlanRunID))
+	if err != nil {
+		return fmt.Errorf("failed to read the approval of run %s: %w", approval.PlanRunID, err)
+	}
+	var claimed Approval
+	if err := json.Unmarshal(existing, &claimed); err != nil {
+		return fmt.Errorf("failed to parse the approval of run %s: %w", approval.PlanRunID, err)
+	}
+	return &ApprovedError{Approval: claimed}
+}
@@ -0,0 +1,159 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+const stateTable = "opsql_state"
+
+// DatabaseStore keeps state in the opsql_state table
+type DatabaseStore struct {
+	db database.DB
+	// schema holds the table when set (--opsql-schema); it must already exist
+	schema string
+}
+
+func NewDatabaseStore(db database.DB, schema string) *DatabaseStore {
+	return &DatabaseStore{db: db, schema: schema}
+}
+
+// Init creates the state table if it does not exist
+func (s *DatabaseStore) Init(ctx context.Context) error {
+	valueType := "BYTEA"
+	if s.db.DriverName() == "mysql" {
+		valueType = "LONGBLOB"
+	}
+
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		state_key VARCHAR(512) NOT NULL PRIMARY KEY,
+		state_value %s NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, s.table(), valueType)
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create %s: %w", database.QualifyName(s.schema, stateTable), err)
+	}
+	return nil
+}
+
+func (s *DatabaseStore) Get(ctx context.Context, key string) ([]byte, error) {
+	rows, err := s.db.QueryRowsContext(ctx, s.rebind("SELECT state_value FROM %s WHERE state_key = ?"), key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+
+	switch value := rows[0]["state_value"].(type) {
+	case database.Binary:
+		return []byte(value), nil
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return nil, fmt.Errorf("unexpected state value type %T", value)
+	}
+}
+
+func (s *DatabaseStore) Put(ctx context.Context, key string, data []byte) error {
+	query := "INSERT INTO %s (state_key, state_value) VALUES (?, ?) ON CONFLICT (state_key) DO UPDATE SET state_value = EXCLUDED.state_value, updated_at = CURRENT_TIMESTAMP"
+	if s.db.DriverName() == "mysql" {
+		query = "INSERT INTO %s (state_key, state_value) VALUES (?, ?) ON DUPLICATE KEY UPDATE state_value = VALUES(state_value), updated_at = CURRENT_TIMESTAMP"
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(query), key, data)
+	return err
+}
+
+func (s *DatabaseStore) PutIfAbsent(ctx context.Context, key string, data []byte) error {
+	query := "INSERT INTO %s (state_key, state_value) VALUES (?, ?) ON CONFLICT (state_key) DO NOTHING"
+	if s.db.DriverName() == "mysql" {
+		query = "INSERT IGNORE INTO %s (state_key, state_value) VALUES (?, ?)"
+	}
+	affected, err := s.db.ExecContext(ctx, s.rebind(query), key, data)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (s *DatabaseStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM %s WHERE state_key = ?"), key)
+	return err
+}
+
+func (s *DatabaseStore) Replace(ctx context.Context, key string, old, data []byte) error {
+	affected, err := s.db.ExecContext(ctx, s.rebind("UPDATE %s SET state_value = ?, updated_at = CURRENT_TIMESTAMP WHERE state_key = ? AND state_value = ?"), data, key, old)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *DatabaseStore) DeleteIf(ctx context.Context, key string, old []byte) error {
+	affected, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM %s WHERE state_key = ? AND state_value = ?"), key, old)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *DatabaseStore) List(ctx context.Context, prefix string) ([]string, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+	rows, err := s.db.QueryRowsContext(ctx, s.rebind("SELECT state_key FROM %s WHERE state_key LIKE ? ORDER BY state_key"), escaped+"%")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(rows))
+	for _, row := range rows {
+		switch key := row["state_key"].(type) {
+		case []byte:
+			keys = append(keys, string(key))
+		case string:
+			keys = append(keys, key)
+		default:
+			return nil, errors.New("unexpected state key type")
+		}
+	}
+	return keys, nil
+}
+
+func (s *DatabaseStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *DatabaseStore) table() string {
+	return database.QuoteIdentifier(s.db.DriverName(), database.QualifyName(s.schema, stateTable))
+}
+
+// rebind fills in the table name and converts ? placeholders for the driver
+func (s *DatabaseStore) rebind(query string) string {
+	query = fmt.Sprintf(query, s.table())
+
+	var buf strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString(database.Placeholder(s.db.DriverName(), n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
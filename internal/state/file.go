@@ -0,0 +1,199 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist
+var ErrNotFound = errors.New("state: key not found")
+
+// ErrExists is returned by PutIfAbsent when the key already exists
+var ErrExists = errors.New("state: key already exists")
+
+// ErrConflict is returned by Replace and DeleteIf when the key no longer holds the expected value
+var ErrConflict = errors.New("state: key was changed concurrently")
+
+// guardTTL is how old a guard file must be before it is considered left behind by a crashed process
+const guardTTL = 10 * time.Second
+
+// FileStore keeps state as files under a local directory
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// 書き込み途中のファイルを読まれないよう rename で置き換える
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) PutIfAbsent(ctx context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// 書き終えたファイルを link で置くので、作成と書き込みの間の空のファイルを読まれない。
+	// link は置き換えずに失敗するため、同時に置こうとした側は ErrExists になる
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	err = os.Link(tmp, path)
+	if errors.Is(err, os.ErrExist) {
+		return ErrExists
+	}
+	return err
+}
+
+// writeTemp writes data to a new temporary file in dir and returns its path
+func writeTemp(dir string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (s *FileStore) Replace(ctx context.Context, key string, old, data []byte) error {
+	return s.compareAnd(key, old, func() error {
+		return s.Put(ctx, key, data)
+	})
+}
+
+func (s *FileStore) DeleteIf(ctx context.Context, key string, old []byte) error {
+	return s.compareAnd(key, old, func() error {
+		return s.Delete(ctx, key)
+	})
+}
+
+// compareAnd runs fn while the key holds old. A guard file created with O_EXCL keeps other
+// processes from changing the key between the comparison and fn.
+func (s *FileStore) compareAnd(key string, old []byte, fn func() error) error {
+	path := s.path(key)
+	guard := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path)+".guard")
+
+	file, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(guard)
+		if statErr != nil || time.Since(info.ModTime()) < guardTTL {
+			return ErrConflict
+		}
+		// 異常終了したプロセスが残したガードは取り除いてやり直す
+		if err := os.Remove(guard); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		file, err = os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return ErrConflict
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	defer func() { _ = os.Remove(guard) }()
+
+	current, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, old) {
+		return ErrConflict
+	}
+	return fn()
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns the keys under the prefix in lexical order
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	root := s.path(prefix)
+	var keys []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
@@ -0,0 +1,422 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusAborted = "aborted"
+	// StatusCancelled marks runs cancelled before their last operation; their changes were rolled back
+	StatusCancelled = "cancelled"
+
+	runsPrefix  = "runs/"
+	locksPrefix = "locks/"
+)
+
+// DefaultLockTTL is how long a lock stays valid without being refreshed
+const DefaultLockTTL = 5 * time.Minute
+
+// RunRecord is the history entry of a single opsql run
+type RunRecord struct {
+	ID          string            `json:"id"`
+	Definition  string            `json:"definition"`
+	Configs     []string          `json:"configs,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Checksum    string            `json:"checksum"`
+	Environment string            `json:"environment"`
+	Actor       string            `json:"actor"`
+	DryRun      bool              `json:"dry_run"`
+	// PlanRunID is the dry run an apply was approved from
+	PlanRunID string `json:"plan_run_id,omitempty"`
+	// GitHubRepo and GitHubPR are the pull request the run was started for
+	GitHubRepo string `json:"github_repo,omitempty"`
+	GitHubPR   int    `json:"github_pr,omitempty"`
+	// RollbackOf is the apply a rollback reverts
+	RollbackOf string              `json:"rollback_of,omitempty"`
+	Status     string              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Reports    []definition.Report `json:"reports,omitempty"`
+}
+
+// LockInfo describes who holds a run lock
+type LockInfo struct {
+	Key        string    `json:"key"`
+	RunID      string    `json:"run_id"`
+	Actor      string    `json:"actor"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LockedError is returned when another run holds the lock
+type LockedError struct {
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("another run is in progress by %s (run %s, started %s)",
+		e.Holder.Actor, e.Holder.RunID, definition.FormatReportTime(e.Holder.AcquiredAt))
+}
+
+// History records runs and serializes them per definition and environment
+type History struct {
+	store   StateStore
+	lockTTL time.Duration
+}
+
+func NewHistory(store StateStore) *History {
+	return &History{store: store, lockTTL: DefaultLockTTL}
+}
+
+// NewRunID returns a sortable unique run ID
+func NewRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// CurrentActor identifies who is running opsql
+func CurrentActor() string {
+	if actor := os.Getenv("OPSQL_ACTOR"); actor != "" {
+		return actor
+	}
+	if actor := os.Getenv("GITHUB_ACTOR"); actor != "" {
+		return actor
+	}
+
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+// CIRunKey identifies the CI job opsql runs in, the same for every attempt of a retried job:
+// the workflow run and job on GitHub Actions, the pipeline and job name on GitLab CI, the
+// workflow and job on CircleCI. It is empty elsewhere.
+func CIRunKey() string {
+	switch {
+	case os.Getenv("GITHUB_RUN_ID") != "":
+		return "github:" + os.Getenv("GITHUB_RUN_ID") + ":" + os.Getenv("GITHUB_JOB")
+	case os.Getenv("CI_PIPELINE_ID") != "":
+		return "gitlab:" + os.Getenv("CI_PIPELINE_ID") + ":" + os.Getenv("CI_JOB_NAME")
+	case os.Getenv("CIRCLE_WORKFLOW_ID") != "":
+		return "circleci:" + os.Getenv("CIRCLE_WORKFLOW_ID") + ":" + os.Getenv("CIRCLE_JOB")
+	}
+	return ""
+}
+
+// LockKey returns the lock key of a definition in an environment
+func LockKey(definitionName, environment string) string {
+	return definitionName + "@" + environment
+}
+
+func (h *History) SaveRun(ctx context.Context, record RunRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return h.store.Put(ctx, runsPrefix+record.ID+".json", data)
+}
+
+// GetRun returns the recorded run or ErrNotFound
+func (h *History) GetRun(ctx context.Context, id string) (*RunRecord, error) {
+	data, err := h.store.Get(ctx, runsPrefix+id+".json")
+	if err != nil {
+		return nil, err
+	}
+	var record RunRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse run %s: %w", id, err)
+	}
+	return &record, nil
+}
+
+// ListRuns returns all recorded runs, oldest first
+func (h *History) ListRuns(ctx context.Context) ([]RunRecord, error) {
+	entries, err := h.listRunEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]RunRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = entry.record
+	}
+	return records, nil
+}
+
+type runEntry struct {
+	key    string
+	record RunRecord
+	size   int64
+}
+
+func (h *History) listRunEntries(ctx context.Context) ([]runEntry, error) {
+	keys, err := h.store.List(ctx, runsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]runEntry, 0, len(keys))
+	for _, key := range keys {
+		data, err := h.store.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		var record RunRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		entries = append(entries, runEntry{key: key, record: record, size: int64(len(data))})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].record.ID < entries[j].record.ID })
+	return entries, nil
+}
+
+// LastPassedApply returns the latest passed, non-dry-run run of the checksum and params in the
+// environment that was not reverted by a passed rollback, or nil when there is none
+func (h *History) LastPassedApply(ctx context.Context, checksum, environment string, params map[string]string) (*RunRecord, error) {
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := revertedRuns(records)
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		// 同じ定義でもパラメータが違えば別の操作なので、昇格の根拠にしない
+		if record.Checksum == checksum && record.Environment == environment && maps.Equal(record.Params, params) && isApplied(record) && !reverted[record.ID] {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// isApplied reports whether the run is a passed apply, not a dry run or a rollback
+func isApplied(record RunRecord) bool {
+	return !record.DryRun && record.Status == StatusPassed && record.RollbackOf == ""
+}
+
+// revertedRuns returns the IDs of the applies that a passed rollback reverted
+func revertedRuns(records []RunRecord) map[string]bool {
+	reverted := make(map[string]bool)
+	for _, record := range records {
+		if record.RollbackOf != "" && !record.DryRun && record.Status == StatusPassed {
+			reverted[record.RollbackOf] = true
+		}
+	}
+	return reverted
+}
+
+// RevertedBy returns the passed rollback that reverted the run, or nil when there is none
+func (h *History) RevertedBy(ctx context.Context, runID string) (*RunRecord, error) {
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if record.RollbackOf == runID && !record.DryRun && record.Status == StatusPassed {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// LastCompletedRun returns the latest run of the definition in the environment that executed
+// all of its operations (passed or failed), or nil when there is none
+func (h *History) LastCompletedRun(ctx context.Context, definitionName, environment string) (*RunRecord, error) {
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		completed := record.Status == StatusPassed || record.Status == StatusFailed
+		if record.Definition == definitionName && record.Environment == environment && completed {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// Lock is a held run lock; it is refreshed in the background until released
+type Lock struct {
+	history *History
+	info    LockInfo
+	// data is the lock as last written, which every later write must still find in the store
+	data []byte
+	stop chan struct{}
+	// lostCh is closed once another run has taken the lock over
+	lostCh   chan struct{}
+	mu       sync.Mutex
+	released bool
+	lost     bool
+}
+
+// Lost returns a channel closed once the lock expired and another run took it over; the run
+// must stop before its next operation
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lostCh
+}
+
+// AcquireLock takes the lock for key or returns a *LockedError naming the current holder.
+// Locks not refreshed within the lock TTL are considered abandoned and taken over; the takeover
+// replaces the stale lock only if it is still the one that was read, so two runs cannot both win.
+func (h *History) AcquireLock(ctx context.Context, key, runID, actor string) (*Lock, error) {
+	now := time.Now().UTC()
+	info := LockInfo{
+		Key:        key,
+		RunID:      runID,
+		Actor:      actor,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(h.lockTTL),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKey := lockStoreKey(key)
+	for attempt := 0; attempt < 3; attempt++ {
+		err = h.store.PutIfAbsent(ctx, storeKey, data)
+		if err == nil {
+			return h.startLock(info, data), nil
+		}
+		if !errors.Is(err, ErrExists) {
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+		}
+
+		current, err := h.store.Get(ctx, storeKey)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var holder LockInfo
+		if err := json.Unmarshal(current, &holder); err != nil {
+			return nil, fmt.Errorf("failed to parse lock %s: %w", storeKey, err)
+		}
+		if time.Now().Before(holder.ExpiresAt) {
+			return nil, &LockedError{Holder: holder}
+		}
+
+		err = h.store.Replace(ctx, storeKey, current, data)
+		if err == nil {
+			return h.startLock(info, data), nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, fmt.Errorf("failed to take over expired lock %s: %w", key, err)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to acquire lock %s: lock keeps changing", key)
+}
+
+func (h *History) startLock(info LockInfo, data []byte) *Lock {
+	lock := &Lock{history: h, info: info, data: data, stop: make(chan struct{}), lostCh: make(chan struct{})}
+	go lock.refresh()
+	return lock
+}
+
+func (h *History) readLock(ctx context.Context, storeKey string) (LockInfo, error) {
+	var info LockInfo
+	data, err := h.store.Get(ctx, storeKey)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse lock %s: %w", storeKey, err)
+	}
+	return info, nil
+}
+
+// refresh extends the lock while it is still the one this run wrote. Once another run has
+// taken it over, refreshing stops rather than overwriting the new holder, and Lost is closed.
+func (l *Lock) refresh() {
+	ticker := time.NewTicker(l.history.lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.released && !l.lost {
+				info := l.info
+				info.ExpiresAt = time.Now().UTC().Add(l.history.lockTTL)
+				data, err := json.Marshal(info)
+				if err == nil {
+					err = l.history.store.Replace(context.Background(), lockStoreKey(l.info.Key), l.data, data)
+				}
+				switch {
+				case err == nil:
+					l.info, l.data = info, data
+				case errors.Is(err, ErrConflict):
+					l.lost = true
+					close(l.lostCh)
+					fmt.Fprintf(os.Stderr, "Warning: lock %s is no longer held by run %s; it expired and was taken over, stopping before the next operation\n", l.info.Key, l.info.RunID)
+				default:
+					fmt.Fprintf(os.Stderr, "Warning: failed to refresh lock %s: %v\n", l.info.Key, err)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Release stops refreshing and removes the lock unless another run has taken it over
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released {
+		return nil
+	}
+	l.released = true
+	close(l.stop)
+
+	storeKey := lockStoreKey(l.info.Key)
+	err := l.history.store.DeleteIf(ctx, storeKey, l.data)
+	if !errors.Is(err, ErrConflict) {
+		return err
+	}
+	holder, err := l.history.readLock(ctx, storeKey)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("lock %s is now held by run %s of %s; left in place", l.info.Key, holder.RunID, holder.Actor)
+}
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+func lockStoreKey(key string) string {
+	return locksPrefix + strings.Trim(unsafeKeyChars.ReplaceAllString(key, "_"), "_") + ".json"
+}
@@ -0,0 +1,533 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+func TestHistory_AcquireLock(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+	key := LockKey("runbooks/cleanup.yaml", "prod")
+
+	lock, err := history.AcquireLock(ctx, key, "run-1", "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = history.AcquireLock(ctx, key, "run-2", "bob")
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+	if locked.Holder.Actor != "alice" || locked.Holder.RunID != "run-1" {
+		t.Errorf("unexpected holder: %+v", locked.Holder)
+	}
+
+	if _, err := history.AcquireLock(ctx, LockKey("runbooks/cleanup.yaml", "staging"), "run-3", "bob"); err != nil {
+		t.Errorf("lock of another environment should be independent: %v", err)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := history.AcquireLock(ctx, key, "run-4", "bob"); err != nil {
+		t.Errorf("lock should be available after release: %v", err)
+	}
+}
+
+func TestHistory_AcquireExpiredLock(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	stale, err := json.Marshal(LockInfo{
+		Key:        "cleanup@prod",
+		RunID:      "run-1",
+		Actor:      "alice",
+		AcquiredAt: time.Now().Add(-time.Hour),
+		ExpiresAt:  time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := history.store.Put(ctx, lockStoreKey("cleanup@prod"), stale); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := history.AcquireLock(ctx, "cleanup@prod", "run-2", "bob"); err != nil {
+		t.Errorf("expired lock should be taken over: %v", err)
+	}
+}
+
+func TestHistory_ReleaseTakenOverLock(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	lock, err := history.AcquireLock(ctx, "cleanup@prod", "run-1", "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// run-1 が止まっている間に期限切れとみなされ run-2 に引き継がれた状態
+	takeover, err := json.Marshal(LockInfo{Key: "cleanup@prod", RunID: "run-2", Actor: "bob", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := history.store.Put(ctx, lockStoreKey("cleanup@prod"), takeover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := lock.Release(ctx); err == nil {
+		t.Error("releasing a lock taken over by another run should fail")
+	}
+	holder, err := history.readLock(ctx, lockStoreKey("cleanup@prod"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if holder.RunID != "run-2" {
+		t.Errorf("the new holder's lock should be left in place, got %+v", holder)
+	}
+}
+
+func TestHistory_LockLostOnTakeover(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+	history.lockTTL = 30 * time.Millisecond
+
+	lock, err := history.AcquireLock(ctx, "cleanup@prod", "run-1", "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = lock.Release(ctx) }()
+
+	takeover, err := json.Marshal(LockInfo{Key: "cleanup@prod", RunID: "run-2", Actor: "bob", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := history.store.Put(ctx, lockStoreKey("cleanup@prod"), takeover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 次の更新で引き継がれたことに気づき、実行を止めさせる
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("losing the lock should be signalled to the run")
+	}
+}
+
+func TestFileStore_Replace(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+
+	if err := store.Put(ctx, "locks/a.json", []byte("first")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Replace(ctx, "locks/a.json", []byte("stale"), []byte("second")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if err := store.Replace(ctx, "locks/a.json", []byte("first"), []byte("second")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := store.DeleteIf(ctx, "locks/a.json", []byte("first")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if err := store.DeleteIf(ctx, "locks/a.json", []byte("second")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := store.Get(ctx, "locks/a.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileStore_PutIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := store.PutIfAbsent(ctx, "locks/a.json", []byte(fmt.Sprintf("run-%d", i)))
+			switch {
+			case err == nil:
+				created.Add(1)
+			case !errors.Is(err, ErrExists):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+		// 置かれた鍵は書き終えた内容で読める
+		go func() {
+			defer wg.Done()
+			data, err := store.Get(ctx, "locks/a.json")
+			if err == nil && len(data) == 0 {
+				t.Error("a key being put should never be read empty")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created.Load() != 1 {
+		t.Errorf("expected exactly one PutIfAbsent to succeed, got %d", created.Load())
+	}
+	entries, err := os.ReadDir(filepath.Join(store.dir, "locks"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files should not be left behind, got %d files", len(entries))
+	}
+}
+
+func TestHistory_SaveAndListRuns(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	for _, id := range []string{"20260102T000000Z-b", "20260101T000000Z-a"} {
+		if err := history.SaveRun(ctx, RunRecord{ID: id, Status: StatusPassed}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	runs, err := history.ListRuns(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != "20260101T000000Z-a" || runs[1].ID != "20260102T000000Z-b" {
+		t.Errorf("runs should be listed oldest first, got %+v", runs)
+	}
+}
+
+func TestHistory_LastPassedApply(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	records := []RunRecord{
+		{ID: "20260101T000000Z-a", Checksum: "abc", Environment: "staging", Status: StatusPassed},
+		{ID: "20260102T000000Z-b", Checksum: "abc", Environment: "staging", Status: StatusPassed, DryRun: true},
+		{ID: "20260103T000000Z-c", Checksum: "abc", Environment: "staging", Status: StatusFailed},
+		{ID: "20260104T000000Z-d", Checksum: "def", Environment: "staging", Status: StatusPassed},
+		{ID: "20260104T000000Z-e", Checksum: "abc", Environment: "staging", Status: StatusPassed, Params: map[string]string{"days": "30"}},
+	}
+	for _, record := range records {
+		if err := history.SaveRun(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	run, err := history.LastPassedApply(ctx, "abc", "staging", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run == nil || run.ID != "20260101T000000Z-a" {
+		t.Errorf("expected the passed apply run, got %+v", run)
+	}
+
+	// 取り消された適用は昇格の根拠にならない
+	rollback := RunRecord{ID: "20260105T000000Z-f", Environment: "staging", Status: StatusPassed, RollbackOf: "20260101T000000Z-a"}
+	if err := history.SaveRun(ctx, rollback); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		checksum, environment string
+		params                map[string]string
+	}{{"abc", "staging", nil}, {"abc", "prod", nil}, {"xyz", "staging", nil}, {"abc", "staging", map[string]string{"days": "7"}}} {
+		run, err := history.LastPassedApply(ctx, tt.checksum, tt.environment, tt.params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if run != nil {
+			t.Errorf("expected no run for %s@%s %v, got %+v", tt.checksum, tt.environment, tt.params, run)
+		}
+	}
+
+	// 同じパラメータで適用した実行は昇格の根拠になる
+	run, err = history.LastPassedApply(ctx, "abc", "staging", map[string]string{"days": "30"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run == nil || run.ID != "20260104T000000Z-e" {
+		t.Errorf("expected the apply with the same params, got %+v", run)
+	}
+}
+
+func TestHistory_Releases(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	records := []RunRecord{
+		{ID: "run-1", Definition: "cleanup.yaml", Checksum: "abc", Environment: "prod", Status: StatusPassed},
+		{ID: "run-2", Definition: "cleanup.yaml", Checksum: "def", Environment: "prod", Status: StatusPassed},
+		{ID: "run-3", Definition: "cleanup.yaml", Checksum: "def", Environment: "staging", Status: StatusPassed},
+		{ID: "run-4", Definition: "backfill.yaml", Checksum: "ghi", Environment: "prod", Status: StatusPassed},
+	}
+	for _, record := range records {
+		if _, err := history.RecordRelease(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := history.RecordRelease(ctx, RunRecord{ID: "run-5", Definition: "cleanup.yaml", Environment: "prod", Status: StatusPassed, DryRun: true}); err == nil {
+		t.Error("expected dry runs to be rejected")
+	}
+
+	releases, err := history.Releases(ctx, "prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, release := range releases {
+		got = append(got, fmt.Sprintf("%s %s r%d %s", release.Definition, release.Checksum, release.Revision, release.RunID))
+	}
+	expected := []string{"backfill.yaml ghi r1 run-4", "cleanup.yaml def r2 run-2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	all, err := history.Releases(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(all) != 3 || all[2].Environment != "staging" {
+		t.Errorf("expected the releases of every environment, got %+v", all)
+	}
+}
+
+func TestHistory_RevertRelease(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	records := []RunRecord{
+		{ID: "run-1", Definition: "cleanup.yaml", Checksum: "abc", Environment: "prod", Status: StatusPassed},
+		{ID: "run-2", Definition: "cleanup.yaml", Checksum: "def", Environment: "prod", Status: StatusPassed},
+	}
+	for _, record := range records {
+		if err := history.SaveRun(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := history.RecordRelease(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	rollback := RunRecord{ID: "run-3", Definition: "cleanup.yaml", Environment: "prod", Status: StatusPassed, RollbackOf: "run-2"}
+	if err := history.SaveRun(ctx, rollback); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release, err := history.RevertRelease(ctx, rollback)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release == nil || release.RunID != "run-1" || release.Checksum != "abc" || release.Revision != 3 {
+		t.Errorf("expected the apply before the reverted one to be the release again, got %+v", release)
+	}
+
+	rollback = RunRecord{ID: "run-4", Definition: "cleanup.yaml", Environment: "prod", Status: StatusPassed, RollbackOf: "run-1"}
+	if err := history.SaveRun(ctx, rollback); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := history.RevertRelease(ctx, rollback); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	releases, err := history.Releases(ctx, "prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(releases) != 0 {
+		t.Errorf("expected no release once every apply was reverted, got %+v", releases)
+	}
+}
+
+func TestHistory_Prune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	newHistory := func(t *testing.T) *History {
+		history := NewHistory(NewFileStore(t.TempDir()))
+		for i, age := range []int{120, 60, 10, 1} {
+			record := RunRecord{
+				ID:        fmt.Sprintf("run-%d", i),
+				Status:    StatusPassed,
+				StartedAt: now.AddDate(0, 0, -age),
+			}
+			if err := history.SaveRun(ctx, record); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return history
+	}
+
+	ids := func(runs []RunRecord) []string {
+		var ids []string
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		want      []string
+	}{
+		{name: "older than", retention: Retention{OlderThan: 90 * 24 * time.Hour}, want: []string{"run-0"}},
+		{name: "keep last", retention: Retention{KeepLast: 1}, want: []string{"run-0", "run-1", "run-2"}},
+		{name: "combined", retention: Retention{OlderThan: 30 * 24 * time.Hour, KeepLast: 3}, want: []string{"run-0", "run-1"}},
+		{name: "nothing to prune", retention: Retention{KeepLast: 10}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := newHistory(t)
+
+			preview, err := history.Prune(ctx, tt.retention, now, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if runs, _ := history.ListRuns(ctx); len(runs) != 4 {
+				t.Fatalf("dry run must not delete, %d runs left", len(runs))
+			}
+
+			result, err := history.Prune(ctx, tt.retention, now, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(ids(result.Runs), tt.want) || !reflect.DeepEqual(ids(preview.Runs), tt.want) {
+				t.Errorf("got %v (dry run %v), want %v", ids(result.Runs), ids(preview.Runs), tt.want)
+			}
+
+			runs, _ := history.ListRuns(ctx)
+			if len(runs) != 4-len(tt.want) {
+				t.Errorf("expected %d runs left, got %d", 4-len(tt.want), len(runs))
+			}
+		})
+	}
+
+	t.Run("max size", func(t *testing.T) {
+		history := newHistory(t)
+		entries, err := history.listRunEntries(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		result, err := history.Prune(ctx, Retention{MaxBytes: entries[3].size + entries[2].size}, now, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(ids(result.Runs), []string{"run-0", "run-1"}) {
+			t.Errorf("got %v", ids(result.Runs))
+		}
+	})
+}
+
+func TestHistory_OperationStats(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	for i, affected := range []int64{100, 110, 90, 5000} {
+		record := RunRecord{
+			ID:          fmt.Sprintf("run-%d", i),
+			Definition:  "cleanup.yaml",
+			Environment: "prod",
+			DryRun:      i == 3,
+			Status:      StatusPassed,
+			Reports: []definition.Report{
+				{ID: "count", Type: definition.TypeSelect, Pass: true, Result: []map[string]interface{}{{"n": 1}}},
+				{ID: "cleanup", Type: definition.TypeDelete, Pass: i != 2, Result: affected, DurationMS: int64(10 * (i + 1))},
+			},
+		}
+		if err := history.SaveRun(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	stats, err := history.OperationStats(ctx, "cleanup", StatsFilter{Environment: "prod", Applied: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Runs != 3 || stats.Failed != 1 {
+		t.Errorf("expected 3 runs with 1 failure, got %d runs with %d failures", stats.Runs, stats.Failed)
+	}
+	if stats.Rows.Min != 90 || stats.Rows.Median != 100 || stats.Rows.Max != 110 {
+		t.Errorf("unexpected row summary: %+v", stats.Rows)
+	}
+	if stats.DurationMS.Median != 20 || stats.DurationMS.P95 != 29 {
+		t.Errorf("unexpected duration summary: %+v", stats.DurationMS)
+	}
+
+	selectStats, err := history.OperationStats(ctx, "count", StatsFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selectStats.Runs != 4 || selectStats.Rows.Max != 1 {
+		t.Errorf("SELECT rows should count returned rows, got %+v", selectStats)
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(NewFileStore(t.TempDir()))
+
+	for i, affected := range []int64{100, 90, 110, 0} {
+		record := RunRecord{
+			ID:          fmt.Sprintf("run-%d", i),
+			Definition:  "cleanup.yaml",
+			Environment: "prod",
+			Status:      StatusPassed,
+			Reports: []definition.Report{
+				{ID: "cleanup", Type: definition.TypeDelete, Pass: true, Result: affected},
+				{ID: "archive", Type: definition.TypeInsert, Pass: i < 2, Result: int64(0)},
+			},
+		}
+		if err := history.SaveRun(ctx, record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	baselines, err := history.RowBaselines(ctx, StatsFilter{Definition: "cleanup.yaml", Environment: "prod"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if baselines["cleanup"].Median != 95 || baselines["archive"].Count != 2 {
+		t.Fatalf("unexpected baselines: %+v", baselines)
+	}
+
+	tests := []struct {
+		name     string
+		cleanup  int64
+		expected []string
+	}{
+		{name: "usual", cleanup: 120},
+		{name: "far above", cleanup: 100000, expected: []string{"cleanup"}},
+		{name: "far below", cleanup: 5, expected: []string{"cleanup"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reports := []definition.Report{
+				{ID: "cleanup", Type: definition.TypeDelete, Result: tt.cleanup},
+				// 失敗を除くと2件しかないので判定しない
+				{ID: "archive", Type: definition.TypeInsert, Result: int64(50000)},
+			}
+			var ids []string
+			for _, anomaly := range DetectAnomalies(reports, baselines, 10, DefaultAnomalyMinSamples) {
+				ids = append(ids, anomaly.OperationID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("got %v, want %v", ids, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const releasesPrefix = "releases/"
+
+// Release is the version of a definition last applied to an environment, kept apart from
+// the run records so that pruning the history does not lose it
+type Release struct {
+	Definition  string   `json:"definition"`
+	Environment string   `json:"environment"`
+	Configs     []string `json:"configs,omitempty"`
+	Checksum    string   `json:"checksum"`
+	// Revision counts the successful applies of the definition to the environment
+	Revision  int       `json:"revision"`
+	RunID     string    `json:"run_id"`
+	Actor     string    `json:"actor"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// RecordRelease records a passed apply as the current release of its definition in its environment
+func (h *History) RecordRelease(ctx context.Context, record RunRecord) (*Release, error) {
+	if record.DryRun || record.Status != StatusPassed {
+		return nil, fmt.Errorf("run %s is not a passed apply", record.ID)
+	}
+
+	key := releaseStoreKey(record.Definition, record.Environment)
+	revision := 1
+	data, err := h.store.Get(ctx, key)
+	switch {
+	case err == nil:
+		var previous Release
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		revision = previous.Revision + 1
+	case !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+
+	release := &Release{
+		Definition:  record.Definition,
+		Environment: record.Environment,
+		Configs:     record.Configs,
+		Checksum:    record.Checksum,
+		Revision:    revision,
+		RunID:       record.ID,
+		Actor:       record.Actor,
+		AppliedAt:   record.FinishedAt,
+	}
+	data, err = json.MarshalIndent(release, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.Put(ctx, key, data); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// RevertRelease makes the apply before the one a passed rollback reverted the current release
+// again, or removes the release when there is no such apply in the history. A release that has
+// moved on to a later apply is left as it is.
+func (h *History) RevertRelease(ctx context.Context, rollback RunRecord) (*Release, error) {
+	if rollback.RollbackOf == "" || rollback.DryRun || rollback.Status != StatusPassed {
+		return nil, fmt.Errorf("run %s is not a passed rollback", rollback.ID)
+	}
+
+	key := releaseStoreKey(rollback.Definition, rollback.Environment)
+	data, err := h.store.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var current Release
+	if err := json.Unmarshal(data, &current); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+	}
+	if current.RunID != rollback.RollbackOf {
+		return &current, nil
+	}
+
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reverted := revertedRuns(records)
+	reverted[rollback.RollbackOf] = true
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Definition != rollback.Definition || record.Environment != rollback.Environment || !isApplied(record) || reverted[record.ID] {
+			continue
+		}
+
+		release := &Release{
+			Definition:  record.Definition,
+			Environment: record.Environment,
+			Configs:     record.Configs,
+			Checksum:    record.Checksum,
+			Revision:    current.Revision + 1,
+			RunID:       record.ID,
+			Actor:       record.Actor,
+			AppliedAt:   record.FinishedAt,
+		}
+		data, err := json.MarshalIndent(release, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := h.store.Put(ctx, key, data); err != nil {
+			return nil, err
+		}
+		return release, nil
+	}
+	return nil, h.store.Delete(ctx, key)
+}
+
+// Releases returns the current releases ordered by environment and definition;
+// a non-empty environment keeps only that environment's
+func (h *History) Releases(ctx context.Context, environment string) ([]Release, error) {
+	keys, err := h.store.List(ctx, releasesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []Release
+	for _, key := range keys {
+		data, err := h.store.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		var release Release
+		if err := json.Unmarshal(data, &release); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		if environment != "" && release.Environment != environment {
+			continue
+		}
+		releases = append(releases, release)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Environment != releases[j].Environment {
+			return releases[i].Environment < releases[j].Environment
+		}
+		return releases[i].Definition < releases[j].Definition
+	})
+	return releases, nil
+}
+
+func releaseStoreKey(definitionName, environment string) string {
+	return releasesPrefix + strings.Trim(unsafeKeyChars.ReplaceAllString(LockKey(definitionName, environment), "_"), "_") + ".json"
+}
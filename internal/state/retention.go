@@ -0,0 +1,69 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// Retention decides which recorded runs are pruned. Zero fields do not limit.
+type Retention struct {
+	// OlderThan prunes runs started before now minus this duration
+	OlderThan time.Duration
+	// KeepLast keeps at most this many of the newest runs
+	KeepLast int
+	// MaxBytes keeps the newest runs whose records fit in this many bytes in total
+	MaxBytes int64
+}
+
+// PruneResult describes the runs removed (or, in a dry run, that would be removed)
+type PruneResult struct {
+	Runs  []RunRecord
+	Bytes int64
+}
+
+// Prune deletes the runs outside the retention; with dryRun it only reports them
+func (h *History) Prune(ctx context.Context, retention Retention, now time.Time, dryRun bool) (*PruneResult, error) {
+	entries, err := h.listRunEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-retention.OlderThan)
+	var kept int
+	var keptBytes int64
+	prune := make([]bool, len(entries))
+
+	// 新しい順に保持枠を消費する
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		switch {
+		case retention.OlderThan > 0 && entry.record.StartedAt.Before(cutoff):
+			prune[i] = true
+		case retention.KeepLast > 0 && kept >= retention.KeepLast:
+			prune[i] = true
+		case retention.MaxBytes > 0 && keptBytes+entry.size > retention.MaxBytes:
+			prune[i] = true
+		default:
+			kept++
+			keptBytes += entry.size
+		}
+	}
+
+	result := &PruneResult{}
+	for i, entry := range entries {
+		if !prune[i] {
+			continue
+		}
+		if !dryRun {
+			if err := h.store.Delete(ctx, entry.key); err != nil {
+				return result, err
+			}
+			if err := h.store.Delete(ctx, undoKey(entry.record.ID)); err != nil {
+				return result, err
+			}
+		}
+		result.Runs = append(result.Runs, entry.record)
+		result.Bytes += entry.size
+	}
+	return result, nil
+}
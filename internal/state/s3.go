@@ -0,0 +1,193 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Store keeps state as objects under a prefix of an S3 bucket.
+// Credentials and region come from the standard AWS environment.
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Store(ctx context.Context, bucket, prefix string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &S3Store{
+		client: s3.NewFromConfig(cfg),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer func() { _ = out.Body.Close() }()
+
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// PutIfAbsent relies on S3 conditional writes (If-None-Match: *)
+func (s *S3Store) PutIfAbsent(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	if conditionFailed(err) {
+		return ErrExists
+	}
+	return err
+}
+
+// Replace compares the object with old and overwrites it only if its ETag is unchanged (If-Match)
+func (s *S3Store) Replace(ctx context.Context, key string, old, data []byte) error {
+	etag, err := s.matchingETag(ctx, key, old)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.prefix + key),
+		Body:    bytes.NewReader(data),
+		IfMatch: aws.String(etag),
+	})
+	if conditionFailed(err) || objectGone(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// DeleteIf compares the object with old and deletes it only if its ETag is unchanged (If-Match)
+func (s *S3Store) DeleteIf(ctx context.Context, key string, old []byte) error {
+	etag, err := s.matchingETag(ctx, key, old)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.prefix + key),
+		IfMatch: aws.String(etag),
+	})
+	if conditionFailed(err) || objectGone(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// matchingETag returns the ETag of the object when it holds old, and ErrConflict otherwise
+func (s *S3Store) matchingETag(ctx context.Context, key string, old []byte) (string, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", ErrConflict
+		}
+		return "", err
+	}
+	defer func() { _ = out.Body.Close() }()
+
+	current, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(current, old) {
+		return "", ErrConflict
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// conditionFailed reports whether a conditional write was rejected because the object changed
+func conditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(object.Key), s.prefix))
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *S3Store) Close() error {
+	return nil
+}
+
+// objectGone reports whether a conditional write failed because the object was deleted meanwhile
+func objectGone(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound")
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+// OperationSample is one execution of an operation in the run history
+type OperationSample struct {
+	RunID       string    `json:"run_id"`
+	Definition  string    `json:"definition"`
+	Environment string    `json:"environment"`
+	DryRun      bool      `json:"dry_run"`
+	StartedAt   time.Time `json:"started_at"`
+	Pass        bool      `json:"pass"`
+	DurationMS  int64     `json:"duration_ms"`
+	// Rows is the affected row count of DML and the returned row count of SELECT
+	Rows *int64 `json:"rows,omitempty"`
+}
+
+// Summary describes a series of values
+type Summary struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// OperationStats aggregates the executions of an operation across runs
+type OperationStats struct {
+	OperationID string            `json:"operation_id"`
+	Runs        int               `json:"runs"`
+	Failed      int               `json:"failed"`
+	DurationMS  Summary           `json:"duration_ms"`
+	Rows        Summary           `json:"rows"`
+	Samples     []OperationSample `json:"samples"`
+}
+
+// StatsFilter limits the runs taken into account; empty fields match every run
+type StatsFilter struct {
+	Definition  string
+	Environment string
+	// Applied leaves dry runs out
+	Applied bool
+}
+
+func (f StatsFilter) matches(record RunRecord) bool {
+	return (f.Definition == "" || record.Definition == f.Definition) &&
+		(f.Environment == "" || record.Environment == f.Environment) &&
+		(!f.Applied || !record.DryRun)
+}
+
+// OperationSamples returns the executions of the operation in the matching runs, oldest first
+func (h *History) OperationSamples(ctx context.Context, operationID string, filter StatsFilter) ([]OperationSample, error) {
+	records, err := h.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []OperationSample
+	for _, record := range records {
+		if !filter.matches(record) {
+			continue
+		}
+		for _, report := range record.Reports {
+			if report.ID != operationID {
+				continue
+			}
+			sample := OperationSample{
+				RunID:       record.ID,
+				Definition:  record.Definition,
+				Environment: record.Environment,
+				DryRun:      record.DryRun,
+				StartedAt:   record.StartedAt,
+				Pass:        report.Pass,
+				DurationMS:  report.DurationMS,
+			}
+			if rows, ok := RowCount(report); ok {
+				sample.Rows = &rows
+			}
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// OperationStats aggregates the executions of the operation in the matching runs
+func (h *History) OperationStats(ctx context.Context, operationID string, filter StatsFilter) (*OperationStats, error) {
+	samples, err := h.OperationSamples(ctx, operationID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &OperationStats{OperationID: operationID, Runs: len(samples), Samples: samples}
+	var durations, rows []float64
+	for _, sample := range samples {
+		if !sample.Pass {
+			stats.Failed++
+		}
+		durations = append(durations, float64(sample.DurationMS))
+		if sample.Rows != nil {
+			rows = append(rows, float64(*sample.Rows))
+		}
+	}
+	stats.DurationMS = Summarize(durations)
+	stats.Rows = Summarize(rows)
+	return stats, nil
+}
+
+// RowCount returns the affected rows of a DML report or the returned rows of a SELECT report.
+// Reports read back from history hold JSON numbers and arrays.
+func RowCount(report definition.Report) (int64, bool) {
+	switch result := report.Result.(type) {
+	case int64:
+		return result, true
+	case int:
+		return int64(result), true
+	case float64:
+		return int64(result), true
+	case []interface{}:
+		return int64(len(result)), true
+	case []map[string]interface{}:
+		return int64(len(result)), true
+	default:
+		return 0, false
+	}
+}
+
+// Summarize returns the count, min, median, 95th percentile and max of values
+func Summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return Summary{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// percentile interpolates linearly between the closest ranks of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pyama86/opsql/internal/database"
+)
+
+// StateStore is the key-value storage behind run history, locks and other persisted state.
+// Keys are slash-separated paths such as "runs/<id>.json".
+type StateStore interface {
+	// Get returns ErrNotFound when the key does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	// PutIfAbsent returns ErrExists when the key already exists
+	PutIfAbsent(ctx context.Context, key string, data []byte) error
+	// Replace writes data only while the key still holds old and returns ErrConflict otherwise
+	Replace(ctx context.Context, key string, old, data []byte) error
+	Delete(ctx context.Context, key string) error
+	// DeleteIf removes the key only while it still holds old and returns ErrConflict otherwise
+	DeleteIf(ctx context.Context, key string, old []byte) error
+	// List returns the keys under the prefix in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+	Close() error
+}
+
+// Open returns the store for a backend location:
+//
+//	/path/to/dir, file:///path/to/dir   local directory
+//	s3://bucket/prefix                  S3 bucket
+//	database                            opsql_state table in the target database (targetDSN)
+//	postgres://..., mysql://..., ...    opsql_state table in another database
+//
+// schema places the opsql_state table in that schema instead of the connection's default.
+func Open(ctx context.Context, location, targetDSN, schema string) (StateStore, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 state backend: %s (expected s3://bucket/prefix)", location)
+		}
+		return NewS3Store(ctx, bucket, prefix)
+	case location == "database":
+		if targetDSN == "" {
+			return nil, fmt.Errorf("database state backend requires DATABASE_DSN")
+		}
+		return openDatabaseStore(ctx, targetDSN, schema)
+	case strings.HasPrefix(location, "file://"):
+		return NewFileStore(strings.TrimPrefix(location, "file://")), nil
+	case strings.Contains(location, "://") || strings.Contains(location, "@tcp("):
+		return openDatabaseStore(ctx, location, schema)
+	default:
+		if _, err := os.Stat(location); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("invalid file state backend: %w", err)
+		}
+		return NewFileStore(location), nil
+	}
+}
+
+func openDatabaseStore(ctx context.Context, dsn, schema string) (StateStore, error) {
+	db, err := database.NewDatabase(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to state database: %w", err)
+	}
+
+	store := NewDatabaseStore(db, schema)
+	if err := store.Init(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
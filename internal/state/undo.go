@@ -0,0 +1,38 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pyama86/opsql/internal/definition"
+)
+
+const undoPrefix = "undo/"
+
+func undoKey(runID string) string {
+	return undoPrefix + runID + ".json"
+}
+
+// SaveUndo records the undo plan of an apply, kept apart from the run record so that the rows
+// it restores stay out of the reports
+func (h *History) SaveUndo(ctx context.Context, plan definition.UndoPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return h.store.Put(ctx, undoKey(plan.RunID), data)
+}
+
+// GetUndo returns the undo plan of a run or ErrNotFound
+func (h *History) GetUndo(ctx context.Context, runID string) (*definition.UndoPlan, error) {
+	data, err := h.store.Get(ctx, undoKey(runID))
+	if err != nil {
+		return nil, err
+	}
+	var plan definition.UndoPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse the undo plan of run %s: %w", runID, err)
+	}
+	return &plan, nil
+}
@@ -0,0 +1,239 @@
+// Package opsqltest runs opsql definitions in Go tests, against sqlmock or a throwaway database
+// container, so runbooks can be tested in the CI of the repositories that keep them:
+//
+//	func TestCleanupRunbook(t *testing.T) {
+//		target := opsqltest.NewMock(t, "mysql")
+//		target.Mock.ExpectBegin()
+//		target.Mock.ExpectExec("DELETE FROM orders").WillReturnResult(sqlmock.NewResult(0, 3))
+//		target.Mock.ExpectRollback()
+//
+//		run := opsqltest.Plan(t, target, []string{"runbooks/cleanup.yaml"}, opsqltest.WithParam("cutoff", "2025-01-01"))
+//		run.RequirePassed(t)
+//	}
+package opsqltest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/ephemeral"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/fixture"
+)
+
+type (
+	// Report is the report of an operation, as opsql run prints it
+	Report = definition.Report
+	// Failure is the machine-readable reason an operation did not pass
+	Failure = definition.Failure
+)
+
+// Target is the database a test runs definitions against
+type Target struct {
+	db database.DB
+	// Mock sets the expectations of a sqlmock target; it is nil for containers
+	Mock sqlmock.Sqlmock
+}
+
+// NewMock returns a sqlmock target with the SQL dialect of driver (mysql or postgres). Expected
+// queries are regular expressions, and the expectations must be met by the end of the test.
+func NewMock(t testing.TB, driver string) *Target {
+	t.Helper()
+	if driver != "mysql" && driver != "postgres" {
+		t.Fatalf("opsqltest: unsupported driver %q (expected mysql or postgres)", driver)
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("opsqltest: failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("opsqltest: %v", err)
+		}
+		_ = db.Close()
+	})
+	return &Target{db: &database.Database{DB: sqlx.NewDb(db, driver)}, Mock: mock}
+}
+
+// NewEphemeral starts a throwaway database container from image (e.g. mysql:8, postgres:16),
+// removed at the end of the test. It needs a Docker daemon.
+func NewEphemeral(t testing.TB, image string) *Target {
+	t.Helper()
+	ctx := context.Background()
+	container, err := ephemeral.Start(ctx, image)
+	if err != nil {
+		t.Fatalf("opsqltest: %v", err)
+	}
+	t.Cleanup(func() { _ = container.Terminate() })
+
+	db, err := database.NewDatabase(container.DSN)
+	if err != nil {
+		t.Fatalf("opsqltest: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return &Target{db: db}
+}
+
+// Exec runs statements against the target, e.g. to create tables and seed rows
+func (tg *Target) Exec(t testing.TB, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if _, err := tg.db.ExecContext(context.Background(), statement); err != nil {
+			t.Fatalf("opsqltest: %s: %v", statement, err)
+		}
+	}
+}
+
+// LoadFixture loads a SQL file, or a CSV file into the table named after it, into the target
+func (tg *Target) LoadFixture(t testing.TB, path string) {
+	t.Helper()
+	if err := fixture.Load(context.Background(), tg.db, definition.Fixture{File: path}); err != nil {
+		t.Fatalf("opsqltest: %v", err)
+	}
+}
+
+// Query returns the rows of a query against the target, to check the state after an apply
+func (tg *Target) Query(t testing.TB, query string, args ...interface{}) []map[string]interface{} {
+	t.Helper()
+	rows, err := tg.db.QueryRowsContext(context.Background(), database.Rebind(tg.db.DriverName(), query), args...)
+	if err != nil {
+		t.Fatalf("opsqltest: %s: %v", query, err)
+	}
+	return rows
+}
+
+// Option configures a run
+type Option func(*runOptions)
+
+type runOptions struct {
+	params     map[string]string
+	sampleRows int
+}
+
+// WithParam overrides a definition param, as --param name=value does
+func WithParam(name, value string) Option {
+	return func(o *runOptions) {
+		o.params[name] = value
+	}
+}
+
+// WithSampleRows includes up to n of the rows each DML operation touches in its report
+func WithSampleRows(n int) Option {
+	return func(o *runOptions) {
+		o.sampleRows = n
+	}
+}
+
+// Run is the outcome of running definitions
+type Run struct {
+	Reports []Report
+	// Err is the error opsql run would exit with, nil when every operation passed
+	Err error
+}
+
+// Plan runs the definitions in a transaction that is rolled back, as opsql run --dry-run does
+func Plan(t testing.TB, target *Target, configs []string, opts ...Option) *Run {
+	t.Helper()
+	return run(t, target, configs, true, opts)
+}
+
+// Apply runs the definitions and commits them when every operation passes, as opsql run does
+func Apply(t testing.TB, target *Target, configs []string, opts ...Option) *Run {
+	t.Helper()
+	return run(t, target, configs, false, opts)
+}
+
+func run(t testing.TB, target *Target, configs []string, dryRun bool, opts []Option) *Run {
+	t.Helper()
+	options := &runOptions{params: make(map[string]string)}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// 読み込めない定義はテスト対象の誤りなので、その場で失敗させる
+	def, err := definition.LoadDefinitionsWithParams(configs, options.params)
+	if err != nil {
+		t.Fatalf("opsqltest: failed to load definition: %v", err)
+	}
+	ctx := context.Background()
+	if def.HasDerivedParams() {
+		if err := def.ResolveDerivedParams(ctx, target.db); err != nil {
+			t.Fatalf("opsqltest: failed to resolve derived params: %v", err)
+		}
+	}
+
+	executorOpts := []executor.Option{executor.WithSampleRows(options.sampleRows)}
+	result := &Run{}
+	if dryRun {
+		result.Reports, result.Err = executor.NewPlanExecutor(target.db, executorOpts...).Execute(ctx, def)
+	} else {
+		result.Reports, result.Err = executor.NewApplyExecutor(target.db, executorOpts...).Execute(ctx, def)
+	}
+	return result
+}
+
+// Report returns the report of the operation id, failing the test when there is none
+func (r *Run) Report(t testing.TB, id string) *Report {
+	t.Helper()
+	for i := range r.Reports {
+		if r.Reports[i].ID == id {
+			return &r.Reports[i]
+		}
+	}
+	t.Fatalf("opsqltest: no report for operation %q (reported: %s)", id, strings.Join(r.ids(), ", "))
+	return nil
+}
+
+// RequirePassed fails the test unless every operation ran and passed
+func (r *Run) RequirePassed(t testing.TB) {
+	t.Helper()
+	var failures []string
+	for _, report := range r.Reports {
+		if !report.Pass {
+			failures = append(failures, fmt.Sprintf("%s: %s", report.ID, report.Message))
+		}
+	}
+	if r.Err != nil || len(failures) > 0 {
+		t.Fatalf("opsqltest: run failed: %v\n%s", r.Err, strings.Join(failures, "\n"))
+	}
+}
+
+// RequireFailure fails the test unless the operation id failed with the failure code
+// (definition failure codes such as VALUE_MISMATCH or AFFECTED_ROWS_MISMATCH)
+func (r *Run) RequireFailure(t testing.TB, id, code string) {
+	t.Helper()
+	report := r.Report(t, id)
+	if report.Pass || report.Failure == nil {
+		t.Fatalf("opsqltest: operation %s passed, expected it to fail with %s", id, code)
+	}
+	if report.Failure.Code != code {
+		t.Fatalf("opsqltest: operation %s failed with %s, expected %s: %s", id, report.Failure.Code, code, report.Message)
+	}
+}
+
+// RequireAffected fails the test unless the DML operation id affected n rows
+func (r *Run) RequireAffected(t testing.TB, id string, n int64) {
+	t.Helper()
+	report := r.Report(t, id)
+	affected, ok := report.Result.(int64)
+	if !ok {
+		t.Fatalf("opsqltest: operation %s has no affected rows (result %v)", id, report.Result)
+	}
+	if affected != n {
+		t.Fatalf("opsqltest: operation %s affected %d rows, expected %d", id, affected, n)
+	}
+}
+
+func (r *Run) ids() []string {
+	ids := make([]string, len(r.Reports))
+	for i, report := range r.Reports {
+		ids[i] = report.ID
+	}
+	return ids
+}
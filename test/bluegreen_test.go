@@ -0,0 +1,46 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/pyama86/opsql/internal/bluegreen"
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlueGreenCompare(t *testing.T) {
+	blue := []definition.Report{
+		{ID: "counts", Type: "select", Pass: true, Result: []map[string]interface{}{
+			{"status": "active", "c": int64(120), "total": database.Decimal("10.50")},
+			{"status": "closed", "c": int64(7), "total": database.Decimal("3.00")},
+		}},
+		{ID: "cleanup", Type: "delete", Pass: true, Result: int64(3)},
+		{ID: "orphans", Type: "select", Pass: true, Result: []map[string]interface{}{{"c": int64(0)}}},
+	}
+	green := []definition.Report{
+		{ID: "counts", Type: "select", Pass: true, Result: []map[string]interface{}{
+			{"status": "active", "c": int64(118), "total": 10.5},
+		}},
+		{ID: "cleanup", Type: "delete", Pass: true, Result: int64(3)},
+		{ID: "orphans", Type: "select", Pass: false, Result: []map[string]interface{}{{"c": int64(2)}},
+			Failure: &definition.Failure{Code: definition.FailureValueMismatch}},
+	}
+
+	diffs := bluegreen.Compare(blue, green)
+	assert.Equal(t, []bluegreen.Difference{
+		{Operation: "counts", Field: "rows", Blue: 2, Green: 1},
+		{Operation: "counts", Field: "result[0].c", Blue: int64(120), Green: int64(118)},
+		{Operation: "counts", Field: "result[1]", Blue: blue[0].Result.([]map[string]interface{})[1], Green: bluegreen.Missing},
+		{Operation: "orphans", Field: "pass", Blue: true, Green: false},
+		{Operation: "orphans", Field: "failure.code", Blue: "", Green: definition.FailureValueMismatch},
+		{Operation: "orphans", Field: "result[0].c", Blue: int64(0), Green: int64(2)},
+	}, diffs)
+	assert.Equal(t, "counts: result[0].c: blue 120, green 118", diffs[1].String())
+
+	assert.Empty(t, bluegreen.Compare(blue, blue))
+	assert.Equal(t, []bluegreen.Difference{
+		{Operation: "counts", Field: "executed", Blue: true, Green: bluegreen.Missing},
+		{Operation: "orphans", Field: "executed", Blue: true, Green: bluegreen.Missing},
+	}, bluegreen.Compare(blue, green[1:2]))
+}
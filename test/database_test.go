@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseQueryRowsKeepsNumericPrecision(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		mock.NewColumn("balance").OfType("DECIMAL", nil),
+		mock.NewColumn("id").OfType("BIGINT", nil),
+		mock.NewColumn("counter").OfType("UNSIGNED BIGINT", nil),
+		mock.NewColumn("ratio").OfType("DOUBLE", nil),
+		mock.NewColumn("name").OfType("VARCHAR", nil),
+	).AddRow([]byte("12345678901234567890.12"), []byte("9007199254740993"), []byte("18446744073709551615"), []byte("0.25"), "alice")
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	conn := &database.Database{DB: sqlx.NewDb(db, "mysql")}
+	result, err := conn.QueryRowsContext(context.Background(), "SELECT balance, id, counter, ratio, name FROM accounts")
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+
+	row := result[0]
+	assert.Equal(t, database.Decimal("12345678901234567890.12"), row["balance"])
+	assert.Equal(t, int64(9007199254740993), row["id"])
+	assert.Equal(t, uint64(18446744073709551615), row["counter"])
+	assert.Equal(t, 0.25, row["ratio"])
+	assert.Equal(t, "alice", row["name"])
+
+	data, err := json.Marshal(row)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"balance": "12345678901234567890.12", "id": 9007199254740993, "counter": 18446744073709551615, "ratio": 0.25, "name": "alice"}`, string(data))
+	assert.Contains(t, string(data), `"id":9007199254740993`)
+}
+
+func TestDatabaseQueryRowsRendersBinaryColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		mock.NewColumn("avatar").OfType("BLOB", nil),
+		mock.NewColumn("note").OfType("TEXT", nil),
+		mock.NewColumn("raw").OfType("GEOMETRY", nil),
+	).AddRow([]byte{0x89, 0x50, 0x4e, 0x47}, []byte("hello"), []byte{0xff, 0xfe})
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	conn := &database.Database{DB: sqlx.NewDb(db, "mysql")}
+	result, err := conn.QueryRowsContext(context.Background(), "SELECT avatar, note, raw FROM users")
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+
+	row := result[0]
+	assert.Equal(t, database.Binary{0x89, 0x50, 0x4e, 0x47}, row["avatar"])
+	assert.Equal(t, "hello", row["note"])
+	// 型が分からなくてもUTF-8でないバイト列はバイナリとして扱う
+	assert.Equal(t, database.Binary{0xff, 0xfe}, row["raw"])
+
+	data, err := json.Marshal(row)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"avatar": {"base64": "iVBORw==", "length": 4}, "note": "hello", "raw": {"base64": "//4=", "length": 2}}`, string(data))
+	assert.Equal(t, "base64:iVBORw== (4 bytes)", row["avatar"].(database.Binary).String())
+}
+
+func TestDatabaseQueryRowsRejectsDuplicateColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "id"}).AddRow(1, "alice", 10))
+
+	conn := &database.Database{DB: sqlx.NewDb(db, "mysql")}
+	_, err = conn.QueryRowsContext(context.Background(), "SELECT * FROM users u JOIN orders o ON o.user_id = u.id")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate column names in result: id")
+	assert.Contains(t, err.Error(), "AS")
+}
+
+func TestReadBinlogSettings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectQuery("SELECT @@GLOBAL.log_bin").WillReturnRows(
+		sqlmock.NewRows([]string{"log_bin", "sql_log_bin", "binlog_format"}).AddRow(1, 0, "statement"))
+
+	conn := &database.Database{DB: sqlx.NewDb(db, "mysql")}
+	settings, err := database.ReadBinlogSettings(context.Background(), conn)
+	require.NoError(t, err)
+	assert.Equal(t, &database.BinlogSettings{LogBin: true, SQLLogBin: false, Format: "STATEMENT"}, settings)
+	problems := settings.Problems()
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "sql_log_bin is disabled")
+	// changes left out of the binary log are not replicated as statements either
+	assert.False(t, settings.StatementBased())
+
+	assert.Empty(t, (&database.BinlogSettings{LogBin: false, SQLLogBin: false, Format: "ROW"}).Problems())
+	assert.True(t, (&database.BinlogSettings{LogBin: true, SQLLogBin: true, Format: "STATEMENT"}).StatementBased())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
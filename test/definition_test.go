@@ -1,8 +1,12 @@
 package test
 
 import (
+	"context"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/pyama86/opsql/internal/definition"
 )
 
@@ -81,3 +85,130 @@ func TestLoadDefinitionWithAutoDetection(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractTables(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected []string
+	}{
+		{
+			name:     "simple select",
+			sql:      "SELECT id FROM users WHERE id = 1",
+			expected: []string{"users"},
+		},
+		{
+			name:     "join and subquery",
+			sql:      "SELECT u.id FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id IN (SELECT user_id FROM bans)",
+			expected: []string{"users", "orders", "bans"},
+		},
+		{
+			name:     "insert select",
+			sql:      "INSERT INTO user_logs (user_id) SELECT id FROM users",
+			expected: []string{"user_logs", "users"},
+		},
+		{
+			name:     "update with quoted schema-qualified table",
+			sql:      "UPDATE `app.users` SET status = 'inactive'",
+			expected: []string{"app.users"},
+		},
+		{
+			name:     "delete",
+			sql:      "DELETE FROM user_sessions WHERE user_id NOT IN (SELECT id FROM users)",
+			expected: []string{"user_sessions", "users"},
+		},
+		{
+			name:     "function in from clause",
+			sql:      "SELECT * FROM generate_series(1, 3)",
+			expected: nil,
+		},
+		{
+			name:     "from inside function arguments",
+			sql:      "SELECT EXTRACT(YEAR FROM created_at) AS y, TRIM(BOTH ' ' FROM name) AS n FROM users",
+			expected: []string{"users"},
+		},
+		{
+			name:     "keywords inside string literals and comments",
+			sql:      "UPDATE items SET note = 'moved from archive', memo = 'it''s from legacy' -- from old\nWHERE id = 1 /* join other */",
+			expected: []string{"items"},
+		},
+		{
+			name:     "comma-separated tables",
+			sql:      "SELECT * FROM users u, orders AS o, (SELECT id FROM bans WHERE id > 0) b, refunds WHERE u.id = o.user_id",
+			expected: []string{"users", "orders", "bans", "refunds"},
+		},
+		{
+			name:     "dual, distinct from and upserts",
+			sql:      "INSERT INTO counters(id, n) SELECT 1, 1 FROM DUAL WHERE 1 IS DISTINCT FROM x ON DUPLICATE KEY UPDATE n = n + 1",
+			expected: []string{"counters"},
+		},
+		{
+			name:     "common table expressions and subqueries in functions",
+			sql:      "WITH stale AS (SELECT id FROM sessions) SELECT COALESCE((SELECT MAX(id) FROM logs), 0) FROM stale WHERE EXISTS(SELECT 1 FROM users)",
+			expected: []string{"sessions", "logs", "users"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := definition.ExtractTables(tt.sql)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ExtractTables() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveDerivedParams(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Params: map[string]interface{}{"tenant": "acme"},
+		DerivedParams: []definition.DerivedParam{
+			{Name: "tenant_id", SQL: "SELECT id FROM tenants WHERE name = {{ bind .params.tenant }}"},
+			{Name: "user_ids", SQL: "SELECT id FROM users WHERE tenant_id = {{ .params.tenant_id }}", List: true},
+		},
+		Operations: []definition.Operation{
+			{ID: "purge", Type: "delete", SQL: "DELETE FROM users WHERE id IN ({{ inList .params.user_ids }})"},
+		},
+	}
+
+	mock.ExpectQuery("SELECT id FROM tenants WHERE name = \\?").
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(7)))
+	mock.ExpectQuery("SELECT id FROM users WHERE tenant_id = 7").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)).AddRow(int64(2)))
+
+	if err := def.ResolveDerivedParams(context.Background(), &MockDatabase{db: db, mock: mock}); err != nil {
+		t.Fatalf("ResolveDerivedParams() error = %v", err)
+	}
+
+	if want := "DELETE FROM users WHERE id IN (1, 2)"; def.Operations[0].SQL != want {
+		t.Errorf("SQL = %q, want %q", def.Operations[0].SQL, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResolveDerivedParamsRequiresOneRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		DerivedParams: []definition.DerivedParam{{Name: "tenant_id", SQL: "SELECT id FROM tenants WHERE name = 'missing'"}},
+	}
+	mock.ExpectQuery("SELECT id FROM tenants").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	err = def.ResolveDerivedParams(context.Background(), &MockDatabase{db: db, mock: mock})
+	if err == nil || !strings.Contains(err.Error(), "exactly one row, got 0") {
+		t.Errorf("expected one-row error, got %v", err)
+	}
+}
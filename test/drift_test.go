@@ -0,0 +1,58 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/drift"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriftNewlyFailing(t *testing.T) {
+	previous := []definition.Report{
+		{ID: "orphans", Pass: true},
+		{ID: "duplicates", Pass: false},
+	}
+	current := []definition.Report{
+		{ID: "orphans", Pass: false},
+		{ID: "duplicates", Pass: false},
+		{ID: "negative_balance", Pass: false},
+		{ID: "stale_sessions", Pass: true},
+	}
+
+	newly := drift.NewlyFailing(previous, current)
+	require.Len(t, newly, 2)
+	assert.Equal(t, "orphans", newly[0].ID)
+	assert.Equal(t, "negative_balance", newly[1].ID)
+
+	assert.Len(t, drift.NewlyFailing(nil, current), 3, "without a previous check every failure is new")
+	assert.Empty(t, drift.NewlyFailing(current, current))
+}
+
+func TestDriftFormatIssue(t *testing.T) {
+	row := 0
+	newly := []definition.Report{{
+		ID:          "orphans",
+		Description: "No orders without users",
+		SQL:         "SELECT COUNT(*) AS count FROM orders o LEFT JOIN users u ON u.id = o.user_id WHERE u.id IS NULL",
+		Message:     "row 0 column count: expected 0, got 3",
+		Failure: &definition.Failure{
+			Code:        definition.FailureValueMismatch,
+			Row:         &row,
+			Column:      "count",
+			ExpectedRow: map[string]interface{}{"count": 0},
+			ActualRow:   map[string]interface{}{"count": 3},
+		},
+	}}
+
+	body := drift.FormatIssue("checks.yaml", "prod", newly, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	assert.Contains(t, body, "### 1 assertion(s) newly failing")
+	assert.Contains(t, body, "**Environment:** prod")
+	assert.Contains(t, body, "**Checked At:** 2026-10-16T12:00:00Z")
+	assert.Contains(t, body, "#### ❌ orphans - No orders without users")
+	assert.Contains(t, body, "```diff\n- {\n-   \"count\": 0\n- }\n+ {\n+   \"count\": 3\n+ }\n```")
+
+	assert.Equal(t, "opsql drift: checks.yaml [prod]", drift.IssueTitle("checks.yaml", "prod"))
+}
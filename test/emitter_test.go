@@ -0,0 +1,57 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/emitter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitterWebhook(t *testing.T) {
+	var received struct {
+		Events []emitter.Event `json:"events"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	reports := []definition.Report{
+		{ID: "check", Type: definition.TypeSelect, SQL: "SELECT 1", Pass: true},
+		{
+			ID:           "deactivate",
+			Type:         definition.TypeUpdate,
+			SQL:          "UPDATE users SET status = 'inactive' WHERE id IN (3, 5)",
+			Pass:         true,
+			CapturedKeys: []map[string]interface{}{{"id": 3}, {"id": 5}},
+		},
+	}
+	appliedAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	events := emitter.Events(reports, "prod", "ops.yaml", appliedAt)
+	require.Len(t, events, 2)
+
+	e, err := emitter.Open(context.Background(), server.URL)
+	require.NoError(t, err)
+	require.NoError(t, e.Emit(context.Background(), events))
+
+	require.Len(t, received.Events, 2)
+	assert.Equal(t, "deactivate", received.Events[0].OperationID)
+	assert.Equal(t, "users", received.Events[0].Table)
+	assert.Equal(t, "prod", received.Events[0].Environment)
+	assert.Equal(t, float64(5), received.Events[1].Keys["id"])
+	assert.True(t, appliedAt.Equal(received.Events[1].AppliedAt))
+}
+
+func TestEmitterOpenUnsupported(t *testing.T) {
+	_, err := emitter.Open(context.Background(), "ftp://example.com")
+	assert.Error(t, err)
+}
@@ -3,9 +3,14 @@ package test
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
 	"github.com/pyama86/opsql/internal/database"
 	"github.com/pyama86/opsql/internal/definition"
 	"github.com/pyama86/opsql/internal/executor"
@@ -71,6 +76,10 @@ func (m *MockDatabase) BeginTransaction(ctx context.Context) (database.Transacti
 	return &MockTransaction{tx: tx}, nil
 }
 
+func (m *MockDatabase) DriverName() string {
+	return "mysql"
+}
+
 func (m *MockDatabase) Close() error {
 	return m.db.Close()
 }
@@ -348,3 +357,1179 @@ func TestApplyExecutor_TransactionExecution(t *testing.T) {
 		})
 	}
 }
+
+func TestPlanExecutor_FailureCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation definition.Operation
+		setupMock func(sqlmock.Sqlmock)
+		wantCode  string
+	}{
+		{
+			name: "row count mismatch",
+			operation: definition.Operation{
+				ID:       "check_users",
+				Type:     definition.TypeSelect,
+				SQL:      "SELECT id FROM users",
+				Expected: []map[string]interface{}{{"id": int64(1)}},
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+			},
+			wantCode: definition.FailureRowCountMismatch,
+		},
+		{
+			name: "value mismatch",
+			operation: definition.Operation{
+				ID:       "check_users",
+				Type:     definition.TypeSelect,
+				SQL:      "SELECT id FROM users",
+				Expected: []map[string]interface{}{{"id": int64(1)}},
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id FROM users").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			},
+			wantCode: definition.FailureValueMismatch,
+		},
+		{
+			name: "affected rows mismatch",
+			operation: definition.Operation{
+				ID:              "delete_users",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM users",
+				ExpectedChanges: map[string]int{"delete": 1},
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+			wantCode: definition.FailureAffectedRowsMismatch,
+		},
+		{
+			name: "sql error",
+			operation: definition.Operation{
+				ID:              "delete_users",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM users",
+				ExpectedChanges: map[string]int{"delete": 1},
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM users").WillReturnError(sql.ErrConnDone)
+			},
+			wantCode: definition.FailureSQLError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = db.Close() }()
+
+			mock.ExpectBegin()
+			tt.setupMock(mock)
+			mock.ExpectRollback()
+
+			planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+			reports, _ := planExecutor.Execute(context.Background(), &definition.Definition{
+				Version:    1,
+				Operations: []definition.Operation{tt.operation},
+			})
+
+			require.Len(t, reports, 1)
+			assert.False(t, reports[0].Pass)
+			require.NotNil(t, reports[0].Failure)
+			assert.Equal(t, tt.wantCode, reports[0].Failure.Code)
+			assert.NotNil(t, reports[0].Expected)
+			if tt.wantCode == definition.FailureValueMismatch {
+				assert.Equal(t, tt.operation.Expected[0], reports[0].Failure.ExpectedRow)
+				assert.Equal(t, map[string]interface{}{"id": int64(2)}, reports[0].Failure.ActualRow)
+			}
+		})
+	}
+}
+
+func TestPlanExecutor_KeyColumns(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []map[string]interface{}
+		wantCode string
+	}{
+		{
+			name:     "subset in any order",
+			expected: []map[string]interface{}{{"id": 3, "status": "paid"}, {"id": 1, "status": "pending"}},
+		},
+		{
+			name:     "value mismatch",
+			expected: []map[string]interface{}{{"id": 2, "status": "paid"}},
+			wantCode: definition.FailureValueMismatch,
+		},
+		{
+			name:     "missing row",
+			expected: []map[string]interface{}{{"id": 4, "status": "paid"}},
+			wantCode: definition.FailureMissingRow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = db.Close() }()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, status FROM orders").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).
+					AddRow(1, "pending").AddRow(2, "shipped").AddRow(3, "paid"))
+			mock.ExpectRollback()
+
+			planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+			reports, _ := planExecutor.Execute(context.Background(), &definition.Definition{
+				Version: 1,
+				Operations: []definition.Operation{{
+					ID:         "check_orders",
+					Type:       definition.TypeSelect,
+					SQL:        "SELECT id, status FROM orders",
+					Expected:   tt.expected,
+					KeyColumns: definition.Keys{"id"},
+				}},
+			})
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+			require.Len(t, reports, 1)
+			if tt.wantCode == "" {
+				assert.True(t, reports[0].Pass, reports[0].Message)
+				return
+			}
+			require.NotNil(t, reports[0].Failure)
+			assert.Equal(t, tt.wantCode, reports[0].Failure.Code)
+			assert.Equal(t, tt.expected[0], reports[0].Failure.ExpectedRow)
+		})
+	}
+}
+
+func TestPlanExecutor_Unordered(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM tags").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("go").AddRow("sql"))
+	mock.ExpectQuery("SELECT name FROM tags").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("go").AddRow("yaml"))
+	mock.ExpectRollback()
+
+	expected := []map[string]interface{}{{"name": "sql"}, {"name": "go"}}
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := planExecutor.Execute(context.Background(), &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{ID: "tags", Type: definition.TypeSelect, SQL: "SELECT name FROM tags", Expected: expected, Unordered: true},
+			{ID: "tags_again", Type: definition.TypeSelect, SQL: "SELECT name FROM tags", Expected: expected, Unordered: true},
+		},
+	})
+
+	require.Error(t, err)
+	require.Len(t, reports, 2)
+	assert.True(t, reports[0].Pass, reports[0].Message)
+	require.NotNil(t, reports[1].Failure)
+	assert.Equal(t, definition.FailureMissingRow, reports[1].Failure.Code)
+	assert.Equal(t, expected[0], reports[1].Failure.ExpectedRow)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestShadowExecutor_ClonesTablesAndCommits(t *testing.T) {
+	sourceDB, sourceMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = sourceDB.Close() }()
+
+	shadowDB, shadowMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = shadowDB.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "deactivate_user",
+				Type:            definition.TypeUpdate,
+				SQL:             "UPDATE users SET status = 'inactive' WHERE id = 1",
+				ExpectedChanges: map[string]int{"update": 1},
+			},
+		},
+	}
+
+	// 更新される行だけを写す
+	sourceMock.ExpectQuery("SELECT \\* FROM \\(SELECT \\* FROM users WHERE id = 1\\) opsql_shadow LIMIT 10001").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "active"))
+
+	shadowMock.ExpectBegin()
+	shadowMock.ExpectExec("DELETE FROM `users`").WillReturnResult(sqlmock.NewResult(0, 0))
+	shadowMock.ExpectExec("INSERT INTO `users` \\(`id`, `status`\\) VALUES \\(\\?, \\?\\)").
+		WithArgs(int64(1), "active").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	shadowMock.ExpectCommit()
+	shadowMock.ExpectBegin()
+	shadowMock.ExpectExec("UPDATE users SET status = 'inactive' WHERE id = 1").WillReturnResult(sqlmock.NewResult(0, 1))
+	shadowMock.ExpectCommit()
+
+	shadowExecutor := executor.NewShadowExecutor(
+		&MockDatabase{db: sourceDB, mock: sourceMock},
+		&MockDatabase{db: shadowDB, mock: shadowMock},
+	)
+	reports, err := shadowExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+
+	assert.NoError(t, sourceMock.ExpectationsWereMet())
+	assert.NoError(t, shadowMock.ExpectationsWereMet())
+}
+
+func TestShadowExecutor_RebindsBoundWhereForPostgres(t *testing.T) {
+	sourceDB, sourceMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = sourceDB.Close() }()
+
+	shadowDB, shadowMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = shadowDB.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "deactivate_user",
+				Type:            definition.TypeUpdate,
+				SQL:             "UPDATE users SET status = 'inactive' WHERE id = ?",
+				Args:            []interface{}{int64(1)},
+				ExpectedChanges: map[string]int{"update": 1},
+			},
+		},
+	}
+
+	// bindの引数はPostgreSQLの$n形式で渡す
+	sourceMock.ExpectQuery("SELECT \\* FROM \\(SELECT \\* FROM users WHERE id = \\$1\\) opsql_shadow LIMIT 10001").
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "active"))
+
+	shadowMock.ExpectBegin()
+	shadowMock.ExpectExec("DELETE FROM `users`").WillReturnResult(sqlmock.NewResult(0, 0))
+	shadowMock.ExpectExec("INSERT INTO `users`").WithArgs(int64(1), "active").WillReturnResult(sqlmock.NewResult(1, 1))
+	shadowMock.ExpectCommit()
+	shadowMock.ExpectBegin()
+	shadowMock.ExpectExec("UPDATE users SET status = 'inactive' WHERE id = \\?").WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	shadowMock.ExpectCommit()
+
+	shadowExecutor := executor.NewShadowExecutor(
+		&database.Database{DB: sqlx.NewDb(sourceDB, "postgres")},
+		&MockDatabase{db: shadowDB, mock: shadowMock},
+	)
+	reports, err := shadowExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+
+	assert.NoError(t, sourceMock.ExpectationsWereMet())
+	assert.NoError(t, shadowMock.ExpectationsWereMet())
+}
+
+func TestShadowExecutor_RowLimit(t *testing.T) {
+	sourceDB, sourceMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = sourceDB.Close() }()
+
+	shadowDB, shadowMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = shadowDB.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "archive_orders",
+				Type:            definition.TypeInsert,
+				SQL:             "INSERT INTO archived_orders SELECT * FROM orders WHERE status = 'done'",
+				ExpectedChanges: map[string]int{"insert": 2},
+			},
+		},
+	}
+
+	// INSERT ... SELECT の参照先はテーブル全体を上限まで写す
+	sourceMock.ExpectQuery("SELECT \\* FROM \\(SELECT \\* FROM `archived_orders`\\) opsql_shadow LIMIT 3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	sourceMock.ExpectQuery("SELECT \\* FROM \\(SELECT \\* FROM `orders`\\) opsql_shadow LIMIT 3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+	shadowMock.ExpectBegin()
+	shadowMock.ExpectExec("DELETE FROM `archived_orders`").WillReturnResult(sqlmock.NewResult(0, 0))
+	shadowMock.ExpectExec("INSERT INTO `archived_orders`").WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(1, 1))
+	shadowMock.ExpectRollback()
+
+	shadowExecutor := executor.NewShadowExecutor(
+		&MockDatabase{db: sourceDB, mock: sourceMock},
+		&MockDatabase{db: shadowDB, mock: shadowMock},
+		executor.WithShadowRowLimit(2),
+	)
+	_, err = shadowExecutor.Execute(context.Background(), def)
+	assert.ErrorContains(t, err, "table orders has more than 2 relevant rows")
+
+	assert.NoError(t, sourceMock.ExpectationsWereMet())
+	assert.NoError(t, shadowMock.ExpectationsWereMet())
+}
+
+func TestPlanExecutor_SampleRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Mask:    []string{"email"},
+		Operations: []definition.Operation{
+			{
+				ID:              "delete_users",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM users WHERE status = 'deleted'",
+				ExpectedChanges: map[string]int{"delete": 3},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM users WHERE status = 'deleted' LIMIT 2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).
+			AddRow(1, "user1@example.com").
+			AddRow(2, "user2@example.com"))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM users WHERE status = 'deleted'").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectRollback()
+
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock}, executor.WithSampleRows(2))
+	reports, err := planExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+	assert.Equal(t, []map[string]interface{}{
+		{"id": int64(1), "email": definition.MaskedValue},
+		{"id": int64(2), "email": definition.MaskedValue},
+	}, reports[0].Sample)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanExecutor_MasksFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Mask:    []string{"email"},
+		Operations: []definition.Operation{
+			{
+				ID:       "admin",
+				Type:     definition.TypeSelect,
+				SQL:      "SELECT id, email FROM users WHERE id = 1",
+				Expected: []map[string]interface{}{{"id": 1, "email": "admin@example.com"}},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, email FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "alice@example.com"))
+	mock.ExpectRollback()
+
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := planExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	require.Len(t, reports, 1)
+
+	// 結果・期待値・失敗の行とメッセージのどこにも実際の値を残さない
+	report := reports[0]
+	assert.NotContains(t, report.Message, "alice@example.com")
+	assert.Equal(t, definition.MaskedValue, report.Result.([]map[string]interface{})[0]["email"])
+	require.NotNil(t, report.Failure)
+	assert.Equal(t, definition.FailureValueMismatch, report.Failure.Code)
+	assert.Equal(t, definition.MaskedValue, report.Failure.Actual)
+	assert.Equal(t, definition.MaskedValue, report.Failure.ActualRow["email"])
+	assert.Equal(t, int64(1), report.Failure.ActualRow["id"])
+	assert.Equal(t, definition.MaskedValue, report.Failure.ExpectedRow["email"])
+	assert.Contains(t, report.Message, "column 'email': expected ***, got ***")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanExecutor_RepeatDetectsNondeterminism(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:       "first_user",
+				Type:     definition.TypeSelect,
+				SQL:      "SELECT id FROM users LIMIT 1",
+				Expected: []map[string]interface{}{{"id": 1}},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users LIMIT 1").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectRollback()
+
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock}, executor.WithRepeat(2))
+	reports, err := planExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "result differs between run 1 and run 2")
+	require.Len(t, reports, 1)
+	assert.False(t, reports[0].Pass)
+	require.NotNil(t, reports[0].Failure)
+	assert.Equal(t, definition.FailureNondeterministicResult, reports[0].Failure.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanExecutor_CaptureKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "deactivate_users",
+				Type:            definition.TypeUpdate,
+				SQL:             "UPDATE users SET status = 'inactive' WHERE last_login < '2024-01-01'",
+				ExpectedChanges: map[string]int{"update": 2},
+				CaptureKeys:     definition.Keys{"id"},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT `id` FROM users WHERE last_login < '2024-01-01'").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(5))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE users SET status = 'inactive'").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectRollback()
+
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := planExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(3)}, {"id": int64(5)}}, reports[0].CapturedKeys)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_Progress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "delete_users",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM users WHERE status = 'deleted'",
+				ExpectedChanges: map[string]int{"delete": 3},
+			},
+			{
+				ID:       "check_users",
+				Type:     definition.TypeSelect,
+				SQL:      "SELECT COUNT(*) AS cnt FROM users",
+				Expected: []map[string]interface{}{{"cnt": int64(1)}},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users WHERE status = 'deleted'").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(2))
+	mock.ExpectRollback()
+
+	var events []executor.ProgressEvent
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithProgress(func(event executor.ProgressEvent) {
+		events = append(events, event)
+	}))
+	_, err = applyExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+
+	require.Len(t, events, 4)
+	assert.Equal(t, executor.ProgressStarted, events[0].Type)
+	assert.Equal(t, "delete_users", events[0].OperationID)
+	assert.Equal(t, 2, events[0].Total)
+
+	assert.Equal(t, executor.ProgressFinished, events[1].Type)
+	assert.True(t, events[1].Pass)
+	require.NotNil(t, events[1].Affected)
+	assert.Equal(t, int64(3), *events[1].Affected)
+
+	assert.Equal(t, "check_users", events[3].OperationID)
+	assert.Equal(t, 1, events[3].Index)
+	assert.False(t, events[3].Pass)
+	assert.Nil(t, events[3].Affected, "selects affect no rows")
+	assert.NotEmpty(t, events[3].Message)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_CancelStopsBeforeNextOperation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "delete_users",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM users WHERE status = 'deleted'",
+				ExpectedChanges: map[string]int{"delete": 3},
+			},
+			{
+				ID:              "delete_orders",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM orders WHERE status = 'deleted'",
+				ExpectedChanges: map[string]int{"delete": 1},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users WHERE status = 'deleted'").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectRollback()
+
+	// 最初の操作の途中でキャンセルされても、その操作は最後まで実行される
+	cancel := make(chan struct{})
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock},
+		executor.WithCancel(cancel),
+		executor.WithProgress(func(event executor.ProgressEvent) {
+			if event.Type == executor.ProgressStarted {
+				close(cancel)
+			}
+		}))
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.ErrorIs(t, err, definition.ErrCancelled)
+	assert.Contains(t, err.Error(), "delete_orders")
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_Anonymize(t *testing.T) {
+	anonymize := definition.Operation{
+		ID:   "erase_user",
+		Type: definition.TypeAnonymize,
+		Anonymize: &definition.Anonymize{
+			Table: "users",
+			Where: "id = ?",
+			Columns: map[string]definition.AnonymizeRule{
+				"email": {Kind: definition.AnonymizeHash},
+				"name":  {Kind: definition.AnonymizeFake, Value: "Deleted User"},
+				"phone": {Kind: definition.AnonymizeNull},
+			},
+		},
+		Args:            []interface{}{42},
+		ExpectedChanges: map[string]int{"update": 1},
+	}
+	update := "UPDATE `users` SET `email` = SHA2(`email`, 256), `name` = ?, `phone` = NULL WHERE id = ?"
+	verify := "SELECT COUNT(CASE WHEN `email` IS NOT NULL AND LENGTH(`email`) <> 64 THEN 1 END) AS `email`, " +
+		"COUNT(CASE WHEN `name` IS NULL OR `name` <> ? THEN 1 END) AS `name`, " +
+		"COUNT(CASE WHEN `phone` IS NOT NULL THEN 1 END) AS `phone` FROM `users` WHERE id = ?"
+
+	t.Run("verified", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer func() { _ = db.Close() }()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(update).WithArgs("Deleted User", 42).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(verify).WithArgs("Deleted User", 42).
+			WillReturnRows(sqlmock.NewRows([]string{"email", "name", "phone"}).AddRow(0, 0, 0))
+		mock.ExpectCommit()
+
+		reports, err := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithSampleRows(5)).
+			Execute(context.Background(), &definition.Definition{Version: 1, Operations: []definition.Operation{anonymize}})
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.True(t, reports[0].Pass)
+		assert.Equal(t, definition.TypeAnonymize, reports[0].Type)
+		assert.Equal(t, int64(1), reports[0].Result)
+		assert.Equal(t, update+";\n"+verify, reports[0].SQL)
+		assert.Nil(t, reports[0].Sample, "pre-anonymization rows must not be sampled")
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("column left behind", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer func() { _ = db.Close() }()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(verify).
+			WillReturnRows(sqlmock.NewRows([]string{"email", "name", "phone"}).AddRow(0, 1, 0))
+		mock.ExpectRollback()
+
+		reports, err := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}).
+			Execute(context.Background(), &definition.Definition{Version: 1, Operations: []definition.Operation{anonymize}})
+		require.Error(t, err)
+		require.Len(t, reports, 1)
+		assert.False(t, reports[0].Pass)
+		require.NotNil(t, reports[0].Failure)
+		assert.Equal(t, definition.FailureNotAnonymized, reports[0].Failure.Code)
+		assert.Equal(t, "name", reports[0].Failure.Column)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPlanExecutor_CrossCheck(t *testing.T) {
+	crossCheck := definition.Operation{
+		ID:   "orders_match",
+		Type: definition.TypeCrossCheck,
+		SQL:  "SELECT id, total FROM orders WHERE id = ? ORDER BY id",
+		Args: []interface{}{7},
+		Secondary: &definition.RemoteQuery{
+			Database: "analytics",
+			SQL:      "SELECT order_id AS id, amount AS total FROM fact_orders WHERE order_id = ? ORDER BY order_id",
+			Args:     []interface{}{7},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		secondary *sqlmock.Rows
+		wantPass  bool
+	}{
+		{
+			name:      "results match",
+			secondary: sqlmock.NewRows([]string{"id", "total"}).AddRow(7, 1200),
+			wantPass:  true,
+		},
+		{
+			name:      "results differ",
+			secondary: sqlmock.NewRows([]string{"id", "total"}).AddRow(7, 1000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = db.Close() }()
+			secondaryDB, secondaryMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = secondaryDB.Close() }()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, total FROM orders").WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "total"}).AddRow(7, 1200))
+			mock.ExpectRollback()
+			secondaryMock.ExpectQuery("SELECT order_id AS id, amount AS total FROM fact_orders").WithArgs(7).
+				WillReturnRows(tt.secondary)
+
+			var opened []string
+			planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock},
+				executor.WithDatabases(func(name string) (database.DB, error) {
+					opened = append(opened, name)
+					return &MockDatabase{db: secondaryDB, mock: secondaryMock}, nil
+				}))
+			reports, err := planExecutor.Execute(context.Background(), &definition.Definition{
+				Version:    1,
+				Operations: []definition.Operation{crossCheck},
+			})
+
+			assert.Equal(t, []string{"analytics"}, opened)
+			require.Len(t, reports, 1)
+			assert.Equal(t, tt.wantPass, reports[0].Pass)
+			if tt.wantPass {
+				assert.NoError(t, err)
+				assert.Nil(t, reports[0].Failure)
+			} else {
+				assert.Error(t, err)
+				require.NotNil(t, reports[0].Failure)
+				assert.Equal(t, definition.FailureValueMismatch, reports[0].Failure.Code)
+				assert.Equal(t, "total", reports[0].Failure.Column)
+				assert.Contains(t, reports[0].Message, "results differ from analytics")
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.NoError(t, secondaryMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestApplyExecutor_Copy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	sourceDB, sourceMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = sourceDB.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Mask:    []string{"price"},
+		Operations: []definition.Operation{
+			{
+				ID:   "backfill_plans",
+				Type: definition.TypeCopy,
+				Source: &definition.RemoteQuery{
+					Database: "billing",
+					SQL:      "SELECT id, name, price FROM plans WHERE id > ?",
+					Args:     []interface{}{100},
+				},
+				Into:            "plans",
+				ExpectedChanges: map[string]int{"insert": 2},
+			},
+		},
+	}
+
+	sourceMock.ExpectQuery("SELECT id, name, price FROM plans").WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).AddRow(101, "pro", 20).AddRow(102, "team", 50))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `plans` \\(`id`, `name`, `price`\\) VALUES \\(\\?, \\?, \\?\\)").
+		WithArgs(101, "pro", 20).WillReturnResult(sqlmock.NewResult(101, 1))
+	mock.ExpectExec("INSERT INTO `plans`").
+		WithArgs(102, "team", 50).WillReturnResult(sqlmock.NewResult(102, 1))
+	mock.ExpectCommit()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock},
+		executor.WithSampleRows(1),
+		executor.WithDatabases(func(name string) (database.DB, error) {
+			require.Equal(t, "billing", name)
+			return &MockDatabase{db: sourceDB, mock: sourceMock}, nil
+		}))
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+	assert.Equal(t, int64(2), reports[0].Result)
+	require.Len(t, reports[0].Sample, 1)
+	assert.Equal(t, "pro", reports[0].Sample[0]["name"])
+	assert.Equal(t, definition.MaskedValue, reports[0].Sample[0]["price"])
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, sourceMock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_Import(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "refunds.csv")
+	require.NoError(t, os.WriteFile(csvPath, []byte("Order ID,Amount,Note\n1001,25,late\n1002,\\N,damaged\n"), 0644))
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "load_refunds",
+				Type:            definition.TypeImport,
+				File:            csvPath,
+				Into:            "refund_staging",
+				Columns:         map[string]string{"Order ID": "order_id", "Amount": "amount"},
+				Staging:         true,
+				ExpectedChanges: map[string]int{"insert": 2},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE `refund_staging` (`order_id` TEXT, `amount` TEXT)").WillReturnResult(sqlmock.NewResult(0, 0))
+	insert := "INSERT INTO `refund_staging` (`order_id`, `amount`) VALUES (?, ?)"
+	mock.ExpectExec(insert).WithArgs("1001", "25").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insert).WithArgs("1002", nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithSampleRows(1))
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+	assert.Equal(t, int64(2), reports[0].Result)
+	assert.Equal(t, []map[string]interface{}{{"order_id": "1001", "amount": "25"}}, reports[0].Sample)
+	assert.Contains(t, reports[0].SQL, insert)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_Export(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "exports", "orders.csv")
+	jsonPath := filepath.Join(dir, "orders.json")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	query := "SELECT id, note FROM orders WHERE status = 'stale'"
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{ID: "orders_csv", Type: definition.TypeExport, SQL: query, File: csvPath},
+			{ID: "orders_json", Type: definition.TypeExport, SQL: query, File: jsonPath, Expected: []map[string]interface{}{{"id": 1}, {"id": 2}}},
+		},
+	}
+
+	mock.ExpectBegin()
+	for range def.Operations {
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"note", "id"}).AddRow("late", 1).AddRow(nil, 2))
+	}
+	mock.ExpectCommit()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	for i, report := range reports {
+		assert.True(t, report.Pass, report.Message)
+		assert.Equal(t, int64(2), report.Result)
+		assert.Equal(t, def.Operations[i].File, report.File)
+	}
+
+	data, err := os.ReadFile(csvPath)
+	require.NoError(t, err)
+	// MockTransactionは列順を返さないので列名順になる
+	assert.Equal(t, "id,note\n1,late\n2,\\N\n", string(data))
+
+	data, err = os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id": 1, "note": "late"}, {"id": 2, "note": null}]`, string(data))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanExecutor_ExportDoesNotWriteFiles(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "orders.csv")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	query := "SELECT id FROM orders"
+	def := &definition.Definition{
+		Version:    1,
+		Operations: []definition.Operation{{ID: "orders", Type: definition.TypeExport, SQL: query, File: csvPath}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	planExecutor := executor.NewPlanExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := planExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].Pass)
+	assert.Contains(t, reports[0].Message, "would export 1 rows")
+	assert.NoFileExists(t, csvPath)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_Decoders(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	query := "SELECT status, settings FROM users WHERE id = 1"
+	def := &definition.Definition{
+		Version: 1,
+		Decoders: map[string]definition.Decoder{
+			"settings": {Kind: definition.DecodeJSON},
+			"status":   {Kind: definition.DecodeEnum, Enum: map[string]interface{}{"1": "active", "2": "suspended"}},
+		},
+		Operations: []definition.Operation{
+			{
+				ID:   "check_user",
+				Type: definition.TypeSelect,
+				SQL:  query,
+				Expected: []map[string]interface{}{
+					{"status": "suspended", "settings": map[string]interface{}{"notify": true, "limit": 10}},
+				},
+			},
+			{ID: "check_unknown_status", Type: definition.TypeSelect, SQL: query},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"status", "settings"}).AddRow(2, `{"notify": true, "limit": 10}`))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"status", "settings"}).AddRow(9, `{}`))
+	mock.ExpectRollback()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock})
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	require.Len(t, reports, 2)
+
+	assert.True(t, reports[0].Pass, reports[0].Message)
+	assert.Equal(t, []map[string]interface{}{
+		{"status": "suspended", "settings": map[string]interface{}{"notify": true, "limit": float64(10)}},
+	}, reports[0].Result)
+
+	assert.False(t, reports[1].Pass)
+	require.NotNil(t, reports[1].Failure)
+	assert.Equal(t, definition.FailureDecodeFailed, reports[1].Failure.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOfflineReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`version: 1
+params:
+  cutoff: "2025-01-01"
+derived_params:
+  - name: max_id
+    sql: SELECT MAX(id) AS max_id FROM orders
+  - name: user_ids
+    sql: SELECT id FROM users WHERE status = 'banned'
+    list: true
+operations:
+  - id: count_orders
+    sql: SELECT COUNT(*) AS c FROM orders WHERE id <= {{ bind .params.max_id }}
+    expected:
+      - c: 3
+  - id: delete_orders
+    sql: DELETE FROM orders WHERE created_at < {{ bind .params.cutoff }} AND user_id IN ({{ bind .params.user_ids }})
+    expected_changes:
+      delete: 3
+  - id: update_users
+    sql: UPDATE users SET status = 'purged' WHERE id IN ({{ bind .params.user_ids }})
+    expected_changes:
+      update: 2
+`), 0644))
+
+	def, err := definition.LoadDefinitionsWithParams([]string{path}, map[string]string{"max_id": "100"})
+	require.NoError(t, err)
+	placeholders, err := def.PlaceholderDerivedParams()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user_ids"}, placeholders)
+
+	reports := executor.OfflineReports(def)
+	require.Len(t, reports, 3)
+	for _, report := range reports {
+		assert.True(t, report.Pass)
+		assert.Nil(t, report.Result)
+	}
+	assert.Equal(t, []interface{}{"100"}, reports[0].Args)
+	assert.Equal(t, []interface{}{"2025-01-01", "<user_ids>"}, reports[1].Args)
+	assert.Equal(t, map[string]int{"delete": 3}, reports[1].Expected)
+	assert.Equal(t, "not executed (offline): would delete 3 rows (orders)", reports[1].Message)
+
+	assert.Equal(t, map[string]int{"delete": 3, "update": 2}, executor.OfflineImpact(def))
+}
+
+func TestApplyExecutor_RecordUndo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "delete_sessions",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM sessions WHERE user_id = 1",
+				ExpectedChanges: map[string]int{"delete": 1},
+			},
+			{
+				ID:              "deactivate_users",
+				Type:            definition.TypeUpdate,
+				SQL:             "UPDATE users SET status = 'inactive' WHERE id = 1",
+				ExpectedChanges: map[string]int{"update": 1},
+				CaptureKeys:     definition.Keys{"id"},
+			},
+			{
+				ID:              "add_audit",
+				Type:            definition.TypeInsert,
+				SQL:             "INSERT INTO audit (user_id) VALUES (1)",
+				ExpectedChanges: map[string]int{"insert": 1},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM sessions WHERE user_id = 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(10, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sessions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT `id`, `status` FROM users WHERE id = 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "active"))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT `id` FROM users WHERE id = 1").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE users SET status").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT `id`, `status` FROM users WHERE \\(`id` = \\?\\)").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "inactive"))
+	mock.ExpectExec("RELEASE SAVEPOINT opsql_sample").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO audit").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	plan := &definition.UndoPlan{}
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithUndo(plan))
+	_, err = applyExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the last operation is reverted first
+	require.Len(t, plan.Steps, 2)
+	// the row is found by the values the update left, so a row changed again since is not reverted
+	assert.Equal(t, "UPDATE users SET `status` = ? WHERE `id` = ? AND `status` = ?", plan.Steps[0].SQL)
+	assert.Equal(t, "INSERT INTO sessions (`id`, `user_id`) VALUES (?, ?)", plan.Steps[1].SQL)
+	require.Len(t, plan.Skipped, 1)
+	assert.Equal(t, "add_audit", plan.Skipped[0].OperationID)
+
+	// the plan survives the state backend as JSON
+	data, err := json.Marshal(plan)
+	require.NoError(t, err)
+	var stored definition.UndoPlan
+	require.NoError(t, json.Unmarshal(data, &stored))
+
+	undo := stored.Definition()
+	require.Len(t, undo.Operations, 2)
+	assert.Equal(t, "undo.deactivate_users.1", undo.Operations[0].ID)
+	assert.Equal(t, []interface{}{"active", "1", "inactive"}, undo.Operations[0].Args)
+	assert.Equal(t, map[string]int{"insert": 1}, undo.Operations[1].ExpectedChanges)
+	assert.Equal(t, []interface{}{"10", "1"}, undo.Operations[1].Args)
+}
+
+func TestApplyExecutor_MaxTxAge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "purge_events",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM events WHERE created_at < '2024-01-01'",
+				ExpectedChanges: map[string]int{"delete": 100},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM events").WillDelayFor(5 * time.Second).WillReturnResult(sqlmock.NewResult(0, 100))
+	mock.ExpectRollback()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithTxMonitor(0, 50*time.Millisecond, nil))
+	startedAt := time.Now()
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, executor.ErrTransactionTooOld)
+	assert.Less(t, time.Since(startedAt), 5*time.Second)
+	require.Len(t, reports, 1)
+	assert.False(t, reports[0].Pass)
+	require.NotNil(t, reports[0].Failure)
+	assert.Equal(t, definition.FailureTransactionTooOld, reports[0].Failure.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_NotifyBeforeCommit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "close_orders",
+				Type:            definition.TypeUpdate,
+				SQL:             "UPDATE orders SET status = 'closed' WHERE id = 1",
+				ExpectedChanges: map[string]int{"update": 1},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT pg_notify").WithArgs("opsql_applied", "20261016T030000Z-1a2b3c4d").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithNotify("opsql_applied", "20261016T030000Z-1a2b3c4d"))
+	_, err = applyExecutor.Execute(context.Background(), def)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// a failed apply notifies nobody
+	db2, mock2, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db2.Close() }()
+
+	mock2.ExpectBegin()
+	mock2.ExpectExec("UPDATE orders").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock2.ExpectRollback()
+
+	applyExecutor = executor.NewApplyExecutor(&MockDatabase{db: db2, mock: mock2}, executor.WithNotify("opsql_applied", "20261016T030000Z-1a2b3c4d"))
+	_, err = applyExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	assert.NoError(t, mock2.ExpectationsWereMet())
+}
+
+func TestApplyExecutor_UnsafeStatementCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	def := &definition.Definition{
+		Version: 1,
+		Operations: []definition.Operation{
+			{
+				ID:              "purge_one",
+				Type:            definition.TypeDelete,
+				SQL:             "DELETE FROM events LIMIT 1",
+				ExpectedChanges: map[string]int{"delete": 1},
+			},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM events LIMIT 1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SHOW WARNINGS").WillReturnRows(sqlmock.NewRows([]string{"Level", "Code", "Message"}).
+		AddRow("Note", 1592, "Unsafe statement written to the binary log using statement format since BINLOG_FORMAT = STATEMENT. The statement is unsafe because it uses a LIMIT clause."))
+	mock.ExpectRollback()
+
+	applyExecutor := executor.NewApplyExecutor(&MockDatabase{db: db, mock: mock}, executor.WithUnsafeStatementCheck())
+	reports, err := applyExecutor.Execute(context.Background(), def)
+	require.Error(t, err)
+	require.Len(t, reports, 1)
+	assert.False(t, reports[0].Pass)
+	require.NotNil(t, reports[0].Failure)
+	assert.Equal(t, definition.FailureUnsafeForBinlog, reports[0].Failure.Code)
+	assert.Contains(t, reports[0].Message, "binlog_format ROW")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
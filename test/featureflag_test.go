@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/featureflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureFlagCheck(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flags/new-billing":
+			authorization = r.Header.Get("Authorization")
+			_, _ = w.Write([]byte(`{"key": "new-billing", "environments": {"production": {"on": false}}}`))
+		case "/features/dual-write":
+			_, _ = w.Write([]byte(`{"name": "dual-write", "enabled": true}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("FLAG_TOKEN", "secret")
+
+	billing := definition.FeatureFlag{
+		Name:    "new-billing",
+		URL:     server.URL + "/flags/new-billing",
+		Headers: map[string]string{"Authorization": "${FLAG_TOKEN}"},
+		Path:    "environments.production.on",
+		Expect:  false,
+	}
+	dualWrite := definition.FeatureFlag{Name: "dual-write", URL: server.URL + "/features/dual-write", Path: "enabled", Expect: true}
+
+	checker := featureflag.NewChecker()
+	require.NoError(t, checker.Check(context.Background(), []definition.FeatureFlag{billing, dualWrite}))
+	assert.Equal(t, "secret", authorization)
+
+	billing.Expect = true
+	dualWrite.Expect = false
+	err := checker.Check(context.Background(), []definition.FeatureFlag{billing, dualWrite})
+	assert.ErrorContains(t, err, "new-billing is false, the runbook requires true; dual-write is true, the runbook requires false")
+
+	billing.Path = "environments.staging.on"
+	assert.ErrorContains(t, checker.Check(context.Background(), []definition.FeatureFlag{billing}), `field "staging" not found`)
+
+	missing := definition.FeatureFlag{Name: "missing", URL: server.URL + "/flags/missing", Expect: true}
+	assert.ErrorContains(t, checker.Check(context.Background(), []definition.FeatureFlag{missing}), "404 Not Found")
+}
+
+func TestLoadDefinitionFeatureFlags(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backfill.yaml")
+	content := `version: 1
+feature_flags:
+  - name: new-billing
+    url: https://flags.example.com/api/flags/new-billing
+    path: on
+    expect: false
+operations:
+  - sql: SELECT 1 AS one
+    expected:
+      - one: 1
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	def, err := definition.LoadDefinition(path)
+	require.NoError(t, err)
+	require.Len(t, def.FeatureFlags, 1)
+	assert.Equal(t, false, def.FeatureFlags[0].Expect)
+
+	require.NoError(t, os.WriteFile(path, []byte(`version: 1
+feature_flags:
+  - name: new-billing
+    url: file:///etc/flags
+    expect: false
+operations:
+  - sql: SELECT 1 AS one
+    expected:
+      - one: 1
+`), 0644))
+	_, err = definition.LoadDefinition(path)
+	assert.ErrorContains(t, err, "url must be an http or https URL")
+}
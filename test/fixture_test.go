@@ -0,0 +1,50 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/fixture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixtureLoad_CSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	path := filepath.Join(t.TempDir(), "users.csv")
+	require.NoError(t, os.WriteFile(path, []byte("id,name,email\n1,Alice,alice@example.com\n2,Bob,\\N\n"), 0644))
+
+	mock.ExpectExec("INSERT INTO `users` \\(`id`, `name`, `email`\\) VALUES \\(\\?, \\?, \\?\\)").
+		WithArgs("1", "Alice", "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `users` \\(`id`, `name`, `email`\\) VALUES \\(\\?, \\?, \\?\\)").
+		WithArgs("2", "Bob", nil).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	err = fixture.Load(context.Background(), &MockDatabase{db: db, mock: mock}, definition.Fixture{File: path})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFixtureLoad_SQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	require.NoError(t, os.WriteFile(path, []byte("CREATE TABLE users (id INT);\nINSERT INTO users VALUES (1);\n"), 0644))
+
+	mock.ExpectExec("CREATE TABLE users \\(id INT\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO users VALUES \\(1\\)").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = fixture.Load(context.Background(), &MockDatabase{db: db, mock: mock}, definition.Fixture{File: path})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
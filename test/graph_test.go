@@ -0,0 +1,74 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/graph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraph(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "purge.yaml"), []byte(`args:
+  table:
+    required: true
+operations:
+  - id: count
+    sql: SELECT COUNT(*) AS c FROM {{.args.table}} WHERE expired = 1
+    expected:
+      - c: 2
+  - id: delete
+    sql: DELETE FROM {{.args.table}} WHERE expired = 1
+    expected_changes:
+      delete: 2
+`), 0644))
+	config := filepath.Join(dir, "runbook.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`version: 1
+operations:
+  - id: check
+    sql: SELECT COUNT(*) AS c FROM orders JOIN users ON users.id = orders.user_id
+    expected:
+      - c: 1
+  - id: purge_sessions
+    use: purge
+    with:
+      table: sessions
+  - id: close
+    sql: UPDATE orders SET status = 'closed' WHERE user_id IN (SELECT id FROM users)
+    expected_changes:
+      update: 1
+`), 0644))
+
+	def, err := definition.LoadDefinition(config)
+	require.NoError(t, err)
+
+	g := graph.Build(def)
+	require.Len(t, g.Operations, 4)
+	assert.Equal(t, []string{"orders", "users", "sessions"}, g.Tables)
+	assert.Equal(t, []string{"orders", "users"}, g.Operations[0].Reads)
+	assert.Equal(t, "purge_sessions", g.Operations[1].Group)
+	assert.Equal(t, "purge_sessions", g.Operations[2].Group)
+	assert.Equal(t, []string{"sessions"}, g.Operations[2].Writes)
+	assert.Equal(t, []string{"orders"}, g.Operations[3].Writes)
+	assert.Equal(t, []string{"users"}, g.Operations[3].Reads)
+
+	dot, err := g.Render(graph.FormatDOT)
+	require.NoError(t, err)
+	assert.Contains(t, dot, "subgraph cluster_0 {\n    label=\"use: purge_sessions\";")
+	assert.Contains(t, dot, "op2 -> op3;")
+	assert.Contains(t, dot, "op3 -> table0 [style=dashed, color=firebrick, label=\"writes\"];")
+
+	mermaid, err := g.Render(graph.FormatMermaid)
+	require.NoError(t, err)
+	assert.Contains(t, mermaid, "flowchart TD\n")
+	assert.Contains(t, mermaid, "table2 -. reads .-> op1")
+	assert.Contains(t, mermaid, "op2 -. writes .-> table2")
+
+	_, err = g.Render("svg")
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	opsqlv1 "github.com/pyama86/opsql/api/opsql/v1"
+	"github.com/pyama86/opsql/internal/server"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, srv *server.Server) opsqlv1.OpsqlClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	g := srv.GRPCServer()
+	go func() { _ = g.Serve(lis) }()
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return opsqlv1.NewOpsqlClient(conn)
+}
+
+func TestGRPCPlanWatchAndApply(t *testing.T) {
+	srv, _, _ := newTestServer(t, false, server.WithTokens(map[string]string{"alice": "alice-token", "bob": "bob-token"}))
+	client := newTestGRPCClient(t, srv)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer alice-token", "x-opsql-actor", "mallory")
+	approverCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer bob-token")
+
+	definitions, err := client.ListDefinitions(ctx, &opsqlv1.ListDefinitionsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cleanup.yaml"}, definitions.Definitions)
+
+	plan, err := client.Plan(ctx, &opsqlv1.PlanRequest{Configs: []string{"cleanup.yaml"}, Environment: "staging"})
+	require.NoError(t, err)
+	assert.True(t, plan.DryRun)
+	assert.Equal(t, "api:alice", plan.Actor, "the caller is recorded by its token, not by what it sends")
+
+	stream, err := client.WatchRun(ctx, &opsqlv1.WatchRunRequest{Id: plan.Id})
+	require.NoError(t, err)
+	var last *opsqlv1.Run
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if run := event.GetRun(); run != nil {
+			last = run
+		}
+	}
+	require.NotNil(t, last)
+	assert.Equal(t, state.StatusPassed, last.Status)
+	require.Len(t, last.Reports, 1)
+	assert.True(t, last.Reports[0].Pass)
+
+	_, err = client.Apply(ctx, &opsqlv1.ApplyRequest{PlanRunId: plan.Id})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "the planner cannot approve")
+
+	apply, err := client.Apply(approverCtx, &opsqlv1.ApplyRequest{PlanRunId: plan.Id})
+	require.NoError(t, err)
+	assert.Equal(t, "api:bob", apply.Actor)
+	assert.False(t, apply.DryRun)
+	assert.Equal(t, plan.Id, apply.PlanRunId)
+	srv.Wait()
+
+	runs, err := client.ListRuns(ctx, &opsqlv1.ListRunsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, runs.Runs, 2)
+
+	_, err = client.Apply(ctx, &opsqlv1.ApplyRequest{PlanRunId: apply.Id})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.Apply(approverCtx, &opsqlv1.ApplyRequest{PlanRunId: plan.Id})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "a plan is applied only once")
+}
+
+func TestGRPCRejectsInvalidRequests(t *testing.T) {
+	srv, _, _ := newTestServer(t, false, server.WithToken("secret"))
+	client := newTestGRPCClient(t, srv)
+
+	_, err := client.ListRuns(context.Background(), &opsqlv1.ListRunsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err = client.Plan(ctx, &opsqlv1.PlanRequest{Configs: []string{"../etc/passwd"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetRun(ctx, &opsqlv1.GetRunRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
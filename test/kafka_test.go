@@ -0,0 +1,45 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaNewClientRequiresEnv(t *testing.T) {
+	t.Setenv("OPSQL_KAFKA_BROKERS", "")
+	t.Setenv("OPSQL_KAFKA_TOPIC", "opsql-runs")
+	assert.Nil(t, kafka.NewClient())
+
+	t.Setenv("OPSQL_KAFKA_BROKERS", "broker1:9092,broker2:9092")
+	assert.NotNil(t, kafka.NewClient())
+}
+
+func TestKafkaNewMessage(t *testing.T) {
+	reports := []definition.Report{
+		{ID: "op1", Pass: true},
+		{ID: "op2", Pass: false},
+	}
+
+	msg := kafka.NewMessage(reports, true, "staging", errors.New("assertion failed"))
+	assert.Equal(t, "staging", msg.Environment)
+	assert.True(t, msg.DryRun)
+	assert.Equal(t, 1, msg.Passed)
+	assert.Equal(t, 1, msg.Failed)
+	assert.Equal(t, "assertion failed", msg.Error)
+	assert.False(t, msg.Cancelled)
+	assert.Len(t, msg.Reports, 2)
+
+	msg = kafka.NewMessage(reports[:1], false, "prod", fmt.Errorf("cancelled before operation[op2]: %w", definition.ErrCancelled))
+	assert.True(t, msg.Cancelled)
+}
+
+func TestKafkaNewWriterRejectsUnknownMechanism(t *testing.T) {
+	t.Setenv("OPSQL_KAFKA_SASL_MECHANISM", "gssapi")
+	_, err := kafka.NewWriter([]string{"localhost:9092"}, "opsql-runs")
+	assert.Error(t, err)
+}
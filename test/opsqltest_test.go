@@ -0,0 +1,67 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/opsqltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpsqltestPlan(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "cleanup.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`version: 1
+params:
+  status:
+    default: expired
+operations:
+  - id: count_expired
+    type: select
+    sql: SELECT COUNT(*) AS c FROM sessions WHERE status = '{{.params.status}}'
+    expected:
+      - c: 2
+  - id: delete_expired
+    type: delete
+    sql: DELETE FROM sessions WHERE status = '{{.params.status}}'
+    expected_changes:
+      delete: 2
+`), 0644))
+
+	target := opsqltest.NewMock(t, "mysql")
+	target.Mock.ExpectBegin()
+	target.Mock.ExpectQuery("SELECT COUNT\\(\\*\\) AS c FROM sessions WHERE status = 'stale'").
+		WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(int64(2)))
+	target.Mock.ExpectExec("DELETE FROM sessions WHERE status = 'stale'").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	target.Mock.ExpectRollback()
+
+	run := opsqltest.Plan(t, target, []string{config}, opsqltest.WithParam("status", "stale"))
+	run.RequirePassed(t)
+	run.RequireAffected(t, "delete_expired", 2)
+	assert.Equal(t, "count_expired", run.Report(t, "count_expired").ID)
+}
+
+func TestOpsqltestRequireFailure(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "check.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`version: 1
+operations:
+  - id: count_users
+    type: select
+    sql: SELECT COUNT(*) AS c FROM users
+    expected:
+      - c: 1
+`), 0644))
+
+	target := opsqltest.NewMock(t, "postgres")
+	target.Mock.ExpectBegin()
+	target.Mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(int64(3)))
+	target.Mock.ExpectRollback()
+
+	run := opsqltest.Apply(t, target, []string{config})
+	require.Error(t, run.Err)
+	run.RequireFailure(t, "count_users", definition.FailureValueMismatch)
+}
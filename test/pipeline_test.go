@@ -0,0 +1,152 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pipeline.yaml")
+	content := `stages:
+  - name: staging-apply
+    configs: [ops/cleanup.yaml]
+    environment: staging
+    dsn_env: STAGING_DSN
+    params:
+      user_ids: "1,2"
+  - name: verify
+    configs: [ops/verify.yaml]
+    environment: staging
+    dsn_env: STAGING_DSN
+    dry_run: true
+  - name: prod-apply
+    configs: [ops/cleanup.yaml]
+    environment: prod
+    approval: true
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	p, err := pipeline.Load(path)
+	require.NoError(t, err)
+	require.Len(t, p.Stages, 3)
+
+	assert.Equal(t, []string{filepath.Join(dir, "ops/cleanup.yaml")}, p.Stages[0].Configs)
+	assert.Equal(t, "1,2", p.Stages[0].Params["user_ids"])
+	assert.True(t, p.Stages[1].DryRun)
+	assert.True(t, p.Stages[2].Approval)
+
+	t.Setenv("STAGING_DSN", "staging-dsn")
+	t.Setenv("DATABASE_DSN", "default")
+	t.Setenv("DATABASE_DSN_PROD", "")
+	dsn, err := p.Stages[0].DSN()
+	require.NoError(t, err)
+	assert.Equal(t, "staging-dsn", dsn)
+
+	_, err = p.Stages[2].DSN()
+	assert.ErrorContains(t, err, "DATABASE_DSN_PROD environment variable is required")
+}
+
+func TestStageDSN(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "default")
+	t.Setenv("DATABASE_DSN_PROD", "prod")
+	t.Setenv("STAGING_DSN", "staging")
+
+	tests := []struct {
+		stage pipeline.Stage
+		want  string
+	}{
+		{pipeline.Stage{Name: "explicit", Environment: "prod", DSNEnv: "STAGING_DSN"}, "staging"},
+		{pipeline.Stage{Name: "environment", Environment: "prod"}, "prod"},
+		{pipeline.Stage{Name: "none"}, "default"},
+	}
+	for _, tt := range tests {
+		dsn, err := tt.stage.DSN()
+		require.NoError(t, err, tt.stage.Name)
+		assert.Equal(t, tt.want, dsn, tt.stage.Name)
+	}
+
+	_, err := pipeline.Stage{Name: "missing", DSNEnv: "MISSING_DSN"}.DSN()
+	assert.Error(t, err)
+
+	// 環境を名乗るステージは DATABASE_DSN に頼らない
+	_, err = pipeline.Stage{Name: "fallback", Environment: "dev"}.DSN()
+	assert.ErrorContains(t, err, "DATABASE_DSN_DEV environment variable is required")
+}
+
+func TestPipelineValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		stages   []pipeline.Stage
+		errorMsg string
+	}{
+		{name: "no stages", errorMsg: "pipeline has no stages"},
+		{name: "missing name", stages: []pipeline.Stage{{Configs: []string{"a.yaml"}}}, errorMsg: "name is required"},
+		{name: "duplicate name", stages: []pipeline.Stage{{Name: "a", Configs: []string{"a.yaml"}}, {Name: "a", Configs: []string{"b.yaml"}}}, errorMsg: "duplicate stage name: a"},
+		{name: "missing configs", stages: []pipeline.Stage{{Name: "a"}}, errorMsg: "configs is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pipeline.Pipeline{Stages: tt.stages}
+			assert.ErrorContains(t, p.Validate(), tt.errorMsg)
+		})
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	p := &pipeline.Pipeline{Stages: []pipeline.Stage{
+		{Name: "staging-apply", Configs: []string{"a.yaml"}},
+		{Name: "verify", Configs: []string{"b.yaml"}, DryRun: true},
+		{Name: "prod-apply", Configs: []string{"a.yaml"}, Approval: true},
+	}}
+
+	t.Run("stops at a rejected gate", func(t *testing.T) {
+		var ran []string
+		err := p.Run(context.Background(), func(ctx context.Context, stage pipeline.Stage) error {
+			ran = append(ran, stage.Name)
+			return nil
+		}, func(stage pipeline.Stage) (bool, error) {
+			return false, nil
+		})
+		assert.ErrorContains(t, err, "stage prod-apply: not approved, 2 of 3 stages completed")
+		assert.Equal(t, []string{"staging-apply", "verify"}, ran)
+	})
+
+	t.Run("stops at a failing stage", func(t *testing.T) {
+		var ran []string
+		approvals := 0
+		err := p.Run(context.Background(), func(ctx context.Context, stage pipeline.Stage) error {
+			ran = append(ran, stage.Name)
+			if stage.Name == "verify" {
+				return errors.New("assertion failed")
+			}
+			return nil
+		}, func(stage pipeline.Stage) (bool, error) {
+			approvals++
+			return true, nil
+		})
+		assert.ErrorContains(t, err, "stage verify failed: assertion failed")
+		assert.Equal(t, []string{"staging-apply", "verify"}, ran)
+		assert.Zero(t, approvals)
+	})
+
+	t.Run("runs every approved stage", func(t *testing.T) {
+		var ran []string
+		err := p.Run(context.Background(), func(ctx context.Context, stage pipeline.Stage) error {
+			ran = append(ran, stage.Name)
+			return nil
+		}, func(stage pipeline.Stage) (bool, error) {
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"staging-apply", "verify", "prod-apply"}, ran)
+	})
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routing.yaml")
+	content := `owners:
+  team-payments:
+    slack_webhook: https://hooks.slack.com/services/T/B/X
+    pagerduty_routing_key: abc123
+  team-growth:
+    slack_webhook: https://hooks.slack.com/services/T/B/Y
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	r, err := routing.Load(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", r.Owners["team-payments"].PagerDutyRoutingKey)
+	assert.Empty(t, r.Owners["team-growth"].PagerDutyRoutingKey)
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("owners:\n  team-empty: {}\n"), 0644))
+	_, err = routing.Load(context.Background(), invalid)
+	assert.ErrorContains(t, err, "owner team-empty has neither slack_webhook nor pagerduty_routing_key")
+}
+
+func TestRoutingFailuresByOwner(t *testing.T) {
+	reports := []definition.Report{
+		{ID: "refunds", Owner: "team-payments", Pass: false},
+		{ID: "invoices", Owner: "team-payments", Pass: true},
+		{ID: "signups", Owner: "team-growth", Pass: false},
+		{ID: "cleanup", Pass: false},
+		{ID: "payouts", Owner: "team-payments", Pass: false},
+	}
+
+	failures := routing.FailuresByOwner(reports)
+	require.Len(t, failures, 2)
+	assert.Equal(t, "team-growth", failures[0].Owner)
+	assert.Equal(t, "team-payments", failures[1].Owner)
+	require.Len(t, failures[1].Reports, 2)
+	assert.Equal(t, "refunds", failures[1].Reports[0].ID)
+	assert.Equal(t, "payouts", failures[1].Reports[1].ID)
+}
+
+func TestPagerDutyTrigger(t *testing.T) {
+	var event map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	reports := []definition.Report{{ID: "refunds", SQL: "DELETE FROM refunds WHERE id = 1", Message: "expected 1 rows, got 0"}}
+	err := routing.NewPagerDuty(server.URL).Trigger(context.Background(), "abc123", "team-payments", "ops.yaml", "prod", reports)
+	require.NoError(t, err)
+
+	assert.Equal(t, "abc123", event["routing_key"])
+	assert.Equal(t, "trigger", event["event_action"])
+	assert.Equal(t, "opsql/team-payments/ops.yaml/prod", event["dedup_key"])
+	payload := event["payload"].(map[string]interface{})
+	assert.Equal(t, "[prod] opsql: 1 operations of team-payments failed: refunds", payload["summary"])
+	assert.Equal(t, "error", payload["severity"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	err = routing.NewPagerDuty(failing.URL).Trigger(context.Background(), "abc123", "team-payments", "ops.yaml", "prod", reports)
+	assert.ErrorContains(t, err, "400")
+}
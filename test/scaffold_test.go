@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/scaffold"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRetentionRunbook(t *testing.T) {
+	sweep := scaffold.Retention{
+		Table:  "events",
+		Column: "created_at",
+		Key:    "id",
+		Cutoff: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Batch:  10000,
+		Rows:   25000,
+		Driver: "mysql",
+	}
+	data, err := sweep.YAML()
+	require.NoError(t, err)
+
+	// 生成したランブックはそのまま読み込めること
+	path := filepath.Join(t.TempDir(), "retention.yaml")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	def, err := definition.LoadDefinition(path)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, op := range def.Operations {
+		ids = append(ids, op.ID)
+	}
+	assert.Equal(t, []string{"count_before", "delete_batch_1", "delete_batch_2", "delete_batch_3", "count_after"}, ids)
+
+	assert.Equal(t, "SELECT COUNT(*) AS expired FROM `events` WHERE `created_at` < ?", def.Operations[0].SQL)
+	assert.Equal(t, []interface{}{"2025-01-02 03:04:05"}, def.Operations[0].Args)
+	assert.Equal(t, 25000, def.Operations[0].Expected[0]["expired"])
+
+	assert.Equal(t, "DELETE FROM `events` WHERE `created_at` < ? ORDER BY `id` LIMIT 10000", def.Operations[1].SQL)
+	assert.Equal(t, map[string]int{"delete": 10000}, def.Operations[2].ExpectedChanges)
+	assert.Equal(t, map[string]int{"delete": 5000}, def.Operations[3].ExpectedChanges)
+	assert.Equal(t, 0, def.Operations[4].Expected[0]["expired"])
+
+	sweep.Driver = "postgres"
+	postgres := sweep.Definition()
+	assert.Equal(t, `DELETE FROM "events" WHERE "id" IN (SELECT "id" FROM "events" WHERE "created_at" < {{ bind .params.cutoff }} ORDER BY "id" LIMIT {{ .params.batch }})`, postgres.Operations[1].SQL)
+
+	sweep.Rows = 0
+	assert.Len(t, sweep.Definition().Operations, 2, "no batches when nothing has expired")
+}
+
+func TestWorkflowScaffold(t *testing.T) {
+	w := scaffold.Workflow{
+		Name:        "cleanup-orders",
+		Image:       "ghcr.io/pyama86/opsql:1.5.0",
+		ConfigMap:   "cleanup-orders-definitions",
+		Configs:     []string{"runbooks/cleanup.yaml", "runbooks/prod.yaml"},
+		Secret:      "opsql-prod",
+		SecretKey:   "dsn",
+		Environment: "prod",
+		Params: scaffold.WorkflowParams(
+			map[string]interface{}{"cutoff": "2025-01-01", "statuses": []interface{}{"stale", "void"}},
+			map[string]bool{"tenant": true},
+		),
+		Approvers: []string{"alice", "group:dba"},
+	}
+	assert.Equal(t, []scaffold.WorkflowParam{{Name: "cutoff", Default: "2025-01-01"}, {Name: "statuses", Default: "stale,void"}, {Name: "tenant"}}, w.Params)
+	assert.Equal(t, "/work/cleanup.yaml,/work/prod.yaml", w.ConfigPaths())
+
+	w.Platform = scaffold.PlatformArgo
+	data, err := w.YAML()
+	require.NoError(t, err)
+
+	var argo struct {
+		Kind string `yaml:"kind"`
+		Spec struct {
+			Arguments struct {
+				Parameters []struct {
+					Name  string `yaml:"name"`
+					Value string `yaml:"value"`
+				} `yaml:"parameters"`
+			} `yaml:"arguments"`
+			Templates []struct {
+				Name      string                 `yaml:"name"`
+				Steps     [][]map[string]any     `yaml:"steps"`
+				Suspend   map[string]interface{} `yaml:"suspend"`
+				Container struct {
+					Image string `yaml:"image"`
+					Env   []struct {
+						Name  string `yaml:"name"`
+						Value string `yaml:"value"`
+					} `yaml:"env"`
+				} `yaml:"container"`
+			} `yaml:"templates"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &argo))
+	assert.Equal(t, "WorkflowTemplate", argo.Kind)
+	require.Len(t, argo.Spec.Arguments.Parameters, 4)
+	assert.Equal(t, "environment", argo.Spec.Arguments.Parameters[0].Name)
+	assert.Equal(t, "prod", argo.Spec.Arguments.Parameters[0].Value)
+
+	require.Len(t, argo.Spec.Templates, 3)
+	var stages []interface{}
+	for _, step := range argo.Spec.Templates[0].Steps {
+		stages = append(stages, step[0]["name"])
+	}
+	assert.Equal(t, []interface{}{"plan", "approve", "apply"}, stages)
+	assert.NotNil(t, argo.Spec.Templates[1].Suspend)
+
+	run := argo.Spec.Templates[2].Container
+	assert.Equal(t, "ghcr.io/pyama86/opsql:1.5.0", run.Image)
+	env := make(map[string]string)
+	for _, e := range run.Env {
+		env[e.Name] = e.Value
+	}
+	assert.Equal(t, "/work/cleanup.yaml,/work/prod.yaml", env["OPSQL_CONFIG"])
+	assert.Equal(t, "{{inputs.parameters.dry-run}}", env["OPSQL_DRY_RUN"])
+	assert.Equal(t, "{{workflow.parameters.statuses}}", env["OPSQL_PARAM_STATUSES"])
+	assert.Contains(t, env, "DATABASE_DSN")
+
+	w.Platform = scaffold.PlatformTekton
+	data, err = w.YAML()
+	require.NoError(t, err)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var kinds []string
+	for {
+		var doc struct {
+			Kind string `yaml:"kind"`
+		}
+		if err := decoder.Decode(&doc); err != nil {
+			break
+		}
+		kinds = append(kinds, doc.Kind)
+	}
+	assert.Equal(t, []string{"Task", "Pipeline"}, kinds)
+	assert.Contains(t, string(data), `value: ["alice", "group:dba"]`)
+
+	w.Platform = "jenkins"
+	_, err = w.YAML()
+	assert.Error(t, err)
+}
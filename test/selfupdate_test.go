@@ -0,0 +1,160 @@
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/selfupdate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// releaseArchive builds the archive goreleaser publishes for the running platform
+func releaseArchive(t *testing.T, binary []byte) []byte {
+	var buf bytes.Buffer
+	if runtime.GOOS == "windows" {
+		w := zip.NewWriter(&buf)
+		f, err := w.Create("opsql.exe")
+		require.NoError(t, err)
+		_, err = f.Write(binary)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "README.md", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "opsql", Mode: 0755, Size: int64(len(binary)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(binary)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestSelfUpdate(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	binary := []byte("new opsql")
+	name := selfupdate.AssetName("1.5.0", runtime.GOOS, runtime.GOARCH)
+	archive := releaseArchive(t, binary)
+	sum := sha256.Sum256(archive)
+	checksums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name))
+
+	assets := map[string][]byte{
+		name:                archive,
+		"checksums.txt":     checksums,
+		"checksums.txt.sig": ed25519.Sign(privateKey, checksums),
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/pyama86/opsql/releases/latest" || r.URL.Path == "/repos/pyama86/opsql/releases/tags/v1.5.0":
+			var list []string
+			for asset := range assets {
+				list = append(list, fmt.Sprintf(`{"name": %q, "browser_download_url": "%s/download/%s"}`, asset, server.URL, asset))
+			}
+			_, _ = fmt.Fprintf(w, `{"tag_name": "v1.5.0", "assets": [%s]}`, strings.Join(list, ","))
+		case strings.HasPrefix(r.URL.Path, "/download/"):
+			data, ok := assets[strings.TrimPrefix(r.URL.Path, "/download/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	updater, err := selfupdate.NewUpdater(base64.StdEncoding.EncodeToString(publicKey))
+	require.NoError(t, err)
+	updater.APIURL = server.URL
+
+	ctx := context.Background()
+	release, err := updater.Release(ctx, "1.5.0")
+	require.NoError(t, err)
+	assert.Equal(t, "1.5.0", release.Version())
+
+	downloaded, err := updater.Download(ctx, release)
+	require.NoError(t, err)
+	assert.Equal(t, binary, downloaded)
+
+	executable := filepath.Join(t.TempDir(), "opsql")
+	require.NoError(t, os.WriteFile(executable, []byte("old opsql"), 0755))
+	require.NoError(t, selfupdate.Replace(executable, downloaded))
+	data, err := os.ReadFile(executable)
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+
+	// 署名が別の鍵のものなら入れ替えない
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	assets["checksums.txt.sig"] = ed25519.Sign(otherKey, checksums)
+	_, err = updater.Download(ctx, release)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "signature")
+
+	// 公開鍵のないビルドは明示的に許可しない限り署名なしで更新しない
+	unsigned, err := selfupdate.NewUpdater("")
+	require.NoError(t, err)
+	unsigned.APIURL = server.URL
+	_, err = unsigned.Download(ctx, release)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "public key")
+	unsigned.AllowUnsigned = true
+	_, err = unsigned.Download(ctx, release)
+	require.NoError(t, err)
+
+	// 改ざんされたアーカイブはチェックサムで弾く
+	assets["checksums.txt.sig"] = ed25519.Sign(privateKey, checksums)
+	assets[name] = releaseArchive(t, []byte("tampered"))
+	_, err = updater.Download(ctx, release)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"1.5.0", "1.4.0", 1, true},
+		{"v1.4.0", "1.4.0", 0, true},
+		{"1.4.0", "1.10.0", -1, true},
+		{"2.0.0", "1.99.99", 1, true},
+		{"1.5.0", "1.5.0-rc.1", 1, true},
+		{"1.5.0-rc.2", "1.5.0-rc.10", -1, true},
+		{"1.5.0-rc.1", "1.5.0-beta", 1, true},
+		{"1.5.0-alpha", "1.5.0-alpha.1", -1, true},
+		{"1.5.0+build.7", "1.5.0", 0, true},
+		{"1.5.0", "dev", 0, false},
+		{"1.5", "1.5.0", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := selfupdate.CompareVersions(tt.a, tt.b)
+		assert.Equal(t, tt.ok, ok, "%s vs %s", tt.a, tt.b)
+		assert.Equal(t, tt.want, got, "%s vs %s", tt.a, tt.b)
+	}
+}
@@ -0,0 +1,699 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/executor"
+	"github.com/pyama86/opsql/internal/server"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRunner records runs in the history like executeRun does
+func fakeRunner(history *state.History, fail bool) server.Runner {
+	return func(ctx context.Context, run server.Run) error {
+		if fail {
+			return errors.New("failed to load definition")
+		}
+		if run.Progress != nil {
+			affected := int64(2)
+			run.Progress(executor.ProgressEvent{Type: executor.ProgressStarted, OperationID: "check", Total: 1})
+			run.Progress(executor.ProgressEvent{Type: executor.ProgressFinished, OperationID: "check", Total: 1, Pass: true, Affected: &affected})
+		}
+		checksum, err := definition.Checksum(run.Configs)
+		if err != nil {
+			return err
+		}
+		return history.SaveRun(ctx, state.RunRecord{
+			ID:          run.ID,
+			Definition:  definition.Name(run.Configs),
+			Configs:     run.Configs,
+			Params:      run.Params,
+			Checksum:    checksum,
+			Environment: run.Environment,
+			Actor:       run.Actor,
+			DryRun:      run.DryRun,
+			PlanRunID:   run.PlanRunID,
+			GitHubRepo:  run.GitHubRepo,
+			GitHubPR:    run.GitHubPR,
+			Status:      state.StatusPassed,
+			Reports:     []definition.Report{{ID: "check", Pass: true}},
+		})
+	}
+}
+
+func newTestServer(t *testing.T, fail bool, opts ...server.Option) (*server.Server, *httptest.Server, string) {
+	t.Helper()
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "cleanup.yaml"), []byte("version: 1\noperations: []\n"), 0644))
+
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	srv := server.New(history, fakeRunner(history, fail), configDir, opts...)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts, configDir
+}
+
+func doJSON(t *testing.T, method, url string, body interface{}, out interface{}) int {
+	t.Helper()
+	return doJSONAs(t, "", method, url, body, out)
+}
+
+// doJSONAs sends the request with the bearer token of a caller, or without one when token is empty
+func doJSONAs(t *testing.T, token, method, url string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	require.NoError(t, err)
+	req.Header.Set("X-Opsql-Actor", "mallory")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestServerPlanAndApprove(t *testing.T) {
+	srv, ts, configDir := newTestServer(t, false, server.WithTokens(map[string]string{"alice": "alice-token", "bob": "bob-token"}))
+
+	var definitions []string
+	assert.Equal(t, http.StatusOK, doJSONAs(t, "alice-token", "GET", ts.URL+"/api/definitions", nil, &definitions))
+	assert.Equal(t, []string{"cleanup.yaml"}, definitions)
+
+	var plan state.RunRecord
+	status := doJSONAs(t, "alice-token", "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}, Environment: "staging"}, &plan)
+	require.Equal(t, http.StatusAccepted, status)
+	assert.True(t, plan.DryRun)
+	assert.Equal(t, "api:alice", plan.Actor, "the caller is recorded by its token, not by what it sends")
+	srv.Wait()
+
+	var recorded state.RunRecord
+	require.Equal(t, http.StatusOK, doJSONAs(t, "alice-token", "GET", ts.URL+"/api/runs/"+plan.ID, nil, &recorded))
+	assert.Equal(t, state.StatusPassed, recorded.Status)
+	require.Len(t, recorded.Reports, 1)
+
+	// 計画した本人は承認できない
+	var errBody map[string]string
+	assert.Equal(t, http.StatusForbidden, doJSONAs(t, "alice-token", "POST", ts.URL+"/api/runs/"+plan.ID+"/approve", nil, &errBody))
+	assert.Contains(t, errBody["error"], "alice planned run "+plan.ID+" and cannot approve it")
+
+	var apply state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSONAs(t, "bob-token", "POST", ts.URL+"/api/runs/"+plan.ID+"/approve", nil, &apply))
+	assert.False(t, apply.DryRun)
+	assert.Equal(t, plan.ID, apply.PlanRunID)
+	assert.Equal(t, "api:bob", apply.Actor)
+	srv.Wait()
+
+	// 一度承認した計画は二度適用されない
+	assert.Equal(t, http.StatusConflict, doJSONAs(t, "bob-token", "POST", ts.URL+"/api/runs/"+plan.ID+"/approve", nil, &errBody))
+	assert.Contains(t, errBody["error"], "already approved")
+
+	var runs []state.RunRecord
+	require.Equal(t, http.StatusOK, doJSONAs(t, "bob-token", "GET", ts.URL+"/api/runs", nil, &runs))
+	require.Len(t, runs, 2)
+	assert.ElementsMatch(t, []string{plan.ID, apply.ID}, []string{runs[0].ID, runs[1].ID})
+	assert.Empty(t, runs[0].Reports, "the list leaves reports out")
+
+	// 適用済みの実行や、計画後に定義が変わった実行は承認できない
+	assert.Equal(t, http.StatusConflict, doJSONAs(t, "alice-token", "POST", ts.URL+"/api/runs/"+apply.ID+"/approve", nil, nil))
+
+	require.Equal(t, http.StatusAccepted, doJSONAs(t, "alice-token", "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &plan))
+	srv.Wait()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "cleanup.yaml"), []byte("version: 1\noperations: [] # changed\n"), 0644))
+	assert.Equal(t, http.StatusConflict, doJSONAs(t, "bob-token", "POST", ts.URL+"/api/runs/"+plan.ID+"/approve", nil, &errBody))
+	assert.Contains(t, errBody["error"], "definitions changed")
+}
+
+func TestServerSharedTokenCannotApproveItsOwnPlans(t *testing.T) {
+	srv, ts, _ := newTestServer(t, false, server.WithToken("shared"))
+
+	var plan state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSONAs(t, "shared", "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &plan))
+	assert.Equal(t, "opsql-server", plan.Actor)
+	srv.Wait()
+
+	// 共有トークンの呼び出し元は区別できないので、互いの計画を承認できない
+	var errBody map[string]string
+	assert.Equal(t, http.StatusForbidden, doJSONAs(t, "shared", "POST", ts.URL+"/api/runs/"+plan.ID+"/approve", nil, &errBody))
+	assert.Contains(t, errBody["error"], "cannot approve it")
+}
+
+func TestServerRejectsInvalidRequests(t *testing.T) {
+	_, ts, _ := newTestServer(t, false, server.WithToken("secret"))
+
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, "GET", ts.URL+"/api/runs", nil, nil))
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/plan", bytes.NewReader([]byte(`{"configs":["../etc/passwd"]}`)))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", ts.URL+"/api/runs/missing", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the UI itself is public; the API checks the token")
+}
+
+func TestLoadTokens(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("alice: alice-token\ndeploy-bot: bot-token\n"), 0600))
+
+	tokens, err := server.LoadTokens(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "alice-token", "deploy-bot": "bot-token"}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte("alice: same\nbob: same\n"), 0600))
+	_, err = server.LoadTokens(context.Background(), path)
+	assert.ErrorContains(t, err, "share a token")
+
+	require.NoError(t, os.WriteFile(path, []byte("alice: \"\"\n"), 0600))
+	_, err = server.LoadTokens(context.Background(), path)
+	assert.ErrorContains(t, err, "alice has an empty token")
+}
+
+func TestServerRecordsRunsThatFailBeforeHistory(t *testing.T) {
+	srv, ts, _ := newTestServer(t, true)
+
+	var plan state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSON(t, "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &plan))
+	srv.Wait()
+
+	var recorded state.RunRecord
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", ts.URL+"/api/runs/"+plan.ID, nil, &recorded))
+	assert.Equal(t, state.StatusAborted, recorded.Status)
+	assert.Equal(t, "failed to load definition", recorded.Error)
+}
+
+type fakePullRequestFiles struct {
+	changed []string
+	content map[string]string
+}
+
+func (f *fakePullRequestFiles) ChangedFiles(ctx context.Context, repo string, pr int) ([]string, error) {
+	return f.changed, nil
+}
+
+func (f *fakePullRequestFiles) FileContent(ctx context.Context, repo, path, ref string) ([]byte, error) {
+	content, ok := f.content[path]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func postWebhook(t *testing.T, url, secret, event string, payload []byte) int {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	req, err := http.NewRequest("POST", url+"/webhooks/github", bytes.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerGitHubWebhook(t *testing.T) {
+	files := &fakePullRequestFiles{
+		changed: []string{"runbooks/cleanup.yaml", "README.md", "other/ignored.yaml"},
+		content: map[string]string{"runbooks/cleanup.yaml": "version: 1\noperations: []\n"},
+	}
+	workDir := t.TempDir()
+
+	var mu sync.Mutex
+	var runs []server.Run
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	srv := server.New(history, func(ctx context.Context, run server.Run) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, run)
+		return nil
+	}, t.TempDir(), server.WithGitHubWebhook(server.GitHubWebhook{
+		Secret:      "hook-secret",
+		Paths:       []string{"runbooks/"},
+		Environment: "staging",
+		Files:       files,
+		WorkDir:     workDir,
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	payload := []byte(`{"action":"synchronize","number":12,"pull_request":{"author_association":"MEMBER","head":{"sha":"abc123","repo":{"full_name":"acme/db-ops"}}},"repository":{"full_name":"acme/db-ops"},"sender":{"login":"bob"}}`)
+
+	assert.Equal(t, http.StatusUnauthorized, postWebhook(t, ts.URL, "wrong-secret", "pull_request", payload))
+	assert.Equal(t, http.StatusOK, postWebhook(t, ts.URL, "hook-secret", "ping", []byte(`{}`)))
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", []byte(`{"action":"closed","number":12}`)))
+	// 外部の作者やフォークからのPRは計画しない
+	outsider := []byte(`{"action":"opened","number":13,"pull_request":{"author_association":"CONTRIBUTOR","head":{"sha":"abc123","repo":{"full_name":"acme/db-ops"}}},"repository":{"full_name":"acme/db-ops"},"sender":{"login":"eve"}}`)
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", outsider))
+	fork := []byte(`{"action":"opened","number":14,"pull_request":{"author_association":"MEMBER","head":{"sha":"abc123","repo":{"full_name":"eve/db-ops"}}},"repository":{"full_name":"acme/db-ops"},"sender":{"login":"bob"}}`)
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", fork))
+
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", payload))
+	srv.Wait()
+
+	require.Len(t, runs, 1)
+	run := runs[0]
+	assert.True(t, run.DryRun)
+	assert.Equal(t, "staging", run.Environment)
+	assert.Equal(t, "github:bob", run.Actor)
+	assert.Equal(t, "acme/db-ops", run.GitHubRepo)
+	assert.Equal(t, 12, run.GitHubPR)
+
+	// 配信ごとに、計画する実行の名前のディレクトリに取り出す
+	expected := filepath.Join(workDir, "acme", "db-ops", "pr-12", run.ID, "runbooks", "cleanup.yaml")
+	assert.Equal(t, []string{expected}, run.Configs)
+	content, err := os.ReadFile(expected)
+	require.NoError(t, err)
+	assert.Equal(t, files.content["runbooks/cleanup.yaml"], string(content))
+
+	// 対象のrunbookが変わっていなければ実行しない
+	files.changed = []string{"README.md"}
+	assert.Equal(t, http.StatusOK, postWebhook(t, ts.URL, "hook-secret", "pull_request", payload))
+	srv.Wait()
+	assert.Len(t, runs, 1)
+}
+
+func TestServerGitHubWebhookFetchesTemplatesAndKeepsDeliveriesApart(t *testing.T) {
+	files := &fakePullRequestFiles{
+		changed: []string{"runbooks/orders/purge.yaml"},
+		content: map[string]string{
+			"runbooks/orders/purge.yaml":         "version: 1\noperations:\n  - use: purge_rows\n    with: {table: orders}\n  - type: import\n    into: ids\n    file: ids.csv\n",
+			"runbooks/orders/ids.csv":            "id\n1\n",
+			"runbooks/templates/purge_rows.yaml": "args:\n  table: {type: string}\noperations:\n  - type: select\n    sql: SELECT {{ file \"keep.txt\" }}\n",
+			"runbooks/templates/keep.txt":        "keep",
+		},
+	}
+	workDir := t.TempDir()
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	srv := server.New(history, fakeRunner(history, false), t.TempDir(), server.WithGitHubWebhook(server.GitHubWebhook{
+		Secret:  "hook-secret",
+		Files:   files,
+		WorkDir: workDir,
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	payload := []byte(`{"action":"synchronize","number":12,"pull_request":{"author_association":"MEMBER","head":{"sha":"abc123","repo":{"full_name":"acme/db-ops"}}},"repository":{"full_name":"acme/db-ops"},"sender":{"login":"bob"}}`)
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", payload))
+	srv.Wait()
+
+	runs, err := history.ListRuns(context.Background())
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	firstID := runs[0].ID
+	first := filepath.Join(workDir, "acme", "db-ops", "pr-12", firstID)
+	for _, file := range []string{"runbooks/orders/purge.yaml", "runbooks/orders/ids.csv", "runbooks/templates/purge_rows.yaml", "runbooks/templates/keep.txt"} {
+		content, err := os.ReadFile(filepath.Join(first, filepath.FromSlash(file)))
+		require.NoError(t, err, file)
+		assert.Equal(t, files.content[file], string(content))
+	}
+	_, err = definition.LoadDefinitionRaw(filepath.Join(first, "runbooks", "orders", "purge.yaml"))
+	require.NoError(t, err, "the runbook finds its template in the delivery's directory")
+
+	// 次の配信は別のディレクトリに取り出し、記録済みの前の配信のディレクトリを片付ける
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "pull_request", payload))
+	srv.Wait()
+	runs, err = history.ListRuns(context.Background())
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	second := runs[0].ID
+	if second == firstID {
+		second = runs[1].ID
+	}
+	assert.NoDirExists(t, first)
+	assert.FileExists(t, filepath.Join(workDir, "acme", "db-ops", "pr-12", second, "runbooks", "orders", "purge.yaml"))
+}
+
+func postSlackCommand(t *testing.T, url, secret string, form url.Values) (int, map[string]string) {
+	t.Helper()
+	body := form.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	req, err := http.NewRequest("POST", url+"/chatops/slack", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	var reply map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	return resp.StatusCode, reply
+}
+
+func TestServerSlackCommands(t *testing.T) {
+	var mu sync.Mutex
+	var responses []map[string]string
+	responseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&message)
+		mu.Lock()
+		responses = append(responses, message)
+		mu.Unlock()
+	}))
+	t.Cleanup(responseServer.Close)
+
+	srv, ts, _ := newTestServer(t, false, server.WithChatOps(server.ChatOps{
+		SlackSigningSecret: "slack-secret",
+		Approvers:          []string{"slack:U_ALICE", "github:U_BOB"},
+	}))
+
+	plan := url.Values{
+		"text":         {"plan cleanup.yaml env=staging user_ids=1,2"},
+		"user_id":      {"U_BOB"},
+		"user_name":    {"bob"},
+		"response_url": {responseServer.URL},
+	}
+	status, _ := postSlackCommand(t, ts.URL, "wrong-secret", plan)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, reply := postSlackCommand(t, ts.URL, "slack-secret", plan)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "in_channel", reply["response_type"])
+	assert.Contains(t, reply["text"], "Planning")
+	assert.Contains(t, reply["text"], "on staging")
+	fields := strings.Fields(reply["text"])
+	planID := fields[len(fields)-1]
+	srv.Wait()
+
+	require.Len(t, responses, 1)
+	assert.Contains(t, responses[0]["text"], "plan "+planID)
+	assert.Contains(t, responses[0]["text"], "passed (1 of 1 operations passed)")
+	assert.Contains(t, responses[0]["text"], "/opsql approve "+planID)
+
+	// 承認者以外は適用できず、同じ名前のGitHubのログインが承認者でもSlackのユーザーは通らない
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_BOB"}})
+	assert.Equal(t, "ephemeral", reply["response_type"])
+	assert.Contains(t, reply["text"], "slack:U_BOB is not allowed to approve")
+
+	// 変えられる表示名は承認者の判定に使わない
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_BOB"}, "user_name": {"U_ALICE"}})
+	assert.Contains(t, reply["text"], "slack:U_BOB is not allowed to approve")
+
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_ALICE"}})
+	assert.Contains(t, reply["text"], "Applying")
+	srv.Wait()
+
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"status " + planID}})
+	assert.Equal(t, "in_channel", reply["response_type"])
+	assert.Contains(t, reply["text"], "plan "+planID)
+
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"drop everything"}})
+	assert.Equal(t, "ephemeral", reply["response_type"])
+	assert.Contains(t, reply["text"], "Usage:")
+}
+
+type fakeCommenter struct {
+	mu      sync.Mutex
+	replies []string
+}
+
+func (f *fakeCommenter) Reply(ctx context.Context, repo string, pr int, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, body)
+	return nil
+}
+
+func TestServerGitHubCommentCommands(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(configDir, "runbooks"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "runbooks", "fix.yaml"), []byte("version: 1\noperations: []\n"), 0644))
+
+	var mu sync.Mutex
+	var runs []server.Run
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	commenter := &fakeCommenter{}
+	srv := server.New(history, func(ctx context.Context, run server.Run) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, run)
+		return nil
+	}, configDir,
+		server.WithGitHubWebhook(server.GitHubWebhook{Secret: "hook-secret", Files: &fakePullRequestFiles{}}),
+		server.WithChatOps(server.ChatOps{Comments: commenter}))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	comment := func(body, association string) []byte {
+		payload, err := json.Marshal(map[string]interface{}{
+			"action":     "created",
+			"issue":      map[string]interface{}{"number": 7, "pull_request": map[string]interface{}{}},
+			"comment":    map[string]interface{}{"body": body, "author_association": association, "user": map[string]string{"login": "carol"}},
+			"repository": map[string]string{"full_name": "acme/db-ops"},
+		})
+		require.NoError(t, err)
+		return payload
+	}
+
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("LGTM", "MEMBER")))
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("/opsql plan runbooks/fix.yaml", "NONE")))
+	srv.Wait()
+	assert.Empty(t, runs)
+
+	// コマンドは1行目だけを見るので、引用や後の行では動かない
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("Trying it:\n/opsql plan runbooks/fix.yaml", "COLLABORATOR")))
+	assert.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("> /opsql plan runbooks/fix.yaml", "COLLABORATOR")))
+	srv.Wait()
+	assert.Empty(t, runs)
+
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("/opsql plan runbooks/fix.yaml env=staging", "COLLABORATOR")))
+	srv.Wait()
+
+	require.Len(t, runs, 1)
+	run := runs[0]
+	assert.True(t, run.DryRun)
+	assert.Equal(t, "staging", run.Environment)
+	assert.Equal(t, "github:carol", run.Actor)
+	assert.Equal(t, "acme/db-ops", run.GitHubRepo)
+	assert.Equal(t, 7, run.GitHubPR)
+	assert.Equal(t, []string{filepath.Join(configDir, "runbooks", "fix.yaml")}, run.Configs)
+	assert.Empty(t, commenter.replies)
+
+	assert.Equal(t, http.StatusBadRequest, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment("/opsql plan ../secret.yaml", "OWNER")))
+	require.Len(t, commenter.replies, 1)
+	assert.Contains(t, commenter.replies[0], "error: config must be a relative path")
+}
+
+func TestServerChatOpsRejectsSelfApproval(t *testing.T) {
+	srv, ts, _ := newTestServer(t, false, server.WithChatOps(server.ChatOps{SlackSigningSecret: "slack-secret"}))
+
+	_, reply := postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"plan cleanup.yaml"}, "user_id": {"U_BOB"}})
+	fields := strings.Fields(reply["text"])
+	planID := fields[len(fields)-1]
+	srv.Wait()
+
+	// 承認者を決めていなくても、計画した本人は承認できない
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_BOB"}})
+	assert.Equal(t, "ephemeral", reply["response_type"])
+	assert.Contains(t, reply["text"], "slack:U_BOB planned run "+planID+" and cannot approve it")
+
+	_, reply = postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_ALICE"}})
+	assert.Contains(t, reply["text"], "Applying")
+	srv.Wait()
+}
+
+func TestServerGitHubCommentApprovesOnlyPlansOfThePullRequest(t *testing.T) {
+	commenter := &fakeCommenter{}
+	srv, ts, _ := newTestServer(t, false,
+		server.WithGitHubWebhook(server.GitHubWebhook{Secret: "hook-secret", Files: &fakePullRequestFiles{}}),
+		server.WithChatOps(server.ChatOps{SlackSigningSecret: "slack-secret", Comments: commenter}))
+
+	comment := func(pr int, login, body string) []byte {
+		payload, err := json.Marshal(map[string]interface{}{
+			"action":     "created",
+			"issue":      map[string]interface{}{"number": pr, "pull_request": map[string]interface{}{}},
+			"comment":    map[string]interface{}{"body": body, "author_association": "MEMBER", "user": map[string]string{"login": login}},
+			"repository": map[string]string{"full_name": "acme/db-ops"},
+		})
+		require.NoError(t, err)
+		return payload
+	}
+
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment(7, "carol", "/opsql plan cleanup.yaml")))
+	srv.Wait()
+	var runs []state.RunRecord
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", ts.URL+"/api/runs", nil, &runs))
+	require.Len(t, runs, 1)
+	planID := runs[0].ID
+	assert.Equal(t, 7, runs[0].GitHubPR)
+
+	// 計画した本人と、別のPRからの承認は受け付けない
+	assert.Equal(t, http.StatusForbidden, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment(7, "carol", "/opsql approve "+planID)))
+	assert.Equal(t, http.StatusForbidden, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment(8, "dave", "/opsql approve "+planID)))
+
+	var apiPlan state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSON(t, "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &apiPlan))
+	srv.Wait()
+	assert.Equal(t, http.StatusForbidden, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment(7, "dave", "/opsql approve "+apiPlan.ID)))
+
+	commenter.mu.Lock()
+	require.Len(t, commenter.replies, 3)
+	assert.Contains(t, commenter.replies[0], "github:carol planned run "+planID)
+	assert.Contains(t, commenter.replies[1], "was not planned for acme/db-ops#8")
+	assert.Contains(t, commenter.replies[2], "was not planned for acme/db-ops#7")
+	commenter.mu.Unlock()
+
+	// PRの計画はSlackから別の名前で承認させない
+	_, reply := postSlackCommand(t, ts.URL, "slack-secret", url.Values{"text": {"approve " + planID}, "user_id": {"U_DAVE"}})
+	assert.Contains(t, reply["text"], "was planned for acme/db-ops#7 and can only be approved there")
+
+	require.Equal(t, http.StatusAccepted, postWebhook(t, ts.URL, "hook-secret", "issue_comment", comment(7, "dave", "/opsql approve "+planID)))
+	srv.Wait()
+}
+
+func TestChatOpsValidateRequiresPrefixedApprovers(t *testing.T) {
+	assert.NoError(t, server.ChatOps{Approvers: []string{"slack:U012AB3CD", "github:alice"}}.Validate())
+	assert.ErrorContains(t, server.ChatOps{Approvers: []string{"alice"}}.Validate(), `approver "alice" must be slack:<user ID> or github:<login>`)
+}
+
+func TestServerStreamsRunEvents(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "cleanup.yaml"), []byte("version: 1\noperations: []\n"), 0644))
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	release := make(chan struct{})
+	runner := fakeRunner(history, false)
+	srv := server.New(history, func(ctx context.Context, run server.Run) error {
+		<-release
+		return runner(ctx, run)
+	}, configDir)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	var plan state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSON(t, "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &plan))
+
+	resp, err := http.Get(ts.URL + "/api/runs/" + plan.ID + "/events")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var names []string
+	var progress []executor.ProgressEvent
+	var runs []state.RunRecord
+	scanner := bufio.NewScanner(resp.Body)
+	name := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+			names = append(names, name)
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			if name == "progress" {
+				var event executor.ProgressEvent
+				require.NoError(t, json.Unmarshal(data, &event))
+				progress = append(progress, event)
+				continue
+			}
+			var record state.RunRecord
+			require.NoError(t, json.Unmarshal(data, &record))
+			runs = append(runs, record)
+			if record.Status == server.StatusRunning {
+				close(release)
+			}
+		}
+	}
+	srv.Wait()
+
+	assert.Equal(t, []string{"run", "progress", "progress", "run"}, names)
+	assert.Equal(t, server.StatusRunning, runs[0].Status)
+	assert.Equal(t, state.StatusPassed, runs[1].Status)
+	assert.Equal(t, executor.ProgressStarted, progress[0].Type)
+	assert.Equal(t, executor.ProgressFinished, progress[1].Type)
+	require.NotNil(t, progress[1].Affected)
+	assert.Equal(t, int64(2), *progress[1].Affected)
+
+	// 終了済みの実行は結果だけを返す
+	resp, err = http.Get(ts.URL + "/api/runs/" + plan.ID + "/events")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 1, strings.Count(string(body), "event: run"))
+	assert.NotContains(t, string(body), "event: progress")
+
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", ts.URL+"/api/runs/missing/events", nil, nil))
+}
+
+func TestServerCancelsRuns(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "cleanup.yaml"), []byte("version: 1\noperations: []\n"), 0644))
+	history := state.NewHistory(state.NewFileStore(t.TempDir()))
+	srv := server.New(history, func(ctx context.Context, run server.Run) error {
+		<-run.Cancel
+		return history.SaveRun(ctx, state.RunRecord{ID: run.ID, Definition: definition.Name(run.Configs), Status: state.StatusCancelled})
+	}, configDir)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	var plan state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSON(t, "POST", ts.URL+"/api/plan", server.PlanRequest{Configs: []string{"cleanup.yaml"}}, &plan))
+
+	var cancelled state.RunRecord
+	require.Equal(t, http.StatusAccepted, doJSON(t, "POST", ts.URL+"/api/runs/"+plan.ID+"/cancel", nil, &cancelled))
+	assert.Equal(t, plan.ID, cancelled.ID)
+	srv.Wait()
+
+	var recorded state.RunRecord
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", ts.URL+"/api/runs/"+plan.ID, nil, &recorded))
+	assert.Equal(t, state.StatusCancelled, recorded.Status)
+
+	assert.Equal(t, http.StatusConflict, doJSON(t, "POST", ts.URL+"/api/runs/"+plan.ID+"/cancel", nil, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "POST", ts.URL+"/api/runs/missing/cancel", nil, nil))
+}
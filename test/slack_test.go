@@ -0,0 +1,57 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pyama86/opsql/internal/definition"
+	"github.com/pyama86/opsql/internal/slack"
+	slackapi "github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSlack answers the Web API methods the bot client calls and records the calls
+func fakeSlack(t *testing.T, history []map[string]interface{}) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		calls = append(calls, method)
+		response := map[string]interface{}{"ok": true, "channel": "C1", "ts": "1700000000.000200"}
+		switch method {
+		case "conversations.history":
+			assert.Equal(t, "1", r.Form.Get("include_all_metadata"))
+			response["messages"] = history
+		case "chat.update":
+			assert.Equal(t, "1700000000.000100", r.Form.Get("ts"))
+			assert.Contains(t, r.Form.Get("metadata"), `"key":"abc"`)
+		case "chat.postMessage":
+			assert.Contains(t, r.Form.Get("metadata"), `"key":"abc"`)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestSlackBotClientUpdatesMessageOfIdempotencyKey(t *testing.T) {
+	reports := []definition.Report{{ID: "op1", Type: "select", Pass: true, Message: "ok"}}
+
+	server, calls := fakeSlack(t, []map[string]interface{}{
+		{"type": "message", "ts": "1700000000.000050", "text": "other"},
+		{"type": "message", "ts": "1700000000.000100", "text": "opsql Execution Results",
+			"metadata": map[string]interface{}{"event_type": "opsql_run", "event_payload": map[string]interface{}{"key": "abc"}}},
+	})
+	client := slack.NewBotClient("xoxb-test", "C1", slackapi.OptionAPIURL(server.URL+"/")).WithIdempotencyKey("abc")
+	require.NoError(t, client.SendNotificationWithContextAndError(reports, true, "prod", nil))
+	assert.Equal(t, []string{"conversations.history", "chat.update"}, *calls)
+
+	server, calls = fakeSlack(t, nil)
+	client = slack.NewBotClient("xoxb-test", "C1", slackapi.OptionAPIURL(server.URL+"/")).WithIdempotencyKey("abc")
+	require.NoError(t, client.SendNotificationWithContextAndError(reports, true, "prod", nil))
+	assert.Equal(t, []string{"conversations.history", "chat.postMessage"}, *calls)
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/pyama86/opsql/internal/database"
+	"github.com/pyama86/opsql/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseStore_PutIfAbsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	store := state.NewDatabaseStore(&MockDatabase{db: db, mock: mock}, "")
+
+	mock.ExpectExec("INSERT IGNORE INTO `opsql_state` \\(state_key, state_value\\) VALUES \\(\\?, \\?\\)").
+		WithArgs("locks/a.json", []byte("first")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT IGNORE INTO `opsql_state` \\(state_key, state_value\\) VALUES \\(\\?, \\?\\)").
+		WithArgs("locks/a.json", []byte("second")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+	require.NoError(t, store.PutIfAbsent(ctx, "locks/a.json", []byte("first")))
+	assert.ErrorIs(t, store.PutIfAbsent(ctx, "locks/a.json", []byte("second")), state.ErrExists)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStore_Replace(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	store := state.NewDatabaseStore(&MockDatabase{db: db, mock: mock}, "")
+
+	mock.ExpectExec("UPDATE `opsql_state` SET state_value = \\?, updated_at = CURRENT_TIMESTAMP WHERE state_key = \\? AND state_value = \\?").
+		WithArgs([]byte("second"), "locks/a.json", []byte("first")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `opsql_state` SET state_value = \\?, updated_at = CURRENT_TIMESTAMP WHERE state_key = \\? AND state_value = \\?").
+		WithArgs([]byte("third"), "locks/a.json", []byte("first")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+	require.NoError(t, store.Replace(ctx, "locks/a.json", []byte("first"), []byte("second")))
+	assert.ErrorIs(t, store.Replace(ctx, "locks/a.json", []byte("first"), []byte("third")), state.ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStore_GetBinaryValue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	// 実際のドライバ経由では LONGBLOB / BYTEA の値は database.Binary として返る
+	store := state.NewDatabaseStore(&database.Database{DB: sqlx.NewDb(db, "mysql")}, "")
+	rows := sqlmock.NewRowsWithColumnDefinition(mock.NewColumn("state_value").OfType("LONGBLOB", nil)).
+		AddRow([]byte(`{"id":"run-1"}`))
+	mock.ExpectQuery("SELECT state_value FROM `opsql_state` WHERE state_key = \\?").
+		WithArgs("runs/run-1.json").
+		WillReturnRows(rows)
+
+	data, err := store.Get(context.Background(), "runs/run-1.json")
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"id":"run-1"}`), data)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStore_GetAndList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	store := state.NewDatabaseStore(&MockDatabase{db: db, mock: mock}, "ops")
+
+	mock.ExpectQuery("SELECT state_value FROM `ops`.`opsql_state` WHERE state_key = \\?").
+		WithArgs("runs/missing.json").
+		WillReturnRows(sqlmock.NewRows([]string{"state_value"}))
+	mock.ExpectQuery("SELECT state_key FROM `ops`.`opsql_state` WHERE state_key LIKE \\?").
+		WithArgs("runs/%").
+		WillReturnRows(sqlmock.NewRows([]string{"state_key"}).AddRow("runs/1.json").AddRow("runs/2.json"))
+
+	ctx := context.Background()
+	_, err = store.Get(ctx, "runs/missing.json")
+	assert.ErrorIs(t, err, state.ErrNotFound)
+
+	keys, err := store.List(ctx, "runs/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runs/1.json", "runs/2.json"}, keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}